@@ -6,11 +6,6 @@ import (
 	"glut/common/sqlutil"
 	"glut/common/valid"
 	"time"
-
-	"github.com/jackc/pgx/v5"
-	"github.com/stephenafamo/bob/dialect/psql"
-	"github.com/stephenafamo/bob/dialect/psql/sm"
-	"github.com/stephenafamo/bob/dialect/psql/um"
 )
 
 type ChangePasswordInput struct {
@@ -24,6 +19,10 @@ type ChangeEmailInput struct {
 	Password string `json:"password"`
 }
 
+type RevertEmailInput struct {
+	Token string `json:"token"`
+}
+
 type VerifyUserInput struct {
 	Token string `json:"token"`
 }
@@ -38,107 +37,100 @@ type ForgotUsernameInput struct {
 	Email string `json:"email"`
 }
 
+type SetPasswordInput struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password"`
+}
+
 func (s *Service) ChangePassword(f *flux.Flow, in ChangePasswordInput) error {
 	var errs valid.Errors
 	if in.OldPassword == "" {
-		errs = append(errs, valid.Error{Field: "old_password", Error: "Required."})
+		errs = append(errs, valid.Required("old_password"))
 	}
 	if in.NewPassword == "" {
-		errs = append(errs, valid.Error{Field: "new_password", Error: "Required."})
+		errs = append(errs, valid.Required("new_password"))
 	}
 	if len(errs) != 0 {
 		return errs
 	}
 
-	tx, err := s.db.Begin(f.Ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(f.Ctx)
-
-	sql, args := psql.Select(
-		sm.From("auth.users"),
-		sm.Columns("password_hash"),
-		sm.Where(psql.Quote("id").EQ(psql.Arg(f.Session.User))),
-		sm.ForNoKeyUpdate(),
-	).MustBuild()
-
-	var passwordHash string
-	if err := tx.QueryRow(f.Ctx, sql, args...).Scan(&passwordHash); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return ErrUserNotFound
-		}
-		return err
-	}
-	if err := validatePassword(passwordHash, in.OldPassword, s.cfg.PasswordChecker); err != nil {
-		return err
-	}
-	if err := updateUserPassword(f.Ctx, tx, f.Session.User, in.NewPassword); err != nil {
-		return err
-	}
-	if err := deleteUserSessions(f.Ctx, tx, f.Session.User); err != nil {
-		return err
-	}
-	if err := tx.Commit(f.Ctx); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (s *Service) ChangeEmail(f *flux.Flow, in ChangeEmailInput) error {
-	if in.Token != "" {
-		tx, err := s.db.Begin(f.Ctx)
+	return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		user, err := tx.Users().Get(f.Ctx, f.Session.User)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback(f.Ctx)
-
-		token, err := getToken(f, tx, in.Token, tokenKindChangeEmail)
-		if err != nil {
+		if err := validatePassword(user.Password, in.OldPassword, s.config().PasswordChecker); err != nil {
 			return err
 		}
-		newEmail := token.Get(tokenMetaNewEmail)
-		if newEmail == "" {
-			return ErrInvalidToken
-		}
-
-		sql, args := psql.Select(
-			sm.From("auth.users"),
-			sm.Columns("email"),
-			sm.Where(psql.Quote("id").EQ(psql.Arg(token.UserID))),
-			sm.ForNoKeyUpdate(),
-		).MustBuild()
-
-		var email string
-		if err := tx.QueryRow(f.Ctx, sql, args...).Scan(&email); err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				return ErrUserNotFound
-			}
-			return err
-		}
-
-		sql, args = psql.Update(
-			um.Table("auth.users"),
-			um.Set("email").ToArg(newEmail),
-			um.Where(psql.Quote("id").EQ(psql.Arg(token.UserID))),
-		).MustBuild()
-
-		if _, err := tx.Exec(f.Ctx, sql, args...); err != nil {
+		if err := updateUserPassword(f.Ctx, tx, f.Session.User, in.NewPassword); err != nil {
 			return err
 		}
-		if err := deleteToken(f.Ctx, tx, token.ID); err != nil {
+		if _, err := tx.Sessions().Delete(f.Ctx, nil, f.Session.User); err != nil {
 			return err
 		}
-		if err := deleteUserSessions(f.Ctx, tx, token.UserID); err != nil {
+		if err := emit(f, tx, EventUserPasswordChanged, f.Session.User, UserChange{UserID: f.Session.User}); err != nil {
 			return err
 		}
+		return s.queueEmail(f, tx, &user.ID, EmailSecurityAlert, securityAlertData(user, SecurityAlertPasswordChanged))
+	})
+}
 
-		// TODO: send notification to previous email
+func (s *Service) ChangeEmail(f *flux.Flow, in ChangeEmailInput) error {
+	if in.Token != "" {
+		return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+			token, err := tx.Tokens().Get(f.Ctx, in.Token, tokenKindChangeEmail, f.Time)
+			if err != nil {
+				return err
+			}
+			newEmail := token.Get(tokenMetaNewEmail)
+			if newEmail == "" {
+				return ErrInvalidToken
+			}
 
-		if err := tx.Commit(f.Ctx); err != nil {
-			return err
-		}
-		return nil
+			user, err := tx.Users().Get(f.Ctx, token.UserID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Users().Update(f.Ctx, token.UserID, UserUpdate{Email: &newEmail}); err != nil {
+				return err
+			}
+			if err := tx.Tokens().Delete(f.Ctx, token.ID); err != nil {
+				return err
+			}
+			if _, err := tx.Sessions().Delete(f.Ctx, nil, token.UserID); err != nil {
+				return err
+			}
+			if err := emit(f, tx, EventUserEmailChanged, token.UserID, UserChange{UserID: token.UserID, Email: newEmail}); err != nil {
+				return err
+			}
+
+			// The previous address may revert the change. An unexpired
+			// revert token is kept, so that changing the email again does
+			// not take the revert away from the original address.
+			data := securityAlertData(user, SecurityAlertEmailChanged)
+			data.NewEmail = newEmail
+			prev, ok, err := tx.Tokens().ByUser(f.Ctx, token.UserID, tokenKindRevertEmail)
+			if err != nil {
+				return err
+			}
+			if !ok || !prev.ExpiresAt.After(f.Time) {
+				revert := Token{
+					ID:        mustGenerateToken(s.config().TokenLength),
+					UserID:    token.UserID,
+					Kind:      tokenKindRevertEmail,
+					CreatedAt: f.Time,
+					ExpiresAt: f.Time.Add(s.config().RevertEmailTokenDuration),
+					Meta: map[string]*string{
+						tokenMetaOldEmail: &user.Email,
+					},
+				}
+				if err := tx.Tokens().Save(f.Ctx, revert); err != nil {
+					return err
+				}
+				data.Token, data.ExpiresAt = revert.ID, revert.ExpiresAt
+			}
+			return s.queueEmail(f, tx, &user.ID, EmailSecurityAlert, data)
+		})
 	}
 
 	if f.Session == nil {
@@ -146,282 +138,284 @@ func (s *Service) ChangeEmail(f *flux.Flow, in ChangeEmailInput) error {
 	}
 	var errs valid.Errors
 	if in.Email == "" {
-		errs = append(errs, valid.Error{Field: "email", Error: "Required."})
+		errs = append(errs, valid.Required("email"))
 	}
 	if in.Password == "" {
-		errs = append(errs, valid.Error{Field: "password", Error: "Required."})
+		errs = append(errs, valid.Required("password"))
 	}
 	if len(errs) != 0 {
 		return errs
 	}
 
-	tx, err := s.db.Begin(f.Ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(f.Ctx)
-
-	sql, args := psql.Select(
-		sm.From("auth.users"),
-		sm.Columns("email", "password_hash"),
-		sm.Where(psql.Quote("id").EQ(psql.Arg(f.Session.User))),
-		sm.ForNoKeyUpdate(),
-	).MustBuild()
+	return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		user, err := tx.Users().Get(f.Ctx, f.Session.User)
+		if err != nil {
+			return err
+		}
+		if err := validatePassword(user.Password, in.Password, s.config().PasswordChecker); err != nil {
+			return err
+		}
 
-	var email string
-	var passwordHash string
-	if err := tx.QueryRow(f.Ctx, sql, args...).Scan(&email, &passwordHash); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return ErrUserNotFound
+		token := Token{
+			ID:        mustGenerateToken(s.config().TokenLength),
+			UserID:    f.Session.User,
+			Kind:      tokenKindChangeEmail,
+			CreatedAt: f.Time,
+			ExpiresAt: f.Time.Add(s.config().ChangeEmailTokenDuration),
+			Meta: map[string]*string{
+				tokenMetaNewEmail: &in.Email,
+			},
 		}
-		return err
-	}
-	if err := validatePassword(passwordHash, in.Password, s.cfg.PasswordChecker); err != nil {
-		return err
-	}
+		if err := tx.Tokens().Save(f.Ctx, token); err != nil {
+			return err
+		}
+		data := tokenEmailData(user, token)
+		data.Email = in.Email
+		return s.queueEmail(f, tx, &user.ID, EmailChangeEmail, data)
+	})
+}
 
-	token := Token{
-		ID:        mustGenerateToken(s.cfg.TokenLength),
-		UserID:    f.Session.User,
-		Kind:      tokenKindChangeEmail,
-		CreatedAt: f.Time,
-		ExpiresAt: f.Time.Add(s.cfg.ChangeEmailTokenDuration),
-		Meta: map[string]*string{
-			tokenMetaNewEmail: &in.Email,
-		},
-	}
-	if err := saveToken(f, tx, token); err != nil {
-		return err
+// RevertEmail restores the email a user had before changing it, using the
+// revert token sent to that address. As the change may have been made by
+// someone who took over the account, all sessions and pending change email
+// and password reset tokens of the user are deleted and the password is
+// replaced: the user has to reset it with the token sent to the restored
+// address.
+func (s *Service) RevertEmail(f *flux.Flow, in RevertEmailInput) error {
+	var errs valid.Errors
+	if in.Token == "" {
+		errs = append(errs, valid.Required("token"))
 	}
-
-	// TODO: send email with token
-
-	if err := tx.Commit(f.Ctx); err != nil {
-		return err
+	if len(errs) != 0 {
+		return errs
 	}
-	return nil
-}
 
-func (s *Service) VerifyUser(f *flux.Flow, in VerifyUserInput) error {
-	if in.Token != "" {
-		tx, err := s.db.Begin(f.Ctx)
+	return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		token, err := tx.Tokens().Get(f.Ctx, in.Token, tokenKindRevertEmail, f.Time)
 		if err != nil {
 			return err
 		}
-		defer tx.Rollback(f.Ctx)
+		oldEmail := token.Get(tokenMetaOldEmail)
+		if oldEmail == "" {
+			return ErrInvalidToken
+		}
 
-		token, err := getToken(f, tx, in.Token, tokenKindVerifyUser)
+		user, err := tx.Users().Get(f.Ctx, token.UserID)
 		if err != nil {
 			return err
 		}
+		if err := tx.Users().Update(f.Ctx, user.ID, UserUpdate{Email: &oldEmail}); err != nil {
+			return err
+		}
+		user.Email = oldEmail
+		if err := tx.Tokens().Delete(f.Ctx, token.ID); err != nil {
+			return err
+		}
+		for _, kind := range []string{tokenKindChangeEmail, tokenKindResetPassword} {
+			t, ok, err := tx.Tokens().ByUser(f.Ctx, user.ID, kind)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if err := tx.Tokens().Delete(f.Ctx, t.ID); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := tx.Sessions().Delete(f.Ctx, nil, user.ID); err != nil {
+			return err
+		}
 
-		sql, args := psql.Update(
-			um.Table("auth.users"),
-			um.Set("verified_at").ToArg(f.Time),
-			um.Where(psql.Quote("id").EQ(psql.Arg(token.UserID))),
-		).MustBuild()
-
-		if _, err := tx.Exec(f.Ctx, sql, args...); err != nil {
+		// Nobody knows the new password, so the user has to reset it.
+		if err := updateUserPassword(f.Ctx, tx, user.ID, mustGenerateToken(maxTokenLength)); err != nil {
 			return err
 		}
-		if err := deleteToken(f.Ctx, tx, token.ID); err != nil {
+		reset := Token{
+			ID:        mustGenerateToken(s.config().TokenLength),
+			UserID:    user.ID,
+			Kind:      tokenKindResetPassword,
+			CreatedAt: f.Time,
+			ExpiresAt: f.Time.Add(s.config().ResetPasswordTokenDuration),
+		}
+		if err := tx.Tokens().Save(f.Ctx, reset); err != nil {
 			return err
 		}
-		if err := tx.Commit(f.Ctx); err != nil {
+
+		if err := emit(f, tx, EventUserEmailChanged, user.ID, UserChange{UserID: user.ID, Email: oldEmail}); err != nil {
 			return err
 		}
-		return nil
+		if err := emit(f, tx, EventUserPasswordChanged, user.ID, UserChange{UserID: user.ID}); err != nil {
+			return err
+		}
+		return s.queueEmail(f, tx, &user.ID, EmailResetPassword, tokenEmailData(user, reset))
+	})
+}
+
+func (s *Service) VerifyUser(f *flux.Flow, in VerifyUserInput) error {
+	if in.Token != "" {
+		return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+			token, err := tx.Tokens().Get(f.Ctx, in.Token, tokenKindVerifyUser, f.Time)
+			if err != nil {
+				return err
+			}
+			if err := tx.Users().Update(f.Ctx, token.UserID, UserUpdate{VerifiedAt: &f.Time}); err != nil {
+				return err
+			}
+			if err := tx.Tokens().Delete(f.Ctx, token.ID); err != nil {
+				return err
+			}
+			return emit(f, tx, EventUserVerified, token.UserID, UserChange{UserID: token.UserID, VerifiedAt: &f.Time})
+		})
 	}
 
 	if f.Session == nil {
 		return ErrUnauthorized
 	}
 
-	tx, err := s.db.Begin(f.Ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(f.Ctx)
-
-	sql, args := psql.Select(
-		sm.From("auth.users"),
-		sm.Columns("verified_at"),
-		sm.Where(psql.Quote("id").EQ(psql.Arg(f.Session.User))),
-		sm.ForNoKeyUpdate(),
-	).MustBuild()
-
-	var verifiedAt *time.Time
-	if err := tx.QueryRow(f.Ctx, sql, args...).Scan(&verifiedAt); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return ErrUserNotFound
+	return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		user, err := tx.Users().Get(f.Ctx, f.Session.User)
+		if err != nil {
+			return err
+		}
+		if user.VerifiedAt != nil {
+			return ErrUserVerified
 		}
-		return err
-	}
-	if verifiedAt != nil {
-		return ErrUserVerified
-	}
-
-	sql, args = psql.Select(
-		sm.From("auth.tokens"),
-		sm.Columns("created_at"),
-		psql.WhereAnd(
-			sm.Where(psql.Quote("user_id").EQ(psql.Arg(f.Session.User))),
-			sm.Where(psql.Quote("kind").EQ(psql.Arg(tokenKindVerifyUser))),
-		),
-	).MustBuild()
-
-	var tokenCreatedAt time.Time
-	if err := tx.QueryRow(f.Ctx, sql, args...).Scan(&tokenCreatedAt); err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		return err
-	}
-	if !tokenCreatedAt.IsZero() && time.Since(tokenCreatedAt) < s.cfg.VerificationTokenWaitTime {
-		return ErrTryLater
-	}
-
-	token := s.createUserVerificationToken(f.Session.User, f.Time)
-	if err := saveToken(f, tx, token); err != nil {
-		return err
-	}
 
-	// TODO: send email with token
+		prev, ok, err := tx.Tokens().ByUser(f.Ctx, f.Session.User, tokenKindVerifyUser)
+		if err != nil {
+			return err
+		}
+		if ok && time.Since(prev.CreatedAt) < s.config().VerificationTokenWaitTime {
+			return ErrTryLater
+		}
 
-	if err := tx.Commit(f.Ctx); err != nil {
-		return err
-	}
-	return nil
+		token := s.createUserVerificationToken(f.Session.User, f.Time)
+		if err := tx.Tokens().Save(f.Ctx, token); err != nil {
+			return err
+		}
+		return s.queueEmail(f, tx, &user.ID, EmailVerifyUser, tokenEmailData(user, token))
+	})
 }
 
 func (s *Service) ResetPassword(f *flux.Flow, in ResetPasswordInput) error {
 	if in.Token != "" {
 		var errs valid.Errors
 		if in.Password == "" {
-			errs = append(errs, valid.Error{Field: "password", Error: "Required."})
+			errs = append(errs, valid.Required("password"))
 		}
 		if len(errs) != 0 {
 			return errs
 		}
 
-		tx, err := s.db.Begin(f.Ctx)
-		if err != nil {
-			return err
-		}
-		defer tx.Rollback(f.Ctx)
-
-		token, err := getToken(f, tx, in.Token, tokenKindResetPassword)
-		if err != nil {
-			return err
-		}
-
-		sql, args := psql.Select(
-			sm.From("auth.users"),
-			sm.Columns("id"),
-			sm.Where(psql.Quote("id").EQ(psql.Arg(token.UserID))),
-			sm.ForNoKeyUpdate(),
-		).MustBuild()
+		return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+			token, err := tx.Tokens().Get(f.Ctx, in.Token, tokenKindResetPassword, f.Time)
+			if err != nil {
+				return err
+			}
 
-		var userExists bool
-		if err := tx.QueryRow(f.Ctx, sqlutil.Exists(sql), args...).Scan(&userExists); err != nil {
-			return err
-		}
-		if !userExists {
-			return ErrUserNotFound
-		}
-		if err := updateUserPassword(f.Ctx, tx, token.UserID, in.Password); err != nil {
-			return err
-		}
-		if err := deleteUserSessions(f.Ctx, tx, token.UserID); err != nil {
-			return err
-		}
-		if err := deleteToken(f.Ctx, tx, token.ID); err != nil {
-			return err
-		}
-		if err := tx.Commit(f.Ctx); err != nil {
-			return err
-		}
-		return nil
+			user, err := tx.Users().Get(f.Ctx, token.UserID)
+			if err != nil {
+				return err
+			}
+			if err := updateUserPassword(f.Ctx, tx, token.UserID, in.Password); err != nil {
+				return err
+			}
+			if _, err := tx.Sessions().Delete(f.Ctx, nil, token.UserID); err != nil {
+				return err
+			}
+			if err := tx.Tokens().Delete(f.Ctx, token.ID); err != nil {
+				return err
+			}
+			if err := emit(f, tx, EventUserPasswordChanged, token.UserID, UserChange{UserID: token.UserID}); err != nil {
+				return err
+			}
+			return s.queueEmail(f, tx, &user.ID, EmailSecurityAlert, securityAlertData(user, SecurityAlertPasswordChanged))
+		})
 	}
 
 	var errs valid.Errors
 	if in.Username == "" {
-		errs = append(errs, valid.Error{Field: "username", Error: "Required."})
+		errs = append(errs, valid.Required("username"))
 	}
 	if len(errs) != 0 {
 		return errs
 	}
 
-	tx, err := s.db.Begin(f.Ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(f.Ctx)
-
-	sql, args := psql.Select(
-		sm.From("auth.users"),
-		sm.Columns("id", "email"),
-		sm.Where(psql.Quote("username").EQ(psql.Arg(in.Username))),
-	).MustBuild()
-
-	var userID string
-	var email string
-	if err := tx.QueryRow(f.Ctx, sql, args...).Scan(&userID, &email); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil
+	return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		user, err := tx.Users().GetByUsername(f.Ctx, in.Username)
+		if err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				return nil
+			}
+			return err
 		}
-		return err
-	}
-
-	token := Token{
-		ID:        mustGenerateToken(s.cfg.TokenLength),
-		UserID:    userID,
-		Kind:      tokenKindResetPassword,
-		CreatedAt: f.Time,
-		ExpiresAt: f.Time.Add(s.cfg.ResetPasswordTokenDuration),
-	}
-	if err := saveToken(f, tx, token); err != nil {
-		return err
-	}
 
-	// TODO: send email with token
-
-	if err := tx.Commit(f.Ctx); err != nil {
-		return err
-	}
-	return nil
+		token := Token{
+			ID:        mustGenerateToken(s.config().TokenLength),
+			UserID:    user.ID,
+			Kind:      tokenKindResetPassword,
+			CreatedAt: f.Time,
+			ExpiresAt: f.Time.Add(s.config().ResetPasswordTokenDuration),
+		}
+		if err := tx.Tokens().Save(f.Ctx, token); err != nil {
+			return err
+		}
+		return s.queueEmail(f, tx, &user.ID, EmailResetPassword, tokenEmailData(user, token))
+	})
 }
 
 // ForgotUsername...
 func (s *Service) ForgotUsername(f *flux.Flow, in ForgotUsernameInput) error {
 	var errs valid.Errors
 	if in.Email == "" {
-		errs = append(errs, valid.Error{Field: "email", Error: "Required."})
+		errs = append(errs, valid.Required("email"))
 	}
 	if len(errs) != 0 {
 		return errs
 	}
 
-	sql, args := psql.Select(
-		sm.From("auth.users"),
-		sm.Columns("username"),
-		sm.Where(psql.Quote("email").EQ(psql.Arg(in.Email))),
-	).MustBuild()
-
-	rows, err := s.db.Query(f.Ctx, sql, args...)
+	usernames, err := s.store.Users().UsernamesByEmail(f.Ctx, in.Email)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
 
-	var usernames []string
-	for rows.Next() {
-		var username string
-		if err := rows.Scan(&username); err != nil {
-			return err
-		}
-		usernames = append(usernames, username)
+	if len(usernames) == 0 {
+		return nil
 	}
+	return s.queueEmail(f, s.store, nil, EmailForgotUsername, EmailData{Email: in.Email, Usernames: usernames})
+}
 
-	if len(usernames) != 0 {
-		// TODO: send email with usernames
+// SetPassword sets the password of a user without requiring the current
+// password or a reset token, e.g. from the command line. All sessions of the
+// user are deleted.
+func (s *Service) SetPassword(f *flux.Flow, in SetPasswordInput) error {
+	var errs valid.Errors
+	if in.UserID == "" {
+		errs = append(errs, valid.Required("user_id"))
+	} else if !valid.IsUUID(in.UserID) {
+		errs = append(errs, valid.InvalidID("user_id"))
+	}
+	if in.Password == "" {
+		errs = append(errs, valid.Required("password"))
+	}
+	if len(errs) != 0 {
+		return errs
 	}
-	return nil
+
+	return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		user, err := tx.Users().Get(f.Ctx, in.UserID)
+		if err != nil {
+			return err
+		}
+		if err := updateUserPassword(f.Ctx, tx, in.UserID, in.Password); err != nil {
+			return err
+		}
+		if _, err := tx.Sessions().Delete(f.Ctx, nil, in.UserID); err != nil {
+			return err
+		}
+		if err := emit(f, tx, EventUserPasswordChanged, in.UserID, UserChange{UserID: in.UserID}); err != nil {
+			return err
+		}
+		return s.queueEmail(f, tx, &user.ID, EmailSecurityAlert, securityAlertData(user, SecurityAlertPasswordChanged))
+	})
 }
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"glut/common/flux"
+	"testing"
+	"time"
+)
+
+func TestRevertEmail(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	svc := NewService(store, &Config{RevertEmailTokenDuration: 24 * time.Hour})
+	f := &flux.Flow{Ctx: ctx, Time: testNow}
+
+	user, err := svc.CreateUser(f, CreateUserInput{Username: "alice", Email: "alice@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(kind string) Token {
+		t.Helper()
+		token, ok, err := store.Tokens().ByUser(ctx, user.ID, kind)
+		if err != nil || !ok {
+			t.Fatalf("%s token of alice: %v, %v", kind, ok, err)
+		}
+		return token
+	}
+	lastEmail := func() Email {
+		t.Helper()
+		page, err := svc.Emails(f, EmailQuery{UserID: user.ID, Sort: "created_at,desc"})
+		if err != nil || len(page.Items) == 0 {
+			t.Fatalf("emails of alice: %v, %v", page.Items, err)
+		}
+		return page.Items[0]
+	}
+	changeEmail := func(email, password string) {
+		t.Helper()
+		f.Time = f.Time.Add(time.Minute)
+		session := &flux.Flow{Ctx: ctx, Time: f.Time, Session: &flux.Session{User: user.ID}}
+		if err := svc.ChangeEmail(session, ChangeEmailInput{Email: email, Password: password}); err != nil {
+			t.Fatal(err)
+		}
+		f.Time = f.Time.Add(time.Minute)
+		if err := svc.ChangeEmail(f, ChangeEmailInput{Token: token(tokenKindChangeEmail).ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The previous address is alerted with a revert token, which is kept
+	// when the email is changed again.
+	changeEmail("mallory@example.com", "secret")
+	revert := token(tokenKindRevertEmail)
+	if revert.Get(tokenMetaOldEmail) != "alice@example.com" || !revert.ExpiresAt.Equal(f.Time.Add(24*time.Hour)) {
+		t.Errorf("revert token = %+v, want one restoring alice@example.com for 24h", revert)
+	}
+	if e := lastEmail(); e.Kind != EmailSecurityAlert || e.To != "alice@example.com" {
+		t.Errorf("last email = %+v, want a security alert to alice@example.com", e)
+	}
+	changeEmail("eve@example.com", "secret")
+	if token(tokenKindRevertEmail).ID != revert.ID {
+		t.Error("changing the email again replaced the revert token")
+	}
+	if e := lastEmail(); e.Kind != EmailSecurityAlert || e.To != "mallory@example.com" {
+		t.Errorf("last email = %+v, want a security alert to mallory@example.com", e)
+	}
+
+	// Reverting restores the email, deletes the sessions and the reset
+	// token of the attacker and sends a new one to the restored address.
+	if _, err := svc.CreateSession(f, Credentials{Username: "alice", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.ResetPassword(f, ResetPasswordInput{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	stolen := token(tokenKindResetPassword)
+	f.Time = f.Time.Add(time.Minute)
+	if err := svc.RevertEmail(f, RevertEmailInput{Token: revert.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := store.Users().Get(ctx, user.ID); err != nil || got.Email != "alice@example.com" {
+		t.Errorf("email of alice = %q, %v, want alice@example.com", got.Email, err)
+	}
+	if n, _, err := store.Sessions().Count(ctx, SessionQuery{UserID: user.ID}, ListParams{}); err != nil || n != 0 {
+		t.Errorf("alice has %d sessions, %v, want 0", n, err)
+	}
+	if _, err := svc.CreateSession(f, Credentials{Username: "alice", Password: "secret"}); err == nil {
+		t.Error("CreateSession with the previous password succeeded")
+	}
+	reset := token(tokenKindResetPassword)
+	if reset.ID == stolen.ID {
+		t.Error("the reset token of the attacker was kept")
+	}
+	if e := lastEmail(); e.Kind != EmailResetPassword || e.To != "alice@example.com" {
+		t.Errorf("last email = %+v, want a password reset to alice@example.com", e)
+	}
+	if err := svc.RevertEmail(f, RevertEmailInput{Token: revert.ID}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("RevertEmail with a used token: got %v, want ErrInvalidToken", err)
+	}
+	f.Time = f.Time.Add(time.Minute)
+	if err := svc.ResetPassword(f, ResetPasswordInput{Token: reset.ID, Password: "new secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.CreateSession(f, Credentials{Username: "alice", Password: "new secret"}); err != nil {
+		t.Errorf("CreateSession with the reset password: %v", err)
+	}
+	if e := lastEmail(); e.Kind != EmailSecurityAlert || e.Subject != "Your password was changed" {
+		t.Errorf("last email = %+v, want the password change alert", e)
+	}
+}
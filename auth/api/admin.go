@@ -55,6 +55,26 @@ func changeEmail(s *auth.Service) flux.HandlerFunc {
 	}
 }
 
+func revertEmail(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.RevertEmailInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		if err := s.RevertEmail(f, in); err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrInvalidToken) {
+				return ErrInvalidToken
+			}
+			return fmt.Errorf("api.revertEmail: %w", err)
+		}
+		return f.Respond(http.StatusOK, nil)
+	}
+}
+
 func verifyUser(s *auth.Service) flux.HandlerFunc {
 	return func(f *flux.Flow) error {
 		var in auth.VerifyUserInput
@@ -73,7 +93,7 @@ func verifyUser(s *auth.Service) flux.HandlerFunc {
 				return ErrUserVerified
 			}
 			if errors.Is(err, auth.ErrTryLater) {
-				return flux.TryLaterError("User verification was initiated recently. Try again later.")
+				return ErrVerificationTryLater
 			}
 			return fmt.Errorf("api.verifyUser: %w", err)
 		}
@@ -5,40 +5,83 @@ import (
 	"glut/common/flux"
 )
 
+// Permissions required by the handlers. They are granted to users through
+// their roles.
+const (
+	permSessionsRead   = "sessions:read"
+	permSessionsDelete = "sessions:delete"
+	permUsersRead      = "users:read"
+	permUsersDelete    = "users:delete"
+	permBansRead       = "bans:read"
+	permBansWrite      = "bans:write"
+	permRBACRead       = "rbac:read"
+	permRBACWrite      = "rbac:write"
+	permRBACCheck      = "rbac:check"
+	permWebhooksRead   = "webhooks:read"
+	permWebhooksWrite  = "webhooks:write"
+	permEmailsRead     = "emails:read"
+)
+
 func Handler(s *flux.Server, service *auth.Service) {
 	// Sessions API
-	s.Handle("auth.sessions.query", querySessions(service), &flux.Options{})
+	s.Handle("auth.sessions.query", querySessions(service), &flux.Options{Permissions: []string{permSessionsRead}})
 	s.Handle("auth.sessions.create", createSession(service), &flux.Options{})
-	s.Handle("auth.sessions.clear", clearSessions(service), &flux.Options{})
+	s.Handle("auth.sessions.clear", clearSessions(service), &flux.Options{Permissions: []string{permSessionsDelete}})
 
 	// Users API
-	s.Handle("auth.users.query", queryUsers(service), &flux.Options{})
+	s.Handle("auth.users.query", queryUsers(service), &flux.Options{Permissions: []string{permUsersRead}})
 	s.Handle("auth.users.create", createUser(service), &flux.Options{})
-	s.Handle("auth.users.delete", deleteUsers(service), &flux.Options{})
+	s.Handle("auth.users.delete", deleteUsers(service), &flux.Options{Permissions: []string{permUsersDelete}})
 
 	// Me API
-	s.Handle("auth.me.user", myUser(service), &flux.Options{})
-	s.Handle("auth.me.deleteUser", deleteMyUser(service), &flux.Options{})
-	s.Handle("auth.me.sessions", mySessions(service), &flux.Options{})
-	s.Handle("auth.me.logout", logout(service), &flux.Options{})
-	s.Handle("auth.me.renewSession", renewSession(service), &flux.Options{})
+	s.Handle("auth.me.user", myUser(service), &flux.Options{RequireAuth: true})
+	s.Handle("auth.me.deleteUser", deleteMyUser(service), &flux.Options{RequireAuth: true})
+	s.Handle("auth.me.sessions", mySessions(service), &flux.Options{RequireAuth: true})
+	s.Handle("auth.me.logout", logout(service), &flux.Options{RequireAuth: true})
+	s.Handle("auth.me.renewSession", renewSession(service), &flux.Options{RequireAuth: true})
+	s.Handle("auth.me.activateRoles", activateRoles(service), &flux.Options{RequireAuth: true})
+	s.Handle("auth.me.dropRoles", dropRoles(service), &flux.Options{RequireAuth: true})
+	s.Handle("auth.me.permissions", myPermissions(service), &flux.Options{RequireAuth: true})
 
 	// Admin API
-	s.Handle("auth.admin.changePassword", changePassword(service), &flux.Options{})
-	s.Handle("auth.admin.changeEmail", changeEmail(service), &flux.Options{})
+	s.Handle("auth.admin.changePassword", changePassword(service), &flux.Options{RequireAuth: true})
+	s.Handle("auth.admin.changeEmail", changeEmail(service), &flux.Options{RequireAuth: true})
+	s.Handle("auth.admin.revertEmail", revertEmail(service), &flux.Options{})
 	s.Handle("auth.admin.verifyUser", verifyUser(service), &flux.Options{})
 	s.Handle("auth.admin.resetPassword", resetPassword(service), &flux.Options{})
 	s.Handle("auth.admin.forgotUsername", forgotUsername(service), &flux.Options{})
 
 	// Security API
-	s.Handle("auth.security.bans", queryBans(service), &flux.Options{})
-	s.Handle("auth.security.banUser", banUser(service), &flux.Options{})
-	s.Handle("auth.security.unbanUser", unbanUser(service), &flux.Options{})
+	s.Handle("auth.security.bans", queryBans(service), &flux.Options{Permissions: []string{permBansRead}})
+	s.Handle("auth.security.banUser", banUser(service), &flux.Options{Permissions: []string{permBansWrite}})
+	s.Handle("auth.security.unbanUser", unbanUser(service), &flux.Options{Permissions: []string{permBansWrite}})
 
 	// RBAC API
-	s.Handle("auth.rbac.roles", queryRoles(service), &flux.Options{})
-	s.Handle("auth.rbac.createRole", createRole(service), &flux.Options{})
-	s.Handle("auth.rbac.updateRole", updateRole(service), &flux.Options{})
-	s.Handle("auth.rbac.deleteRole", deleteRole(service), &flux.Options{})
-	s.Handle("auth.rbac.permissions", queryPermissions(service), &flux.Options{})
+	s.Handle("auth.rbac.roles", queryRoles(service), &flux.Options{Permissions: []string{permRBACRead}})
+	s.Handle("auth.rbac.createRole", createRole(service), &flux.Options{Permissions: []string{permRBACWrite}})
+	s.Handle("auth.rbac.updateRole", updateRole(service), &flux.Options{Permissions: []string{permRBACWrite}})
+	s.Handle("auth.rbac.deleteRole", deleteRole(service), &flux.Options{Permissions: []string{permRBACWrite}})
+	s.Handle("auth.rbac.permissions", queryPermissions(service), &flux.Options{Permissions: []string{permRBACRead}})
+	s.Handle("auth.rbac.createPermission", createPermission(service), &flux.Options{Permissions: []string{permRBACWrite}})
+	s.Handle("auth.rbac.updatePermission", updatePermission(service), &flux.Options{Permissions: []string{permRBACWrite}})
+	s.Handle("auth.rbac.deletePermission", deletePermission(service), &flux.Options{Permissions: []string{permRBACWrite}})
+	s.Handle("auth.rbac.grantPermissions", grantPermissions(service), &flux.Options{Permissions: []string{permRBACWrite}})
+	s.Handle("auth.rbac.revokePermissions", revokePermissions(service), &flux.Options{Permissions: []string{permRBACWrite}})
+	s.Handle("auth.rbac.rolePermissions", queryRolePermissions(service), &flux.Options{Permissions: []string{permRBACRead}})
+	s.Handle("auth.rbac.userRoles", queryUserRoles(service), &flux.Options{Permissions: []string{permRBACRead}})
+	s.Handle("auth.rbac.roleMembers", queryRoleMembers(service), &flux.Options{Permissions: []string{permRBACRead}})
+	s.Handle("auth.rbac.assignRoles", assignRoles(service), &flux.Options{Permissions: []string{permRBACWrite}})
+	s.Handle("auth.rbac.unassignRoles", unassignRoles(service), &flux.Options{Permissions: []string{permRBACWrite}})
+	s.Handle("auth.rbac.check", checkPermissions(service), &flux.Options{Permissions: []string{permRBACCheck}})
+
+	// Webhooks API
+	s.Handle("auth.webhooks.query", queryWebhooks(service), &flux.Options{Permissions: []string{permWebhooksRead}})
+	s.Handle("auth.webhooks.create", createWebhook(service), &flux.Options{Permissions: []string{permWebhooksWrite}})
+	s.Handle("auth.webhooks.update", updateWebhook(service), &flux.Options{Permissions: []string{permWebhooksWrite}})
+	s.Handle("auth.webhooks.delete", deleteWebhooks(service), &flux.Options{Permissions: []string{permWebhooksWrite}})
+	s.Handle("auth.webhooks.deliveries", queryWebhookDeliveries(service), &flux.Options{Permissions: []string{permWebhooksRead}})
+	s.Handle("auth.webhooks.redeliver", redeliverWebhook(service), &flux.Options{Permissions: []string{permWebhooksWrite}})
+
+	// Emails API
+	s.Handle("auth.emails.query", queryEmails(service), &flux.Options{Permissions: []string{permEmailsRead}})
 }
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"glut/auth"
+	"glut/common/flux"
+	"glut/common/migrate"
+	"glut/common/sqlite"
+	"glut/db/migrations"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerPermissions(t *testing.T) {
+	store := auth.NewMemoryStore()
+	svc := auth.NewService(store, &auth.Config{})
+	s := flux.NewServer(&flux.ServerOptions{
+		Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Authenticator: auth.NewAuthenticator(store),
+	})
+	Handler(s, svc)
+	f := &flux.Flow{Ctx: context.Background(), Time: time.Now()}
+
+	// alice is a moderator, who may read users but not manage roles.
+	alice, err := svc.CreateUser(f, auth.CreateUserInput{Username: "alice", Email: "alice@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moderator, err := svc.CreateRole(f, auth.CreateRoleInput{Name: "moderator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := svc.CreatePermission(f, auth.CreatePermissionInput{Name: permUsersRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.GrantPermissions(f, auth.GrantPermissionsInput{RoleID: moderator.ID, PermissionIDs: []string{read.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.AssignRoles(f, auth.AssignRolesInput{UserID: alice.ID, RoleIDs: []string{moderator.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := svc.CreateSession(f, auth.Credentials{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assign := `{"user_id": "` + alice.ID + `", "role_ids": ["` + moderator.ID + `"]}`
+	for _, tt := range []struct {
+		name, token, body string
+		want              int
+	}{
+		{"auth.rbac.assignRoles", "", assign, http.StatusUnauthorized},
+		{"auth.rbac.assignRoles", sess.Token, assign, http.StatusForbidden},
+		{"auth.rbac.check", sess.Token, `{}`, http.StatusForbidden},
+		{"auth.webhooks.create", sess.Token, `{}`, http.StatusForbidden},
+		{"auth.users.query", "", `{}`, http.StatusUnauthorized},
+		{"auth.users.query", sess.Token, `{}`, http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/"+tt.name, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s with token %q: status %d, want %d: %s", tt.name, tt.token, w.Code, tt.want, w.Body)
+		}
+	}
+}
+
+// TestMigrationsGrantAdmin checks that the migrations grant the admin role
+// every permission required by the handlers.
+func TestMigrationsGrantAdmin(t *testing.T) {
+	ctx := context.Background()
+	all, err := migrations.SQLite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlite.Open(ctx, &sqlite.Config{URL: filepath.Join(t.TempDir(), "glut.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := migrate.NewSQLite(db, all, slog.New(slog.NewTextHandler(io.Discard, nil))).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := auth.NewService(auth.NewSQLiteStore(db), &auth.Config{})
+	f := &flux.Flow{Ctx: ctx, Time: time.Now()}
+	roles, err := svc.Roles(f, auth.RoleQuery{Name: "admin"})
+	if err != nil || len(roles.Items) != 1 {
+		t.Fatalf("Roles(admin) = %+v, %v, want the admin role", roles.Items, err)
+	}
+	granted, err := svc.Permissions(f, auth.PermissionQuery{RoleID: roles.Items[0].ID, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range granted.Items {
+		names = append(names, p.Name)
+	}
+	for _, name := range []string{
+		permSessionsRead, permSessionsDelete, permUsersRead, permUsersDelete,
+		permBansRead, permBansWrite, permRBACRead, permRBACWrite, permRBACCheck,
+		permWebhooksRead, permWebhooksWrite, permEmailsRead,
+	} {
+		if !slices.Contains(names, name) {
+			t.Errorf("admin is not granted %s, granted %v", name, names)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"glut/auth"
+	"glut/common/flux"
+	"glut/common/valid"
+)
+
+func queryEmails(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.EmailQuery
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		emails, err := s.Emails(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrEmailNotFound) {
+				return ErrEmailNotFound
+			}
+			return fmt.Errorf("api.queryEmails: %w", err)
+		}
+		return respondPage(f, in.Envelope || in.Cursor != nil, emails)
+	}
+}
@@ -6,10 +6,30 @@ import (
 )
 
 var (
-	ErrInvalidToken       = flux.NewError("invalid_token", http.StatusUnprocessableEntity, "Invalid token.")
-	ErrUserVerified       = flux.NewError("user_verified", http.StatusConflict, "User already verified.")
-	ErrInvalidPassword    = flux.NewError("invalid_password", http.StatusForbidden, "Invalid password.")
-	ErrInvalidCredentials = flux.NewError("invalid_credentials", http.StatusUnauthorized, "Invalid credentials.")
-	ErrUserBanned         = flux.NewError("user_banned", http.StatusForbidden, "User is banned.")
-	ErrSessionLimit       = flux.NewError("session_limit", http.StatusConflict, "Session limit reached.")
+	ErrInvalidToken         = flux.NewError("invalid_token", http.StatusUnprocessableEntity, "Invalid token.")
+	ErrUserVerified         = flux.NewError("user_verified", http.StatusConflict, "User already verified.")
+	ErrInvalidPassword      = flux.NewError("invalid_password", http.StatusForbidden, "Invalid password.")
+	ErrInvalidCredentials   = flux.NewError("invalid_credentials", http.StatusUnauthorized, "Invalid credentials.")
+	ErrUserBanned           = flux.NewError("user_banned", http.StatusForbidden, "User is banned.")
+	ErrSessionLimit         = flux.NewError("session_limit", http.StatusConflict, "Session limit reached.")
+	ErrUserNotFound         = flux.NotFoundError("User not found.").SetParams(resource("user"))
+	ErrUserExists           = flux.ExistsError("User already exists.").SetParams(resource("user"))
+	ErrBanNotFound          = flux.NotFoundError("Ban not found.").SetParams(resource("ban"))
+	ErrBanExists            = flux.ExistsError("Ban already exists.").SetParams(resource("ban"))
+	ErrRoleNotFound         = flux.NotFoundError("Role not found.").SetParams(resource("role"))
+	ErrRoleExists           = flux.ExistsError("Role already exists.").SetParams(resource("role"))
+	ErrPermissionNotFound   = flux.NotFoundError("Permission not found.").SetParams(resource("permission"))
+	ErrPermissionExists     = flux.ExistsError("Permission already exists.").SetParams(resource("permission"))
+	ErrWebhookNotFound      = flux.NotFoundError("Webhook not found.").SetParams(resource("webhook"))
+	ErrDeliveryNotFound     = flux.NotFoundError("Webhook delivery not found.").SetParams(resource("delivery"))
+	ErrEmailNotFound        = flux.NotFoundError("Email not found.").SetParams(resource("email"))
+	ErrWebhookDisabled      = flux.NewError("webhook_disabled", http.StatusConflict, "Webhook is disabled.")
+	ErrLastRoleHolder       = flux.NewError("last_role_holder", http.StatusConflict, "Cannot remove the last holder of a protected role.")
+	ErrVerificationTryLater = flux.TryLaterError("User verification was initiated recently. Try again later.").SetParams(resource("verification"))
 )
+
+// resource returns the error parameters identifying the resource an error
+// refers to.
+func resource(name string) map[string]any {
+	return map[string]any{"resource": name}
+}
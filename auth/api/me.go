@@ -18,11 +18,11 @@ func myUser(s *auth.Service) flux.HandlerFunc {
 				return flux.ValidationError(verr)
 			}
 			if errors.Is(err, auth.ErrUserNotFound) {
-				return flux.NotFoundError("User not found.")
+				return ErrUserNotFound
 			}
 			return fmt.Errorf("api.myUser: %w", err)
 		}
-		return f.Respond(http.StatusOK, users[0])
+		return f.Respond(http.StatusOK, users.Items[0])
 	}
 }
 
@@ -35,7 +35,7 @@ func mySessions(s *auth.Service) flux.HandlerFunc {
 			}
 			return fmt.Errorf("api.mySessions: %w", err)
 		}
-		return f.Respond(http.StatusOK, sessions)
+		return f.Respond(http.StatusOK, sessions.Items)
 	}
 }
 
@@ -92,3 +92,69 @@ func deleteMyUser(s *auth.Service) flux.HandlerFunc {
 		return f.Respond(http.StatusOK, nil)
 	}
 }
+
+func activateRoles(s *auth.Service) flux.HandlerFunc {
+	type response struct {
+		Count int `json:"count"`
+	}
+
+	return func(f *flux.Flow) error {
+		var in auth.ActivateRolesInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		count, err := s.ActivateRoles(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrUnauthorized) || errors.Is(err, auth.ErrSessionNotFound) {
+				return flux.UnauthorizedError
+			}
+			if errors.Is(err, auth.ErrRoleNotFound) {
+				return ErrRoleNotFound
+			}
+			return fmt.Errorf("api.activateRoles: %w", err)
+		}
+		return f.Respond(http.StatusOK, response{count})
+	}
+}
+
+func dropRoles(s *auth.Service) flux.HandlerFunc {
+	type response struct {
+		Count int `json:"count"`
+	}
+
+	return func(f *flux.Flow) error {
+		var in auth.DropRolesInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		count, err := s.DropRoles(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrUnauthorized) {
+				return flux.UnauthorizedError
+			}
+			return fmt.Errorf("api.dropRoles: %w", err)
+		}
+		return f.Respond(http.StatusOK, response{count})
+	}
+}
+
+func myPermissions(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		permissions, err := s.MyPermissions(f)
+		if err != nil {
+			if errors.Is(err, auth.ErrUnauthorized) {
+				return flux.UnauthorizedError
+			}
+			return fmt.Errorf("api.myPermissions: %w", err)
+		}
+		return f.Respond(http.StatusOK, permissions)
+	}
+}
@@ -0,0 +1,16 @@
+package api
+
+import (
+	"glut/common/flux"
+	"glut/common/sqlutil"
+	"net/http"
+)
+
+// respondPage responds with the items of page or, if the query requested an
+// envelope or was paginated by cursor, with the page.
+func respondPage[T any](f *flux.Flow, envelope bool, page sqlutil.Page[T]) error {
+	if envelope {
+		return f.Respond(http.StatusOK, page)
+	}
+	return f.Respond(http.StatusOK, page.Items)
+}
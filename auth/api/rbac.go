@@ -22,11 +22,14 @@ func queryRoles(s *auth.Service) flux.HandlerFunc {
 				return flux.ValidationError(verr)
 			}
 			if errors.Is(err, auth.ErrRoleNotFound) {
-				return flux.NotFoundError("Role not found.")
+				return ErrRoleNotFound
+			}
+			if errors.Is(err, auth.ErrPermissionNotFound) {
+				return ErrPermissionNotFound
 			}
 			return fmt.Errorf("api.queryRoles: %w", err)
 		}
-		return f.Respond(http.StatusOK, roles)
+		return respondPage(f, in.Envelope || in.Cursor != nil, roles)
 	}
 }
 
@@ -43,7 +46,7 @@ func createRole(s *auth.Service) flux.HandlerFunc {
 				return flux.ValidationError(verr)
 			}
 			if errors.Is(err, auth.ErrRoleExists) {
-				return flux.ExistsError("Role already exists.")
+				return ErrRoleExists
 			}
 			return fmt.Errorf("api.createRole: %w", err)
 		}
@@ -63,10 +66,13 @@ func updateRole(s *auth.Service) flux.HandlerFunc {
 				return flux.ValidationError(verr)
 			}
 			if errors.Is(err, auth.ErrRoleNotFound) {
-				return flux.NotFoundError("Role not found.")
+				return ErrRoleNotFound
 			}
 			if errors.Is(err, auth.ErrRoleExists) {
-				return flux.ExistsError("Role already exists.")
+				return ErrRoleExists
+			}
+			if errors.Is(err, auth.ErrLastRoleHolder) {
+				return ErrLastRoleHolder
 			}
 			return fmt.Errorf("api.updateRole: %w", err)
 		}
@@ -90,6 +96,9 @@ func deleteRole(s *auth.Service) flux.HandlerFunc {
 			if verr, ok := err.(valid.Errors); ok {
 				return flux.ValidationError(verr)
 			}
+			if errors.Is(err, auth.ErrLastRoleHolder) {
+				return ErrLastRoleHolder
+			}
 			return fmt.Errorf("api.deleteRole: %w", err)
 		}
 		return f.Respond(http.StatusOK, &response{count})
@@ -109,10 +118,263 @@ func queryPermissions(s *auth.Service) flux.HandlerFunc {
 				return flux.ValidationError(verr)
 			}
 			if errors.Is(err, auth.ErrPermissionNotFound) {
-				return flux.NotFoundError("Permission not found.")
+				return ErrPermissionNotFound
+			}
+			if errors.Is(err, auth.ErrRoleNotFound) {
+				return ErrRoleNotFound
 			}
 			return fmt.Errorf("api.queryPermissions: %w", err)
 		}
-		return f.Respond(http.StatusOK, permissions)
+		return respondPage(f, in.Envelope || in.Cursor != nil, permissions)
+	}
+}
+
+func createPermission(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.CreatePermissionInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		permission, err := s.CreatePermission(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrPermissionExists) {
+				return ErrPermissionExists
+			}
+			return fmt.Errorf("api.createPermission: %w", err)
+		}
+		return f.Respond(http.StatusOK, permission)
+	}
+}
+
+func updatePermission(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.UpdatePermissionInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		if err := s.UpdatePermission(f, in); err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrPermissionNotFound) {
+				return ErrPermissionNotFound
+			}
+			if errors.Is(err, auth.ErrPermissionExists) {
+				return ErrPermissionExists
+			}
+			return fmt.Errorf("api.updatePermission: %w", err)
+		}
+		return f.Respond(http.StatusOK, nil)
+	}
+}
+
+func deletePermission(s *auth.Service) flux.HandlerFunc {
+	type response struct {
+		Count int `json:"count"`
+	}
+
+	return func(f *flux.Flow) error {
+		var in auth.DeletePermissionInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		count, err := s.DeletePermission(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			return fmt.Errorf("api.deletePermission: %w", err)
+		}
+		return f.Respond(http.StatusOK, &response{count})
+	}
+}
+
+func grantPermissions(s *auth.Service) flux.HandlerFunc {
+	type response struct {
+		Count int `json:"count"`
+	}
+
+	return func(f *flux.Flow) error {
+		var in auth.GrantPermissionsInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		count, err := s.GrantPermissions(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrRoleNotFound) {
+				return ErrRoleNotFound
+			}
+			if errors.Is(err, auth.ErrPermissionNotFound) {
+				return ErrPermissionNotFound
+			}
+			return fmt.Errorf("api.grantPermissions: %w", err)
+		}
+		return f.Respond(http.StatusOK, &response{count})
+	}
+}
+
+func revokePermissions(s *auth.Service) flux.HandlerFunc {
+	type response struct {
+		Count int `json:"count"`
+	}
+
+	return func(f *flux.Flow) error {
+		var in auth.RevokePermissionsInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		count, err := s.RevokePermissions(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			return fmt.Errorf("api.revokePermissions: %w", err)
+		}
+		return f.Respond(http.StatusOK, &response{count})
+	}
+}
+
+func queryRolePermissions(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.RolePermissionQuery
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		rolePermissions, err := s.RolePermissions(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrRoleNotFound) {
+				return ErrRoleNotFound
+			}
+			return fmt.Errorf("api.queryRolePermissions: %w", err)
+		}
+		return respondPage(f, in.Envelope || in.Cursor != nil, rolePermissions)
+	}
+}
+
+func queryUserRoles(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.UserRoleQuery
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		userRoles, err := s.UserRoles(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrUserNotFound) {
+				return ErrUserNotFound
+			}
+			return fmt.Errorf("api.queryUserRoles: %w", err)
+		}
+		return respondPage(f, in.Envelope || in.Cursor != nil, userRoles)
+	}
+}
+
+func queryRoleMembers(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.UserRoleQuery
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		members, err := s.RoleMembers(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrRoleNotFound) {
+				return ErrRoleNotFound
+			}
+			return fmt.Errorf("api.queryRoleMembers: %w", err)
+		}
+		return respondPage(f, in.Envelope || in.Cursor != nil, members)
+	}
+}
+
+func assignRoles(s *auth.Service) flux.HandlerFunc {
+	type response struct {
+		Count int `json:"count"`
+	}
+
+	return func(f *flux.Flow) error {
+		var in auth.AssignRolesInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		count, err := s.AssignRoles(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrUserNotFound) {
+				return ErrUserNotFound
+			}
+			if errors.Is(err, auth.ErrRoleNotFound) {
+				return ErrRoleNotFound
+			}
+			return fmt.Errorf("api.assignRoles: %w", err)
+		}
+		return f.Respond(http.StatusOK, &response{count})
+	}
+}
+
+func unassignRoles(s *auth.Service) flux.HandlerFunc {
+	type response struct {
+		Count int `json:"count"`
+	}
+
+	return func(f *flux.Flow) error {
+		var in auth.UnassignRolesInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		count, err := s.UnassignRoles(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrLastRoleHolder) {
+				return ErrLastRoleHolder
+			}
+			return fmt.Errorf("api.unassignRoles: %w", err)
+		}
+		return f.Respond(http.StatusOK, &response{count})
+	}
+}
+
+func checkPermissions(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.CheckPermissionsInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		results, err := s.CheckPermissions(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			return fmt.Errorf("api.checkPermissions: %w", err)
+		}
+		return f.Respond(http.StatusOK, results)
 	}
 }
@@ -22,11 +22,11 @@ func queryBans(s *auth.Service) flux.HandlerFunc {
 				return flux.ValidationError(verr)
 			}
 			if errors.Is(err, auth.ErrBanNotFound) {
-				return flux.NotFoundError("Ban not found.")
+				return ErrBanNotFound
 			}
 			return fmt.Errorf("api.queryBans: %w", err)
 		}
-		return f.Respond(http.StatusOK, bans)
+		return respondPage(f, in.Envelope || in.Cursor != nil, bans)
 	}
 }
 
@@ -43,10 +43,10 @@ func banUser(s *auth.Service) flux.HandlerFunc {
 				return flux.ValidationError(verr)
 			}
 			if errors.Is(err, auth.ErrUserNotFound) {
-				return flux.NotFoundError("User not found.")
+				return ErrUserNotFound
 			}
 			if errors.Is(err, auth.ErrBanExists) {
-				return flux.ExistsError("Ban already exists.")
+				return ErrBanExists
 			}
 			return fmt.Errorf("api.banUser: %w", err)
 		}
@@ -66,7 +66,7 @@ func unbanUser(s *auth.Service) flux.HandlerFunc {
 				return flux.ValidationError(verr)
 			}
 			if errors.Is(err, auth.ErrBanNotFound) {
-				return flux.NotFoundError("Ban not found.")
+				return ErrBanNotFound
 			}
 			return fmt.Errorf("api.unbanUser: %w", err)
 		}
@@ -23,7 +23,7 @@ func querySessions(s *auth.Service) flux.HandlerFunc {
 			}
 			return fmt.Errorf("api.QuerySessions: %w", err)
 		}
-		return f.Respond(http.StatusOK, sessions)
+		return respondPage(f, q.Envelope || q.Cursor != nil, sessions)
 	}
 }
 
@@ -48,6 +48,9 @@ func createSession(s *auth.Service) flux.HandlerFunc {
 			if errors.Is(err, auth.ErrSessionLimit) {
 				return ErrSessionLimit
 			}
+			if errors.Is(err, auth.ErrRoleNotFound) {
+				return ErrRoleNotFound
+			}
 			return fmt.Errorf("api.createSession: %w", err)
 		}
 		return f.Respond(http.StatusOK, sess)
@@ -21,11 +21,11 @@ func queryUsers(s *auth.Service) flux.HandlerFunc {
 				return flux.ValidationError(verr)
 			}
 			if errors.Is(err, auth.ErrUserNotFound) {
-				return flux.NotFoundError("User not found.")
+				return ErrUserNotFound
 			}
 			return fmt.Errorf("api.queryUsers: %w", err)
 		}
-		return f.Respond(http.StatusOK, users)
+		return respondPage(f, in.Envelope || in.Cursor != nil, users)
 	}
 }
 
@@ -42,7 +42,7 @@ func createUser(s *auth.Service) flux.HandlerFunc {
 				return flux.ValidationError(verr)
 			}
 			if errors.Is(err, auth.ErrUserExists) {
-				return flux.ExistsError("User already exists.")
+				return ErrUserExists
 			}
 			return fmt.Errorf("api.createUser: %w", err)
 		}
@@ -66,6 +66,9 @@ func deleteUsers(s *auth.Service) flux.HandlerFunc {
 			if verr, ok := err.(valid.Errors); ok {
 				return flux.ValidationError(verr)
 			}
+			if errors.Is(err, auth.ErrLastRoleHolder) {
+				return ErrLastRoleHolder
+			}
 			return fmt.Errorf("api.deleteUsers: %w", err)
 		}
 		return f.Respond(http.StatusOK, &response{count})
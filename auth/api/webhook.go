@@ -0,0 +1,138 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"glut/auth"
+	"glut/common/flux"
+	"glut/common/valid"
+	"net/http"
+)
+
+func queryWebhooks(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.WebhookQuery
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		webhooks, err := s.Webhooks(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrWebhookNotFound) {
+				return ErrWebhookNotFound
+			}
+			return fmt.Errorf("api.queryWebhooks: %w", err)
+		}
+		return respondPage(f, in.Envelope || in.Cursor != nil, webhooks)
+	}
+}
+
+func createWebhook(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.CreateWebhookInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		webhook, err := s.CreateWebhook(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			return fmt.Errorf("api.createWebhook: %w", err)
+		}
+		return f.Respond(http.StatusOK, webhook)
+	}
+}
+
+func updateWebhook(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.UpdateWebhookInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		if err := s.UpdateWebhook(f, in); err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrWebhookNotFound) {
+				return ErrWebhookNotFound
+			}
+			return fmt.Errorf("api.updateWebhook: %w", err)
+		}
+		return f.Respond(http.StatusOK, nil)
+	}
+}
+
+func deleteWebhooks(s *auth.Service) flux.HandlerFunc {
+	type response struct {
+		Count int `json:"count"`
+	}
+
+	return func(f *flux.Flow) error {
+		var in auth.DeleteWebhooksInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		count, err := s.DeleteWebhooks(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			return fmt.Errorf("api.deleteWebhooks: %w", err)
+		}
+		return f.Respond(http.StatusOK, &response{count})
+	}
+}
+
+func queryWebhookDeliveries(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.WebhookDeliveryQuery
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		deliveries, err := s.WebhookDeliveries(f, in)
+		if err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrWebhookNotFound) {
+				return ErrWebhookNotFound
+			}
+			if errors.Is(err, auth.ErrDeliveryNotFound) {
+				return ErrDeliveryNotFound
+			}
+			return fmt.Errorf("api.queryWebhookDeliveries: %w", err)
+		}
+		return respondPage(f, in.Envelope || in.Cursor != nil, deliveries)
+	}
+}
+
+func redeliverWebhook(s *auth.Service) flux.HandlerFunc {
+	return func(f *flux.Flow) error {
+		var in auth.RedeliverWebhookInput
+		if err := f.Bind(&in); err != nil {
+			return err
+		}
+
+		if err := s.RedeliverWebhook(f, in); err != nil {
+			if verr, ok := err.(valid.Errors); ok {
+				return flux.ValidationError(verr)
+			}
+			if errors.Is(err, auth.ErrDeliveryNotFound) {
+				return ErrDeliveryNotFound
+			}
+			if errors.Is(err, auth.ErrWebhookDisabled) {
+				return ErrWebhookDisabled
+			}
+			return fmt.Errorf("api.redeliverWebhook: %w", err)
+		}
+		return f.Respond(http.StatusOK, nil)
+	}
+}
@@ -3,38 +3,35 @@ package auth
 import (
 	"errors"
 	"glut/common/flux"
-
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/stephenafamo/bob/dialect/psql"
-	"github.com/stephenafamo/bob/dialect/psql/sm"
+	"glut/common/postgres"
 )
 
 // NewAuthenticator...
-func NewAuthenticator(db *pgxpool.Pool) flux.Authenticator {
+//
+// Sessions and their permissions are read from the primary database, so that
+// new sessions and roles activated or dropped just before are observed.
+func NewAuthenticator(store Store) flux.Authenticator {
 	return func(f *flux.Flow, token string) (*flux.Session, error) {
-		sql, args := psql.Select(
-			sm.From("auth.sessions"),
-			sm.Columns("id", "user_id"),
-			psql.WhereAnd(
-				sm.Where(psql.Quote("token").EQ(psql.Arg(token))),
-				sm.Where(psql.Quote("expires_at").GT(psql.Arg(f.Time))),
-			),
-		).MustBuild()
-
-		var id string
-		var userID string
-		if err := db.QueryRow(f.Ctx, sql, args...).Scan(&id, &userID); err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
+		ctx := postgres.ReadYourWrites(f.Ctx)
+		sess, err := store.Sessions().Authenticate(ctx, token, f.Time)
+		if err != nil {
+			if errors.Is(err, ErrSessionNotFound) {
 				return nil, flux.UnauthorizedError
 			}
 			return nil, err
 		}
 
+		// Only the roles active in the session grant permissions.
+		grants, err := store.Sessions().Grants(ctx, sess.ID)
+		if err != nil {
+			return nil, err
+		}
+
 		res := &flux.Session{
-			ID:   id,
-			IP:   f.IP,
-			User: userID,
+			ID:          sess.ID,
+			IP:          f.IP,
+			User:        sess.UserID,
+			Permissions: permissionNames(resolvePermissions(grants)),
 		}
 		return res, nil
 	}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"glut/common/flux"
+	"glut/common/postgres"
+	"glut/common/valid"
+	"slices"
+)
+
+const maxPermissionChecks = 100
+
+// Reasons of the decisions of permission checks.
+const (
+	CheckGranted           = "granted"
+	CheckNotGranted        = "not_granted"
+	CheckUnknownPermission = "unknown_permission"
+	CheckUserNotFound      = "user_not_found"
+	CheckUserBanned        = "user_banned"
+)
+
+// EffectivePermission is a permission granted to a user with the roles that
+// grant it.
+type EffectivePermission struct {
+	ID    string         `json:"id"`
+	Name  string         `json:"name"`
+	Roles []GrantingRole `json:"roles"`
+}
+
+type GrantingRole struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type CheckPermissionsInput struct {
+	Checks []PermissionCheck `json:"checks"`
+}
+
+// PermissionCheck asks whether the user with UserID has the permission named
+// Permission.
+type PermissionCheck struct {
+	UserID     string `json:"user_id"`
+	Permission string `json:"permission"`
+}
+
+// PermissionCheckResult is the decision of a PermissionCheck. Reason explains
+// it and Roles are the roles of the user granting the permission, if allowed.
+type PermissionCheckResult struct {
+	UserID     string         `json:"user_id"`
+	Permission string         `json:"permission"`
+	Allowed    bool           `json:"allowed"`
+	Reason     string         `json:"reason"`
+	Roles      []GrantingRole `json:"roles"`
+}
+
+// resolvePermissions returns the effective permissions of grants ordered by
+// permission and role name. It is how the permissions of sessions, which
+// handlers require with flux.Options.Permissions, are resolved.
+func resolvePermissions(grants []RoleGrant) []EffectivePermission {
+	permissions := []EffectivePermission{}
+	for _, g := range grants {
+		n := len(permissions)
+		if n == 0 || permissions[n-1].ID != g.PermissionID {
+			permissions = append(permissions, EffectivePermission{ID: g.PermissionID, Name: g.PermissionName})
+			n++
+		}
+		permissions[n-1].Roles = append(permissions[n-1].Roles, GrantingRole{ID: g.RoleID, Name: g.RoleName})
+	}
+	return permissions
+}
+
+// permissionNames returns the names of permissions.
+func permissionNames(permissions []EffectivePermission) []string {
+	names := make([]string, len(permissions))
+	for i, p := range permissions {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// MyPermissions returns the effective permissions of the session of f, which
+// are granted by its active roles. They are read from the primary database
+// to reflect roles activated or dropped just before.
+func (s *Service) MyPermissions(f *flux.Flow) ([]EffectivePermission, error) {
+	if f.Session == nil {
+		return nil, ErrUnauthorized
+	}
+	grants, err := s.store.Sessions().Grants(postgres.ReadYourWrites(f.Ctx), f.Session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return resolvePermissions(grants), nil
+}
+
+// CheckPermissions decides whether users have permissions through any of the
+// roles assigned to them, regardless of the roles active in their sessions.
+// Banned users have no permissions.
+func (s *Service) CheckPermissions(f *flux.Flow, in CheckPermissionsInput) ([]PermissionCheckResult, error) {
+	var errs valid.Errors
+	if len(in.Checks) == 0 {
+		errs = append(errs, valid.Required("checks"))
+	} else if len(in.Checks) > maxPermissionChecks {
+		errs = append(errs, valid.MaxItems("checks", maxPermissionChecks))
+	}
+	for i, c := range in.Checks {
+		name := fmt.Sprintf("checks[%d]", i)
+		if c.UserID == "" {
+			errs = append(errs, valid.Required(name+".user_id"))
+		} else if !valid.IsUUID(c.UserID) {
+			errs = append(errs, valid.InvalidID(name+".user_id"))
+		}
+		if c.Permission == "" {
+			errs = append(errs, valid.Required(name+".permission"))
+		}
+	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	names := make([]string, len(in.Checks))
+	for i, c := range in.Checks {
+		names[i] = c.Permission
+	}
+	known, err := s.store.Permissions().Existing(f.Ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	// reasons are the reasons users have no permissions, if any.
+	reasons := make(map[string]string)
+	permissions := make(map[string]map[string]EffectivePermission)
+	results := make([]PermissionCheckResult, len(in.Checks))
+	for i, c := range in.Checks {
+		if _, ok := permissions[c.UserID]; !ok {
+			reason, userPermissions, err := s.userPermissions(f, c.UserID)
+			if err != nil {
+				return nil, err
+			}
+			reasons[c.UserID], permissions[c.UserID] = reason, userPermissions
+		}
+
+		res := PermissionCheckResult{UserID: c.UserID, Permission: c.Permission, Roles: []GrantingRole{}}
+		p, granted := permissions[c.UserID][c.Permission]
+		switch {
+		case reasons[c.UserID] != "":
+			res.Reason = reasons[c.UserID]
+		case !slices.Contains(known, c.Permission):
+			res.Reason = CheckUnknownPermission
+		case !granted:
+			res.Reason = CheckNotGranted
+		default:
+			res.Allowed, res.Reason, res.Roles = true, CheckGranted, p.Roles
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+// userPermissions returns the effective permissions of the user with id by
+// name, or the reason the user has none.
+func (s *Service) userPermissions(f *flux.Flow, id string) (string, map[string]EffectivePermission, error) {
+	if _, err := s.store.Users().Get(f.Ctx, id); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return CheckUserNotFound, nil, nil
+		}
+		return "", nil, err
+	}
+	banned, err := s.store.Bans().Active(f.Ctx, id, f.Time)
+	if err != nil {
+		return "", nil, err
+	}
+	if banned {
+		return CheckUserBanned, nil, nil
+	}
+
+	grants, err := s.store.UserRoles().Grants(f.Ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	permissions := make(map[string]EffectivePermission)
+	for _, p := range resolvePermissions(grants) {
+		permissions[p.Name] = p
+	}
+	return "", permissions, nil
+}
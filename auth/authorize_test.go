@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"glut/common/flux"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	svc := NewService(store, &Config{})
+	f := &flux.Flow{Ctx: ctx, Time: testNow}
+
+	alice := createTestUser(t, store, "alice")
+	bob := createTestUser(t, store, "bob")
+	editor := createTestRBAC(t, store, alice.ID, bob.ID).editor
+	if err := store.Bans().Put(ctx, Ban{UserID: bob.ID, Reason: "spam", BannedAt: testNow, UnbannedAt: testNow.Add(time.Hour)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.UnassignRoles(f, UnassignRolesInput{UserID: alice.ID, RoleIDs: []string{editor.ID}}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := svc.CheckPermissions(f, CheckPermissionsInput{Checks: []PermissionCheck{
+		{UserID: alice.ID, Permission: "posts.write"},
+		{UserID: alice.ID, Permission: "posts.delete"},
+		{UserID: bob.ID, Permission: "posts.read"},
+		{UserID: uuid.New().String(), Permission: "posts.read"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		allowed bool
+		reason  string
+		roles   int
+	}{
+		{true, CheckGranted, 1},
+		{false, CheckUnknownPermission, 0},
+		{false, CheckUserBanned, 0},
+		{false, CheckUserNotFound, 0},
+	} {
+		got := results[i]
+		if got.Allowed != want.allowed || got.Reason != want.reason || len(got.Roles) != want.roles {
+			t.Errorf("check %d = %+v, want allowed %v because %s", i, got, want.allowed, want.reason)
+		}
+	}
+	if roles := results[0].Roles; len(roles) == 1 && roles[0].Name != "admin" {
+		t.Errorf("posts.write of alice granted by %+v, want admin", roles)
+	}
+
+	// Sessions only have the permissions of their active roles.
+	if _, err := svc.AssignRoles(f, AssignRolesInput{UserID: alice.ID, RoleIDs: []string{editor.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := createTestSession(store, alice.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Sessions().ActivateRoles(ctx, sess.ID, []string{editor.ID}); err != nil {
+		t.Fatal(err)
+	}
+	sf := &flux.Flow{Ctx: ctx, Time: testNow, Session: &flux.Session{ID: sess.ID, User: alice.ID}}
+	permissions, err := svc.MyPermissions(sf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 1 || permissions[0].Name != "posts.read" || len(permissions[0].Roles) != 1 || permissions[0].Roles[0].ID != editor.ID {
+		t.Errorf("MyPermissions = %+v, want posts.read by editor", permissions)
+	}
+	results, err = svc.CheckPermissions(f, CheckPermissionsInput{Checks: []PermissionCheck{{UserID: alice.ID, Permission: "posts.read"}}})
+	if err != nil || len(results) != 1 || !results[0].Allowed || len(results[0].Roles) != 2 {
+		t.Errorf("check of posts.read of alice = %+v, %v, want granted by admin and editor", results, err)
+	}
+}
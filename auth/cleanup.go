@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"glut/common/scheduler"
+	"log/slog"
+	"time"
+)
+
+// CleanupJobs returns the jobs deleting, every interval, the sessions and
+// tokens that expired and the bans that lapsed. These are only filtered out
+// when read, so they would pile up otherwise.
+func CleanupJobs(store Store, interval time.Duration, logger *slog.Logger) []scheduler.Job {
+	job := func(name, rows string, purge func(ctx context.Context, before time.Time) (int, error)) scheduler.Job {
+		return scheduler.Job{
+			Name:     name,
+			Interval: interval,
+			Run: func(ctx context.Context) error {
+				n, err := purge(ctx, time.Now())
+				if err != nil {
+					return err
+				}
+				if n != 0 {
+					logger.Debug("Purged "+rows+".", slog.Int(rows, n))
+				}
+				return nil
+			},
+		}
+	}
+	return []scheduler.Job{
+		job("auth.purge_sessions", "sessions", func(ctx context.Context, before time.Time) (int, error) {
+			return store.Sessions().Purge(ctx, before)
+		}),
+		job("auth.purge_tokens", "tokens", func(ctx context.Context, before time.Time) (int, error) {
+			return store.Tokens().Purge(ctx, before)
+		}),
+		job("auth.purge_bans", "bans", func(ctx context.Context, before time.Time) (int, error) {
+			return store.Bans().Purge(ctx, before)
+		}),
+	}
+}
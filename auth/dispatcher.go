@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDispatchPollInterval = time.Second
+	defaultDispatchBatchSize    = 100
+	defaultDispatchLease        = time.Minute
+	defaultDispatchMaxAttempts  = 10
+	defaultDispatchMinBackoff   = time.Second
+	defaultDispatchMaxBackoff   = time.Hour
+	defaultDispatchRetention    = 7 * 24 * time.Hour
+
+	// dispatchPurgeInterval is how often delivered events are purged.
+	dispatchPurgeInterval = time.Hour
+)
+
+// Sink receives the events of the outbox. Events are delivered at least
+// once, so sinks must tolerate duplicates, e.g. by their ids.
+type Sink interface {
+	Deliver(ctx context.Context, e Event) error
+}
+
+// SinkFunc is a function used as a Sink.
+type SinkFunc func(ctx context.Context, e Event) error
+
+func (fn SinkFunc) Deliver(ctx context.Context, e Event) error {
+	return fn(ctx, e)
+}
+
+// DispatcherOptions...
+type DispatcherOptions struct {
+	Logger *slog.Logger
+	// PollInterval is how long the dispatcher waits for new events after
+	// finding none.
+	PollInterval time.Duration
+	// BatchSize is the number of events claimed at once.
+	BatchSize int
+	// Lease is how long claimed events are reserved for the dispatcher.
+	// Events not delivered in time are claimed again, e.g. by another
+	// instance if this one stopped.
+	Lease time.Duration
+	// MaxAttempts is the number of failed deliveries after which an event
+	// is dead.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the delay before retrying a failed
+	// delivery, which doubles with each attempt.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Retention is how long delivered events are kept.
+	Retention time.Duration
+}
+
+// Dispatcher delivers the events of the outbox to its sinks. An event is
+// delivered once all sinks accept it; if one fails, the event is delivered
+// again to all of them later. The events of an aggregate are delivered in
+// order, a failed event holding back the following ones until it is
+// delivered or dead.
+type Dispatcher struct {
+	store  Store
+	opts   DispatcherOptions
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu    sync.RWMutex
+	sinks []Sink
+}
+
+// NewDispatcher returns a Dispatcher of the outbox of store. Zero options are
+// replaced by defaults.
+func NewDispatcher(store Store, opts *DispatcherOptions) *Dispatcher {
+	d := &Dispatcher{
+		store:  store,
+		opts:   *opts,
+		logger: opts.Logger,
+		now:    time.Now,
+	}
+	if d.logger == nil {
+		d.logger = slog.Default()
+	}
+	if d.opts.PollInterval <= 0 {
+		d.opts.PollInterval = defaultDispatchPollInterval
+	}
+	if d.opts.BatchSize <= 0 {
+		d.opts.BatchSize = defaultDispatchBatchSize
+	}
+	if d.opts.Lease <= 0 {
+		d.opts.Lease = defaultDispatchLease
+	}
+	if d.opts.MaxAttempts <= 0 {
+		d.opts.MaxAttempts = defaultDispatchMaxAttempts
+	}
+	if d.opts.MinBackoff <= 0 {
+		d.opts.MinBackoff = defaultDispatchMinBackoff
+	}
+	if d.opts.MaxBackoff < d.opts.MinBackoff {
+		d.opts.MaxBackoff = max(defaultDispatchMaxBackoff, d.opts.MinBackoff)
+	}
+	if d.opts.Retention <= 0 {
+		d.opts.Retention = defaultDispatchRetention
+	}
+	return d
+}
+
+// Register adds a sink. Events are not claimed while no sink is registered.
+func (d *Dispatcher) Register(sink Sink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sinks = append(d.sinks, sink)
+}
+
+// Run dispatches events and purges delivered events until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var purged time.Time
+	for {
+		n, err := d.Dispatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.logger.Error("Failed to dispatch events.", slog.String("error", err.Error()))
+		}
+
+		if now := d.now(); now.Sub(purged) >= dispatchPurgeInterval {
+			purged = now
+			if n, err := d.store.Outbox().Purge(ctx, now.Add(-d.opts.Retention)); err != nil {
+				if ctx.Err() == nil {
+					d.logger.Error("Failed to purge delivered events.", slog.String("error", err.Error()))
+				}
+			} else if n != 0 {
+				d.logger.Debug("Purged delivered events.", slog.Int("events", n))
+			}
+		}
+
+		// Claim more events right away if the batch was full.
+		wait := d.opts.PollInterval
+		if err == nil && n == d.opts.BatchSize {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Dispatch claims a batch of pending events and delivers them. It returns
+// the number of claimed events.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	d.mu.RLock()
+	sinks := d.sinks
+	d.mu.RUnlock()
+	if len(sinks) == 0 {
+		return 0, nil
+	}
+
+	now := d.now()
+	events, err := d.store.Outbox().Claim(ctx, d.opts.BatchSize, now, now.Add(d.opts.Lease))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range events {
+		if err := d.deliver(ctx, sinks, e); err != nil {
+			if ctx.Err() != nil {
+				// The lease expires and the event is claimed again.
+				return len(events), ctx.Err()
+			}
+			if err := d.fail(ctx, e, err); err != nil {
+				return len(events), err
+			}
+			continue
+		}
+		if err := d.store.Outbox().Delivered(ctx, e.ID, d.now()); err != nil {
+			return len(events), err
+		}
+	}
+	return len(events), nil
+}
+
+// deliver delivers e to all sinks, returning their joined errors.
+func (d *Dispatcher) deliver(ctx context.Context, sinks []Sink, e Event) error {
+	var errs []error
+	for _, sink := range sinks {
+		if err := sink.Deliver(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fail records the failed delivery of e, scheduling a retry or, after
+// MaxAttempts, marking e dead.
+func (d *Dispatcher) fail(ctx context.Context, e Event, deliveryErr error) error {
+	attempts := e.Attempts + 1
+	logger := d.logger.With(
+		slog.String("event_id", e.ID),
+		slog.String("event_type", e.Type),
+		slog.Int("attempts", attempts),
+		slog.String("error", deliveryErr.Error()),
+	)
+
+	var next *time.Time
+	if attempts < d.opts.MaxAttempts {
+		at := d.now().Add(backoff(attempts, d.opts.MinBackoff, d.opts.MaxBackoff))
+		next = &at
+		logger.Warn("Failed to deliver event; retrying.", slog.Time("next_attempt_at", at))
+	} else {
+		logger.Error("Failed to deliver event; giving up.")
+	}
+	return d.store.Outbox().Failed(ctx, e.ID, deliveryErr.Error(), next)
+}
+
+// backoff returns the delay before retrying after attempts failed
+// attempts: minDelay, doubled with each further attempt up to maxDelay.
+func backoff(attempts int, minDelay, maxDelay time.Duration) time.Duration {
+	delay := minDelay
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"glut/common/flux"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	svc := NewService(store, &Config{})
+	f := &flux.Flow{Ctx: ctx, Time: testNow}
+
+	alice, err := svc.CreateUser(f, CreateUserInput{Username: "alice", Email: "alice@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := svc.CreateUser(f, CreateUserInput{Username: "bob", Email: "bob@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.DeleteUsers(f, DeleteUsersInput{IDs: []string{alice.ID, bob.ID}}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(store, &DispatcherOptions{
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		MaxAttempts: 2,
+		MinBackoff:  time.Minute,
+	})
+	now := testNow
+	d.now = func() time.Time { return now }
+
+	// The sink rejects the events of bob and the first delivery of each
+	// event of alice.
+	var delivered []string
+	attempts := map[string]int{}
+	d.Register(SinkFunc(func(ctx context.Context, e Event) error {
+		attempts[e.ID]++
+		if e.AggregateID == bob.ID || attempts[e.ID] == 1 {
+			return errors.New("unavailable")
+		}
+		delivered = append(delivered, e.Type+" "+e.AggregateID)
+		return nil
+	}))
+
+	dispatch := func() int {
+		t.Helper()
+		n, err := d.Dispatch(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if n := dispatch(); n != 2 {
+		t.Errorf("first Dispatch claimed %d events, want 2", n)
+	}
+	// Failed events hold back the deletions until they are retried.
+	if n := dispatch(); n != 0 {
+		t.Errorf("Dispatch before the retries claimed %d events, want 0", n)
+	}
+
+	now = now.Add(time.Minute)
+	dispatch() // delivers the creation of alice; bob's dies
+	dispatch() // fails the deletions of alice and bob
+	now = now.Add(time.Minute)
+	dispatch() // delivers the deletion of alice; bob's dies
+	want := []string{
+		EventUserCreated + " " + alice.ID,
+		EventUserDeleted + " " + alice.ID,
+	}
+	if !slices.Equal(delivered, want) {
+		t.Errorf("delivered %v, want %v", delivered, want)
+	}
+
+	now = now.Add(time.Hour)
+	if n := dispatch(); n != 0 {
+		t.Errorf("Dispatch after all events are delivered or dead claimed %d events, want 0", n)
+	}
+}
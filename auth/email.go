@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"glut/common/flux"
+	"glut/common/sqlutil"
+	"glut/common/valid"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultEmailQueryLimit = 20
+	maxEmailQueryLimit     = 100
+)
+
+// Kinds of the emails sent by the service.
+const (
+	EmailVerifyUser     = "verify_user"
+	EmailChangeEmail    = "change_email"
+	EmailResetPassword  = "reset_password"
+	EmailForgotUsername = "forgot_username"
+	EmailSecurityAlert  = "security_alert"
+)
+
+// emailKinds are all kinds of emails.
+var emailKinds = []string{
+	EmailVerifyUser,
+	EmailChangeEmail,
+	EmailResetPassword,
+	EmailForgotUsername,
+	EmailSecurityAlert,
+}
+
+// States of queued emails. Failed emails are not retried.
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// Email is an email queued in the transaction of the change it is about and
+// sent by a MailSender, with the result of its last attempt.
+type Email struct {
+	ID string `json:"id"`
+	// UserID is the user the email is about, if any.
+	UserID        *string    `json:"user_id"`
+	Kind          string     `json:"kind"`
+	To            string     `json:"to"`
+	Subject       string     `json:"subject"`
+	State         string     `json:"state"`
+	Attempts      int        `json:"attempts"`
+	CreatedAt     time.Time  `json:"created_at"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	Error         *string    `json:"error"`
+	SentAt        *time.Time `json:"sent_at"`
+	// Text and HTML are the bodies of the email. They hold tokens, so they
+	// are only set on claimed emails and cleared once sent.
+	Text string `json:"-"`
+	HTML string `json:"-"`
+}
+
+type EmailQuery struct {
+	ID       string           `json:"id"`
+	UserID   string           `json:"user_id"`
+	Kind     string           `json:"kind"`
+	State    string           `json:"state"`
+	Limit    int              `json:"limit"`
+	Offset   int              `json:"offset"`
+	Cursor   *string          `json:"cursor"`
+	Envelope bool             `json:"envelope"`
+	Sort     string           `json:"sort"`
+	Filters  []sqlutil.Filter `json:"filters"`
+}
+
+// sortValue returns the value of e for the sort key name.
+func (e Email) sortValue(name string) any {
+	switch name {
+	case "kind":
+		return e.Kind
+	case "to":
+		return e.To
+	case "last_attempt_at":
+		return e.LastAttemptAt
+	case "sent_at":
+		return e.SentAt
+	default:
+		return e.CreatedAt
+	}
+}
+
+// Emails returns queued emails with their delivery status.
+func (s *Service) Emails(f *flux.Flow, in EmailQuery) (sqlutil.Page[Email], error) {
+	var errs valid.Errors
+	if in.ID != "" && !valid.IsUUID(in.ID) {
+		errs = append(errs, valid.InvalidID("id"))
+	}
+	if in.UserID != "" && !valid.IsUUID(in.UserID) {
+		errs = append(errs, valid.InvalidID("user_id"))
+	}
+	if in.Kind != "" && !slices.Contains(emailKinds, in.Kind) {
+		errs = append(errs, valid.Invalid("kind"))
+	}
+	switch in.State {
+	case "", EmailPending, EmailSent, EmailFailed:
+	default:
+		errs = append(errs, valid.Invalid("state"))
+	}
+	p, listErrs := s.listParams(emailKeyset, emailFields, defaultEmailSort, in.Filters, in.Sort, in.Cursor, in.Offset)
+	errs = append(errs, listErrs...)
+	if len(errs) != 0 {
+		return sqlutil.Page[Email]{}, errs
+	}
+
+	if in.Limit <= 0 || in.Limit > maxEmailQueryLimit {
+		in.Limit = defaultEmailQueryLimit
+	}
+	if in.Offset < 0 {
+		in.Offset = 0
+	}
+	p.Limit, p.Offset, p.Now = in.Limit, in.Offset, f.Time
+
+	page, err := list(f, s, s.store.Emails(), in, p, in.Envelope, in.Cursor, func(e Email) string { return e.ID }, Email.sortValue)
+	if err != nil {
+		return sqlutil.Page[Email]{}, err
+	}
+	if in.ID != "" && len(page.Items) == 0 {
+		return sqlutil.Page[Email]{}, ErrEmailNotFound
+	}
+	return page, nil
+}
+
+// queueEmail renders the email of kind about the user userID, if any, in
+// the locale of f and adds it to the email queue of tx.
+func (s *Service) queueEmail(f *flux.Flow, tx Store, userID *string, kind string, data EmailData) error {
+	data.Time = f.Time
+	e, err := s.templates.Render(f.Locale, kind, data)
+	if err != nil {
+		return err
+	}
+	e.UserID = userID
+	return addEmail(f, tx, e)
+}
+
+// addEmail adds e to the email queue of tx.
+func addEmail(f *flux.Flow, tx Store, e Email) error {
+	e.ID = uuid.New().String()
+	e.CreatedAt = f.Time
+	return tx.Emails().Add(f.Ctx, e)
+}
+
+// tokenEmailData returns the data of an email sending token to the user.
+func tokenEmailData(user User, token Token) EmailData {
+	return EmailData{
+		Email:     user.Email,
+		Username:  user.Username,
+		Token:     token.ID,
+		ExpiresAt: token.ExpiresAt,
+	}
+}
+
+// securityAlertData returns the data of an email alerting the user.
+func securityAlertData(user User, alert string) EmailData {
+	return EmailData{
+		Email:    user.Email,
+		Username: user.Username,
+		Alert:    alert,
+	}
+}
+
+// compareEmailsDue orders claimed emails by the time they are due.
+func compareEmailsDue(a, b Email) int {
+	if c := a.NextAttemptAt.Compare(*b.NextAttemptAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+func formatEmailTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 MST")
+}
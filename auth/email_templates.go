@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Security alerts sent in emails of kind EmailSecurityAlert.
+const (
+	SecurityAlertPasswordChanged = "password_changed"
+	SecurityAlertEmailChanged    = "email_changed"
+)
+
+// securityAlerts are all security alerts.
+var securityAlerts = []string{
+	SecurityAlertPasswordChanged,
+	SecurityAlertEmailChanged,
+}
+
+// defaultTemplates are the embedded email templates. A templates directory
+// only needs to contain the templates it overrides.
+//
+//go:embed templates
+var defaultTemplates embed.FS
+
+// EmailData is the data email templates are executed with.
+type EmailData struct {
+	// Subject is the rendered subject, set for the text and HTML bodies.
+	Subject string
+	// Email is the address the email is sent to.
+	Email string
+	// Username is the name of the user the email is about, if any.
+	Username string
+	// Token and ExpiresAt are the token sent by verify_user, change_email
+	// and reset_password emails, or the revert token of email_changed
+	// security alerts, and the time it expires.
+	Token     string
+	ExpiresAt time.Time
+	// Usernames are the users registered with Email in forgot_username
+	// emails.
+	Usernames []string
+	// Alert is the security alert of security_alert emails, e.g.
+	// password_changed.
+	Alert string
+	// NewEmail is the address that replaced Email in email_changed security
+	// alerts.
+	NewEmail string
+	// Time is the time the email was queued.
+	Time time.Time
+}
+
+// EmailTemplates renders the emails of the service from templates.
+//
+// Each kind of email has a text template, <kind>.txt, which must define a
+// "subject" template, and an optional HTML template, <kind>.html, which
+// defines the "content" of layout.html. Templates of a locale are read from
+// the subdirectory named after it (e.g. "es/reset_password.txt") and
+// replace the default templates of their kind; a locale without a text
+// template for a kind uses the one of its base language or the default.
+//
+// Templates may build links to the application with
+// {{link "/path" "key" "value" ...}} and format times with {{date .Time}}.
+type EmailTemplates struct {
+	baseURL *url.URL
+	locales map[string]map[string]*emailTemplate
+}
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templateFiles reads template files from a directory, falling back to the
+// embedded templates for those it does not contain.
+type templateFiles struct {
+	dir      string
+	defaults fs.FS
+}
+
+// read returns the content of the template file name and whether it exists.
+func (tf templateFiles) read(name string) (string, bool, error) {
+	if tf.dir != "" {
+		b, err := os.ReadFile(filepath.Join(tf.dir, filepath.FromSlash(name)))
+		if err == nil {
+			return string(b), true, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", false, err
+		}
+	}
+	b, err := fs.ReadFile(tf.defaults, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return string(b), true, nil
+}
+
+// locales returns the names of the locale subdirectories.
+func (tf templateFiles) locales() ([]string, error) {
+	entries, err := fs.ReadDir(tf.defaults, ".")
+	if err != nil {
+		return nil, err
+	}
+	if tf.dir != "" {
+		dirEntries, err := os.ReadDir(tf.dir)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, dirEntries...)
+	}
+	var locales []string
+	for _, e := range entries {
+		if e.IsDir() && !slices.Contains(locales, e.Name()) {
+			locales = append(locales, e.Name())
+		}
+	}
+	sort.Strings(locales)
+	return locales, nil
+}
+
+// LoadEmailTemplates loads the email templates of dir, using the embedded
+// templates for the ones it does not contain or for all of them if dir is
+// empty. Links are built relative to baseURL, the public URL of the
+// application. Every template is executed with sample data, so that errors
+// surface when loading rather than when sending.
+func LoadEmailTemplates(dir, baseURL string) (*EmailTemplates, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("auth.LoadEmailTemplates: %w", err)
+	}
+	defaults, err := fs.Sub(defaultTemplates, "templates")
+	if err != nil {
+		return nil, fmt.Errorf("auth.LoadEmailTemplates: %w", err)
+	}
+	files := templateFiles{dir: dir, defaults: defaults}
+	locales, err := files.locales()
+	if err != nil {
+		return nil, fmt.Errorf("auth.LoadEmailTemplates: %w", err)
+	}
+
+	t := &EmailTemplates{
+		baseURL: u,
+		locales: make(map[string]map[string]*emailTemplate),
+	}
+	for _, locale := range append([]string{""}, locales...) {
+		kinds := make(map[string]*emailTemplate)
+		for _, kind := range emailKinds {
+			tmpl, err := t.parse(files, locale, kind)
+			if err != nil {
+				return nil, fmt.Errorf("auth.LoadEmailTemplates: %w", err)
+			}
+			if tmpl != nil {
+				kinds[kind] = tmpl
+			}
+		}
+		t.locales[normalizeLocale(locale)] = kinds
+	}
+
+	for _, locale := range append([]string{""}, t.Locales()...) {
+		for _, kind := range emailKinds {
+			alerts := []string{""}
+			if kind == EmailSecurityAlert {
+				alerts = securityAlerts
+			}
+			for _, alert := range alerts {
+				if _, err := t.Preview(locale, kind, alert); err != nil {
+					return nil, fmt.Errorf("auth.LoadEmailTemplates: %w", err)
+				}
+			}
+		}
+	}
+	return t, nil
+}
+
+// mustLoadEmailTemplates returns the embedded templates with relative links.
+func mustLoadEmailTemplates() *EmailTemplates {
+	t, err := LoadEmailTemplates("", "")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// parse parses the templates of kind in locale. It returns nil if the locale
+// has no text template for kind.
+func (t *EmailTemplates) parse(files templateFiles, locale, kind string) (*emailTemplate, error) {
+	name := path.Join(locale, kind)
+	text, ok, err := files.read(name + ".txt")
+	if err != nil || !ok {
+		return nil, err
+	}
+	funcs := map[string]any{"link": t.link, "date": formatEmailTime}
+	var tmpl emailTemplate
+	if tmpl.text, err = texttemplate.New(name + ".txt").Funcs(funcs).Parse(text); err != nil {
+		return nil, err
+	}
+	if tmpl.text.Lookup("subject") == nil {
+		return nil, fmt.Errorf("%s.txt: no subject template", name)
+	}
+
+	html, ok, err := files.read(name + ".html")
+	if err != nil || !ok {
+		return &tmpl, err
+	}
+	layout, ok, err := files.read(path.Join(locale, "layout.html"))
+	if err == nil && !ok {
+		layout, _, err = files.read("layout.html")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.html, err = htmltemplate.New("layout").Funcs(funcs).Parse(layout); err != nil {
+		return nil, err
+	}
+	if _, err = tmpl.html.New(name + ".html").Parse(html); err != nil {
+		return nil, err
+	}
+	if tmpl.html.Lookup("content") == nil {
+		return nil, fmt.Errorf("%s.html: no content template", name)
+	}
+	return &tmpl, nil
+}
+
+// Locales returns the locales with templates of their own.
+func (t *EmailTemplates) Locales() []string {
+	locales := make([]string, 0, len(t.locales))
+	for locale := range t.locales {
+		if locale != "" {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// lookup returns the templates of kind for locale.
+func (t *EmailTemplates) lookup(locale, kind string) *emailTemplate {
+	locale = normalizeLocale(locale)
+	base, _, _ := strings.Cut(locale, "-")
+	for _, l := range []string{locale, base} {
+		if tmpl, ok := t.locales[l][kind]; ok {
+			return tmpl
+		}
+	}
+	return t.locales[""][kind]
+}
+
+// Render renders the email of kind in locale, sent to data.Email.
+func (t *EmailTemplates) Render(locale, kind string, data EmailData) (Email, error) {
+	tmpl := t.lookup(locale, kind)
+	if tmpl == nil {
+		return Email{}, fmt.Errorf("auth.EmailTemplates.Render: unknown kind %q", kind)
+	}
+	var b strings.Builder
+	if err := tmpl.text.ExecuteTemplate(&b, "subject", data); err != nil {
+		return Email{}, err
+	}
+	data.Subject = strings.Join(strings.Fields(b.String()), " ")
+	if data.Subject == "" {
+		return Email{}, fmt.Errorf("%s: empty subject", tmpl.text.Name())
+	}
+
+	e := Email{Kind: kind, To: data.Email, Subject: data.Subject}
+	b.Reset()
+	if err := tmpl.text.Execute(&b, data); err != nil {
+		return Email{}, err
+	}
+	e.Text = strings.TrimSpace(b.String()) + "\n"
+	if tmpl.html != nil {
+		b.Reset()
+		if err := tmpl.html.Execute(&b, data); err != nil {
+			return Email{}, err
+		}
+		e.HTML = b.String()
+	}
+	return e, nil
+}
+
+// Preview renders the email of kind in locale with sample data. The alert
+// of security_alert emails defaults to password_changed.
+func (t *EmailTemplates) Preview(locale, kind, alert string) (Email, error) {
+	if alert == "" {
+		alert = SecurityAlertPasswordChanged
+	}
+	now := time.Now().UTC().Truncate(time.Minute)
+	return t.Render(locale, kind, EmailData{
+		Email:     "alice@example.com",
+		Username:  "alice",
+		Token:     "sample-token",
+		ExpiresAt: now.Add(3 * time.Hour),
+		Usernames: []string{"alice", "alice.smith"},
+		Alert:     alert,
+		NewEmail:  "alice@example.org",
+		Time:      now,
+	})
+}
+
+// link returns the URL of p in the application with the query parameters of
+// the given key value pairs.
+func (t *EmailTemplates) link(p string, pairs ...string) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", errors.New("link: odd number of query parameters")
+	}
+	u := *t.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(p, "/")
+	u.RawPath = ""
+	q := make(url.Values)
+	for i := 0; i < len(pairs); i += 2 {
+		q.Add(pairs[i], pairs[i+1])
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// normalizeLocale returns the form of a language tag used as locale key.
+func normalizeLocale(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
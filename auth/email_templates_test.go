@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"glut/common/flux"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEmailTemplates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("fr/verify_user.txt", `{{define "subject"}}Vérifiez votre compte{{end}}
+Bonjour {{.Username}}, {{link "/verify" "token" .Token}}`)
+	write("es/reset_password.txt", `{{define "subject"}}  Cambia
+tu contraseña {{end}}
+Hola {{.Username}}: {{link "reset" "token" .Token "locale" "es"}}`)
+
+	templates, err := LoadEmailTemplates(dir, "https://example.com/app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(templates.Locales(), ","); got != "es,fr" {
+		t.Errorf("Locales() = %s, want es,fr", got)
+	}
+
+	data := EmailData{Email: "alice@example.com", Username: "alice", Token: "a+b"}
+	for _, tt := range []struct {
+		locale, kind string
+		subject      string
+		text         string
+		html         bool
+	}{
+		{"", EmailVerifyUser, "Verify your account", "https://example.com/app/verify?token=a%2Bb", true},
+		{"es-MX", EmailVerifyUser, "Verifica tu cuenta", "Hola alice:", true},
+		{"FR", EmailVerifyUser, "Vérifiez votre compte", "Bonjour alice, https://example.com/app/verify?token=a%2Bb\n", false},
+		{"fr", EmailResetPassword, "Reset your password", "Hi alice,", true},
+		{"es", EmailResetPassword, "Cambia tu contraseña", "https://example.com/app/reset?locale=es&token=a%2Bb", true},
+	} {
+		e, err := templates.Render(tt.locale, tt.kind, data)
+		if err != nil {
+			t.Errorf("Render(%q, %s): %v", tt.locale, tt.kind, err)
+			continue
+		}
+		if e.To != data.Email || e.Kind != tt.kind || e.Subject != tt.subject || !strings.Contains(e.Text, tt.text) || (e.HTML != "") != tt.html {
+			t.Errorf("Render(%q, %s) = %+v, want subject %q, text containing %q and HTML %v", tt.locale, tt.kind, e, tt.subject, tt.text, tt.html)
+		}
+	}
+	if e, _ := templates.Render("es", EmailResetPassword, data); !strings.Contains(e.HTML, `lang="es"`) || !strings.Contains(e.HTML, "<title>Cambia tu contraseña</title>") {
+		t.Errorf("HTML of the overridden es reset_password = %s, want the es layout", e.HTML)
+	}
+
+	for name, content := range map[string]string{
+		"verify_user.txt":    "Hi {{.Username}}",
+		"change_email.txt":   `{{define "subject"}}Hi{{end}}{{.Missing}}`,
+		"reset_password.txt": `{{define "subject"}}Hi{{end}}{{link "/reset" "token"}}`,
+		"es/layout.html":     `{{template "content" .}`,
+	} {
+		dir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dir, "es"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadEmailTemplates(dir, ""); err == nil {
+			t.Errorf("LoadEmailTemplates with an invalid %s succeeded", name)
+		}
+	}
+}
+
+func TestSecurityAlertEmails(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	svc := NewService(store, &Config{})
+	f := &flux.Flow{Ctx: ctx, Time: testNow, Locale: "es"}
+
+	user, err := svc.CreateUser(f, CreateUserInput{Username: "alice", Email: "alice@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SetPassword(f, SetPasswordInput{UserID: user.ID, Password: "new secret"}); err != nil {
+		t.Fatal(err)
+	}
+	emails, err := store.Emails().Claim(ctx, 10, testNow, testNow.Add(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, e := range emails {
+		kinds = append(kinds, e.Kind+": "+e.Subject)
+	}
+	slices.Sort(kinds)
+	if got, want := strings.Join(kinds, ", "), "security_alert: Se cambió tu contraseña, verify_user: Verifica tu cuenta"; got != want {
+		t.Errorf("emails = %s, want %s", got, want)
+	}
+}
@@ -19,4 +19,12 @@ var (
 	ErrRoleNotFound       = errors.New("role not found")
 	ErrRoleExists         = errors.New("role already exists")
 	ErrPermissionNotFound = errors.New("permission not found")
+	ErrPermissionExists   = errors.New("permission already exists")
+	ErrLastRoleHolder     = errors.New("last holder of a protected role")
+	ErrEventNotFound      = errors.New("event not found")
+	ErrWebhookNotFound    = errors.New("webhook not found")
+	ErrWebhookDisabled    = errors.New("webhook disabled")
+	ErrDeliveryNotFound   = errors.New("webhook delivery not found")
+	ErrInvalidSignature   = errors.New("invalid webhook signature")
+	ErrEmailNotFound      = errors.New("email not found")
 )
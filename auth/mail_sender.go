@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"glut/common/mail"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMailPollInterval = time.Second
+	defaultMailBatchSize    = 10
+	defaultMailLease        = time.Minute
+	defaultMailTimeout      = 30 * time.Second
+	defaultMailMaxAttempts  = 10
+	defaultMailMinBackoff   = 10 * time.Second
+	defaultMailMaxBackoff   = time.Hour
+
+	// maxMailErrorLength bounds the errors recorded on emails.
+	maxMailErrorLength = 512
+)
+
+// MailSenderOptions...
+type MailSenderOptions struct {
+	Logger *slog.Logger
+	// From is the sender address of the emails, e.g. "Glut
+	// <no-reply@example.com>".
+	From string
+	// PollInterval is how long the sender waits for new emails after
+	// finding none.
+	PollInterval time.Duration
+	// BatchSize is the number of emails claimed, and sent concurrently, at
+	// once.
+	BatchSize int
+	// Lease is how long claimed emails are reserved for the sender.
+	Lease time.Duration
+	// Timeout bounds sending each email.
+	Timeout time.Duration
+	// MaxAttempts is the number of failed attempts after which an email
+	// fails.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the delay before retrying a failed
+	// attempt, which doubles with each attempt.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// MailSender sends the emails queued by the service with a Mailer, retrying
+// failed ones with exponential backoff.
+type MailSender struct {
+	store  Store
+	mailer mail.Mailer
+	opts   MailSenderOptions
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+// NewMailSender returns a MailSender of the emails of store. Zero options are
+// replaced by defaults.
+func NewMailSender(store Store, mailer mail.Mailer, opts *MailSenderOptions) *MailSender {
+	m := &MailSender{
+		store:  store,
+		mailer: mailer,
+		opts:   *opts,
+		logger: opts.Logger,
+		now:    time.Now,
+	}
+	if m.logger == nil {
+		m.logger = slog.Default()
+	}
+	if m.opts.PollInterval <= 0 {
+		m.opts.PollInterval = defaultMailPollInterval
+	}
+	if m.opts.BatchSize <= 0 {
+		m.opts.BatchSize = defaultMailBatchSize
+	}
+	if m.opts.Lease <= 0 {
+		m.opts.Lease = defaultMailLease
+	}
+	if m.opts.Timeout <= 0 {
+		m.opts.Timeout = defaultMailTimeout
+	}
+	if m.opts.MaxAttempts <= 0 {
+		m.opts.MaxAttempts = defaultMailMaxAttempts
+	}
+	if m.opts.MinBackoff <= 0 {
+		m.opts.MinBackoff = defaultMailMinBackoff
+	}
+	if m.opts.MaxBackoff < m.opts.MinBackoff {
+		m.opts.MaxBackoff = max(defaultMailMaxBackoff, m.opts.MinBackoff)
+	}
+	return m
+}
+
+// Run sends emails until ctx is done.
+func (m *MailSender) Run(ctx context.Context) {
+	for {
+		n, err := m.Send(ctx)
+		if err != nil && ctx.Err() == nil {
+			m.logger.Error("Failed to send emails.", slog.String("error", err.Error()))
+		}
+
+		// Claim more emails right away if the batch was full.
+		wait := m.opts.PollInterval
+		if err == nil && n == m.opts.BatchSize {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Send claims a batch of due emails and sends them concurrently. It returns
+// the number of claimed emails.
+func (m *MailSender) Send(ctx context.Context) (int, error) {
+	now := m.now()
+	emails, err := m.store.Emails().Claim(ctx, m.opts.BatchSize, now, now.Add(m.opts.Lease))
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(emails))
+	for i, e := range emails {
+		wg.Add(1)
+		go func(i int, e Email) {
+			defer wg.Done()
+			errs[i] = m.attempt(ctx, e)
+		}(i, e)
+	}
+	wg.Wait()
+	return len(emails), errors.Join(errs...)
+}
+
+// attempt sends e and records the attempt. Invalid messages are not retried.
+func (m *MailSender) attempt(ctx context.Context, e Email) error {
+	sendCtx, cancel := context.WithTimeout(ctx, m.opts.Timeout)
+	err := m.mailer.Send(sendCtx, &mail.Message{
+		From:    m.opts.From,
+		To:      e.To,
+		Subject: e.Subject,
+		Text:    e.Text,
+		HTML:    e.HTML,
+	})
+	cancel()
+	if ctx.Err() != nil {
+		// The lease expires and the email is claimed again.
+		return ctx.Err()
+	}
+
+	at := m.now()
+	logger := m.logger.With(slog.String("email_id", e.ID), slog.String("kind", e.Kind))
+	var errMsg *string
+	var next *time.Time
+	if err != nil {
+		msg := err.Error()
+		if len(msg) > maxMailErrorLength {
+			msg = msg[:maxMailErrorLength]
+		}
+		errMsg = &msg
+		attempts := e.Attempts + 1
+		logger = logger.With(slog.Int("attempts", attempts), slog.String("error", msg))
+		if attempts < m.opts.MaxAttempts && !errors.Is(err, mail.ErrInvalidMessage) {
+			retryAt := at.Add(backoff(attempts, m.opts.MinBackoff, m.opts.MaxBackoff))
+			next = &retryAt
+			logger.Warn("Failed to send email; retrying.", slog.Time("next_attempt_at", retryAt))
+		} else {
+			logger.Error("Failed to send email; giving up.")
+		}
+	}
+	return m.store.Emails().Attempted(ctx, e.ID, at, errMsg, next)
+}
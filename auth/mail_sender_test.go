@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"glut/common/flux"
+	"glut/common/mail"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMailer records the messages it sends and fails while fail is set.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []*mail.Message
+	fail bool
+}
+
+func (m *testMailer) Send(ctx context.Context, msg *mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail {
+		return errors.New("connection refused")
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestMailSender(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	svc := NewService(store, &Config{})
+	f := &flux.Flow{Ctx: ctx, Time: testNow}
+
+	mailer := &testMailer{fail: true}
+	now := testNow
+	sender := NewMailSender(store, mailer, &MailSenderOptions{
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		From:        "Glut <no-reply@example.com>",
+		MinBackoff:  time.Minute,
+		MaxAttempts: 2,
+	})
+	sender.now = func() time.Time { return now }
+	send := func() int {
+		t.Helper()
+		n, err := sender.Send(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	user, err := svc.CreateUser(f, CreateUserInput{Username: "alice", Email: "alice@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, ok, err := store.Tokens().ByUser(ctx, user.ID, tokenKindVerifyUser)
+	if err != nil || !ok {
+		t.Fatalf("verification token: %v, %v", ok, err)
+	}
+
+	// A failed attempt is retried after the backoff.
+	if n := send(); n != 1 {
+		t.Errorf("first Send claimed %d emails, want 1", n)
+	}
+	if n := send(); n != 0 {
+		t.Errorf("Send before the retry claimed %d emails, want 0", n)
+	}
+	mailer.fail = false
+	now = testNow.Add(time.Minute)
+	if n := send(); n != 1 {
+		t.Errorf("Send of the retry claimed %d emails, want 1", n)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(mailer.sent))
+	}
+	if m := mailer.sent[0]; m.To != user.Email || m.From != "Glut <no-reply@example.com>" || !strings.Contains(m.Text, token.ID) {
+		t.Errorf("verification message = %+v, want the token sent to %s", m, user.Email)
+	}
+
+	page, err := svc.Emails(f, EmailQuery{UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Kind != EmailVerifyUser || page.Items[0].State != EmailSent || page.Items[0].Attempts != 2 {
+		t.Errorf("emails of alice = %+v, want the verification sent in 2 attempts", page.Items)
+	}
+
+	// Emails that keep failing fail after MaxAttempts, and invalid
+	// addresses right away.
+	if err := svc.ForgotUsername(f, ForgotUsernameInput{Email: user.Email}); err != nil {
+		t.Fatal(err)
+	}
+	if err := addEmail(f, store, Email{Kind: EmailForgotUsername, To: "not an address", Subject: "Your usernames"}); err != nil {
+		t.Fatal(err)
+	}
+	mailer.fail = true
+	sender.mailer = invalidAddressMailer{mailer}
+	if n := send(); n != 2 {
+		t.Errorf("Send claimed %d emails, want 2", n)
+	}
+	now = now.Add(time.Minute)
+	if n := send(); n != 1 {
+		t.Errorf("Send of the retry claimed %d emails, want 1", n)
+	}
+	page, err = svc.Emails(f, EmailQuery{State: EmailFailed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 {
+		t.Errorf("failed emails = %+v, want 2", page.Items)
+	}
+	for _, e := range page.Items {
+		if want := map[string]int{user.Email: 2, "not an address": 1}[e.To]; e.Attempts != want {
+			t.Errorf("failed email to %q has %d attempts, want %d", e.To, e.Attempts, want)
+		}
+	}
+}
+
+// invalidAddressMailer rejects invalid addresses as mail.Mailer
+// implementations do before sending.
+type invalidAddressMailer struct {
+	mail.Mailer
+}
+
+func (m invalidAddressMailer) Send(ctx context.Context, msg *mail.Message) error {
+	if !strings.Contains(msg.To, "@") {
+		return mail.ErrInvalidMessage
+	}
+	return m.Mailer.Send(ctx, msg)
+}
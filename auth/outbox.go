@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"encoding/json"
+	"glut/common/flux"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of the events of the outbox. Events about users have the user as
+// aggregate, events about roles the role and events about permissions the
+// permission.
+const (
+	EventUserCreated            = "user.created"
+	EventUserVerified           = "user.verified"
+	EventUserBanned             = "user.banned"
+	EventUserUnbanned           = "user.unbanned"
+	EventUserDeleted            = "user.deleted"
+	EventUserEmailChanged       = "user.email_changed"
+	EventUserPasswordChanged    = "user.password_changed"
+	EventUserRolesAssigned      = "user.roles_assigned"
+	EventUserRolesUnassigned    = "user.roles_unassigned"
+	EventRoleCreated            = "role.created"
+	EventRoleUpdated            = "role.updated"
+	EventRoleDeleted            = "role.deleted"
+	EventRolePermissionsGranted = "role.permissions_granted"
+	EventRolePermissionsRevoked = "role.permissions_revoked"
+	EventPermissionCreated      = "permission.created"
+	EventPermissionUpdated      = "permission.updated"
+	EventPermissionDeleted      = "permission.deleted"
+)
+
+// eventTypes are the types of all events.
+var eventTypes = []string{
+	EventUserCreated,
+	EventUserVerified,
+	EventUserBanned,
+	EventUserUnbanned,
+	EventUserDeleted,
+	EventUserEmailChanged,
+	EventUserPasswordChanged,
+	EventUserRolesAssigned,
+	EventUserRolesUnassigned,
+	EventRoleCreated,
+	EventRoleUpdated,
+	EventRoleDeleted,
+	EventRolePermissionsGranted,
+	EventRolePermissionsRevoked,
+	EventPermissionCreated,
+	EventPermissionUpdated,
+	EventPermissionDeleted,
+}
+
+// States of the events of the outbox.
+const (
+	eventPending   = "pending"
+	eventDelivered = "delivered"
+	eventDead      = "dead"
+)
+
+// Event is a change of the auth domain. Events are added to the outbox in
+// the transaction of the change and delivered to sinks by a Dispatcher.
+//
+// The payload of EventUserCreated is a User, of EventUserBanned a Ban, of
+// EventRoleCreated a Role, of EventRoleUpdated an UpdateRoleInput, of
+// EventRolePermissionsGranted a GrantPermissionsInput, of
+// EventRolePermissionsRevoked a RevokePermissionsInput, of
+// EventUserRolesAssigned an AssignRolesInput, of EventUserRolesUnassigned an
+// UnassignRolesInput, of EventPermissionCreated a Permission, of
+// EventPermissionUpdated an UpdatePermissionInput and of the other events a
+// UserChange, RoleChange or PermissionChange.
+type Event struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	AggregateID string `json:"aggregate_id"`
+	// ActorID is the user whose session made the change, or nil if it was
+	// made without a session, e.g. from the command line or with a token.
+	ActorID   *string         `json:"actor_id"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	// Attempts is the number of failed deliveries of the event.
+	Attempts int `json:"-"`
+}
+
+// UserChange is the payload of user events without a type of their own.
+type UserChange struct {
+	UserID     string     `json:"user_id"`
+	Email      string     `json:"email,omitempty"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+// RoleChange is the payload of EventRoleDeleted.
+type RoleChange struct {
+	RoleID string `json:"role_id"`
+}
+
+// PermissionChange is the payload of EventPermissionDeleted.
+type PermissionChange struct {
+	PermissionID string `json:"permission_id"`
+}
+
+// emit adds the event of type typ about aggregateID to the outbox of tx.
+func emit(f *flux.Flow, tx Store, typ, aggregateID string, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return tx.Outbox().Add(f.Ctx, Event{
+		ID:          uuid.New().String(),
+		Type:        typ,
+		AggregateID: aggregateID,
+		ActorID:     sessionUser(f),
+		Payload:     b,
+		CreatedAt:   f.Time,
+	})
+}
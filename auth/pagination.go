@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"glut/common/flux"
+	"glut/common/sqlutil"
+	"glut/common/valid"
+)
+
+var (
+	idOps       = []string{sqlutil.OpEq, sqlutil.OpIn}
+	nullIDOps   = []string{sqlutil.OpEq, sqlutil.OpIn, sqlutil.OpIsNull}
+	textOps     = []string{sqlutil.OpEq, sqlutil.OpIn, sqlutil.OpPrefix}
+	timeOps     = []string{sqlutil.OpRange}
+	nullTimeOps = []string{sqlutil.OpRange, sqlutil.OpIsNull}
+)
+
+// Fields that list queries may filter and sort by.
+var (
+	userFields = sqlutil.Fields{
+		"id":            {Column: "id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"username":      {Column: "username", Ops: textOps, Sortable: true},
+		"email":         {Column: "email", Ops: textOps, Sortable: true},
+		"created_at":    {Column: "created_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+		"last_login_at": {Column: "last_login_at", Type: sqlutil.TypeTime, Nullable: true, Ops: nullTimeOps, Sortable: true},
+	}
+	sessionFields = sqlutil.Fields{
+		"id":         {Column: "id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"user_id":    {Column: "user_id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"created_at": {Column: "created_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+		"expires_at": {Column: "expires_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+	}
+	banFields = sqlutil.Fields{
+		"user_id":     {Column: "b.user_id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"reason":      {Column: "b.reason", Ops: textOps, Sortable: true},
+		"banned_at":   {Column: "b.banned_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+		"unbanned_at": {Column: "b.unbanned_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+		"banned_by":   {Column: "b.banned_by", Type: sqlutil.TypeUUID, Nullable: true, Ops: nullIDOps},
+	}
+	// roleFields are the fields of roles and permissions, both selected as r.
+	roleFields = sqlutil.Fields{
+		"id":         {Column: "r.id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"name":       {Column: "r.name", Ops: textOps, Sortable: true},
+		"created_at": {Column: "r.created_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+		"updated_at": {Column: "r.updated_at", Type: sqlutil.TypeTime, Nullable: true, Ops: nullTimeOps, Sortable: true},
+		"created_by": {Column: "r.created_by", Type: sqlutil.TypeUUID, Nullable: true, Ops: nullIDOps},
+		"updated_by": {Column: "r.updated_by", Type: sqlutil.TypeUUID, Nullable: true, Ops: nullIDOps},
+	}
+	userRoleFields = sqlutil.Fields{
+		"user_id":    {Column: "ur.user_id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"role_id":    {Column: "ur.role_id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"username":   {Column: "u.username", Ops: textOps, Sortable: true},
+		"role_name":  {Column: "r.name", Ops: textOps, Sortable: true},
+		"created_at": {Column: "ur.created_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+		"created_by": {Column: "ur.created_by", Type: sqlutil.TypeUUID, Nullable: true, Ops: nullIDOps},
+	}
+	rolePermissionFields = sqlutil.Fields{
+		"permission_id":   {Column: "rp.permission_id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"permission_name": {Column: "p.name", Ops: textOps, Sortable: true},
+		"created_at":      {Column: "rp.created_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+		"created_by":      {Column: "rp.created_by", Type: sqlutil.TypeUUID, Nullable: true, Ops: nullIDOps},
+	}
+	webhookFields = sqlutil.Fields{
+		"id":          {Column: "id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"url":         {Column: "url", Ops: textOps, Sortable: true},
+		"created_at":  {Column: "created_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+		"updated_at":  {Column: "updated_at", Type: sqlutil.TypeTime, Nullable: true, Ops: nullTimeOps, Sortable: true},
+		"disabled_at": {Column: "disabled_at", Type: sqlutil.TypeTime, Nullable: true, Ops: nullTimeOps},
+	}
+	deliveryFields = sqlutil.Fields{
+		"id":              {Column: "id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"event_id":        {Column: "event_id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"event_type":      {Column: "event_type", Ops: textOps, Sortable: true},
+		"created_at":      {Column: "created_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+		"last_attempt_at": {Column: "last_attempt_at", Type: sqlutil.TypeTime, Nullable: true, Ops: nullTimeOps, Sortable: true},
+	}
+	emailFields = sqlutil.Fields{
+		"id":              {Column: "id", Type: sqlutil.TypeUUID, Ops: idOps},
+		"user_id":         {Column: "user_id", Type: sqlutil.TypeUUID, Nullable: true, Ops: nullIDOps},
+		"kind":            {Column: "kind", Ops: textOps, Sortable: true},
+		"to":              {Column: "recipient", Ops: textOps, Sortable: true},
+		"created_at":      {Column: "created_at", Type: sqlutil.TypeTime, Ops: timeOps, Sortable: true},
+		"last_attempt_at": {Column: "last_attempt_at", Type: sqlutil.TypeTime, Nullable: true, Ops: nullTimeOps, Sortable: true},
+		"sent_at":         {Column: "sent_at", Type: sqlutil.TypeTime, Nullable: true, Ops: nullTimeOps, Sortable: true},
+	}
+)
+
+// Default orders of list queries.
+var (
+	defaultUserSort           = []sqlutil.SortKey{{Name: "created_at", Column: "created_at", Time: true}}
+	defaultSessionSort        = []sqlutil.SortKey{{Name: "created_at", Column: "created_at", Time: true}}
+	defaultBanSort            = []sqlutil.SortKey{{Name: "banned_at", Column: "b.banned_at", Time: true}}
+	defaultRoleSort           = []sqlutil.SortKey{{Name: "created_at", Column: "r.created_at", Time: true}}
+	defaultPermissionSort     = defaultRoleSort
+	defaultUserRoleSort       = []sqlutil.SortKey{{Name: "created_at", Column: "ur.created_at", Time: true}}
+	defaultRolePermissionSort = []sqlutil.SortKey{{Name: "created_at", Column: "rp.created_at", Time: true}}
+	defaultWebhookSort        = []sqlutil.SortKey{{Name: "created_at", Column: "created_at", Time: true}}
+	defaultDeliverySort       = []sqlutil.SortKey{{Name: "created_at", Column: "created_at", Time: true}}
+	defaultEmailSort          = []sqlutil.SortKey{{Name: "created_at", Column: "created_at", Time: true}}
+)
+
+// Keysets of the list queries, ordered by the sort keys of each query.
+var (
+	userKeyset           = sqlutil.Keyset{Scope: "users", IDColumn: "id"}
+	sessionKeyset        = sqlutil.Keyset{Scope: "sessions", IDColumn: "id"}
+	banKeyset            = sqlutil.Keyset{Scope: "bans", IDColumn: "b.user_id"}
+	roleKeyset           = sqlutil.Keyset{Scope: "roles", IDColumn: "r.id"}
+	permissionKeyset     = sqlutil.Keyset{Scope: "permissions", IDColumn: "r.id"}
+	userRoleKeyset       = sqlutil.Keyset{Scope: "user_roles", IDColumn: "ur.id"}
+	rolePermissionKeyset = sqlutil.Keyset{Scope: "role_permissions", IDColumn: "rp.id"}
+	webhookKeyset        = sqlutil.Keyset{Scope: "webhooks", IDColumn: "id"}
+	deliveryKeyset       = sqlutil.Keyset{Scope: "webhook_deliveries", IDColumn: "id"}
+	emailKeyset          = sqlutil.Keyset{Scope: "emails", IDColumn: "id"}
+)
+
+// listParams validates the filters, sort option and cursor of a list query
+// on fields ordered by k. Without a sort option, the query continues in the
+// order of the cursor or else is ordered by def.
+func (s *Service) listParams(k sqlutil.Keyset, fields sqlutil.Fields, def []sqlutil.SortKey, filters []sqlutil.Filter, sort string, cursor *string, offset int) (ListParams, valid.Errors) {
+	var errs valid.Errors
+	if sort == "" && cursor != nil && *cursor != "" {
+		if c, err := s.cursors.Decode(*cursor); err == nil {
+			sort = c.Sort
+		}
+	}
+	keys, ok := fields.Sort(sort, def)
+	if !ok {
+		errs = append(errs, valid.Invalid("sort"))
+	}
+	k.Keys = keys
+	p := ListParams{Filters: filters, Keyset: k, Paginated: cursor != nil}
+	if cursor != nil {
+		var err error
+		if ok {
+			if p.Cursor, err = s.decodeCursor(*cursor, k); err != nil {
+				errs = append(errs, valid.Invalid("cursor"))
+			}
+		}
+		if offset != 0 {
+			errs = append(errs, valid.Invalid("offset"))
+		}
+	}
+	if _, filterErrs := fields.Where(filters); len(filterErrs) != 0 {
+		errs = append(errs, filterErrs...)
+	}
+	return p, errs
+}
+
+// decodeCursor decodes the cursor of a query ordered by k. An empty cursor
+// selects the first page and decodes to nil.
+func (s *Service) decodeCursor(cursor string, k sqlutil.Keyset) (*sqlutil.Cursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	c, err := s.cursors.Decode(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.Check(c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// sortKey returns the key function of items ordered by keys. The value
+// function returns the value of an item for a sort key name.
+func sortKey[T any](keys []sqlutil.SortKey, value func(T, string) any, id func(T) string) func(T) ([]any, string) {
+	return func(item T) ([]any, string) {
+		values := make([]any, len(keys))
+		for i, key := range keys {
+			values[i] = value(item, key.Name)
+		}
+		return values, id(item)
+	}
+}
+
+// list runs the list query q with p on l and returns its page. The total is
+// counted if envelope is set.
+func list[T, Q any](f *flux.Flow, s *Service, l lister[T, Q], q Q, p ListParams, envelope bool, cursor *string, id func(T) string, value func(T, string) any) (sqlutil.Page[T], error) {
+	rows, err := l.List(f.Ctx, q, p)
+	if err != nil {
+		return sqlutil.Page[T]{}, err
+	}
+	var page sqlutil.Page[T]
+	if p.Paginated {
+		page = sqlutil.Paginate(s.cursors, p.Keyset, p.Cursor, rows, p.Limit, sortKey(p.Keyset.Keys, value, id))
+		page.Cursor = cursor
+	} else {
+		page = sqlutil.OffsetPage(rows, p.Limit, p.Offset)
+	}
+	if envelope {
+		total, estimated, err := l.Count(f.Ctx, q, p)
+		if err != nil {
+			return sqlutil.Page[T]{}, err
+		}
+		page.Total = &total
+		page.TotalEstimated = estimated
+	}
+	return page, nil
+}
@@ -2,11 +2,11 @@ package auth
 
 import (
 	"glut/common/flux"
+	"glut/common/sqlutil"
 	"glut/common/valid"
 	"time"
 
-	"github.com/stephenafamo/bob/dialect/psql"
-	"github.com/stephenafamo/bob/dialect/psql/sm"
+	"github.com/google/uuid"
 )
 
 const (
@@ -33,10 +33,17 @@ type PermissionMeta struct {
 }
 
 type PermissionQuery struct {
-	ID       string `json:"id"`
-	Limit    int    `json:"limit"`
-	Offset   int    `json:"offset"`
-	Detailed bool   `json:"detailed"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// RoleID selects the permissions granted to the role.
+	RoleID   string           `json:"role_id"`
+	Limit    int              `json:"limit"`
+	Offset   int              `json:"offset"`
+	Cursor   *string          `json:"cursor"`
+	Envelope bool             `json:"envelope"`
+	Sort     string           `json:"sort"`
+	Filters  []sqlutil.Filter `json:"filters"`
+	Detailed bool             `json:"detailed"`
 }
 
 type CreatePermissionInput struct {
@@ -54,13 +61,30 @@ type DeletePermissionInput struct {
 	IDs []string `json:"ids"`
 }
 
-func (s *Service) Permissions(f *flux.Flow, in PermissionQuery) ([]Permission, error) {
+// sortValue returns the value of p for the sort key name.
+func (p Permission) sortValue(name string) any {
+	switch name {
+	case "name":
+		return p.Name
+	case "updated_at":
+		return p.UpdatedAt
+	default:
+		return p.CreatedAt
+	}
+}
+
+func (s *Service) Permissions(f *flux.Flow, in PermissionQuery) (sqlutil.Page[Permission], error) {
 	var errs valid.Errors
 	if in.ID != "" && !valid.IsUUID(in.ID) {
-		errs = append(errs, valid.Error{Field: "id", Error: "Invalid id."})
+		errs = append(errs, valid.InvalidID("id"))
 	}
+	if in.RoleID != "" && !valid.IsUUID(in.RoleID) {
+		errs = append(errs, valid.InvalidID("role_id"))
+	}
+	p, listErrs := s.listParams(permissionKeyset, roleFields, defaultPermissionSort, in.Filters, in.Sort, in.Cursor, in.Offset)
+	errs = append(errs, listErrs...)
 	if len(errs) != 0 {
-		return nil, errs
+		return sqlutil.Page[Permission]{}, errs
 	}
 
 	if in.Limit <= 0 || in.Limit > maxPermissionQueryLimit {
@@ -69,90 +93,106 @@ func (s *Service) Permissions(f *flux.Flow, in PermissionQuery) ([]Permission, e
 	if in.Offset < 0 {
 		in.Offset = 0
 	}
+	p.Limit, p.Offset, p.Now = in.Limit, in.Offset, f.Time
 
-	cols := []any{"r.id", "r.name", "r.description", "r.created_at", "r.updated_at"}
-	if in.Detailed {
-		cols = append(cols, "cb.id", "cb.username", "cb.email", "ub.id", "ub.username", "ub.email")
-	}
-	q := psql.Select(
-		sm.Columns(cols...),
-		sm.From("auth.permissions").As("r"),
-	)
-	if in.Detailed {
-		q.Apply(
-			sm.LeftJoin("auth.users").As("cb").OnEQ(
-				psql.Raw("r.created_by"), psql.Raw("cb.id"),
-			),
-			sm.LeftJoin("auth.users").As("ub").OnEQ(
-				psql.Raw("r.updated_by"), psql.Raw("ub.id"),
-			),
-		)
-	}
-	if in.ID != "" {
-		q.Apply(
-			sm.Where(psql.Quote("id").EQ(psql.Arg(in.ID))),
-		)
-	}
-	if in.Limit != 0 {
-		q.Apply(
-			sm.Limit(in.Limit),
-		)
-	}
-	if in.Offset != 0 {
-		q.Apply(
-			sm.Offset(in.Offset),
-		)
-	}
-	sql, args := q.MustBuild()
-
-	rows, err := s.db.Query(f.Ctx, sql, args...)
+	page, err := list(f, s, s.store.Permissions(), in, p, in.Envelope, in.Cursor, func(p Permission) string { return p.ID }, Permission.sortValue)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	permissions := []Permission{}
-	for rows.Next() {
-		var id string
-		var name string
-		var description *string
-		var createdAt time.Time
-		var updatedAt *time.Time
-		var createdByID *string
-		var createdByUsername *string
-		var createdByEmail *string
-		var updatedByID *string
-		var updatedByUsername *string
-		var updatedByEmail *string
-
-		dest := []any{&id, &name, &description, &createdAt, &updatedAt}
-		if in.Detailed {
-			dest = append(dest, &createdByID, &createdByUsername, &createdByEmail, &updatedByID, &updatedByUsername, &updatedByEmail)
+		return sqlutil.Page[Permission]{}, err
+	}
+	if (in.ID != "" || in.Name != "") && len(page.Items) == 0 {
+		return sqlutil.Page[Permission]{}, ErrPermissionNotFound
+	}
+	if in.RoleID != "" && len(page.Items) == 0 {
+		n, _, err := s.store.Roles().Count(f.Ctx, RoleQuery{ID: in.RoleID}, ListParams{})
+		if err != nil {
+			return sqlutil.Page[Permission]{}, err
 		}
-		if err := rows.Scan(dest...); err != nil {
-			return nil, err
+		if n == 0 {
+			return sqlutil.Page[Permission]{}, ErrRoleNotFound
 		}
-		permission := Permission{
-			ID:          id,
-			Name:        name,
-			Description: description,
-			CreatedAt:   createdAt,
-			UpdatedAt:   updatedAt,
+	}
+	return page, nil
+}
+
+// CreatePermission creates a permission. It fails with ErrPermissionExists if
+// the name is taken, as reported by the unique constraint on the name.
+func (s *Service) CreatePermission(f *flux.Flow, in CreatePermissionInput) (Permission, error) {
+	var errs valid.Errors
+	if in.Name == "" {
+		errs = append(errs, valid.Required("name"))
+	}
+	if len(errs) != 0 {
+		return Permission{}, errs
+	}
+
+	permission := Permission{
+		ID:          uuid.New().String(),
+		Name:        in.Name,
+		Description: in.Description,
+		CreatedAt:   f.Time,
+	}
+	if err := s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		if err := tx.Permissions().Create(f.Ctx, permission, sessionUser(f)); err != nil {
+			return err
 		}
-		if in.Detailed {
-			permission.Meta = &PermissionMeta{
-				CreatedByID:       createdByID,
-				CreatedByUsername: createdByUsername,
-				CreatedByEmail:    createdByEmail,
-				UpdatedByID:       updatedByID,
-				UpdatedByUsername: updatedByUsername,
-				UpdatedByEmail:    updatedByEmail,
-			}
+		return emit(f, tx, EventPermissionCreated, permission.ID, permission)
+	}); err != nil {
+		return Permission{}, err
+	}
+	return permission, nil
+}
+
+func (s *Service) UpdatePermission(f *flux.Flow, in UpdatePermissionInput) error {
+	var errs valid.Errors
+	if in.ID == "" {
+		errs = append(errs, valid.Required("id"))
+	}
+	if in.ID != "" && !valid.IsUUID(in.ID) {
+		errs = append(errs, valid.InvalidID("id"))
+	}
+	if in.Name == "" && in.Description == "" {
+		errs = append(errs, valid.InputRequired())
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		if err := tx.Permissions().Update(f.Ctx, in, f.Time, sessionUser(f)); err != nil {
+			return err
 		}
-		permissions = append(permissions, permission)
+		return emit(f, tx, EventPermissionUpdated, in.ID, in)
+	})
+}
+
+// DeletePermission deletes permissions and revokes them from all roles. It
+// returns the number of deleted permissions.
+func (s *Service) DeletePermission(f *flux.Flow, in DeletePermissionInput) (int, error) {
+	var errs valid.Errors
+	if len(in.IDs) == 0 {
+		errs = append(errs, valid.Required("ids"))
+	}
+	if !valid.IsUUIDSlice(in.IDs) {
+		errs = append(errs, valid.InvalidIDs("ids"))
+	}
+	if len(errs) != 0 {
+		return 0, errs
 	}
-	if in.ID != "" && len(permissions) == 0 {
-		return nil, ErrPermissionNotFound
+
+	var n int
+	if err := s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		ids, err := tx.Permissions().Delete(f.Ctx, in.IDs)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := emit(f, tx, EventPermissionDeleted, id, PermissionChange{PermissionID: id}); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	}); err != nil {
+		return 0, err
 	}
-	return permissions, nil
+	return n, nil
 }
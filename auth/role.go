@@ -7,11 +7,6 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/stephenafamo/bob/dialect/psql"
-	"github.com/stephenafamo/bob/dialect/psql/dm"
-	"github.com/stephenafamo/bob/dialect/psql/im"
-	"github.com/stephenafamo/bob/dialect/psql/sm"
-	"github.com/stephenafamo/bob/dialect/psql/um"
 )
 
 const (
@@ -38,10 +33,17 @@ type RoleMeta struct {
 }
 
 type RoleQuery struct {
-	ID       string `json:"id"`
-	Limit    int    `json:"limit"`
-	Offset   int    `json:"offset"`
-	Detailed bool   `json:"detailed"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// PermissionID selects the roles granted the permission.
+	PermissionID string           `json:"permission_id"`
+	Limit        int              `json:"limit"`
+	Offset       int              `json:"offset"`
+	Cursor       *string          `json:"cursor"`
+	Envelope     bool             `json:"envelope"`
+	Sort         string           `json:"sort"`
+	Filters      []sqlutil.Filter `json:"filters"`
+	Detailed     bool             `json:"detailed"`
 }
 
 type CreateRoleInput struct {
@@ -59,13 +61,30 @@ type DeleteRoleInput struct {
 	IDs []string `json:"ids"`
 }
 
-func (s *Service) Roles(f *flux.Flow, in RoleQuery) ([]Role, error) {
+// sortValue returns the value of r for the sort key name.
+func (r Role) sortValue(name string) any {
+	switch name {
+	case "name":
+		return r.Name
+	case "updated_at":
+		return r.UpdatedAt
+	default:
+		return r.CreatedAt
+	}
+}
+
+func (s *Service) Roles(f *flux.Flow, in RoleQuery) (sqlutil.Page[Role], error) {
 	var errs valid.Errors
 	if in.ID != "" && !valid.IsUUID(in.ID) {
-		errs = append(errs, valid.Error{Field: "id", Error: "Invalid id."})
+		errs = append(errs, valid.InvalidID("id"))
 	}
+	if in.PermissionID != "" && !valid.IsUUID(in.PermissionID) {
+		errs = append(errs, valid.InvalidID("permission_id"))
+	}
+	p, listErrs := s.listParams(roleKeyset, roleFields, defaultRoleSort, in.Filters, in.Sort, in.Cursor, in.Offset)
+	errs = append(errs, listErrs...)
 	if len(errs) != 0 {
-		return nil, errs
+		return sqlutil.Page[Role]{}, errs
 	}
 
 	if in.Limit <= 0 || in.Limit > maxRoleQueryLimit {
@@ -74,131 +93,50 @@ func (s *Service) Roles(f *flux.Flow, in RoleQuery) ([]Role, error) {
 	if in.Offset < 0 {
 		in.Offset = 0
 	}
+	p.Limit, p.Offset, p.Now = in.Limit, in.Offset, f.Time
 
-	cols := []any{"r.id", "r.name", "r.description", "r.created_at", "r.updated_at"}
-	if in.Detailed {
-		cols = append(cols, "cb.id", "cb.username", "cb.email", "ub.id", "ub.username", "ub.email")
-	}
-	q := psql.Select(
-		sm.Columns(cols...),
-		sm.From("auth.roles").As("r"),
-	)
-	if in.Detailed {
-		q.Apply(
-			sm.LeftJoin("auth.users").As("cb").OnEQ(
-				psql.Raw("r.created_by"), psql.Raw("cb.id"),
-			),
-			sm.LeftJoin("auth.users").As("ub").OnEQ(
-				psql.Raw("r.updated_by"), psql.Raw("ub.id"),
-			),
-		)
-	}
-	if in.ID != "" {
-		q.Apply(
-			sm.Where(psql.Quote("id").EQ(psql.Arg(in.ID))),
-		)
-	}
-	if in.Limit != 0 {
-		q.Apply(
-			sm.Limit(in.Limit),
-		)
-	}
-	if in.Offset != 0 {
-		q.Apply(
-			sm.Offset(in.Offset),
-		)
-	}
-	sql, args := q.MustBuild()
-
-	rows, err := s.db.Query(f.Ctx, sql, args...)
+	page, err := list(f, s, s.store.Roles(), in, p, in.Envelope, in.Cursor, func(r Role) string { return r.ID }, Role.sortValue)
 	if err != nil {
-		return nil, err
+		return sqlutil.Page[Role]{}, err
 	}
-	defer rows.Close()
-
-	roles := []Role{}
-	for rows.Next() {
-		var id string
-		var name string
-		var description *string
-		var createdAt time.Time
-		var updatedAt *time.Time
-		var createdByID *string
-		var createdByUsername *string
-		var createdByEmail *string
-		var updatedByID *string
-		var updatedByUsername *string
-		var updatedByEmail *string
-
-		dest := []any{&id, &name, &description, &createdAt, &updatedAt}
-		if in.Detailed {
-			dest = append(dest, &createdByID, &createdByUsername, &createdByEmail, &updatedByID, &updatedByUsername, &updatedByEmail)
-		}
-		if err := rows.Scan(dest...); err != nil {
-			return nil, err
-		}
-		role := Role{
-			ID:          id,
-			Name:        name,
-			Description: description,
-			CreatedAt:   createdAt,
-			UpdatedAt:   updatedAt,
+	if (in.ID != "" || in.Name != "") && len(page.Items) == 0 {
+		return sqlutil.Page[Role]{}, ErrRoleNotFound
+	}
+	if in.PermissionID != "" && len(page.Items) == 0 {
+		n, _, err := s.store.Permissions().Count(f.Ctx, PermissionQuery{ID: in.PermissionID}, ListParams{})
+		if err != nil {
+			return sqlutil.Page[Role]{}, err
 		}
-		if in.Detailed {
-			role.Meta = &RoleMeta{
-				CreatedByID:       createdByID,
-				CreatedByUsername: createdByUsername,
-				CreatedByEmail:    createdByEmail,
-				UpdatedByID:       updatedByID,
-				UpdatedByUsername: updatedByUsername,
-				UpdatedByEmail:    updatedByEmail,
-			}
+		if n == 0 {
+			return sqlutil.Page[Role]{}, ErrPermissionNotFound
 		}
-		roles = append(roles, role)
-	}
-	if in.ID != "" && len(roles) == 0 {
-		return nil, ErrRoleNotFound
 	}
-	return roles, nil
+	return page, nil
 }
 
+// CreateRole creates a role. It fails with ErrRoleExists if the name is
+// taken, as reported by the unique constraint on the name.
 func (s *Service) CreateRole(f *flux.Flow, in CreateRoleInput) (Role, error) {
 	var errs valid.Errors
 	if in.Name == "" {
-		errs = append(errs, valid.Error{Field: "name", Error: "Required."})
+		errs = append(errs, valid.Required("name"))
 	}
 	if len(errs) != 0 {
 		return Role{}, errs
 	}
 
-	sql, args := psql.Select(
-		sm.Columns("id"),
-		sm.From("auth.roles"),
-		sm.Where(psql.Quote("name").EQ(psql.Arg(in.Name))),
-	).MustBuild()
-
-	var roleExists bool
-	if err := s.db.QueryRow(f.Ctx, sqlutil.Exists(sql), args...).Scan(&roleExists); err != nil {
-		return Role{}, err
-	}
-	if roleExists {
-		return Role{}, ErrRoleExists
-	}
-
 	role := Role{
 		ID:          uuid.New().String(),
 		Name:        in.Name,
 		Description: in.Description,
 		CreatedAt:   f.Time,
 	}
-	sql, args = psql.Insert(
-		im.Into("auth.roles",
-			"id", "name", "description", "created_at", "created_by",
-		),
-		im.Values(psql.Arg(role.ID, role.Name, role.Description, role.CreatedAt, f.Session.User)),
-	).MustBuild()
-
-	if _, err := s.db.Exec(f.Ctx, sql, args...); err != nil {
+	if err := s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		if err := tx.Roles().Create(f.Ctx, role, sessionUser(f)); err != nil {
+			return err
+		}
+		return emit(f, tx, EventRoleCreated, role.ID, role)
+	}); err != nil {
 		return Role{}, err
 	}
 	return role, nil
@@ -207,89 +145,59 @@ func (s *Service) CreateRole(f *flux.Flow, in CreateRoleInput) (Role, error) {
 func (s *Service) UpdateRole(f *flux.Flow, in UpdateRoleInput) error {
 	var errs valid.Errors
 	if in.ID == "" {
-		errs = append(errs, valid.Error{Field: "id", Error: "Required."})
+		errs = append(errs, valid.Required("id"))
 	}
 	if in.ID != "" && !valid.IsUUID(in.ID) {
-		errs = append(errs, valid.Error{Field: "id", Error: "Invalid id."})
+		errs = append(errs, valid.InvalidID("id"))
 	}
 	if in.Name == "" && in.Description == "" {
-		errs = append(errs, valid.Error{Error: "Input required."})
+		errs = append(errs, valid.InputRequired())
 	}
 	if len(errs) != 0 {
 		return errs
 	}
-
-	if in.Name != "" {
-		sql, args := psql.Select(
-			sm.Columns("id"),
-			sm.From("auth.roles"),
-			psql.WhereAnd(
-				sm.Where(psql.Quote("id").NE(psql.Arg(in.ID))),
-				sm.Where(psql.Quote("name").EQ(psql.Arg(in.Name))),
-			),
-		).MustBuild()
-
-		var roleExists bool
-		if err := s.db.QueryRow(f.Ctx, sqlutil.Exists(sql), args...).Scan(&roleExists); err != nil {
+	return s.store.Tx(f.Ctx, protectedRolesTx, func(tx Store) error {
+		if err := s.keepRoleHolders(f, tx, func() error {
+			return tx.Roles().Update(f.Ctx, in, f.Time, sessionUser(f))
+		}); err != nil {
 			return err
 		}
-		if roleExists {
-			return ErrRoleExists
-		}
-	}
-
-	q := psql.Update(
-		um.Table("auth.roles"),
-		um.Set("updated_at").ToArg(f.Time),
-		um.Set("updated_by").ToArg(f.Session.User),
-		um.Where(psql.Quote("id").EQ(psql.Arg(in.ID))),
-	)
-	if in.Name != "" {
-		q.Apply(
-			um.Set("name").ToArg(in.Name),
-		)
-	}
-	if in.Description != "" {
-		q.Apply(
-			um.Set("description").ToArg(in.Description),
-		)
-	}
-	sql, args := q.MustBuild()
-
-	res, err := s.db.Exec(f.Ctx, sql, args...)
-	if err != nil {
-		return err
-	}
-	if res.RowsAffected() == 0 {
-		return ErrRoleNotFound
-	}
-	return nil
+		return emit(f, tx, EventRoleUpdated, in.ID, in)
+	})
 }
 
 func (s *Service) DeleteRole(f *flux.Flow, in DeleteRoleInput) (int, error) {
 	var errs valid.Errors
 	if len(in.IDs) == 0 {
-		errs = append(errs, valid.Error{Field: "ids", Error: "Required."})
+		errs = append(errs, valid.Required("ids"))
 	}
 	if !valid.IsUUIDSlice(in.IDs) {
-		errs = append(errs, valid.Error{Field: "ids", Error: "Contains invalid id."})
+		errs = append(errs, valid.InvalidIDs("ids"))
 	}
 	if len(errs) != 0 {
 		return 0, errs
 	}
 
-	sql, args := psql.Delete(
-		dm.From("auth.roles"),
-		dm.Where(
-			psql.Quote("id").In(
-				psql.Arg(sqlutil.AnySlice(in.IDs)...),
-			),
-		),
-	).MustBuild()
-
-	res, err := s.db.Exec(f.Ctx, sql, args...)
-	if err != nil {
+	var n int
+	if err := s.store.Tx(f.Ctx, protectedRolesTx, func(tx Store) error {
+		var ids []string
+		err := s.keepRoleHolders(f, tx, func() error {
+			var err error
+			ids, err = tx.Roles().Delete(f.Ctx, in.IDs)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := emit(f, tx, EventRoleDeleted, id, RoleChange{RoleID: id}); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	}); err != nil {
 		return 0, err
 	}
-	return int(res.RowsAffected()), nil
+	return n, nil
 }
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"glut/common/flux"
+	"glut/common/sqlutil"
+	"glut/common/valid"
+	"slices"
+	"time"
+)
+
+const (
+	defaultRolePermissionQueryLimit = 20
+	maxRolePermissionQueryLimit     = 100
+)
+
+// RoleGrant is a permission granted by a role.
+type RoleGrant struct {
+	RoleID         string `json:"role_id"`
+	RoleName       string `json:"role_name"`
+	PermissionID   string `json:"permission_id"`
+	PermissionName string `json:"permission_name"`
+}
+
+// RolePermission is the grant of a permission to a role.
+type RolePermission struct {
+	ID             string              `json:"id"`
+	RoleID         string              `json:"role_id"`
+	RoleName       string              `json:"role_name"`
+	PermissionID   string              `json:"permission_id"`
+	PermissionName string              `json:"permission_name"`
+	CreatedAt      time.Time           `json:"created_at"`
+	Meta           *RolePermissionMeta `json:"meta,omitempty"`
+}
+
+// RolePermissionMeta is the user who granted a permission.
+type RolePermissionMeta struct {
+	CreatedByID       *string `json:"created_by_id"`
+	CreatedByUsername *string `json:"created_by_username"`
+	CreatedByEmail    *string `json:"created_by_email"`
+}
+
+type RolePermissionQuery struct {
+	RoleID   string           `json:"role_id"`
+	Limit    int              `json:"limit"`
+	Offset   int              `json:"offset"`
+	Cursor   *string          `json:"cursor"`
+	Envelope bool             `json:"envelope"`
+	Sort     string           `json:"sort"`
+	Filters  []sqlutil.Filter `json:"filters"`
+	Detailed bool             `json:"detailed"`
+}
+
+type GrantPermissionsInput struct {
+	RoleID        string   `json:"role_id"`
+	PermissionIDs []string `json:"permission_ids"`
+}
+
+type RevokePermissionsInput struct {
+	RoleID        string   `json:"role_id"`
+	PermissionIDs []string `json:"permission_ids"`
+}
+
+// sortValue returns the value of rp for the sort key name.
+func (rp RolePermission) sortValue(name string) any {
+	if name == "permission_name" {
+		return rp.PermissionName
+	}
+	return rp.CreatedAt
+}
+
+// RolePermissions returns the permissions granted to a role with when and,
+// if detailed, by whom they were granted.
+func (s *Service) RolePermissions(f *flux.Flow, in RolePermissionQuery) (sqlutil.Page[RolePermission], error) {
+	var errs valid.Errors
+	if in.RoleID == "" {
+		errs = append(errs, valid.Required("role_id"))
+	} else if !valid.IsUUID(in.RoleID) {
+		errs = append(errs, valid.InvalidID("role_id"))
+	}
+	p, listErrs := s.listParams(rolePermissionKeyset, rolePermissionFields, defaultRolePermissionSort, in.Filters, in.Sort, in.Cursor, in.Offset)
+	errs = append(errs, listErrs...)
+	if len(errs) != 0 {
+		return sqlutil.Page[RolePermission]{}, errs
+	}
+
+	if in.Limit <= 0 || in.Limit > maxRolePermissionQueryLimit {
+		in.Limit = defaultRolePermissionQueryLimit
+	}
+	if in.Offset < 0 {
+		in.Offset = 0
+	}
+	p.Limit, p.Offset, p.Now = in.Limit, in.Offset, f.Time
+
+	page, err := list(f, s, s.store.RolePermissions(), in, p, in.Envelope, in.Cursor, func(rp RolePermission) string { return rp.ID }, RolePermission.sortValue)
+	if err != nil {
+		return sqlutil.Page[RolePermission]{}, err
+	}
+	if len(page.Items) == 0 {
+		n, _, err := s.store.Roles().Count(f.Ctx, RoleQuery{ID: in.RoleID}, ListParams{})
+		if err != nil {
+			return sqlutil.Page[RolePermission]{}, err
+		}
+		if n == 0 {
+			return sqlutil.Page[RolePermission]{}, ErrRoleNotFound
+		}
+	}
+	return page, nil
+}
+
+// GrantPermissions grants permissions to a role. Permissions the role
+// already has are skipped. It returns the number of newly granted
+// permissions.
+func (s *Service) GrantPermissions(f *flux.Flow, in GrantPermissionsInput) (int, error) {
+	var errs valid.Errors
+	if in.RoleID == "" {
+		errs = append(errs, valid.Required("role_id"))
+	} else if !valid.IsUUID(in.RoleID) {
+		errs = append(errs, valid.InvalidID("role_id"))
+	}
+	if len(in.PermissionIDs) == 0 {
+		errs = append(errs, valid.Required("permission_ids"))
+	}
+	if !valid.IsUUIDSlice(in.PermissionIDs) {
+		errs = append(errs, valid.InvalidIDs("permission_ids"))
+	}
+	if len(errs) != 0 {
+		return 0, errs
+	}
+	permissionIDs := slices.Clone(in.PermissionIDs)
+	slices.Sort(permissionIDs)
+	permissionIDs = slices.Compact(permissionIDs)
+
+	var n int
+	if err := s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		var err error
+		n, err = tx.Roles().Grant(f.Ctx, in.RoleID, permissionIDs, f.Time, sessionUser(f))
+		if err != nil || n == 0 {
+			return err
+		}
+		return emit(f, tx, EventRolePermissionsGranted, in.RoleID, GrantPermissionsInput{RoleID: in.RoleID, PermissionIDs: permissionIDs})
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// RevokePermissions revokes permissions from a role. It returns the number
+// of revoked permissions.
+func (s *Service) RevokePermissions(f *flux.Flow, in RevokePermissionsInput) (int, error) {
+	var errs valid.Errors
+	if in.RoleID == "" {
+		errs = append(errs, valid.Required("role_id"))
+	} else if !valid.IsUUID(in.RoleID) {
+		errs = append(errs, valid.InvalidID("role_id"))
+	}
+	if len(in.PermissionIDs) == 0 {
+		errs = append(errs, valid.Required("permission_ids"))
+	}
+	if !valid.IsUUIDSlice(in.PermissionIDs) {
+		errs = append(errs, valid.InvalidIDs("permission_ids"))
+	}
+	if len(errs) != 0 {
+		return 0, errs
+	}
+
+	var n int
+	if err := s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		var err error
+		n, err = tx.Roles().Revoke(f.Ctx, in.RoleID, in.PermissionIDs)
+		if err != nil || n == 0 {
+			return err
+		}
+		return emit(f, tx, EventRolePermissionsRevoked, in.RoleID, RevokePermissionsInput{RoleID: in.RoleID, PermissionIDs: in.PermissionIDs})
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
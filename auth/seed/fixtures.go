@@ -0,0 +1,300 @@
+package seed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"glut/auth"
+	"glut/common/flux"
+	"glut/common/postgres"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fixtures declare data loaded into an auth service. Entities reference each
+// other by name: roles by name, permissions by name and users by username.
+type Fixtures struct {
+	Permissions []PermissionFixture `yaml:"permissions"`
+	Roles       []RoleFixture       `yaml:"roles"`
+	Users       []UserFixture       `yaml:"users"`
+	Bans        []BanFixture        `yaml:"bans"`
+}
+
+type PermissionFixture struct {
+	Name        string  `yaml:"name"`
+	Description *string `yaml:"description"`
+}
+
+type RoleFixture struct {
+	Name        string   `yaml:"name"`
+	Description *string  `yaml:"description"`
+	Permissions []string `yaml:"permissions"`
+}
+
+type UserFixture struct {
+	Username string `yaml:"username"`
+	Email    string `yaml:"email"`
+	// Password is the plaintext password of the user. It is hashed when the
+	// user is created.
+	Password string   `yaml:"password"`
+	Roles    []string `yaml:"roles"`
+}
+
+type BanFixture struct {
+	User        string  `yaml:"user"`
+	Reason      string  `yaml:"reason"`
+	Description *string `yaml:"description"`
+	// Duration of the ban. The ban is permanent if it is not set.
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Result counts the entities created and skipped by Load. Entities are
+// skipped if they already exist.
+type Result struct {
+	Created map[string]int
+	Skipped map[string]int
+}
+
+// ReadFixtures reads fixtures from a YAML file. Unknown keys are reported as
+// errors.
+func ReadFixtures(path string) (*Fixtures, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("seed.ReadFixtures: %w", err)
+	}
+	var fx Fixtures
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fx); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("seed.ReadFixtures: %s: %w", path, err)
+	}
+	return &fx, nil
+}
+
+// Load creates the fixtures using the auth service. Existing permissions,
+// roles and users are reused, so loading the same fixtures again only adds
+// what is missing. Lookups read from the primary database as they depend on
+// the preceding writes.
+func Load(ctx context.Context, s *auth.Service, logger *slog.Logger, fx *Fixtures) (*Result, error) {
+	l := &loader{
+		ctx:         postgres.ReadYourWrites(ctx),
+		s:           s,
+		logger:      logger,
+		permissions: make(map[string]string),
+		roles:       make(map[string]string),
+		users:       make(map[string]string),
+		res:         &Result{Created: make(map[string]int), Skipped: make(map[string]int)},
+	}
+	for _, p := range fx.Permissions {
+		if err := l.permission(p); err != nil {
+			return l.res, fmt.Errorf("seed.Load: permission %q: %w", p.Name, err)
+		}
+	}
+	for _, r := range fx.Roles {
+		if err := l.role(r); err != nil {
+			return l.res, fmt.Errorf("seed.Load: role %q: %w", r.Name, err)
+		}
+	}
+	for _, u := range fx.Users {
+		if err := l.user(u); err != nil {
+			return l.res, fmt.Errorf("seed.Load: user %q: %w", u.Username, err)
+		}
+	}
+	for _, b := range fx.Bans {
+		if err := l.ban(b); err != nil {
+			return l.res, fmt.Errorf("seed.Load: ban of %q: %w", b.User, err)
+		}
+	}
+	return l.res, nil
+}
+
+// loader loads fixtures and tracks the ids of loaded entities by name.
+type loader struct {
+	ctx         context.Context
+	s           *auth.Service
+	logger      *slog.Logger
+	permissions map[string]string
+	roles       map[string]string
+	users       map[string]string
+	res         *Result
+}
+
+// flow creates a flow for a service call.
+func (l *loader) flow() *flux.Flow {
+	return flux.NewFlow(l.ctx, l.logger)
+}
+
+func (l *loader) permission(p PermissionFixture) error {
+	permission, err := l.s.CreatePermission(l.flow(), auth.CreatePermissionInput{
+		Name:        p.Name,
+		Description: p.Description,
+	})
+	if errors.Is(err, auth.ErrPermissionExists) {
+		permissions, err := l.s.Permissions(l.flow(), auth.PermissionQuery{Name: p.Name})
+		if err != nil {
+			return err
+		}
+		l.permissions[p.Name] = permissions.Items[0].ID
+		l.res.Skipped["permissions"]++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	l.permissions[p.Name] = permission.ID
+	l.res.Created["permissions"]++
+	return nil
+}
+
+func (l *loader) role(r RoleFixture) error {
+	role, err := l.s.CreateRole(l.flow(), auth.CreateRoleInput{
+		Name:        r.Name,
+		Description: r.Description,
+	})
+	if errors.Is(err, auth.ErrRoleExists) {
+		roles, err := l.s.Roles(l.flow(), auth.RoleQuery{Name: r.Name})
+		if err != nil {
+			return err
+		}
+		role = roles.Items[0]
+		l.res.Skipped["roles"]++
+	} else if err != nil {
+		return err
+	} else {
+		l.res.Created["roles"]++
+	}
+	l.roles[r.Name] = role.ID
+
+	if len(r.Permissions) == 0 {
+		return nil
+	}
+	ids := make([]string, len(r.Permissions))
+	for i, name := range r.Permissions {
+		id, err := l.permissionID(name)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+	count, err := l.s.GrantPermissions(l.flow(), auth.GrantPermissionsInput{RoleID: role.ID, PermissionIDs: ids})
+	if err != nil {
+		return err
+	}
+	l.res.Created["role permissions"] += count
+	return nil
+}
+
+func (l *loader) user(u UserFixture) error {
+	user, err := l.s.CreateUser(l.flow(), auth.CreateUserInput{
+		Username: u.Username,
+		Email:    u.Email,
+		Password: u.Password,
+	})
+	if errors.Is(err, auth.ErrUserExists) {
+		id, err := l.userID(u.Username)
+		if err != nil {
+			return err
+		}
+		user.ID = id
+		l.res.Skipped["users"]++
+	} else if err != nil {
+		return err
+	} else {
+		l.res.Created["users"]++
+	}
+	l.users[u.Username] = user.ID
+
+	if len(u.Roles) == 0 {
+		return nil
+	}
+	ids := make([]string, len(u.Roles))
+	for i, name := range u.Roles {
+		id, err := l.roleID(name)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+	count, err := l.s.AssignRoles(l.flow(), auth.AssignRolesInput{UserID: user.ID, RoleIDs: ids})
+	if err != nil {
+		return err
+	}
+	l.res.Created["user roles"] += count
+	return nil
+}
+
+func (l *loader) ban(b BanFixture) error {
+	userID, err := l.userID(b.User)
+	if err != nil {
+		return err
+	}
+	// A negative duration bans the user permanently.
+	duration := int64(-1)
+	if b.Duration > 0 {
+		duration = int64(b.Duration.Seconds())
+	}
+	_, err = l.s.BanUser(l.flow(), auth.BanUserInput{
+		UserID:      userID,
+		Reason:      b.Reason,
+		Description: b.Description,
+		Duration:    duration,
+	})
+	if errors.Is(err, auth.ErrBanExists) {
+		l.res.Skipped["bans"]++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	l.res.Created["bans"]++
+	return nil
+}
+
+// permissionID returns the id of a permission loaded or existing by name.
+func (l *loader) permissionID(name string) (string, error) {
+	if id, ok := l.permissions[name]; ok {
+		return id, nil
+	}
+	permissions, err := l.s.Permissions(l.flow(), auth.PermissionQuery{Name: name})
+	if err != nil {
+		return "", err
+	}
+	l.permissions[name] = permissions.Items[0].ID
+	return permissions.Items[0].ID, nil
+}
+
+// roleID returns the id of a role loaded or existing by name.
+func (l *loader) roleID(name string) (string, error) {
+	if id, ok := l.roles[name]; ok {
+		return id, nil
+	}
+	roles, err := l.s.Roles(l.flow(), auth.RoleQuery{Name: name})
+	if err != nil {
+		return "", err
+	}
+	l.roles[name] = roles.Items[0].ID
+	return roles.Items[0].ID, nil
+}
+
+// userID returns the id of a user loaded or existing by username.
+func (l *loader) userID(username string) (string, error) {
+	if id, ok := l.users[username]; ok {
+		return id, nil
+	}
+	users, err := l.s.Users(l.flow(), auth.UserQuery{Username: username, Limit: 100})
+	if err != nil {
+		return "", err
+	}
+	for _, u := range users.Items {
+		if u.Username == username {
+			l.users[username] = u.ID
+			return u.ID, nil
+		}
+	}
+	return "", auth.ErrUserNotFound
+}
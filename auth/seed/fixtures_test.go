@@ -0,0 +1,105 @@
+package seed
+
+import (
+	"context"
+	"glut/auth"
+	"glut/common/flux"
+	"log/slog"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(testWriter{t}, nil))
+	fx, err := ReadFixtures("../../db/fixtures/dev.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := auth.NewService(auth.NewMemoryStore(), &auth.Config{})
+
+	res, err := Load(ctx, s, logger, fx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"permissions": 12, "roles": 2, "role permissions": 14, "users": 4, "user roles": 2, "bans": 1}
+	if !maps.Equal(res.Created, want) || len(res.Skipped) != 0 {
+		t.Errorf("result = %+v, want created %v", res, want)
+	}
+
+	f := flux.NewFlow(ctx, logger)
+	users, err := s.Users(f, auth.UserQuery{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]string)
+	for _, u := range users.Items {
+		ids[u.Username] = u.ID
+	}
+	roles := func(username string) []string {
+		userRoles, err := s.UserRoles(f, auth.UserRoleQuery{UserID: ids[username]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, ur := range userRoles.Items {
+			names = append(names, ur.RoleName)
+		}
+		return names
+	}
+	for username, want := range map[string][]string{"glut": {"admin"}, "glut2": {"moderator"}, "glut3": nil, "glut4": nil} {
+		if got := roles(username); !slices.Equal(got, want) {
+			t.Errorf("roles of %s = %v, want %v", username, got, want)
+		}
+	}
+
+	admin, err := s.Roles(f, auth.RoleQuery{Name: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	granted, err := s.RolePermissions(f, auth.RolePermissionQuery{RoleID: admin.Items[0].ID, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rp := range granted.Items {
+		got = append(got, rp.PermissionName)
+	}
+	var all []string
+	for _, p := range fx.Permissions {
+		all = append(all, p.Name)
+	}
+	slices.Sort(got)
+	slices.Sort(all)
+	if !slices.Equal(got, all) {
+		t.Errorf("permissions of admin = %v, want %v", got, all)
+	}
+
+	bans, err := s.Bans(f, auth.BanQuery{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans.Items) != 1 || bans.Items[0].UserID != ids["glut2"] || bans.Items[0].Reason != "spam" {
+		t.Errorf("bans = %+v, want glut2 banned for spam", bans.Items)
+	}
+
+	// Loading again skips what exists.
+	res, err = Load(ctx, s, logger, fx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]int{"permissions": 12, "roles": 2, "users": 4, "bans": 1}
+	created := map[string]int{"role permissions": 0, "user roles": 0}
+	if !maps.Equal(res.Skipped, want) || !maps.Equal(res.Created, created) {
+		t.Errorf("result of the second load = %+v, want skipped %v", res, want)
+	}
+}
+
+// testWriter writes logs to the test log.
+type testWriter struct{ t *testing.T }
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(string(p))
+	return len(p), nil
+}
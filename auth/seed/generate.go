@@ -0,0 +1,187 @@
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"glut/auth"
+	"glut/common/flux"
+	"log/slog"
+	"math/rand"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// MaxGeneratedSessions is the maximum number of sessions generated per
+	// user.
+	MaxGeneratedSessions = 10
+	// DefaultPassword is the password of generated users if none is set.
+	DefaultPassword = "password12345"
+	// generatedEmailDomain is the domain of generated emails.
+	generatedEmailDomain = "example.com"
+)
+
+var firstNames = []string{
+	"james", "mary", "robert", "patricia", "john", "jennifer", "michael", "linda",
+	"david", "elizabeth", "william", "barbara", "richard", "susan", "joseph", "jessica",
+	"thomas", "sarah", "carlos", "karen", "daniel", "lisa", "matthew", "nancy",
+	"anthony", "sofia", "mark", "ana", "paul", "emma", "kevin", "lucia",
+}
+
+var lastNames = []string{
+	"smith", "johnson", "williams", "brown", "jones", "garcia", "miller", "davis",
+	"rodriguez", "martinez", "hernandez", "lopez", "gonzalez", "wilson", "anderson", "thomas",
+	"taylor", "moore", "jackson", "martin", "lee", "perez", "thompson", "white",
+}
+
+// GenerateInput configures Generate.
+type GenerateInput struct {
+	// Users is the number of users to create.
+	Users int
+	// Sessions is the number of sessions to create per user.
+	Sessions int
+	// Password is shared by all generated users. DefaultPassword is used if
+	// it is empty.
+	Password string
+	// Concurrency is the number of users created in parallel.
+	Concurrency int
+	// Seed seeds the random usernames and IPs. The same seed generates the
+	// same users regardless of the concurrency. A random seed is used if it
+	// is 0.
+	Seed int64
+}
+
+// GenerateResult counts the generated users and sessions.
+type GenerateResult struct {
+	Users    int
+	Sessions int
+}
+
+// Generate creates users with realistic usernames and emails and logs each
+// of them in from random IPs. Generated usernames are suffixed with a random
+// number so that repeated runs rarely collide; colliding users are skipped.
+func Generate(ctx context.Context, s *auth.Service, logger *slog.Logger, in GenerateInput) (GenerateResult, error) {
+	if in.Users < 0 {
+		return GenerateResult{}, errors.New("seed.Generate: users must not be negative")
+	}
+	if in.Sessions < 0 || in.Sessions > MaxGeneratedSessions {
+		return GenerateResult{}, fmt.Errorf("seed.Generate: sessions must be between 0 and %d", MaxGeneratedSessions)
+	}
+	if in.Password == "" {
+		in.Password = DefaultPassword
+	}
+	if in.Concurrency <= 0 {
+		in.Concurrency = 1
+	}
+	if in.Seed == 0 {
+		in.Seed = rand.Int63()
+	}
+
+	var (
+		users    atomic.Int64
+		sessions atomic.Int64
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan int)
+	for i := 0; i < in.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// Each user has its own source so that it does not depend on
+				// the worker creating it.
+				g := &generator{s: s, logger: logger, rnd: rand.New(rand.NewSource(in.Seed + int64(i)))}
+				created, n, err := g.user(ctx, in.Password, in.Sessions)
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				if created {
+					users.Add(1)
+				}
+				sessions.Add(int64(n))
+			}
+		}()
+	}
+
+loop:
+	for i := 0; i < in.Users; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	res := GenerateResult{Users: int(users.Load()), Sessions: int(sessions.Load())}
+	if firstErr != nil {
+		return res, fmt.Errorf("seed.Generate: %w", firstErr)
+	}
+	if err := ctx.Err(); err != nil {
+		return res, fmt.Errorf("seed.Generate: %w", err)
+	}
+	return res, nil
+}
+
+// generator creates random users. It is not safe for concurrent use.
+type generator struct {
+	s      *auth.Service
+	logger *slog.Logger
+	rnd    *rand.Rand
+}
+
+// user creates a random user and logs it in sessions times. It returns
+// whether the user was created and the number of sessions created.
+func (g *generator) user(ctx context.Context, password string, sessions int) (bool, int, error) {
+	first := firstNames[g.rnd.Intn(len(firstNames))]
+	last := lastNames[g.rnd.Intn(len(lastNames))]
+	username := fmt.Sprintf("%s.%s%d", first, last, g.rnd.Intn(1_000_000))
+
+	_, err := g.s.CreateUser(g.flow(ctx), auth.CreateUserInput{
+		Username: username,
+		Email:    strings.ReplaceAll(username, ".", "_") + "@" + generatedEmailDomain,
+		Password: password,
+	})
+	if errors.Is(err, auth.ErrUserExists) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, fmt.Errorf("user %q: %w", username, err)
+	}
+
+	for i := 0; i < sessions; i++ {
+		_, err := g.s.CreateSession(g.flow(ctx), auth.Credentials{
+			Username: username,
+			Password: password,
+		})
+		if err != nil {
+			return true, i, fmt.Errorf("session of user %q: %w", username, err)
+		}
+	}
+	return true, sessions, nil
+}
+
+// flow creates a flow originating from a random public IPv4 address.
+func (g *generator) flow(ctx context.Context) *flux.Flow {
+	f := flux.NewFlow(ctx, g.logger)
+	var ip [4]byte
+	ip[0] = byte(1 + g.rnd.Intn(223))
+	for i := 1; i < len(ip); i++ {
+		ip[i] = byte(g.rnd.Intn(256))
+	}
+	f.IP = netip.AddrFrom4(ip).String()
+	return f
+}
@@ -0,0 +1,54 @@
+package seed
+
+import (
+	"context"
+	"glut/auth"
+	"glut/common/flux"
+	"log/slog"
+	"slices"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(testWriter{t}, nil))
+
+	// generate returns the usernames and session IPs generated with seed.
+	generate := func(seed int64, concurrency int) (usernames, ips []string) {
+		s := auth.NewService(auth.NewMemoryStore(), &auth.Config{})
+		res, err := Generate(ctx, s, logger, GenerateInput{Users: 4, Sessions: 2, Concurrency: concurrency, Seed: seed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Users != 4 || res.Sessions != 8 {
+			t.Errorf("result = %+v, want 4 users and 8 sessions", res)
+		}
+		f := flux.NewFlow(ctx, logger)
+		users, err := s.Users(f, auth.UserQuery{Limit: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range users.Items {
+			usernames = append(usernames, u.Username)
+			sessions, err := s.Sessions(f, auth.SessionQuery{UserID: u.ID, Limit: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, session := range sessions.Items {
+				ips = append(ips, session.UserIP)
+			}
+		}
+		slices.Sort(usernames)
+		slices.Sort(ips)
+		return usernames, ips
+	}
+
+	usernames, ips := generate(42, 1)
+	usernames2, ips2 := generate(42, 4)
+	if !slices.Equal(usernames, usernames2) || !slices.Equal(ips, ips2) {
+		t.Errorf("seed 42 generated %v from %v and %v from %v, want the same users", usernames, ips, usernames2, ips2)
+	}
+	if usernames3, _ := generate(43, 1); slices.Equal(usernames, usernames3) {
+		t.Errorf("seeds 42 and 43 generated the same users %v", usernames)
+	}
+}
@@ -2,9 +2,12 @@ package auth
 
 import (
 	"cmp"
+	"glut/common/flux"
+	"glut/common/sqlutil"
+	"glut/common/valid"
+	"slices"
+	"sync/atomic"
 	"time"
-
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var (
@@ -30,15 +33,23 @@ var (
 	maxChangeEmailTokenDuration     = 24 * time.Hour
 	defaultChangeEmailTokenDuration = 3 * time.Hour
 
+	minRevertEmailTokenDuration     = 1 * time.Hour
+	maxRevertEmailTokenDuration     = 30 * 24 * time.Hour
+	defaultRevertEmailTokenDuration = 7 * 24 * time.Hour
+
 	minResetPasswordTokenDuration     = 15 * time.Minute
 	maxResetPasswordTokenDuration     = 24 * time.Hour
 	defaultResetPasswordTokenDuration = 3 * time.Hour
+
+	defaultProtectedRoles = []string{"admin"}
 )
 
 // Service...
 type Service struct {
-	cfg *Config
-	db  *pgxpool.Pool
+	cfg       atomic.Pointer[Config]
+	store     Store
+	cursors   *sqlutil.CursorCodec
+	templates *EmailTemplates
 }
 
 // Config...
@@ -53,28 +64,121 @@ type Config struct {
 	VerificationTokenWaitTime time.Duration
 	// ChangeEmailTokenDuration...
 	ChangeEmailTokenDuration time.Duration
+	// RevertEmailTokenDuration is how long the previous address of a user
+	// can revert a change of their email.
+	RevertEmailTokenDuration time.Duration
 	// ResetPasswordTokenDuration...
 	ResetPasswordTokenDuration time.Duration
 	// PasswordChecker...
 	PasswordChecker PasswordCompareFunc
+	// ProtectedRoles are the names of the roles whose last holder cannot be
+	// removed. It defaults to admin if nil.
+	ProtectedRoles []string
+	// CursorKey signs pagination cursors. Cursors are only valid until the
+	// service is restarted if it is empty. It is only read by NewService.
+	CursorKey []byte
+	// EmailTemplates render the emails sent by the service. The embedded
+	// templates with relative links are used if it is nil. It is only read
+	// by NewService.
+	EmailTemplates *EmailTemplates
 }
 
 // NewService...
-func NewService(db *pgxpool.Pool, cfg *Config) *Service {
+func NewService(store Store, cfg *Config) *Service {
+	s := &Service{
+		store:     store,
+		cursors:   sqlutil.NewCursorCodec(cfg.CursorKey),
+		templates: cfg.EmailTemplates,
+	}
+	if s.templates == nil {
+		s.templates = mustLoadEmailTemplates()
+	}
+	s.SetConfig(cfg)
+	return s
+}
+
+// SetConfig atomically replaces the config of the service. Values outside of
+// their allowed range are replaced by defaults as in NewService.
+func (s *Service) SetConfig(cfg *Config) {
+	cfg = &Config{
+		TokenLength:                cfg.TokenLength,
+		SessionTokenDuration:       cfg.SessionTokenDuration,
+		VerificationTokenDuration:  cfg.VerificationTokenDuration,
+		VerificationTokenWaitTime:  cfg.VerificationTokenWaitTime,
+		ChangeEmailTokenDuration:   cfg.ChangeEmailTokenDuration,
+		RevertEmailTokenDuration:   cfg.RevertEmailTokenDuration,
+		ResetPasswordTokenDuration: cfg.ResetPasswordTokenDuration,
+		PasswordChecker:            cfg.PasswordChecker,
+		ProtectedRoles:             slices.Clone(cfg.ProtectedRoles),
+	}
 	if cfg.PasswordChecker == nil {
 		cfg.PasswordChecker = defaultPasswordFunc
 	}
+	if cfg.ProtectedRoles == nil {
+		cfg.ProtectedRoles = defaultProtectedRoles
+	}
 	cfg.TokenLength = minMaxValue(cfg.TokenLength, minTokenLength, maxTokenLength, defaultTokenLength)
 	cfg.SessionTokenDuration = minMaxValue(cfg.SessionTokenDuration, minSessionTokenDuration, maxSessionTokenDuration, defaultSessionTokenDuration)
 	cfg.VerificationTokenDuration = minMaxValue(cfg.VerificationTokenDuration, minVerificationTokenDuration, maxVerificationTokenDuration, defaultVerificationTokenDuration)
 	cfg.VerificationTokenWaitTime = minMaxValue(cfg.VerificationTokenWaitTime, minVerificationTokenWaitTime, maxVerificationTokenWaitTime, defaultVerificationTokenWaitTime)
 	cfg.ChangeEmailTokenDuration = minMaxValue(cfg.ChangeEmailTokenDuration, minChangeEmailTokenDuration, maxChangeEmailTokenDuration, defaultChangeEmailTokenDuration)
+	cfg.RevertEmailTokenDuration = minMaxValue(cfg.RevertEmailTokenDuration, minRevertEmailTokenDuration, maxRevertEmailTokenDuration, defaultRevertEmailTokenDuration)
 	cfg.ResetPasswordTokenDuration = minMaxValue(cfg.ResetPasswordTokenDuration, minResetPasswordTokenDuration, maxResetPasswordTokenDuration, defaultResetPasswordTokenDuration)
+	s.cfg.Store(cfg)
+}
+
+// config returns the current config of the service.
+func (s *Service) config() *Config {
+	return s.cfg.Load()
+}
+
+// Validate reports configuration values outside of their allowed range.
+// Zero values are valid and replaced by defaults in NewService.
+func (c *Config) Validate() error {
+	var errs valid.Errors
+	if !inRange(c.TokenLength, minTokenLength, maxTokenLength) {
+		errs = append(errs, valid.OutOfRange("token_length", minTokenLength, maxTokenLength))
+	}
+	if !inRange(c.SessionTokenDuration, minSessionTokenDuration, maxSessionTokenDuration) {
+		errs = append(errs, valid.OutOfRange("session_token_duration", minSessionTokenDuration, maxSessionTokenDuration))
+	}
+	if !inRange(c.VerificationTokenDuration, minVerificationTokenDuration, maxVerificationTokenDuration) {
+		errs = append(errs, valid.OutOfRange("verification_token_duration", minVerificationTokenDuration, maxVerificationTokenDuration))
+	}
+	if !inRange(c.VerificationTokenWaitTime, minVerificationTokenWaitTime, maxVerificationTokenWaitTime) {
+		errs = append(errs, valid.OutOfRange("verification_token_wait_time", minVerificationTokenWaitTime, maxVerificationTokenWaitTime))
+	}
+	if !inRange(c.ChangeEmailTokenDuration, minChangeEmailTokenDuration, maxChangeEmailTokenDuration) {
+		errs = append(errs, valid.OutOfRange("change_email_token_duration", minChangeEmailTokenDuration, maxChangeEmailTokenDuration))
+	}
+	if !inRange(c.RevertEmailTokenDuration, minRevertEmailTokenDuration, maxRevertEmailTokenDuration) {
+		errs = append(errs, valid.OutOfRange("revert_email_token_duration", minRevertEmailTokenDuration, maxRevertEmailTokenDuration))
+	}
+	if !inRange(c.ResetPasswordTokenDuration, minResetPasswordTokenDuration, maxResetPasswordTokenDuration) {
+		errs = append(errs, valid.OutOfRange("reset_password_token_duration", minResetPasswordTokenDuration, maxResetPasswordTokenDuration))
+	}
+	if slices.Contains(c.ProtectedRoles, "") {
+		errs = append(errs, valid.Invalid("protected_roles"))
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// inRange reports whether val is zero or within [min, max].
+func inRange[T cmp.Ordered](val, min, max T) bool {
+	var zero T
+	return val == zero || (val >= min && val <= max)
+}
 
-	return &Service{
-		cfg: cfg,
-		db:  db,
+// sessionUser returns the user id of the flow session or nil if the flow has
+// no session, which is the case for calls made from the command line.
+func sessionUser(f *flux.Flow) *string {
+	if f.Session == nil {
+		return nil
 	}
+	return &f.Session.User
 }
 
 func minMaxValue[T cmp.Ordered](val, min, max, defaultVal T) T {
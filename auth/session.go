@@ -1,20 +1,14 @@
 package auth
 
 import (
-	"context"
 	"errors"
 	"glut/common/flux"
 	"glut/common/sqlutil"
 	"glut/common/valid"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
-	"github.com/stephenafamo/bob/dialect/psql"
-	"github.com/stephenafamo/bob/dialect/psql/dm"
-	"github.com/stephenafamo/bob/dialect/psql/im"
-	"github.com/stephenafamo/bob/dialect/psql/sm"
-	"github.com/stephenafamo/bob/dialect/psql/um"
 )
 
 const (
@@ -33,16 +27,31 @@ type Session struct {
 }
 
 type SessionQuery struct {
-	ID             string `json:"id"`
-	UserID         string `json:"user_id"`
-	Limit          int    `json:"limit"`
-	Offset         int    `json:"offset"`
-	IncludeExpired bool   `json:"include_expired"`
+	ID             string           `json:"id"`
+	UserID         string           `json:"user_id"`
+	Limit          int              `json:"limit"`
+	Offset         int              `json:"offset"`
+	Cursor         *string          `json:"cursor"`
+	Envelope       bool             `json:"envelope"`
+	Sort           string           `json:"sort"`
+	Filters        []sqlutil.Filter `json:"filters"`
+	IncludeExpired bool             `json:"include_expired"`
 }
 
 type Credentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// RoleIDs are the roles of the user to activate in the session. All
+	// roles of the user are activated if it is empty.
+	RoleIDs []string `json:"role_ids"`
+}
+
+type ActivateRolesInput struct {
+	RoleIDs []string `json:"role_ids"`
+}
+
+type DropRolesInput struct {
+	RoleIDs []string `json:"role_ids"`
 }
 
 type LogoutInput struct {
@@ -54,16 +63,26 @@ type ClearSessionInput struct {
 	UserID string   `json:"user_id"`
 }
 
-func (s *Service) Sessions(f *flux.Flow, in SessionQuery) ([]Session, error) {
+// sortValue returns the value of s for the sort key name.
+func (s Session) sortValue(name string) any {
+	if name == "expires_at" {
+		return s.ExpiresAt
+	}
+	return s.CreatedAt
+}
+
+func (s *Service) Sessions(f *flux.Flow, in SessionQuery) (sqlutil.Page[Session], error) {
 	var errs valid.Errors
 	if in.ID != "" && !valid.IsUUID(in.ID) {
-		errs = append(errs, valid.Error{Field: "id", Error: "Invalid id."})
+		errs = append(errs, valid.InvalidID("id"))
 	}
 	if in.UserID != "" && !valid.IsUUID(in.UserID) {
-		errs = append(errs, valid.Error{Field: "user_id", Error: "Invalid id."})
+		errs = append(errs, valid.InvalidID("user_id"))
 	}
+	p, listErrs := s.listParams(sessionKeyset, sessionFields, defaultSessionSort, in.Filters, in.Sort, in.Cursor, in.Offset)
+	errs = append(errs, listErrs...)
 	if len(errs) != 0 {
-		return nil, errs
+		return sqlutil.Page[Session]{}, errs
 	}
 
 	if in.Limit <= 0 || in.Limit > maxSessionQueryLimit {
@@ -72,202 +91,84 @@ func (s *Service) Sessions(f *flux.Flow, in SessionQuery) ([]Session, error) {
 	if in.Offset < 0 {
 		in.Offset = 0
 	}
+	p.Limit, p.Offset, p.Now = in.Limit, in.Offset, f.Time
 
-	q := psql.Select(
-		sm.Columns(
-			"id",
-			"token",
-			"user_id",
-			"user_ip",
-			"created_at",
-			"expires_at",
-		),
-		sm.From("auth.sessions"),
-	)
-	if in.ID != "" {
-		q.Apply(
-			sm.Where(psql.Quote("id").EQ(psql.Arg(in.ID))),
-		)
-	}
-	if in.UserID != "" {
-		q.Apply(
-			sm.Where(psql.Quote("user_id").EQ(psql.Arg(in.UserID))),
-		)
-	}
-	if !in.IncludeExpired {
-		q.Apply(
-			sm.Where(psql.Quote("expires_at").GT(psql.Arg(f.Time))),
-		)
-	}
-	if in.Limit != 0 {
-		q.Apply(
-			sm.Limit(in.Limit),
-		)
-	}
-	if in.Offset != 0 {
-		q.Apply(
-			sm.Offset(in.Offset),
-		)
-	}
-	sql, args := q.MustBuild()
-
-	rows, err := s.db.Query(f.Ctx, sql, args...)
+	page, err := list(f, s, s.store.Sessions(), in, p, in.Envelope, in.Cursor, func(s Session) string { return s.ID }, Session.sortValue)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	sessions := []Session{}
-	for rows.Next() {
-		var id string
-		var token string
-		var userID string
-		var userIP string
-		var createdAt time.Time
-		var expiresAt time.Time
-
-		if err := rows.Scan(
-			&id,
-			&token,
-			&userID,
-			&userIP,
-			&createdAt,
-			&expiresAt,
-		); err != nil {
-			return nil, err
-		}
-		sessions = append(sessions, Session{
-			ID:        id,
-			Token:     token,
-			UserID:    userID,
-			UserIP:    userIP,
-			CreatedAt: createdAt,
-			ExpiresAt: expiresAt,
-		})
+		return sqlutil.Page[Session]{}, err
 	}
-	if in.ID != "" && len(sessions) == 0 {
-		return nil, ErrSessionNotFound
+	if in.ID != "" && len(page.Items) == 0 {
+		return sqlutil.Page[Session]{}, ErrSessionNotFound
 	}
-	return sessions, nil
+	return page, nil
 }
 
 func (s *Service) CreateSession(f *flux.Flow, in Credentials) (Session, error) {
 	var errs valid.Errors
 	if in.Username == "" {
-		errs = append(errs, valid.Error{Field: "username", Error: "Required."})
+		errs = append(errs, valid.Required("username"))
 	}
 	if in.Password == "" {
-		errs = append(errs, valid.Error{Field: "password", Error: "Required."})
+		errs = append(errs, valid.Required("password"))
+	}
+	if !valid.IsUUIDSlice(in.RoleIDs) {
+		errs = append(errs, valid.InvalidIDs("role_ids"))
 	}
 	if len(errs) != 0 {
 		return Session{}, errs
 	}
-
-	tx, err := s.db.Begin(f.Ctx)
-	if err != nil {
-		return Session{}, err
-	}
-	defer tx.Rollback(f.Ctx)
-
-	sql, args := psql.Select(
-		sm.From("auth.users"),
-		sm.Columns("id", "password_hash"),
-		sm.Where(psql.Quote("username").EQ(psql.Arg(in.Username))),
-		sm.ForNoKeyUpdate(),
-	).MustBuild()
-
-	var userID string
-	var passwordHash string
-	if err := tx.QueryRow(f.Ctx, sql, args...).Scan(&userID, &passwordHash); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return Session{}, ErrInvalidCredentials
+	var roleIDs []string
+	if len(in.RoleIDs) != 0 {
+		roleIDs = slices.Clone(in.RoleIDs)
+		slices.Sort(roleIDs)
+		roleIDs = slices.Compact(roleIDs)
+	}
+
+	var sess Session
+	if err := s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		user, err := tx.Users().GetByUsername(f.Ctx, in.Username)
+		if err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				return ErrInvalidCredentials
+			}
+			return err
 		}
-		return Session{}, err
-	}
 
-	if err := validatePassword(passwordHash, in.Password, s.cfg.PasswordChecker); err != nil {
-		if errors.Is(err, ErrInvalidPassword) {
-			return Session{}, ErrInvalidCredentials
+		if err := validatePassword(user.Password, in.Password, s.config().PasswordChecker); err != nil {
+			if errors.Is(err, ErrInvalidPassword) {
+				return ErrInvalidCredentials
+			}
+			return err
 		}
-		return Session{}, err
-	}
-
-	sql, args = psql.Select(
-		sm.From("auth.bans"),
-		psql.WhereAnd(
-			sm.Where(psql.Quote("user_id").EQ(psql.Arg(userID))),
-			psql.WhereOr(
-				sm.Where(psql.Quote("unbanned_at").GT(psql.Arg(f.Time))),
-				sm.Where(psql.Quote("banned_at").EQ(psql.Quote("unbanned_at"))),
-			),
-		),
-	).MustBuild()
-
-	var isBanned bool
-	if err := tx.QueryRow(f.Ctx, sqlutil.Exists(sql), args...).Scan(&isBanned); err != nil {
-		return Session{}, err
-	}
-	if isBanned {
-		return Session{}, ErrUserBanned
-	}
-
-	q := `SELECT COUNT(id) FROM auth.sessions WHERE user_id = $1;`
-
-	var sessCount int
-	if err := tx.QueryRow(f.Ctx, q, userID).Scan(&sessCount); err != nil {
-		return Session{}, err
-	}
-	if sessCount >= maxSessionsPerUser {
-		return Session{}, ErrSessionLimit
-	}
 
-	q = `
-	SELECT session_number FROM generate_series (1,$1) session_number
-	EXCEPT (
-		SELECT session_number FROM auth.sessions WHERE user_id = $2
-	)
-	ORDER BY 1
-	LIMIT 1;`
-
-	var nextSessNum int
-	if err := tx.QueryRow(f.Ctx, q, maxSessionsPerUser, userID).Scan(&nextSessNum); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return Session{}, ErrSessionLimit
+		isBanned, err := tx.Bans().Active(f.Ctx, user.ID, f.Time)
+		if err != nil {
+			return err
+		}
+		if isBanned {
+			return ErrUserBanned
 		}
-		return Session{}, err
-	}
-
-	sess := Session{
-		ID:        uuid.New().String(),
-		Token:     mustGenerateToken(s.cfg.TokenLength),
-		UserID:    userID,
-		UserIP:    f.IP,
-		CreatedAt: f.Time,
-		ExpiresAt: f.Time.Add(s.cfg.SessionTokenDuration),
-	}
-
-	sql, args = psql.Insert(
-		im.Into("auth.sessions",
-			"id", "token", "user_id", "user_ip", "session_number", "created_at", "expires_at",
-		),
-		im.Values(psql.Arg(sess.ID, sess.Token, sess.UserID, sess.UserIP, nextSessNum, sess.CreatedAt, sess.ExpiresAt)),
-	).MustBuild()
-
-	if _, err := tx.Exec(f.Ctx, sql, args...); err != nil {
-		return Session{}, err
-	}
 
-	sql, args = psql.Update(
-		um.Table("auth.users"),
-		um.Set("last_login_at").ToArg(f.Time),
-		um.Set("last_login_ip").ToArg(f.IP),
-		um.Where(psql.Quote("id").EQ(psql.Arg(userID))),
-	).MustBuild()
+		sess = Session{
+			ID:        uuid.New().String(),
+			Token:     mustGenerateToken(s.config().TokenLength),
+			UserID:    user.ID,
+			UserIP:    f.IP,
+			CreatedAt: f.Time,
+			ExpiresAt: f.Time.Add(s.config().SessionTokenDuration),
+		}
+		if err := tx.Sessions().Create(f.Ctx, sess, maxSessionsPerUser); err != nil {
+			return err
+		}
+		if _, err := tx.Sessions().ActivateRoles(f.Ctx, sess.ID, roleIDs); err != nil {
+			return err
+		}
 
-	if _, err := tx.Exec(f.Ctx, sql, args...); err != nil {
-		return Session{}, err
-	}
-	if err := tx.Commit(f.Ctx); err != nil {
+		return tx.Users().Update(f.Ctx, user.ID, UserUpdate{
+			LastLoginAt: &f.Time,
+			LastLoginIP: &f.IP,
+		})
+	}); err != nil {
 		return Session{}, err
 	}
 	return sess, nil
@@ -276,64 +177,75 @@ func (s *Service) CreateSession(f *flux.Flow, in Credentials) (Session, error) {
 func (s *Service) ClearSessions(f *flux.Flow, in ClearSessionInput) (int, error) {
 	var errs valid.Errors
 	if len(in.IDs) == 0 && in.UserID == "" {
-		errs = append(errs, valid.Error{Error: "Input required."})
+		errs = append(errs, valid.InputRequired())
 	}
 	if !valid.IsUUIDSlice(in.IDs) {
-		errs = append(errs, valid.Error{Field: "ids", Error: "Contains invalid id."})
+		errs = append(errs, valid.InvalidIDs("ids"))
 	}
 	if in.UserID != "" && !valid.IsUUID(in.UserID) {
-		errs = append(errs, valid.Error{Field: "user_id", Error: "Invalid id."})
+		errs = append(errs, valid.InvalidID("user_id"))
 	}
 	if len(errs) != 0 {
 		return 0, errs
 	}
+	return s.store.Sessions().Delete(f.Ctx, in.IDs, in.UserID)
+}
 
-	q := psql.Delete(dm.From("auth.sessions"))
-	if in.IDs != nil {
-		q.Apply(dm.Where(
-			psql.Quote("id").In(
-				psql.Arg(sqlutil.AnySlice(in.IDs)...)),
-		))
+func (s *Service) RenewSession(f *flux.Flow) (time.Time, error) {
+	newExpiry := f.Time.Add(s.config().SessionTokenDuration)
+	if err := s.store.Sessions().Renew(f.Ctx, f.Session.ID, newExpiry); err != nil {
+		return time.Time{}, err
 	}
-	if in.UserID != "" {
-		q.Apply(dm.Where(
-			psql.Quote("user_id").EQ(psql.Arg(in.UserID)),
-		))
+	return newExpiry, nil
+}
+
+// ActivateRoles activates roles of the user in the session of f, adding the
+// permissions they grant to the session. It returns the number of newly
+// activated roles.
+func (s *Service) ActivateRoles(f *flux.Flow, in ActivateRolesInput) (int, error) {
+	if f.Session == nil {
+		return 0, ErrUnauthorized
 	}
-	sql, args := q.MustBuild()
+	var errs valid.Errors
+	if len(in.RoleIDs) == 0 {
+		errs = append(errs, valid.Required("role_ids"))
+	}
+	if !valid.IsUUIDSlice(in.RoleIDs) {
+		errs = append(errs, valid.InvalidIDs("role_ids"))
+	}
+	if len(errs) != 0 {
+		return 0, errs
+	}
+	roleIDs := slices.Clone(in.RoleIDs)
+	slices.Sort(roleIDs)
+	roleIDs = slices.Compact(roleIDs)
 
-	res, err := s.db.Exec(f.Ctx, sql, args...)
-	if err != nil {
+	var n int
+	if err := s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		var err error
+		n, err = tx.Sessions().ActivateRoles(f.Ctx, f.Session.ID, roleIDs)
+		return err
+	}); err != nil {
 		return 0, err
 	}
-	return int(res.RowsAffected()), nil
+	return n, nil
 }
 
-func (s *Service) RenewSession(f *flux.Flow) (time.Time, error) {
-	newExpiry := f.Time.Add(s.cfg.SessionTokenDuration)
-
-	sql, args := psql.Update(
-		um.Table("auth.sessions"),
-		um.Set("expires_at").ToArg(newExpiry),
-		um.Where(psql.Quote("id").EQ(psql.Arg(f.Session.ID))),
-	).MustBuild()
-
-	res, err := s.db.Exec(f.Ctx, sql, args...)
-	if err != nil {
-		return time.Time{}, err
+// DropRoles deactivates roles in the session of f. The user keeps the roles
+// and may activate them again. It returns the number of dropped roles.
+func (s *Service) DropRoles(f *flux.Flow, in DropRolesInput) (int, error) {
+	if f.Session == nil {
+		return 0, ErrUnauthorized
 	}
-	if res.RowsAffected() == 0 {
-		return time.Time{}, ErrSessionNotFound
+	var errs valid.Errors
+	if len(in.RoleIDs) == 0 {
+		errs = append(errs, valid.Required("role_ids"))
 	}
-	return newExpiry, nil
-}
-
-func deleteUserSessions(ctx context.Context, tx pgx.Tx, userID string) error {
-	sql, args := psql.Delete(
-		dm.From("auth.sessions"),
-		dm.Where(psql.Quote("user_id").EQ(psql.Arg(userID))),
-	).MustBuild()
-
-	_, err := tx.Exec(ctx, sql, args...)
-	return err
+	if !valid.IsUUIDSlice(in.RoleIDs) {
+		errs = append(errs, valid.InvalidIDs("role_ids"))
+	}
+	if len(errs) != 0 {
+		return 0, errs
+	}
+	return s.store.Sessions().DropRoles(f.Ctx, f.Session.ID, in.RoleIDs)
 }
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"glut/common/flux"
+	"glut/common/postgres"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSessionRoles(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	svc := NewService(store, &Config{})
+	authenticate := NewAuthenticator(store)
+	f := &flux.Flow{Ctx: ctx, Time: testNow}
+
+	user, err := svc.CreateUser(f, CreateUserInput{Username: "alice", Email: "alice@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rbac := createTestRBAC(t, store, user.ID)
+	admin, editor := rbac.admin, rbac.editor
+
+	permissions := func(sess Session) []string {
+		t.Helper()
+		s, err := authenticate(f, sess.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Permissions
+	}
+
+	// Sessions activate all roles of the user unless some are selected.
+	all, err := svc.CreateSession(f, Credentials{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := permissions(all), []string{"posts.read", "posts.write"}; !slices.Equal(got, want) {
+		t.Errorf("permissions of a session with all roles = %v, want %v", got, want)
+	}
+	if _, err := svc.CreateSession(f, Credentials{Username: "alice", Password: "secret", RoleIDs: []string{uuid.New().String()}}); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("selecting a role not held: got %v, want ErrRoleNotFound", err)
+	}
+	sess, err := svc.CreateSession(f, Credentials{Username: "alice", Password: "secret", RoleIDs: []string{editor.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := permissions(sess), []string{"posts.read"}; !slices.Equal(got, want) {
+		t.Errorf("permissions of an editor session = %v, want %v", got, want)
+	}
+
+	sf := &flux.Flow{Ctx: ctx, Time: testNow, Session: &flux.Session{ID: sess.ID, User: user.ID}}
+	if n, err := svc.ActivateRoles(sf, ActivateRolesInput{RoleIDs: []string{admin.ID, editor.ID}}); err != nil || n != 1 {
+		t.Errorf("ActivateRoles(admin, editor) = %d, %v, want 1", n, err)
+	}
+	if got, want := permissions(sess), []string{"posts.read", "posts.write"}; !slices.Equal(got, want) {
+		t.Errorf("permissions after activating admin = %v, want %v", got, want)
+	}
+	if n, err := svc.DropRoles(sf, DropRolesInput{RoleIDs: []string{editor.ID}}); err != nil || n != 1 {
+		t.Errorf("DropRoles(editor) = %d, %v, want 1", n, err)
+	}
+	if got, want := permissions(sess), []string{"posts.read", "posts.write"}; !slices.Equal(got, want) {
+		t.Errorf("permissions after dropping editor = %v, want %v", got, want)
+	}
+	if n, err := svc.DropRoles(sf, DropRolesInput{RoleIDs: []string{admin.ID}}); err != nil || n != 1 {
+		t.Errorf("DropRoles(admin) = %d, %v, want 1", n, err)
+	}
+	if got := permissions(sess); len(got) != 0 {
+		t.Errorf("permissions without active roles = %v, want none", got)
+	}
+
+	// Other sessions are not affected.
+	if got, want := permissions(all), []string{"posts.read", "posts.write"}; !slices.Equal(got, want) {
+		t.Errorf("permissions of the other session = %v, want %v", got, want)
+	}
+}
+
+// primaryStore is a Store checking that sessions are read from the primary.
+type primaryStore struct {
+	Store
+	t *testing.T
+}
+
+func (s primaryStore) Sessions() SessionStore { return primarySessionStore{s.Store.Sessions(), s.t} }
+
+type primarySessionStore struct {
+	SessionStore
+	t *testing.T
+}
+
+func (s primarySessionStore) Authenticate(ctx context.Context, token string, now time.Time) (Session, error) {
+	if !postgres.IsReadYourWrites(ctx) {
+		s.t.Error("Authenticate reads from a replica")
+	}
+	return s.SessionStore.Authenticate(ctx, token, now)
+}
+
+func (s primarySessionStore) Grants(ctx context.Context, id string) ([]RoleGrant, error) {
+	if !postgres.IsReadYourWrites(ctx) {
+		s.t.Error("Grants reads from a replica")
+	}
+	return s.SessionStore.Grants(ctx, id)
+}
+
+func TestAuthenticatorReadsPrimary(t *testing.T) {
+	store := primaryStore{NewMemoryStore(), t}
+	svc := NewService(store, &Config{})
+	f := &flux.Flow{Ctx: context.Background(), Time: testNow}
+	if _, err := svc.CreateUser(f, CreateUserInput{Username: "alice", Email: "alice@example.com", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := svc.CreateSession(f, Credentials{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAuthenticator(store)(f, sess.Token); err != nil {
+		t.Fatal(err)
+	}
+	f.Session = &flux.Session{ID: sess.ID}
+	if _, err := svc.MyPermissions(f); err != nil {
+		t.Fatal(err)
+	}
+}
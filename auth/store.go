@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"context"
+	"glut/common/sqlutil"
+	"time"
+)
+
+// Store is the storage of the service. Implementations must enforce the
+// constraints of the schemas of db/migrations: usernames, role names and
+// permission names are unique, a user has at most one ban and one token of
+// each kind, and deleting users or roles deletes what refers to them.
+type Store interface {
+	// Tx runs fn with a store whose operations run in one transaction, which
+	// is committed if fn returns nil and rolled back otherwise. Transactions
+	// may be retried as described by sqlutil.WithTx, so fn must not have
+	// effects outside of the store. Calling Tx on the store passed to fn
+	// starts a nested transaction.
+	Tx(ctx context.Context, opts sqlutil.TxOptions, fn func(tx Store) error) error
+	Users() UserStore
+	Sessions() SessionStore
+	Tokens() TokenStore
+	Roles() RoleStore
+	Permissions() PermissionStore
+	UserRoles() UserRoleStore
+	RolePermissions() RolePermissionStore
+	Bans() BanStore
+	Outbox() OutboxStore
+	Webhooks() WebhookStore
+	WebhookDeliveries() WebhookDeliveryStore
+	Emails() EmailStore
+}
+
+// ListParams are the validated filters, order and pagination of a list
+// query.
+type ListParams struct {
+	Filters []sqlutil.Filter
+	Keyset  sqlutil.Keyset
+	// Cursor is the position the page starts after or before. Rows are
+	// paginated by Offset unless Paginated is set; a nil Cursor then selects
+	// the first page.
+	Cursor    *sqlutil.Cursor
+	Paginated bool
+	Limit     int
+	Offset    int
+	// Now is the time expired rows are excluded at.
+	Now time.Time
+}
+
+// UserStore stores users. Get methods return users with their password hash
+// and fail with ErrUserNotFound. In a transaction, Get locks the user until
+// the transaction ends.
+type UserStore interface {
+	// List returns up to p.Limit+1 users matching q and p.
+	List(ctx context.Context, q UserQuery, p ListParams) ([]User, error)
+	// Count returns the number of users matching q and p and whether the
+	// number is estimated.
+	Count(ctx context.Context, q UserQuery, p ListParams) (int64, bool, error)
+	Get(ctx context.Context, id string) (User, error)
+	GetByUsername(ctx context.Context, username string) (User, error)
+	// UsernamesByEmail returns the usernames of the users with email,
+	// ordered by username.
+	UsernamesByEmail(ctx context.Context, email string) ([]string, error)
+	// Create fails with ErrUserExists if the username is taken.
+	Create(ctx context.Context, u User) error
+	Update(ctx context.Context, id string, in UserUpdate) error
+	// Delete deletes users and their sessions, tokens, bans and roles. It
+	// returns the ids of the deleted users.
+	Delete(ctx context.Context, ids []string) ([]string, error)
+}
+
+// UserUpdate are the fields of a user to update. Nil fields are unchanged.
+type UserUpdate struct {
+	Email        *string
+	PasswordHash *string
+	VerifiedAt   *time.Time
+	LastLoginAt  *time.Time
+	LastLoginIP  *string
+}
+
+// SessionStore stores sessions.
+type SessionStore interface {
+	List(ctx context.Context, q SessionQuery, p ListParams) ([]Session, error)
+	Count(ctx context.Context, q SessionQuery, p ListParams) (int64, bool, error)
+	// Authenticate returns the unexpired session with token or fails with
+	// ErrSessionNotFound.
+	Authenticate(ctx context.Context, token string, now time.Time) (Session, error)
+	// Create fails with ErrSessionLimit if the user has max sessions.
+	Create(ctx context.Context, s Session, max int) error
+	Renew(ctx context.Context, id string, expiresAt time.Time) error
+	// ActivateRoles activates roles of its user in the session with id, or
+	// all of them if roleIDs is nil, and returns the number of newly
+	// activated roles. It fails with ErrSessionNotFound or, if the user does
+	// not hold a role, with ErrRoleNotFound.
+	ActivateRoles(ctx context.Context, id string, roleIDs []string) (int, error)
+	// DropRoles deactivates roles in the session with id and returns the
+	// number of dropped roles.
+	DropRoles(ctx context.Context, id string, roleIDs []string) (int, error)
+	// Grants returns the permissions granted by the active roles of the
+	// session with id that its user still holds, ordered by permission and
+	// role name.
+	Grants(ctx context.Context, id string) ([]RoleGrant, error)
+	// Delete deletes the sessions with ids, of the user with userID, or
+	// both if both are given.
+	Delete(ctx context.Context, ids []string, userID string) (int, error)
+	// Purge deletes the sessions expired at before.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// TokenStore stores tokens. A user has at most one token of each kind.
+type TokenStore interface {
+	// Get returns the unexpired token with id and kind or fails with
+	// ErrInvalidToken. In a transaction, the token is locked until the
+	// transaction ends.
+	Get(ctx context.Context, id, kind string, now time.Time) (Token, error)
+	// ByUser returns the token of the user of kind, expired or not, and
+	// whether it exists.
+	ByUser(ctx context.Context, userID, kind string) (Token, bool, error)
+	// Save saves t, replacing the token of the same user and kind.
+	Save(ctx context.Context, t Token) error
+	Delete(ctx context.Context, id string) error
+	// Purge deletes the tokens expired at before.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// RoleStore stores roles and their assignments.
+type RoleStore interface {
+	List(ctx context.Context, q RoleQuery, p ListParams) ([]Role, error)
+	Count(ctx context.Context, q RoleQuery, p ListParams) (int64, bool, error)
+	// Create fails with ErrRoleExists if the name is taken.
+	Create(ctx context.Context, r Role, by *string) error
+	// Update fails with ErrRoleNotFound or, if the new name is taken, with
+	// ErrRoleExists.
+	Update(ctx context.Context, in UpdateRoleInput, at time.Time, by *string) error
+	// Delete returns the ids of the deleted roles.
+	Delete(ctx context.Context, ids []string) ([]string, error)
+	// Grant grants permissions to a role and returns the number of newly
+	// granted permissions. The grants record by unless the user does not
+	// exist. It fails with ErrRoleNotFound or ErrPermissionNotFound.
+	Grant(ctx context.Context, roleID string, permissionIDs []string, at time.Time, by *string) (int, error)
+	// Revoke revokes permissions from a role and returns the number of
+	// revoked permissions.
+	Revoke(ctx context.Context, roleID string, permissionIDs []string) (int, error)
+	// Assign assigns roles to a user and returns the number of newly
+	// assigned roles. The assignments record by unless the user does not
+	// exist. It fails with ErrUserNotFound or ErrRoleNotFound.
+	Assign(ctx context.Context, userID string, roleIDs []string, at time.Time, by *string) (int, error)
+	Unassign(ctx context.Context, userID string, roleIDs []string) (int, error)
+}
+
+// UserRoleStore lists the roles assigned to users by RoleStore.Assign.
+type UserRoleStore interface {
+	List(ctx context.Context, q UserRoleQuery, p ListParams) ([]UserRole, error)
+	Count(ctx context.Context, q UserRoleQuery, p ListParams) (int64, bool, error)
+	// Held reports whether any user holds the role named roleName. Unlike
+	// Count, it is always exact.
+	Held(ctx context.Context, roleName string) (bool, error)
+	// Grants returns the permissions granted by the roles of the user,
+	// ordered by permission and role name.
+	Grants(ctx context.Context, userID string) ([]RoleGrant, error)
+}
+
+// RolePermissionStore lists the permissions granted to roles by
+// RoleStore.Grant.
+type RolePermissionStore interface {
+	List(ctx context.Context, q RolePermissionQuery, p ListParams) ([]RolePermission, error)
+	Count(ctx context.Context, q RolePermissionQuery, p ListParams) (int64, bool, error)
+}
+
+// PermissionStore stores permissions.
+type PermissionStore interface {
+	List(ctx context.Context, q PermissionQuery, p ListParams) ([]Permission, error)
+	Count(ctx context.Context, q PermissionQuery, p ListParams) (int64, bool, error)
+	// Existing returns the names of names that are names of permissions,
+	// sorted.
+	Existing(ctx context.Context, names []string) ([]string, error)
+	// Create fails with ErrPermissionExists if the name is taken.
+	Create(ctx context.Context, p Permission, by *string) error
+	// Update fails with ErrPermissionNotFound or, if the new name is taken,
+	// with ErrPermissionExists.
+	Update(ctx context.Context, in UpdatePermissionInput, at time.Time, by *string) error
+	// Delete returns the ids of the deleted permissions. They are revoked
+	// from all roles.
+	Delete(ctx context.Context, ids []string) ([]string, error)
+}
+
+// BanStore stores bans.
+type BanStore interface {
+	List(ctx context.Context, q BanQuery, p ListParams) ([]Ban, error)
+	Count(ctx context.Context, q BanQuery, p ListParams) (int64, bool, error)
+	// Active reports whether the user is banned at now. In a transaction,
+	// the ban is locked until the transaction ends.
+	Active(ctx context.Context, userID string, now time.Time) (bool, error)
+	// Put saves the ban of a user, replacing any previous ban. It fails with
+	// ErrUserNotFound.
+	Put(ctx context.Context, b Ban, by *string) error
+	// Delete fails with ErrBanNotFound if the user has no ban.
+	Delete(ctx context.Context, userID string) error
+	// Purge deletes the bans lapsed at before. Permanent bans never lapse.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// OutboxStore stores the events of the outbox. Events are pending until they
+// are delivered, or dead once their delivery failed too often.
+type OutboxStore interface {
+	Add(ctx context.Context, events ...Event) error
+	// Claim leases up to limit pending events due at now until until and
+	// returns them in the order they were added. Only the oldest pending
+	// event of an aggregate is claimed, so that the events of an aggregate
+	// are delivered one at a time and in order.
+	Claim(ctx context.Context, limit int, now, until time.Time) ([]Event, error)
+	Delivered(ctx context.Context, id string, at time.Time) error
+	// Failed records a failed delivery with its reason. The event is retried
+	// at next, or is dead if next is nil.
+	Failed(ctx context.Context, id, reason string, next *time.Time) error
+	// Purge deletes the events delivered before before.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// WebhookStore stores webhooks. Get and Update fail with ErrWebhookNotFound.
+type WebhookStore interface {
+	List(ctx context.Context, q WebhookQuery, p ListParams) ([]Webhook, error)
+	Count(ctx context.Context, q WebhookQuery, p ListParams) (int64, bool, error)
+	// Get returns the webhook with its secret.
+	Get(ctx context.Context, id string) (Webhook, error)
+	Create(ctx context.Context, w Webhook) error
+	Update(ctx context.Context, id string, in WebhookUpdate, at time.Time) error
+	// Delete deletes webhooks and their deliveries.
+	Delete(ctx context.Context, ids []string) (int, error)
+	// Subscribers returns the ids of the enabled webhooks subscribed to
+	// events of type typ.
+	Subscribers(ctx context.Context, typ string) ([]string, error)
+	// Report records the result of a delivery attempt to a webhook. A
+	// success resets the failures of the webhook; a failure disables it
+	// once it has failed maxFailures consecutive attempts. It returns
+	// whether the webhook was disabled.
+	Report(ctx context.Context, id string, ok bool, at time.Time, maxFailures int) (bool, error)
+}
+
+// WebhookUpdate are the fields of a webhook to update. Nil fields are
+// unchanged. Enabling a webhook resets its failures.
+type WebhookUpdate struct {
+	URL         *string
+	Secret      *string
+	Events      []string
+	Description *string
+	Enabled     *bool
+}
+
+// WebhookDeliveryStore stores the deliveries of webhooks.
+type WebhookDeliveryStore interface {
+	List(ctx context.Context, q WebhookDeliveryQuery, p ListParams) ([]WebhookDelivery, error)
+	Count(ctx context.Context, q WebhookDeliveryQuery, p ListParams) (int64, bool, error)
+	// Add adds pending deliveries, due at their creation, with their
+	// payloads. Deliveries of an event to a webhook it was already added
+	// to are skipped.
+	Add(ctx context.Context, deliveries ...WebhookDelivery) error
+	// Claim leases up to limit pending deliveries of enabled webhooks due
+	// at now until until, with their payloads and webhooks.
+	Claim(ctx context.Context, limit int, now, until time.Time) ([]ClaimedDelivery, error)
+	// Attempted records an attempt of a delivery. A failed delivery is
+	// retried at next, or failed if next is nil. It fails with
+	// ErrDeliveryNotFound.
+	Attempted(ctx context.Context, id string, a DeliveryAttempt, next *time.Time) error
+	// Redeliver makes a delivery pending again, due at now and without
+	// attempts, and returns the id of its webhook. It fails with
+	// ErrDeliveryNotFound.
+	Redeliver(ctx context.Context, id string, now time.Time) (string, error)
+}
+
+// ClaimedDelivery is a claimed delivery with the webhook to send it to.
+type ClaimedDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// DeliveryAttempt is the result of an attempt to deliver to a webhook.
+type DeliveryAttempt struct {
+	At time.Time
+	OK bool
+	// StatusCode is the status of the response, if any.
+	StatusCode *int
+	Error      *string
+	Duration   time.Duration
+}
+
+// EmailStore stores the queue of emails.
+type EmailStore interface {
+	List(ctx context.Context, q EmailQuery, p ListParams) ([]Email, error)
+	Count(ctx context.Context, q EmailQuery, p ListParams) (int64, bool, error)
+	// Add adds pending emails, due at their creation, with their bodies. It
+	// fails with ErrUserNotFound if the user of an email does not exist.
+	Add(ctx context.Context, emails ...Email) error
+	// Claim leases up to limit pending emails due at now until until, with
+	// their bodies.
+	Claim(ctx context.Context, limit int, now, until time.Time) ([]Email, error)
+	// Attempted records an attempt to send an email, which failed if
+	// errMsg is set. A sent email loses its bodies; a failed one is retried
+	// at next, or failed if next is nil. It fails with ErrEmailNotFound.
+	Attempted(ctx context.Context, id string, at time.Time, errMsg *string, next *time.Time) error
+}
+
+// lister is implemented by the stores of listed entities.
+type lister[T, Q any] interface {
+	List(ctx context.Context, q Q, p ListParams) ([]T, error)
+	Count(ctx context.Context, q Q, p ListParams) (int64, bool, error)
+}
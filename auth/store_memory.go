@@ -0,0 +1,1918 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"glut/common/sqlutil"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memoryDB is the state shared by a memory store and its transactions.
+type memoryDB struct {
+	mu sync.Mutex
+	st *memState
+}
+
+// memState is the content of a memory store. Maps and the outbox hold values
+// that are replaced, never modified in place, so that cloning them snapshots
+// the state.
+type memState struct {
+	users           map[string]User
+	sessions        map[string]memSession
+	tokens          map[string]Token
+	roles           map[string]memRBAC
+	permissions     map[string]memRBAC
+	rolePermissions map[[2]string]memRolePermission
+	userRoles       map[[2]string]memUserRole
+	bans            map[string]memBan
+	// outbox holds events in the order they were added.
+	outbox     []memEvent
+	webhooks   map[string]Webhook
+	deliveries map[string]memDelivery
+	emails     map[string]memEmail
+}
+
+type memSession struct {
+	Session
+	number int
+	// roles are the ids of the active roles of the session.
+	roles []string
+}
+
+// memRBAC is a role or permission with the users who created and updated it.
+type memRBAC struct {
+	Role
+	createdBy *string
+	updatedBy *string
+}
+
+// memUserRole is a role assignment with the user who made it. The names of
+// the user and role are set when listing it.
+type memUserRole struct {
+	UserRole
+	createdBy *string
+}
+
+// memRolePermission is a permission grant with the user who made it. The
+// names of the role and permission are set when listing it.
+type memRolePermission struct {
+	RolePermission
+	createdBy *string
+}
+
+type memBan struct {
+	Ban
+	bannedBy *string
+}
+
+type memDelivery struct {
+	WebhookDelivery
+	lockedUntil *time.Time
+}
+
+type memEmail struct {
+	Email
+	lockedUntil *time.Time
+}
+
+type memEvent struct {
+	Event
+	state         string
+	nextAttemptAt time.Time
+	lockedUntil   *time.Time
+	lastError     *string
+	deliveredAt   *time.Time
+}
+
+func newMemState() *memState {
+	return &memState{
+		users:           map[string]User{},
+		sessions:        map[string]memSession{},
+		tokens:          map[string]Token{},
+		roles:           map[string]memRBAC{},
+		permissions:     map[string]memRBAC{},
+		rolePermissions: map[[2]string]memRolePermission{},
+		userRoles:       map[[2]string]memUserRole{},
+		bans:            map[string]memBan{},
+		webhooks:        map[string]Webhook{},
+		deliveries:      map[string]memDelivery{},
+		emails:          map[string]memEmail{},
+	}
+}
+
+func (st *memState) clone() *memState {
+	return &memState{
+		users:           maps.Clone(st.users),
+		sessions:        maps.Clone(st.sessions),
+		tokens:          maps.Clone(st.tokens),
+		roles:           maps.Clone(st.roles),
+		permissions:     maps.Clone(st.permissions),
+		rolePermissions: maps.Clone(st.rolePermissions),
+		userRoles:       maps.Clone(st.userRoles),
+		bans:            maps.Clone(st.bans),
+		outbox:          slices.Clone(st.outbox),
+		webhooks:        maps.Clone(st.webhooks),
+		deliveries:      maps.Clone(st.deliveries),
+		emails:          maps.Clone(st.emails),
+	}
+}
+
+// memoryStore is a Store keeping its content in memory, for tests and
+// development. Transactions run one at a time on a copy of the content that
+// replaces it on commit; operations outside of transactions wait for them.
+type memoryStore struct {
+	db *memoryDB
+	// st is the state of the transaction the store runs in, or nil.
+	st *memState
+}
+
+// NewMemoryStore returns an empty Store keeping its content in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{db: &memoryDB{st: newMemState()}}
+}
+
+func (s *memoryStore) Tx(ctx context.Context, opts sqlutil.TxOptions, fn func(tx Store) error) error {
+	if s.st != nil {
+		st := s.st.clone()
+		if err := fn(&memoryStore{db: s.db, st: st}); err != nil {
+			return err
+		}
+		*s.st = *st
+		return nil
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	st := s.db.st.clone()
+	if err := fn(&memoryStore{db: s.db, st: st}); err != nil {
+		return err
+	}
+	s.db.st = st
+	return nil
+}
+
+// do runs fn on the state of the store.
+func (s *memoryStore) do(fn func(st *memState) error) error {
+	if s.st != nil {
+		return fn(s.st)
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	return fn(s.db.st)
+}
+
+func (s *memoryStore) Users() UserStore             { return memUserStore{s} }
+func (s *memoryStore) Sessions() SessionStore       { return memSessionStore{s} }
+func (s *memoryStore) Tokens() TokenStore           { return memTokenStore{s} }
+func (s *memoryStore) Roles() RoleStore             { return memRoleStore{s} }
+func (s *memoryStore) Permissions() PermissionStore { return memPermissionStore{s} }
+func (s *memoryStore) UserRoles() UserRoleStore     { return memUserRoleStore{s} }
+func (s *memoryStore) RolePermissions() RolePermissionStore {
+	return memRolePermissionStore{s}
+}
+func (s *memoryStore) Bans() BanStore         { return memBanStore{s} }
+func (s *memoryStore) Outbox() OutboxStore    { return memOutboxStore{s} }
+func (s *memoryStore) Webhooks() WebhookStore { return memWebhookStore{s} }
+func (s *memoryStore) WebhookDeliveries() WebhookDeliveryStore {
+	return memDeliveryStore{s}
+}
+func (s *memoryStore) Emails() EmailStore { return memEmailStore{s} }
+
+type memUserStore struct{ s *memoryStore }
+
+// field returns the value of u for a field of userFields.
+func (memUserStore) field(u User, name string) any {
+	switch name {
+	case "id":
+		return u.ID
+	case "username":
+		return u.Username
+	case "email":
+		return u.Email
+	case "last_login_at":
+		return u.LastLoginAt
+	}
+	return u.CreatedAt
+}
+
+func (r memUserStore) match(q UserQuery) func(User) bool {
+	return func(u User) bool {
+		return (q.ID == "" || u.ID == q.ID) &&
+			(q.Email == "" || containsFold(u.Email, q.Email)) &&
+			(q.Username == "" || containsFold(u.Username, q.Username))
+	}
+}
+
+func (r memUserStore) List(ctx context.Context, q UserQuery, p ListParams) ([]User, error) {
+	var users []User
+	err := r.s.do(func(st *memState) error {
+		users = memList(mapValues(st.users), p, r.match(q), r.field, func(u User) string { return u.ID })
+		return nil
+	})
+	for i := range users {
+		users[i].Password = ""
+		users[i].VerifiedAt = nil
+	}
+	return users, err
+}
+
+func (r memUserStore) Count(ctx context.Context, q UserQuery, p ListParams) (int64, bool, error) {
+	var n int64
+	err := r.s.do(func(st *memState) error {
+		n = memCount(mapValues(st.users), p, r.match(q), r.field)
+		return nil
+	})
+	return n, false, err
+}
+
+func (r memUserStore) Get(ctx context.Context, id string) (User, error) {
+	var u User
+	err := r.s.do(func(st *memState) error {
+		var ok bool
+		if u, ok = st.users[id]; !ok {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+	return u, err
+}
+
+func (r memUserStore) GetByUsername(ctx context.Context, username string) (User, error) {
+	var u User
+	err := r.s.do(func(st *memState) error {
+		for _, user := range st.users {
+			if user.Username == username {
+				u = user
+				return nil
+			}
+		}
+		return ErrUserNotFound
+	})
+	return u, err
+}
+
+func (r memUserStore) UsernamesByEmail(ctx context.Context, email string) ([]string, error) {
+	var usernames []string
+	err := r.s.do(func(st *memState) error {
+		for _, u := range st.users {
+			if u.Email == email {
+				usernames = append(usernames, u.Username)
+			}
+		}
+		return nil
+	})
+	slices.Sort(usernames)
+	return usernames, err
+}
+
+func (r memUserStore) Create(ctx context.Context, u User) error {
+	return r.s.do(func(st *memState) error {
+		for _, user := range st.users {
+			if user.Username == u.Username {
+				return ErrUserExists
+			}
+		}
+		st.users[u.ID] = u
+		return nil
+	})
+}
+
+func (r memUserStore) Update(ctx context.Context, id string, in UserUpdate) error {
+	return r.s.do(func(st *memState) error {
+		u, ok := st.users[id]
+		if !ok {
+			return ErrUserNotFound
+		}
+		if in.Email != nil {
+			u.Email = *in.Email
+		}
+		if in.PasswordHash != nil {
+			u.Password = *in.PasswordHash
+		}
+		if in.VerifiedAt != nil {
+			u.VerifiedAt = ptr(*in.VerifiedAt)
+		}
+		if in.LastLoginAt != nil {
+			u.LastLoginAt = ptr(*in.LastLoginAt)
+		}
+		if in.LastLoginIP != nil {
+			u.LastLoginIP = ptr(*in.LastLoginIP)
+		}
+		st.users[id] = u
+		return nil
+	})
+}
+
+func (r memUserStore) Delete(ctx context.Context, ids []string) ([]string, error) {
+	var deleted []string
+	err := r.s.do(func(st *memState) error {
+		for _, id := range ids {
+			if _, ok := st.users[id]; !ok {
+				continue
+			}
+			delete(st.users, id)
+			deleted = append(deleted, id)
+			deleteFunc(st.sessions, func(s memSession) bool { return s.UserID == id })
+			deleteFunc(st.tokens, func(t Token) bool { return t.UserID == id })
+			deleteFunc(st.emails, func(e memEmail) bool { return e.UserID != nil && *e.UserID == id })
+			delete(st.bans, id)
+			for k := range st.userRoles {
+				if k[0] == id {
+					delete(st.userRoles, k)
+				}
+			}
+			for _, m := range []map[string]memRBAC{st.roles, st.permissions} {
+				for k, v := range m {
+					created := nullify(&v.createdBy, id)
+					updated := nullify(&v.updatedBy, id)
+					if created || updated {
+						m[k] = v
+					}
+				}
+			}
+			for k, v := range st.bans {
+				if nullify(&v.bannedBy, id) {
+					st.bans[k] = v
+				}
+			}
+			for k, v := range st.userRoles {
+				if nullify(&v.createdBy, id) {
+					st.userRoles[k] = v
+				}
+			}
+			for k, v := range st.rolePermissions {
+				if nullify(&v.createdBy, id) {
+					st.rolePermissions[k] = v
+				}
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+type memSessionStore struct{ s *memoryStore }
+
+// field returns the value of s for a field of sessionFields.
+func (memSessionStore) field(s Session, name string) any {
+	switch name {
+	case "id":
+		return s.ID
+	case "user_id":
+		return s.UserID
+	case "expires_at":
+		return s.ExpiresAt
+	}
+	return s.CreatedAt
+}
+
+func (r memSessionStore) match(q SessionQuery, now time.Time) func(Session) bool {
+	return func(s Session) bool {
+		return (q.ID == "" || s.ID == q.ID) &&
+			(q.UserID == "" || s.UserID == q.UserID) &&
+			(q.IncludeExpired || s.ExpiresAt.After(now))
+	}
+}
+
+func (r memSessionStore) rows(st *memState) []Session {
+	sessions := make([]Session, 0, len(st.sessions))
+	for _, s := range st.sessions {
+		sessions = append(sessions, s.Session)
+	}
+	return sessions
+}
+
+func (r memSessionStore) List(ctx context.Context, q SessionQuery, p ListParams) ([]Session, error) {
+	var sessions []Session
+	err := r.s.do(func(st *memState) error {
+		sessions = memList(r.rows(st), p, r.match(q, p.Now), r.field, func(s Session) string { return s.ID })
+		return nil
+	})
+	return sessions, err
+}
+
+func (r memSessionStore) Count(ctx context.Context, q SessionQuery, p ListParams) (int64, bool, error) {
+	var n int64
+	err := r.s.do(func(st *memState) error {
+		n = memCount(r.rows(st), p, r.match(q, p.Now), r.field)
+		return nil
+	})
+	return n, false, err
+}
+
+func (r memSessionStore) Authenticate(ctx context.Context, token string, now time.Time) (Session, error) {
+	var sess Session
+	err := r.s.do(func(st *memState) error {
+		for _, s := range st.sessions {
+			if s.Token == token && s.ExpiresAt.After(now) {
+				sess = s.Session
+				return nil
+			}
+		}
+		return ErrSessionNotFound
+	})
+	return sess, err
+}
+
+func (r memSessionStore) Create(ctx context.Context, sess Session, max int) error {
+	return r.s.do(func(st *memState) error {
+		used := map[int]bool{}
+		for _, s := range st.sessions {
+			if s.UserID == sess.UserID {
+				used[s.number] = true
+			}
+		}
+		if len(used) >= max {
+			return ErrSessionLimit
+		}
+		number := 1
+		for used[number] {
+			number++
+		}
+		if number > max {
+			return ErrSessionLimit
+		}
+		st.sessions[sess.ID] = memSession{Session: sess, number: number}
+		return nil
+	})
+}
+
+func (r memSessionStore) Renew(ctx context.Context, id string, expiresAt time.Time) error {
+	return r.s.do(func(st *memState) error {
+		s, ok := st.sessions[id]
+		if !ok {
+			return ErrSessionNotFound
+		}
+		s.ExpiresAt = expiresAt
+		st.sessions[id] = s
+		return nil
+	})
+}
+
+func (r memSessionStore) ActivateRoles(ctx context.Context, id string, roleIDs []string) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		s, ok := st.sessions[id]
+		if !ok {
+			return ErrSessionNotFound
+		}
+		if roleIDs == nil {
+			for k := range st.userRoles {
+				if k[0] == s.UserID {
+					roleIDs = append(roleIDs, k[1])
+				}
+			}
+		}
+		roles := slices.Clone(s.roles)
+		for _, roleID := range roleIDs {
+			if _, ok := st.userRoles[[2]string{s.UserID, roleID}]; !ok {
+				return ErrRoleNotFound
+			}
+			if !slices.Contains(roles, roleID) {
+				roles = append(roles, roleID)
+				n++
+			}
+		}
+		s.roles = roles
+		st.sessions[id] = s
+		return nil
+	})
+	return n, err
+}
+
+func (r memSessionStore) DropRoles(ctx context.Context, id string, roleIDs []string) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		s, ok := st.sessions[id]
+		if !ok {
+			return nil
+		}
+		roles := slices.DeleteFunc(slices.Clone(s.roles), func(roleID string) bool {
+			return slices.Contains(roleIDs, roleID)
+		})
+		n = len(s.roles) - len(roles)
+		s.roles = roles
+		st.sessions[id] = s
+		return nil
+	})
+	return n, err
+}
+
+func (r memSessionStore) Grants(ctx context.Context, id string) ([]RoleGrant, error) {
+	var grants []RoleGrant
+	err := r.s.do(func(st *memState) error {
+		s := st.sessions[id]
+		grants = st.grants(s.UserID, s.roles)
+		return nil
+	})
+	return grants, err
+}
+
+func (r memSessionStore) Delete(ctx context.Context, ids []string, userID string) (int, error) {
+	if len(ids) == 0 && userID == "" {
+		return 0, nil
+	}
+	var n int
+	err := r.s.do(func(st *memState) error {
+		n = deleteFunc(st.sessions, func(s memSession) bool {
+			return (ids == nil || slices.Contains(ids, s.ID)) &&
+				(userID == "" || s.UserID == userID)
+		})
+		return nil
+	})
+	return n, err
+}
+
+func (r memSessionStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		n = deleteFunc(st.sessions, func(s memSession) bool { return !s.ExpiresAt.After(before) })
+		return nil
+	})
+	return n, err
+}
+
+type memTokenStore struct{ s *memoryStore }
+
+func (r memTokenStore) Get(ctx context.Context, id, kind string, now time.Time) (Token, error) {
+	var token Token
+	err := r.s.do(func(st *memState) error {
+		t, ok := st.tokens[id]
+		if !ok || t.Kind != kind || !t.ExpiresAt.After(now) {
+			return ErrInvalidToken
+		}
+		token = t
+		return nil
+	})
+	token.Meta = maps.Clone(token.Meta)
+	return token, err
+}
+
+func (r memTokenStore) ByUser(ctx context.Context, userID, kind string) (Token, bool, error) {
+	var token Token
+	var found bool
+	err := r.s.do(func(st *memState) error {
+		for _, t := range st.tokens {
+			if t.UserID == userID && t.Kind == kind {
+				token, found = t, true
+				break
+			}
+		}
+		return nil
+	})
+	token.Meta = maps.Clone(token.Meta)
+	return token, found, err
+}
+
+func (r memTokenStore) Save(ctx context.Context, token Token) error {
+	token.Meta = maps.Clone(token.Meta)
+	return r.s.do(func(st *memState) error {
+		if _, ok := st.users[token.UserID]; !ok {
+			return ErrUserNotFound
+		}
+		deleteFunc(st.tokens, func(t Token) bool {
+			return t.UserID == token.UserID && t.Kind == token.Kind
+		})
+		st.tokens[token.ID] = token
+		return nil
+	})
+}
+
+func (r memTokenStore) Delete(ctx context.Context, id string) error {
+	return r.s.do(func(st *memState) error {
+		delete(st.tokens, id)
+		return nil
+	})
+}
+
+func (r memTokenStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		n = deleteFunc(st.tokens, func(t Token) bool { return !t.ExpiresAt.After(before) })
+		return nil
+	})
+	return n, err
+}
+
+// rbacField returns the value of a role or permission for a field of
+// roleFields.
+func rbacField(r memRBAC, name string) any {
+	switch name {
+	case "id":
+		return r.ID
+	case "name":
+		return r.Name
+	case "updated_at":
+		return r.UpdatedAt
+	case "created_by":
+		return r.createdBy
+	case "updated_by":
+		return r.updatedBy
+	}
+	return r.CreatedAt
+}
+
+func rbacMatch(id, name string, linked map[string]bool) func(memRBAC) bool {
+	return func(r memRBAC) bool {
+		return (id == "" || r.ID == id) && (name == "" || r.Name == name) && (linked == nil || linked[r.ID])
+	}
+}
+
+// linkedIDs returns the ids paired with id at index i of the keys of m, or
+// nil if id is empty.
+func linkedIDs[V any](m map[[2]string]V, i int, id string) map[string]bool {
+	if id == "" {
+		return nil
+	}
+	ids := map[string]bool{}
+	for k := range m {
+		if k[i] == id {
+			ids[k[1-i]] = true
+		}
+	}
+	return ids
+}
+
+// rbacRoles returns the roles or permissions of rows, with the users who
+// created and updated them if detailed is set.
+func rbacRoles(st *memState, rows []memRBAC, detailed bool) []Role {
+	roles := make([]Role, len(rows))
+	for i, row := range rows {
+		roles[i] = row.Role
+		roles[i].Meta = nil
+		if detailed {
+			meta := &RoleMeta{}
+			meta.CreatedByID, meta.CreatedByUsername, meta.CreatedByEmail = st.userRef(row.createdBy)
+			meta.UpdatedByID, meta.UpdatedByUsername, meta.UpdatedByEmail = st.userRef(row.updatedBy)
+			roles[i].Meta = meta
+		}
+	}
+	return roles
+}
+
+// userRef returns the id, username and email of the user with id, or nils.
+func (st *memState) userRef(id *string) (*string, *string, *string) {
+	if id == nil {
+		return nil, nil, nil
+	}
+	u, ok := st.users[*id]
+	if !ok {
+		return nil, nil, nil
+	}
+	return ptr(u.ID), ptr(u.Username), ptr(u.Email)
+}
+
+// createRBAC adds r to m, created by the user by.
+func createRBAC(st *memState, m map[string]memRBAC, r Role, by *string, exists error) error {
+	for _, other := range m {
+		if other.Name == r.Name {
+			return exists
+		}
+	}
+	r.Meta = nil
+	m[r.ID] = memRBAC{Role: r, createdBy: st.userID(by)}
+	return nil
+}
+
+// updateRBAC updates the name and description of the role or permission
+// of m, if they are not empty.
+func updateRBAC(st *memState, m map[string]memRBAC, in UpdateRoleInput, at time.Time, by *string, exists, notFound error) error {
+	r, ok := m[in.ID]
+	if !ok {
+		return notFound
+	}
+	if in.Name != "" {
+		for _, other := range m {
+			if other.ID != in.ID && other.Name == in.Name {
+				return exists
+			}
+		}
+		r.Name = in.Name
+	}
+	if in.Description != "" {
+		r.Description = ptr(in.Description)
+	}
+	r.UpdatedAt = ptr(at)
+	r.updatedBy = st.userID(by)
+	m[in.ID] = r
+	return nil
+}
+
+// grants returns the permissions granted by the roles with roleIDs that the
+// user holds, ordered by permission and role name.
+func (st *memState) grants(userID string, roleIDs []string) []RoleGrant {
+	grants := []RoleGrant{}
+	for _, roleID := range roleIDs {
+		if _, ok := st.userRoles[[2]string{userID, roleID}]; !ok {
+			continue
+		}
+		for k := range st.rolePermissions {
+			if k[0] == roleID {
+				grants = append(grants, RoleGrant{
+					RoleID:         roleID,
+					RoleName:       st.roles[roleID].Name,
+					PermissionID:   k[1],
+					PermissionName: st.permissions[k[1]].Name,
+				})
+			}
+		}
+	}
+	slices.SortFunc(grants, func(a, b RoleGrant) int {
+		if c := strings.Compare(a.PermissionName, b.PermissionName); c != 0 {
+			return c
+		}
+		return strings.Compare(a.RoleName, b.RoleName)
+	})
+	return grants
+}
+
+// userID returns id if it is the id of a user, as a foreign key would
+// require, or nil.
+func (st *memState) userID(id *string) *string {
+	if id == nil {
+		return nil
+	}
+	if _, ok := st.users[*id]; !ok {
+		return nil
+	}
+	return ptr(*id)
+}
+
+type memRoleStore struct{ s *memoryStore }
+
+func (r memRoleStore) List(ctx context.Context, q RoleQuery, p ListParams) ([]Role, error) {
+	var roles []Role
+	err := r.s.do(func(st *memState) error {
+		rows := memList(mapValues(st.roles), p, rbacMatch(q.ID, q.Name, linkedIDs(st.rolePermissions, 1, q.PermissionID)), rbacField, func(r memRBAC) string { return r.ID })
+		roles = rbacRoles(st, rows, q.Detailed)
+		return nil
+	})
+	return roles, err
+}
+
+func (r memRoleStore) Count(ctx context.Context, q RoleQuery, p ListParams) (int64, bool, error) {
+	var n int64
+	err := r.s.do(func(st *memState) error {
+		n = memCount(mapValues(st.roles), p, rbacMatch(q.ID, q.Name, linkedIDs(st.rolePermissions, 1, q.PermissionID)), rbacField)
+		return nil
+	})
+	return n, false, err
+}
+
+func (r memRoleStore) Create(ctx context.Context, role Role, by *string) error {
+	return r.s.do(func(st *memState) error {
+		return createRBAC(st, st.roles, role, by, ErrRoleExists)
+	})
+}
+
+func (r memRoleStore) Update(ctx context.Context, in UpdateRoleInput, at time.Time, by *string) error {
+	return r.s.do(func(st *memState) error {
+		return updateRBAC(st, st.roles, in, at, by, ErrRoleExists, ErrRoleNotFound)
+	})
+}
+
+func (r memRoleStore) Delete(ctx context.Context, ids []string) ([]string, error) {
+	var deleted []string
+	err := r.s.do(func(st *memState) error {
+		for _, id := range ids {
+			if _, ok := st.roles[id]; ok {
+				delete(st.roles, id)
+				deleted = append(deleted, id)
+			}
+		}
+		for k := range st.rolePermissions {
+			if slices.Contains(ids, k[0]) {
+				delete(st.rolePermissions, k)
+			}
+		}
+		for k := range st.userRoles {
+			if slices.Contains(ids, k[1]) {
+				delete(st.userRoles, k)
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+func (r memRoleStore) Grant(ctx context.Context, roleID string, permissionIDs []string, at time.Time, by *string) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		if _, ok := st.roles[roleID]; !ok {
+			return ErrRoleNotFound
+		}
+		for _, id := range permissionIDs {
+			if _, ok := st.permissions[id]; !ok {
+				return ErrPermissionNotFound
+			}
+		}
+		for _, id := range permissionIDs {
+			k := [2]string{roleID, id}
+			if _, ok := st.rolePermissions[k]; ok {
+				continue
+			}
+			st.rolePermissions[k] = memRolePermission{
+				RolePermission: RolePermission{ID: uuid.New().String(), RoleID: roleID, PermissionID: id, CreatedAt: at},
+				createdBy:      st.userID(by),
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (r memRoleStore) Revoke(ctx context.Context, roleID string, permissionIDs []string) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		for _, id := range permissionIDs {
+			k := [2]string{roleID, id}
+			if _, ok := st.rolePermissions[k]; ok {
+				delete(st.rolePermissions, k)
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (r memRoleStore) Assign(ctx context.Context, userID string, roleIDs []string, at time.Time, by *string) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		if _, ok := st.users[userID]; !ok {
+			return ErrUserNotFound
+		}
+		for _, id := range roleIDs {
+			if _, ok := st.roles[id]; !ok {
+				return ErrRoleNotFound
+			}
+		}
+		for _, id := range roleIDs {
+			k := [2]string{userID, id}
+			if _, ok := st.userRoles[k]; ok {
+				continue
+			}
+			st.userRoles[k] = memUserRole{
+				UserRole:  UserRole{ID: uuid.New().String(), UserID: userID, RoleID: id, CreatedAt: at},
+				createdBy: st.userID(by),
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (r memRoleStore) Unassign(ctx context.Context, userID string, roleIDs []string) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		for _, id := range roleIDs {
+			k := [2]string{userID, id}
+			if _, ok := st.userRoles[k]; ok {
+				delete(st.userRoles, k)
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+type memPermissionStore struct{ s *memoryStore }
+
+func (r memPermissionStore) List(ctx context.Context, q PermissionQuery, p ListParams) ([]Permission, error) {
+	var permissions []Permission
+	err := r.s.do(func(st *memState) error {
+		rows := memList(mapValues(st.permissions), p, rbacMatch(q.ID, q.Name, linkedIDs(st.rolePermissions, 0, q.RoleID)), rbacField, func(r memRBAC) string { return r.ID })
+		for _, role := range rbacRoles(st, rows, q.Detailed) {
+			permissions = append(permissions, Permission{
+				ID:          role.ID,
+				Name:        role.Name,
+				Description: role.Description,
+				CreatedAt:   role.CreatedAt,
+				UpdatedAt:   role.UpdatedAt,
+				Meta:        (*PermissionMeta)(role.Meta),
+			})
+		}
+		return nil
+	})
+	if permissions == nil {
+		permissions = []Permission{}
+	}
+	return permissions, err
+}
+
+func (r memPermissionStore) Count(ctx context.Context, q PermissionQuery, p ListParams) (int64, bool, error) {
+	var n int64
+	err := r.s.do(func(st *memState) error {
+		n = memCount(mapValues(st.permissions), p, rbacMatch(q.ID, q.Name, linkedIDs(st.rolePermissions, 0, q.RoleID)), rbacField)
+		return nil
+	})
+	return n, false, err
+}
+
+func (r memPermissionStore) Existing(ctx context.Context, names []string) ([]string, error) {
+	var existing []string
+	err := r.s.do(func(st *memState) error {
+		for _, p := range st.permissions {
+			if slices.Contains(names, p.Name) {
+				existing = append(existing, p.Name)
+			}
+		}
+		return nil
+	})
+	slices.Sort(existing)
+	return existing, err
+}
+
+func (r memPermissionStore) Create(ctx context.Context, permission Permission, by *string) error {
+	role := Role{
+		ID:          permission.ID,
+		Name:        permission.Name,
+		Description: permission.Description,
+		CreatedAt:   permission.CreatedAt,
+		UpdatedAt:   permission.UpdatedAt,
+	}
+	return r.s.do(func(st *memState) error {
+		return createRBAC(st, st.permissions, role, by, ErrPermissionExists)
+	})
+}
+
+func (r memPermissionStore) Update(ctx context.Context, in UpdatePermissionInput, at time.Time, by *string) error {
+	return r.s.do(func(st *memState) error {
+		return updateRBAC(st, st.permissions, UpdateRoleInput(in), at, by, ErrPermissionExists, ErrPermissionNotFound)
+	})
+}
+
+func (r memPermissionStore) Delete(ctx context.Context, ids []string) ([]string, error) {
+	var deleted []string
+	err := r.s.do(func(st *memState) error {
+		for _, id := range ids {
+			if _, ok := st.permissions[id]; ok {
+				delete(st.permissions, id)
+				deleted = append(deleted, id)
+			}
+		}
+		for k := range st.rolePermissions {
+			if slices.Contains(ids, k[1]) {
+				delete(st.rolePermissions, k)
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+type memUserRoleStore struct{ s *memoryStore }
+
+// field returns the value of ur for a field of userRoleFields.
+func (memUserRoleStore) field(ur memUserRole, name string) any {
+	switch name {
+	case "user_id":
+		return ur.UserID
+	case "role_id":
+		return ur.RoleID
+	case "username":
+		return ur.Username
+	case "role_name":
+		return ur.RoleName
+	case "created_by":
+		return ur.createdBy
+	}
+	return ur.CreatedAt
+}
+
+func (memUserRoleStore) match(q UserRoleQuery) func(memUserRole) bool {
+	return func(ur memUserRole) bool {
+		return (q.UserID == "" || ur.UserID == q.UserID) &&
+			(q.RoleID == "" || ur.RoleID == q.RoleID) &&
+			(q.RoleName == "" || ur.RoleName == q.RoleName)
+	}
+}
+
+// rows returns the role assignments with the names of their users and roles.
+func (memUserRoleStore) rows(st *memState) []memUserRole {
+	rows := mapValues(st.userRoles)
+	for i, row := range rows {
+		u := st.users[row.UserID]
+		rows[i].Username, rows[i].Email = u.Username, u.Email
+		rows[i].RoleName = st.roles[row.RoleID].Name
+	}
+	return rows
+}
+
+func (r memUserRoleStore) List(ctx context.Context, q UserRoleQuery, p ListParams) ([]UserRole, error) {
+	userRoles := []UserRole{}
+	err := r.s.do(func(st *memState) error {
+		rows := memList(r.rows(st), p, r.match(q), r.field, func(ur memUserRole) string { return ur.ID })
+		for _, row := range rows {
+			ur := row.UserRole
+			if q.Detailed {
+				meta := &UserRoleMeta{}
+				meta.CreatedByID, meta.CreatedByUsername, meta.CreatedByEmail = st.userRef(row.createdBy)
+				ur.Meta = meta
+			}
+			userRoles = append(userRoles, ur)
+		}
+		return nil
+	})
+	return userRoles, err
+}
+
+func (r memUserRoleStore) Count(ctx context.Context, q UserRoleQuery, p ListParams) (int64, bool, error) {
+	var n int64
+	err := r.s.do(func(st *memState) error {
+		n = memCount(r.rows(st), p, r.match(q), r.field)
+		return nil
+	})
+	return n, false, err
+}
+
+func (r memUserRoleStore) Held(ctx context.Context, roleName string) (bool, error) {
+	var held bool
+	err := r.s.do(func(st *memState) error {
+		for k := range st.userRoles {
+			if st.roles[k[1]].Name == roleName {
+				held = true
+				break
+			}
+		}
+		return nil
+	})
+	return held, err
+}
+
+func (r memUserRoleStore) Grants(ctx context.Context, userID string) ([]RoleGrant, error) {
+	var grants []RoleGrant
+	err := r.s.do(func(st *memState) error {
+		var roleIDs []string
+		for k := range st.userRoles {
+			if k[0] == userID {
+				roleIDs = append(roleIDs, k[1])
+			}
+		}
+		grants = st.grants(userID, roleIDs)
+		return nil
+	})
+	return grants, err
+}
+
+type memRolePermissionStore struct{ s *memoryStore }
+
+// field returns the value of rp for a field of rolePermissionFields.
+func (memRolePermissionStore) field(rp memRolePermission, name string) any {
+	switch name {
+	case "permission_id":
+		return rp.PermissionID
+	case "permission_name":
+		return rp.PermissionName
+	case "created_by":
+		return rp.createdBy
+	}
+	return rp.CreatedAt
+}
+
+func (memRolePermissionStore) match(q RolePermissionQuery) func(memRolePermission) bool {
+	return func(rp memRolePermission) bool {
+		return rp.RoleID == q.RoleID
+	}
+}
+
+// rows returns the permission grants with the names of their roles and
+// permissions.
+func (memRolePermissionStore) rows(st *memState) []memRolePermission {
+	rows := mapValues(st.rolePermissions)
+	for i, row := range rows {
+		rows[i].RoleName = st.roles[row.RoleID].Name
+		rows[i].PermissionName = st.permissions[row.PermissionID].Name
+	}
+	return rows
+}
+
+func (r memRolePermissionStore) List(ctx context.Context, q RolePermissionQuery, p ListParams) ([]RolePermission, error) {
+	rolePermissions := []RolePermission{}
+	err := r.s.do(func(st *memState) error {
+		rows := memList(r.rows(st), p, r.match(q), r.field, func(rp memRolePermission) string { return rp.ID })
+		for _, row := range rows {
+			rp := row.RolePermission
+			if q.Detailed {
+				meta := &RolePermissionMeta{}
+				meta.CreatedByID, meta.CreatedByUsername, meta.CreatedByEmail = st.userRef(row.createdBy)
+				rp.Meta = meta
+			}
+			rolePermissions = append(rolePermissions, rp)
+		}
+		return nil
+	})
+	return rolePermissions, err
+}
+
+func (r memRolePermissionStore) Count(ctx context.Context, q RolePermissionQuery, p ListParams) (int64, bool, error) {
+	var n int64
+	err := r.s.do(func(st *memState) error {
+		n = memCount(r.rows(st), p, r.match(q), r.field)
+		return nil
+	})
+	return n, false, err
+}
+
+type memBanStore struct{ s *memoryStore }
+
+// field returns the value of b for a field of banFields.
+func (memBanStore) field(b memBan, name string) any {
+	switch name {
+	case "user_id":
+		return b.UserID
+	case "reason":
+		return b.Reason
+	case "unbanned_at":
+		return b.UnbannedAt
+	case "banned_by":
+		return b.bannedBy
+	}
+	return b.BannedAt
+}
+
+// active reports whether b is in effect at now. Bans without an end have
+// equal start and end times.
+func (b memBan) active(now time.Time) bool {
+	return b.UnbannedAt.After(now) || b.BannedAt.Equal(b.UnbannedAt)
+}
+
+func (r memBanStore) match(q BanQuery, now time.Time) func(memBan) bool {
+	return func(b memBan) bool {
+		return (q.UserID == "" || b.UserID == q.UserID) && (q.IncludeExpired || b.active(now))
+	}
+}
+
+func (r memBanStore) List(ctx context.Context, q BanQuery, p ListParams) ([]Ban, error) {
+	bans := []Ban{}
+	err := r.s.do(func(st *memState) error {
+		rows := memList(mapValues(st.bans), p, r.match(q, p.Now), r.field, func(b memBan) string { return b.UserID })
+		for _, row := range rows {
+			ban := row.Ban
+			ban.Meta = nil
+			if q.Detailed {
+				meta := &BanMeta{}
+				meta.BannedByID, meta.BannedByUsername, meta.BannedByEmail = st.userRef(row.bannedBy)
+				ban.Meta = meta
+			}
+			bans = append(bans, ban)
+		}
+		return nil
+	})
+	return bans, err
+}
+
+func (r memBanStore) Count(ctx context.Context, q BanQuery, p ListParams) (int64, bool, error) {
+	var n int64
+	err := r.s.do(func(st *memState) error {
+		n = memCount(mapValues(st.bans), p, r.match(q, p.Now), r.field)
+		return nil
+	})
+	return n, false, err
+}
+
+func (r memBanStore) Active(ctx context.Context, userID string, now time.Time) (bool, error) {
+	var active bool
+	err := r.s.do(func(st *memState) error {
+		b, ok := st.bans[userID]
+		active = ok && b.active(now)
+		return nil
+	})
+	return active, err
+}
+
+func (r memBanStore) Put(ctx context.Context, ban Ban, by *string) error {
+	ban.Meta = nil
+	return r.s.do(func(st *memState) error {
+		if _, ok := st.users[ban.UserID]; !ok {
+			return ErrUserNotFound
+		}
+		st.bans[ban.UserID] = memBan{Ban: ban, bannedBy: st.userID(by)}
+		return nil
+	})
+}
+
+func (r memBanStore) Delete(ctx context.Context, userID string) error {
+	return r.s.do(func(st *memState) error {
+		if _, ok := st.bans[userID]; !ok {
+			return ErrBanNotFound
+		}
+		delete(st.bans, userID)
+		return nil
+	})
+}
+
+func (r memBanStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		n = deleteFunc(st.bans, func(b memBan) bool { return !b.active(before) })
+		return nil
+	})
+	return n, err
+}
+
+type memOutboxStore struct{ s *memoryStore }
+
+func (r memOutboxStore) Add(ctx context.Context, events ...Event) error {
+	return r.s.do(func(st *memState) error {
+		for _, e := range events {
+			st.outbox = append(st.outbox, memEvent{
+				Event:         e,
+				state:         eventPending,
+				nextAttemptAt: e.CreatedAt,
+			})
+		}
+		return nil
+	})
+}
+
+func (r memOutboxStore) Claim(ctx context.Context, limit int, now, until time.Time) ([]Event, error) {
+	var events []Event
+	err := r.s.do(func(st *memState) error {
+		seen := map[string]bool{}
+		for i, e := range st.outbox {
+			if len(events) == limit {
+				break
+			}
+			if e.state != eventPending || seen[e.AggregateID] {
+				continue
+			}
+			seen[e.AggregateID] = true
+			if e.nextAttemptAt.After(now) || (e.lockedUntil != nil && e.lockedUntil.After(now)) {
+				continue
+			}
+			e.lockedUntil = &until
+			st.outbox[i] = e
+			events = append(events, e.Event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (r memOutboxStore) Delivered(ctx context.Context, id string, at time.Time) error {
+	return r.update(id, func(e *memEvent) {
+		e.state = eventDelivered
+		e.deliveredAt = &at
+		e.lockedUntil = nil
+	})
+}
+
+func (r memOutboxStore) Failed(ctx context.Context, id, reason string, next *time.Time) error {
+	return r.update(id, func(e *memEvent) {
+		e.Attempts++
+		e.lastError = &reason
+		e.lockedUntil = nil
+		if next == nil {
+			e.state = eventDead
+		} else {
+			e.nextAttemptAt = *next
+		}
+	})
+}
+
+// update replaces the event with id by the event modified by fn.
+func (r memOutboxStore) update(id string, fn func(e *memEvent)) error {
+	return r.s.do(func(st *memState) error {
+		for i, e := range st.outbox {
+			if e.ID == id {
+				fn(&e)
+				st.outbox[i] = e
+				return nil
+			}
+		}
+		return ErrEventNotFound
+	})
+}
+
+func (r memOutboxStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		n = len(st.outbox)
+		st.outbox = slices.DeleteFunc(st.outbox, func(e memEvent) bool {
+			return e.state == eventDelivered && e.deliveredAt.Before(before)
+		})
+		n -= len(st.outbox)
+		return nil
+	})
+	return n, err
+}
+
+type memWebhookStore struct{ s *memoryStore }
+
+// field returns the value of w for a field of webhookFields.
+func (memWebhookStore) field(w Webhook, name string) any {
+	switch name {
+	case "id":
+		return w.ID
+	case "url":
+		return w.URL
+	case "updated_at":
+		return w.UpdatedAt
+	case "disabled_at":
+		return w.DisabledAt
+	}
+	return w.CreatedAt
+}
+
+func (r memWebhookStore) match(q WebhookQuery) func(Webhook) bool {
+	return func(w Webhook) bool {
+		return (q.ID == "" || w.ID == q.ID) && (q.Enabled == nil || w.Enabled == *q.Enabled)
+	}
+}
+
+func (r memWebhookStore) List(ctx context.Context, q WebhookQuery, p ListParams) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := r.s.do(func(st *memState) error {
+		webhooks = memList(mapValues(st.webhooks), p, r.match(q), r.field, func(w Webhook) string { return w.ID })
+		return nil
+	})
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, err
+}
+
+func (r memWebhookStore) Count(ctx context.Context, q WebhookQuery, p ListParams) (int64, bool, error) {
+	var n int64
+	err := r.s.do(func(st *memState) error {
+		n = memCount(mapValues(st.webhooks), p, r.match(q), r.field)
+		return nil
+	})
+	return n, false, err
+}
+
+func (r memWebhookStore) Get(ctx context.Context, id string) (Webhook, error) {
+	var w Webhook
+	err := r.s.do(func(st *memState) error {
+		var ok bool
+		if w, ok = st.webhooks[id]; !ok {
+			return ErrWebhookNotFound
+		}
+		return nil
+	})
+	return w, err
+}
+
+func (r memWebhookStore) Create(ctx context.Context, w Webhook) error {
+	return r.s.do(func(st *memState) error {
+		st.webhooks[w.ID] = w
+		return nil
+	})
+}
+
+func (r memWebhookStore) Update(ctx context.Context, id string, in WebhookUpdate, at time.Time) error {
+	return r.s.do(func(st *memState) error {
+		w, ok := st.webhooks[id]
+		if !ok {
+			return ErrWebhookNotFound
+		}
+		if in.URL != nil {
+			w.URL = *in.URL
+		}
+		if in.Secret != nil {
+			w.Secret = *in.Secret
+		}
+		if in.Events != nil {
+			w.Events = in.Events
+		}
+		if in.Description != nil {
+			w.Description = in.Description
+		}
+		if in.Enabled != nil && *in.Enabled != w.Enabled {
+			w.Enabled = *in.Enabled
+			w.Failures = 0
+			w.DisabledAt = nil
+			if !w.Enabled {
+				w.DisabledAt = &at
+			}
+		}
+		w.UpdatedAt = &at
+		st.webhooks[id] = w
+		return nil
+	})
+}
+
+func (r memWebhookStore) Delete(ctx context.Context, ids []string) (int, error) {
+	var n int
+	err := r.s.do(func(st *memState) error {
+		n = deleteFunc(st.webhooks, func(w Webhook) bool { return slices.Contains(ids, w.ID) })
+		deleteFunc(st.deliveries, func(d memDelivery) bool { return slices.Contains(ids, d.WebhookID) })
+		return nil
+	})
+	return n, err
+}
+
+func (r memWebhookStore) Subscribers(ctx context.Context, typ string) ([]string, error) {
+	var ids []string
+	err := r.s.do(func(st *memState) error {
+		for _, w := range st.webhooks {
+			if w.Enabled && (slices.Contains(w.Events, typ) || slices.Contains(w.Events, WebhookAllEvents)) {
+				ids = append(ids, w.ID)
+			}
+		}
+		return nil
+	})
+	slices.Sort(ids)
+	return ids, err
+}
+
+func (r memWebhookStore) Report(ctx context.Context, id string, ok bool, at time.Time, maxFailures int) (bool, error) {
+	var disabled bool
+	err := r.s.do(func(st *memState) error {
+		w, found := st.webhooks[id]
+		if !found {
+			return ErrWebhookNotFound
+		}
+		if ok {
+			w.Failures = 0
+		} else {
+			w.Failures++
+			if w.Enabled && w.Failures >= maxFailures {
+				w.Enabled = false
+				w.DisabledAt = &at
+				disabled = true
+			}
+		}
+		st.webhooks[id] = w
+		return nil
+	})
+	return disabled, err
+}
+
+type memDeliveryStore struct{ s *memoryStore }
+
+// field returns the value of d for a field of deliveryFields.
+func (memDeliveryStore) field(d memDelivery, name string) any {
+	switch name {
+	case "id":
+		return d.ID
+	case "event_id":
+		return d.EventID
+	case "event_type":
+		return d.EventType
+	case "last_attempt_at":
+		return d.LastAttemptAt
+	}
+	return d.CreatedAt
+}
+
+func (r memDeliveryStore) match(q WebhookDeliveryQuery) func(memDelivery) bool {
+	return func(d memDelivery) bool {
+		return d.WebhookID == q.WebhookID &&
+			(q.ID == "" || d.ID == q.ID) &&
+			(q.State == "" || d.State == q.State)
+	}
+}
+
+func (r memDeliveryStore) List(ctx context.Context, q WebhookDeliveryQuery, p ListParams) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	err := r.s.do(func(st *memState) error {
+		rows := memList(mapValues(st.deliveries), p, r.match(q), r.field, func(d memDelivery) string { return d.ID })
+		for _, row := range rows {
+			d := row.WebhookDelivery
+			d.Payload = nil
+			deliveries = append(deliveries, d)
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+func (r memDeliveryStore) Count(ctx context.Context, q WebhookDeliveryQuery, p ListParams) (int64, bool, error) {
+	var n int64
+	err := r.s.do(func(st *memState) error {
+		n = memCount(mapValues(st.deliveries), p, r.match(q), r.field)
+		return nil
+	})
+	return n, false, err
+}
+
+func (r memDeliveryStore) Add(ctx context.Context, deliveries ...WebhookDelivery) error {
+	return r.s.do(func(st *memState) error {
+		for _, d := range deliveries {
+			if _, ok := st.webhooks[d.WebhookID]; !ok {
+				return ErrWebhookNotFound
+			}
+			exists := false
+			for _, other := range st.deliveries {
+				if other.WebhookID == d.WebhookID && other.EventID == d.EventID {
+					exists = true
+					break
+				}
+			}
+			if exists {
+				continue
+			}
+			createdAt := d.CreatedAt
+			d.State = DeliveryPending
+			d.NextAttemptAt = &createdAt
+			st.deliveries[d.ID] = memDelivery{WebhookDelivery: d}
+		}
+		return nil
+	})
+}
+
+func (r memDeliveryStore) Claim(ctx context.Context, limit int, now, until time.Time) ([]ClaimedDelivery, error) {
+	var claimed []ClaimedDelivery
+	err := r.s.do(func(st *memState) error {
+		due := slices.DeleteFunc(mapValues(st.deliveries), func(d memDelivery) bool {
+			return d.State != DeliveryPending || d.NextAttemptAt.After(now) ||
+				(d.lockedUntil != nil && d.lockedUntil.After(now)) ||
+				!st.webhooks[d.WebhookID].Enabled
+		})
+		slices.SortFunc(due, func(a, b memDelivery) int {
+			if c := a.NextAttemptAt.Compare(*b.NextAttemptAt); c != 0 {
+				return c
+			}
+			return strings.Compare(a.ID, b.ID)
+		})
+		for _, d := range due[:min(limit, len(due))] {
+			d.lockedUntil = &until
+			st.deliveries[d.ID] = d
+			w := st.webhooks[d.WebhookID]
+			claimed = append(claimed, ClaimedDelivery{WebhookDelivery: d.WebhookDelivery, URL: w.URL, Secret: w.Secret})
+		}
+		return nil
+	})
+	return claimed, err
+}
+
+func (r memDeliveryStore) Attempted(ctx context.Context, id string, a DeliveryAttempt, next *time.Time) error {
+	return r.s.do(func(st *memState) error {
+		d, ok := st.deliveries[id]
+		if !ok {
+			return ErrDeliveryNotFound
+		}
+		durationMS := a.Duration.Milliseconds()
+		d.Attempts++
+		d.LastAttemptAt = &a.At
+		d.StatusCode = a.StatusCode
+		d.Error = a.Error
+		d.DurationMS = &durationMS
+		d.lockedUntil = nil
+		d.NextAttemptAt = next
+		switch {
+		case a.OK:
+			d.State = DeliveryDelivered
+			d.DeliveredAt = &a.At
+			d.NextAttemptAt = nil
+		case next == nil:
+			d.State = DeliveryFailed
+		}
+		st.deliveries[id] = d
+		return nil
+	})
+}
+
+func (r memDeliveryStore) Redeliver(ctx context.Context, id string, now time.Time) (string, error) {
+	var webhookID string
+	err := r.s.do(func(st *memState) error {
+		d, ok := st.deliveries[id]
+		if !ok {
+			return ErrDeliveryNotFound
+		}
+		d.State = DeliveryPending
+		d.Attempts = 0
+		d.NextAttemptAt = &now
+		d.lockedUntil = nil
+		st.deliveries[id] = d
+		webhookID = d.WebhookID
+		return nil
+	})
+	return webhookID, err
+}
+
+type memEmailStore struct{ s *memoryStore }
+
+// field returns the value of e for a field of emailFields.
+func (memEmailStore) field(e memEmail, name string) any {
+	switch name {
+	case "id":
+		return e.ID
+	case "user_id":
+		return e.UserID
+	case "kind":
+		return e.Kind
+	case "to":
+		return e.To
+	case "last_attempt_at":
+		return e.LastAttemptAt
+	case "sent_at":
+		return e.SentAt
+	}
+	return e.CreatedAt
+}
+
+func (r memEmailStore) match(q EmailQuery) func(memEmail) bool {
+	return func(e memEmail) bool {
+		return (q.ID == "" || e.ID == q.ID) &&
+			(q.UserID == "" || (e.UserID != nil && *e.UserID == q.UserID)) &&
+			(q.Kind == "" || e.Kind == q.Kind) &&
+			(q.State == "" || e.State == q.State)
+	}
+}
+
+func (r memEmailStore) List(ctx context.Context, q EmailQuery, p ListParams) ([]Email, error) {
+	emails := []Email{}
+	err := r.s.do(func(st *memState) error {
+		rows := memList(mapValues(st.emails), p, r.match(q), r.field, func(e memEmail) string { return e.ID })
+		for _, row := range rows {
+			e := row.Email
+			e.Text, e.HTML = "", ""
+			emails = append(emails, e)
+		}
+		return nil
+	})
+	return emails, err
+}
+
+func (r memEmailStore) Count(ctx context.Context, q EmailQuery, p ListParams) (int64, bool, error) {
+	var n int64
+	err := r.s.do(func(st *memState) error {
+		n = memCount(mapValues(st.emails), p, r.match(q), r.field)
+		return nil
+	})
+	return n, false, err
+}
+
+func (r memEmailStore) Add(ctx context.Context, emails ...Email) error {
+	return r.s.do(func(st *memState) error {
+		for _, e := range emails {
+			if e.UserID != nil {
+				if _, ok := st.users[*e.UserID]; !ok {
+					return ErrUserNotFound
+				}
+			}
+			createdAt := e.CreatedAt
+			e.State = EmailPending
+			e.NextAttemptAt = &createdAt
+			st.emails[e.ID] = memEmail{Email: e}
+		}
+		return nil
+	})
+}
+
+func (r memEmailStore) Claim(ctx context.Context, limit int, now, until time.Time) ([]Email, error) {
+	var claimed []Email
+	err := r.s.do(func(st *memState) error {
+		due := slices.DeleteFunc(mapValues(st.emails), func(e memEmail) bool {
+			return e.State != EmailPending || e.NextAttemptAt.After(now) ||
+				(e.lockedUntil != nil && e.lockedUntil.After(now))
+		})
+		slices.SortFunc(due, func(a, b memEmail) int { return compareEmailsDue(a.Email, b.Email) })
+		for _, e := range due[:min(limit, len(due))] {
+			e.lockedUntil = &until
+			st.emails[e.ID] = e
+			claimed = append(claimed, e.Email)
+		}
+		return nil
+	})
+	return claimed, err
+}
+
+func (r memEmailStore) Attempted(ctx context.Context, id string, at time.Time, errMsg *string, next *time.Time) error {
+	return r.s.do(func(st *memState) error {
+		e, ok := st.emails[id]
+		if !ok {
+			return ErrEmailNotFound
+		}
+		e.Attempts++
+		e.LastAttemptAt = &at
+		e.Error = errMsg
+		e.lockedUntil = nil
+		e.NextAttemptAt = next
+		switch {
+		case errMsg == nil:
+			e.State = EmailSent
+			e.SentAt = &at
+			e.NextAttemptAt = nil
+			e.Text, e.HTML = "", ""
+		case next == nil:
+			e.State = EmailFailed
+		}
+		st.emails[id] = e
+		return nil
+	})
+}
+
+// memList returns up to p.Limit+1 rows matching match and the filters of p,
+// ordered and paginated as by the SQL of p. The field function returns the
+// value of a row for a field name; values must be strings, time.Times or
+// pointers to one of them.
+func memList[T any](rows []T, p ListParams, match func(T) bool, field func(T, string) any, id func(T) string) []T {
+	rows = memFilter(rows, p, match, field)
+	reverse := p.Paginated && p.Cursor != nil && p.Cursor.Before
+	slices.SortFunc(rows, func(a, b T) int {
+		return compareKeys(p.Keyset.Keys, reverse, func(name string) (any, any) {
+			return field(a, name), field(b, name)
+		}, id(a), id(b))
+	})
+
+	if p.Paginated && p.Cursor != nil {
+		c := p.Cursor
+		rows = slices.DeleteFunc(rows, func(row T) bool {
+			return compareKeys(p.Keyset.Keys, reverse, func(name string) (any, any) {
+				return field(row, name), cursorValue(p.Keyset.Keys, c, name)
+			}, id(row), c.ID) <= 0
+		})
+	} else if p.Offset > 0 {
+		rows = rows[min(p.Offset, len(rows)):]
+	}
+	if len(rows) > p.Limit+1 {
+		rows = rows[:p.Limit+1]
+	}
+	return rows
+}
+
+// memCount returns the number of rows matching match and the filters of p.
+func memCount[T any](rows []T, p ListParams, match func(T) bool, field func(T, string) any) int64 {
+	return int64(len(memFilter(rows, p, match, field)))
+}
+
+// memFilter returns the rows matching match and the filters of p.
+func memFilter[T any](rows []T, p ListParams, match func(T) bool, field func(T, string) any) []T {
+	return slices.DeleteFunc(rows, func(row T) bool {
+		if !match(row) {
+			return true
+		}
+		for _, f := range p.Filters {
+			if !matchFilter(f, field(row, f.Field)) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// matchFilter reports whether the value v of a field satisfies f.
+func matchFilter(f sqlutil.Filter, v any) bool {
+	val, null := memValue(v)
+	switch f.Op {
+	case sqlutil.OpEq:
+		s, _ := f.Value.(string)
+		return !null && compareValues(val, parseLike(s, val)) == 0
+	case sqlutil.OpIn:
+		return !null && slices.ContainsFunc(f.Values, func(s string) bool {
+			return compareValues(val, parseLike(s, val)) == 0
+		})
+	case sqlutil.OpRange:
+		t, ok := val.(time.Time)
+		return !null && ok &&
+			(f.From == nil || !t.Before(*f.From)) &&
+			(f.To == nil || t.Before(*f.To))
+	case sqlutil.OpIsNull:
+		isNull, _ := f.Value.(bool)
+		return null == isNull
+	case sqlutil.OpPrefix:
+		s, _ := f.Value.(string)
+		str, ok := val.(string)
+		return !null && ok && strings.HasPrefix(str, s)
+	}
+	return false
+}
+
+// compareKeys compares two rows by keys and then by id, in the reverse order
+// if reverse is set. The values function returns the values of both rows for
+// a key name.
+func compareKeys(keys []sqlutil.SortKey, reverse bool, values func(name string) (any, any), idA, idB string) int {
+	desc := reverse
+	for _, key := range keys {
+		desc = key.Desc != reverse
+		a, b := values(key.Name)
+		if c := directed(compareNullable(a, b), desc); c != 0 {
+			return c
+		}
+	}
+	return directed(cmp.Compare(idA, idB), desc)
+}
+
+// compareNullable compares two field values, ordering nulls after other
+// values as PostgreSQL does in ascending order.
+func compareNullable(a, b any) int {
+	va, nullA := memValue(a)
+	vb, nullB := memValue(b)
+	switch {
+	case nullA && nullB:
+		return 0
+	case nullA:
+		return 1
+	case nullB:
+		return -1
+	}
+	return compareValues(va, vb)
+}
+
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case string:
+		b, _ := b.(string)
+		return strings.Compare(a, b)
+	case time.Time:
+		b, _ := b.(time.Time)
+		return a.Compare(b)
+	}
+	return 0
+}
+
+func directed(c int, desc bool) int {
+	if desc {
+		return -c
+	}
+	return c
+}
+
+// memValue returns the string or time.Time of a field value and whether it
+// is null.
+func memValue(v any) (any, bool) {
+	switch v := v.(type) {
+	case string, time.Time:
+		return v, false
+	case *string:
+		if v == nil {
+			return nil, true
+		}
+		return *v, false
+	case *time.Time:
+		if v == nil {
+			return nil, true
+		}
+		return *v, false
+	}
+	return nil, true
+}
+
+// parseLike parses s as a value of the type of like.
+func parseLike(s string, like any) any {
+	if _, ok := like.(time.Time); ok {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t
+	}
+	return s
+}
+
+// cursorValue returns the value of c for the key name as a field value.
+func cursorValue(keys []sqlutil.SortKey, c *sqlutil.Cursor, name string) any {
+	for i, key := range keys {
+		if key.Name != name {
+			continue
+		}
+		v := c.Values[i]
+		if v == nil {
+			return nil
+		}
+		if key.Time {
+			t, _ := time.Parse(time.RFC3339Nano, *v)
+			return t
+		}
+		return *v
+	}
+	return nil
+}
+
+// deleteFunc deletes the values of m for which del returns true and returns
+// the number of deleted values.
+func deleteFunc[K comparable, V any](m map[K]V, del func(V) bool) int {
+	var n int
+	for k, v := range m {
+		if del(v) {
+			delete(m, k)
+			n++
+		}
+	}
+	return n
+}
+
+// nullify sets *ref to nil if it refers to id, reporting whether it did.
+func nullify(ref **string, id string) bool {
+	if *ref == nil || **ref != id {
+		return false
+	}
+	*ref = nil
+	return true
+}
+
+func mapValues[K comparable, V any](m map[K]V) []V {
+	values := make([]V, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,2242 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"glut/common/postgres"
+	"glut/common/sqlutil"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stephenafamo/bob"
+	"github.com/stephenafamo/bob/dialect/psql"
+	"github.com/stephenafamo/bob/dialect/psql/dialect"
+	"github.com/stephenafamo/bob/dialect/psql/dm"
+	"github.com/stephenafamo/bob/dialect/psql/im"
+	"github.com/stephenafamo/bob/dialect/psql/sm"
+	"github.com/stephenafamo/bob/dialect/psql/um"
+)
+
+// Constraints of the schema mapped to errors.
+const (
+	constraintUsername        = "users_username_key"
+	constraintRoleName        = "roles_name_key"
+	constraintPermissionName  = "permissions_name_key"
+	constraintSessionNumber   = "sessions_user_id_session_number_key"
+	constraintBanUser         = "bans_user_id_fkey"
+	constraintDeliveryWebhook = "webhook_deliveries_webhook_id_fkey"
+	constraintEmailUser       = "emails_user_id_fkey"
+)
+
+// maxSessionNumberAttempts limits the attempts to number a new session when
+// concurrent logins pick the same number.
+const maxSessionNumberAttempts = 3
+
+// pgDB is the database or transaction of a postgresStore.
+type pgDB interface {
+	sqlutil.DB
+	sqlutil.Beginner
+}
+
+// postgresStore is a Store on the auth schema of db/migrations.
+type postgresStore struct {
+	cluster *postgres.Cluster
+	db      pgDB
+	tx      bool
+}
+
+// NewPostgresStore returns a Store on the auth schema of db. Reads outside of
+// transactions may use replicas, except for authenticating sessions so that a
+// token can be used right after logging in.
+func NewPostgresStore(db *postgres.Cluster) Store {
+	return &postgresStore{cluster: db, db: db}
+}
+
+func (s *postgresStore) Tx(ctx context.Context, opts sqlutil.TxOptions, fn func(tx Store) error) error {
+	return sqlutil.WithTx(ctx, s.db, opts, func(tx pgx.Tx) error {
+		return fn(&postgresStore{cluster: s.cluster, db: tx, tx: true})
+	})
+}
+
+func (s *postgresStore) Users() UserStore             { return pgUserStore{s} }
+func (s *postgresStore) Sessions() SessionStore       { return pgSessionStore{s} }
+func (s *postgresStore) Tokens() TokenStore           { return pgTokenStore{s} }
+func (s *postgresStore) Roles() RoleStore             { return pgRoleStore{s} }
+func (s *postgresStore) Permissions() PermissionStore { return pgPermissionStore{s} }
+func (s *postgresStore) UserRoles() UserRoleStore     { return pgUserRoleStore{s} }
+func (s *postgresStore) RolePermissions() RolePermissionStore {
+	return pgRolePermissionStore{s}
+}
+func (s *postgresStore) Bans() BanStore         { return pgBanStore{s} }
+func (s *postgresStore) Outbox() OutboxStore    { return pgOutboxStore{s} }
+func (s *postgresStore) Webhooks() WebhookStore { return pgWebhookStore{s} }
+func (s *postgresStore) WebhookDeliveries() WebhookDeliveryStore {
+	return pgDeliveryStore{s}
+}
+func (s *postgresStore) Emails() EmailStore { return pgEmailStore{s} }
+
+// lock returns the mods locking the selected rows until the end of the
+// transaction, if the store runs in one.
+func (s *postgresStore) lock() []bob.Mod[*dialect.SelectQuery] {
+	if !s.tx {
+		return nil
+	}
+	return []bob.Mod[*dialect.SelectQuery]{sm.ForNoKeyUpdate()}
+}
+
+// exec executes sql, returning the error mapped to the constraint it
+// violates, if any. In a transaction, sql runs in a savepoint so that the
+// transaction remains usable after a violation.
+func (s *postgresStore) exec(ctx context.Context, violations map[string]error, sql string, args ...any) (pgconn.CommandTag, error) {
+	var tag pgconn.CommandTag
+	var err error
+	if s.tx {
+		err = sqlutil.WithTx(ctx, s.db, sqlutil.TxOptions{}, func(tx pgx.Tx) error {
+			tag, err = tx.Exec(ctx, sql, args...)
+			return err
+		})
+	} else {
+		tag, err = s.db.Exec(ctx, sql, args...)
+	}
+	if mapped, ok := violations[sqlutil.Constraint(err)]; ok && err != nil {
+		return tag, mapped
+	}
+	return tag, err
+}
+
+// returningIDs runs sql, a statement returning ids, on the primary and
+// returns the ids.
+func (s *postgresStore) returningIDs(ctx context.Context, sql string, args ...any) ([]string, error) {
+	rows, err := s.db.Query(postgres.ReadYourWrites(ctx), sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// pageMods returns the mods ordering and limiting the rows of a list query.
+func pageMods(p ListParams) []bob.Mod[*dialect.SelectQuery] {
+	if p.Paginated {
+		return p.Keyset.Mods(p.Cursor, p.Limit)
+	}
+	mods := p.Keyset.OrderBy(false)
+	mods = append(mods, sm.Limit(p.Limit+1))
+	if p.Offset != 0 {
+		mods = append(mods, sm.Offset(p.Offset))
+	}
+	return mods
+}
+
+// whereMods returns the mods applying validated filters.
+func whereMods(fields sqlutil.Fields, filters []sqlutil.Filter) []bob.Mod[*dialect.SelectQuery] {
+	mods, _ := fields.Where(filters)
+	return mods
+}
+
+type pgUserStore struct{ s *postgresStore }
+
+func (r pgUserStore) query(q UserQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := psql.Select(
+		sm.Columns(
+			"id",
+			"username",
+			"email",
+			"created_at",
+			"last_login_at",
+			"last_login_ip",
+		),
+		sm.From("auth.users"),
+	)
+	if q.ID != "" {
+		query.Apply(
+			sm.Where(psql.Quote("id").EQ(psql.Arg(q.ID))),
+		)
+	}
+	if q.Email != "" {
+		query.Apply(
+			sm.Where(psql.Quote("email").ILike(
+				psql.Arg(sqlutil.Like(q.Email)),
+			)),
+		)
+	}
+	if q.Username != "" {
+		query.Apply(
+			sm.Where(psql.Quote("username").ILike(
+				psql.Arg(sqlutil.Like(q.Username)),
+			)),
+		)
+	}
+	query.Apply(whereMods(userFields, p.Filters)...)
+	return query
+}
+
+func (r pgUserStore) List(ctx context.Context, q UserQuery, p ListParams) ([]User, error) {
+	query := r.query(q, p)
+	query.Apply(pageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var id string
+		var username string
+		var email string
+		var createdAt time.Time
+		var lastLoginAt *time.Time
+		var lastLoginIP *string
+
+		if err := rows.Scan(
+			&id,
+			&username,
+			&email,
+			&createdAt,
+			&lastLoginAt,
+			&lastLoginIP,
+		); err != nil {
+			return nil, err
+		}
+		users = append(users, User{
+			ID:          id,
+			Username:    username,
+			Email:       email,
+			CreatedAt:   createdAt,
+			LastLoginAt: lastLoginAt,
+			LastLoginIP: lastLoginIP,
+		})
+	}
+	return users, rows.Err()
+}
+
+func (r pgUserStore) Count(ctx context.Context, q UserQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, p).MustBuild()
+	filtered := q.ID != "" || q.Email != "" || q.Username != "" || len(p.Filters) != 0
+	return sqlutil.Count(ctx, r.s.db, "auth.users", filtered, sql, args...)
+}
+
+func (r pgUserStore) get(ctx context.Context, column, value string) (User, error) {
+	q := psql.Select(
+		sm.From("auth.users"),
+		sm.Columns("id", "username", "email", "password_hash", "created_at", "verified_at", "last_login_at", "last_login_ip"),
+		sm.Where(psql.Quote(column).EQ(psql.Arg(value))),
+	)
+	q.Apply(r.s.lock()...)
+	sql, args := q.MustBuild()
+
+	var u User
+	if err := r.s.db.QueryRow(ctx, sql, args...).Scan(
+		&u.ID,
+		&u.Username,
+		&u.Email,
+		&u.Password,
+		&u.CreatedAt,
+		&u.VerifiedAt,
+		&u.LastLoginAt,
+		&u.LastLoginIP,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (r pgUserStore) Get(ctx context.Context, id string) (User, error) {
+	return r.get(ctx, "id", id)
+}
+
+func (r pgUserStore) GetByUsername(ctx context.Context, username string) (User, error) {
+	return r.get(ctx, "username", username)
+}
+
+func (r pgUserStore) UsernamesByEmail(ctx context.Context, email string) ([]string, error) {
+	sql, args := psql.Select(
+		sm.From("auth.users"),
+		sm.Columns("username"),
+		sm.Where(psql.Quote("email").EQ(psql.Arg(email))),
+		sm.OrderBy("username"),
+	).MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usernames []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, rows.Err()
+}
+
+func (r pgUserStore) Create(ctx context.Context, u User) error {
+	sql, args := psql.Insert(
+		im.Into("auth.users",
+			"id", "username", "email", "password_hash", "created_at",
+		),
+		im.Values(psql.Arg(u.ID, u.Username, u.Email, u.Password, u.CreatedAt)),
+	).MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{constraintUsername: ErrUserExists}, sql, args...)
+	return err
+}
+
+func (r pgUserStore) Update(ctx context.Context, id string, in UserUpdate) error {
+	q := psql.Update(
+		um.Table("auth.users"),
+		um.Where(psql.Quote("id").EQ(psql.Arg(id))),
+	)
+	set := false
+	// The columns are set in a fixed order so that updates of the same
+	// columns build the same statement.
+	for _, c := range []struct {
+		column string
+		value  any
+		isSet  bool
+	}{
+		{"email", in.Email, in.Email != nil},
+		{"password_hash", in.PasswordHash, in.PasswordHash != nil},
+		{"verified_at", in.VerifiedAt, in.VerifiedAt != nil},
+		{"last_login_at", in.LastLoginAt, in.LastLoginAt != nil},
+		{"last_login_ip", in.LastLoginIP, in.LastLoginIP != nil},
+	} {
+		if !c.isSet {
+			continue
+		}
+		q.Apply(um.Set(c.column).ToArg(c.value))
+		set = true
+	}
+	if !set {
+		return nil
+	}
+	sql, args := q.MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("auth.UserStore.Update: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r pgUserStore) Delete(ctx context.Context, ids []string) ([]string, error) {
+	sql, args := psql.Delete(
+		dm.From("auth.users"),
+		dm.Where(psql.Quote("id").In(psql.Arg(sqlutil.AnySlice(ids)...))),
+		dm.Returning("id"),
+	).MustBuild()
+
+	return r.s.returningIDs(ctx, sql, args...)
+}
+
+type pgSessionStore struct{ s *postgresStore }
+
+func (r pgSessionStore) query(q SessionQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := psql.Select(
+		sm.Columns(
+			"id",
+			"token",
+			"user_id",
+			"user_ip",
+			"created_at",
+			"expires_at",
+		),
+		sm.From("auth.sessions"),
+	)
+	if q.ID != "" {
+		query.Apply(
+			sm.Where(psql.Quote("id").EQ(psql.Arg(q.ID))),
+		)
+	}
+	if q.UserID != "" {
+		query.Apply(
+			sm.Where(psql.Quote("user_id").EQ(psql.Arg(q.UserID))),
+		)
+	}
+	if !q.IncludeExpired {
+		query.Apply(
+			sm.Where(psql.Quote("expires_at").GT(psql.Arg(p.Now))),
+		)
+	}
+	query.Apply(whereMods(sessionFields, p.Filters)...)
+	return query
+}
+
+func (r pgSessionStore) List(ctx context.Context, q SessionQuery, p ListParams) ([]Session, error) {
+	query := r.query(q, p)
+	query.Apply(pageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(
+			&sess.ID,
+			&sess.Token,
+			&sess.UserID,
+			&sess.UserIP,
+			&sess.CreatedAt,
+			&sess.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+func (r pgSessionStore) Count(ctx context.Context, q SessionQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, p).MustBuild()
+	filtered := q.ID != "" || q.UserID != "" || !q.IncludeExpired || len(p.Filters) != 0
+	return sqlutil.Count(ctx, r.s.db, "auth.sessions", filtered, sql, args...)
+}
+
+func (r pgSessionStore) Authenticate(ctx context.Context, token string, now time.Time) (Session, error) {
+	sql, args := psql.Select(
+		sm.From("auth.sessions"),
+		sm.Columns("id", "token", "user_id", "user_ip", "created_at", "expires_at"),
+		psql.WhereAnd(
+			sm.Where(psql.Quote("token").EQ(psql.Arg(token))),
+			sm.Where(psql.Quote("expires_at").GT(psql.Arg(now))),
+		),
+	).MustBuild()
+
+	var sess Session
+	if err := r.s.db.QueryRow(ctx, sql, args...).Scan(
+		&sess.ID,
+		&sess.Token,
+		&sess.UserID,
+		&sess.UserIP,
+		&sess.CreatedAt,
+		&sess.ExpiresAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Session{}, ErrSessionNotFound
+		}
+		return Session{}, err
+	}
+	return sess, nil
+}
+
+// Create numbers the session with the lowest number the user does not use.
+// Concurrent logins may pick the same number; the insert is then retried in a
+// savepoint, observing the session of the other login.
+func (r pgSessionStore) Create(ctx context.Context, sess Session, max int) error {
+	for attempt := 1; ; attempt++ {
+		err := sqlutil.WithTx(ctx, r.s.db, sqlutil.TxOptions{MaxAttempts: 1}, func(tx pgx.Tx) error {
+			return r.create(ctx, tx, sess, max)
+		})
+		if attempt < maxSessionNumberAttempts && sqlutil.Constraint(err) == constraintSessionNumber {
+			continue
+		}
+		return err
+	}
+}
+
+func (r pgSessionStore) create(ctx context.Context, tx pgx.Tx, sess Session, max int) error {
+	q := `SELECT COUNT(id) FROM auth.sessions WHERE user_id = $1;`
+
+	var sessCount int
+	if err := tx.QueryRow(ctx, q, sess.UserID).Scan(&sessCount); err != nil {
+		return err
+	}
+	if sessCount >= max {
+		return ErrSessionLimit
+	}
+
+	q = `
+	SELECT session_number FROM generate_series (1,$1) session_number
+	EXCEPT (
+		SELECT session_number FROM auth.sessions WHERE user_id = $2
+	)
+	ORDER BY 1
+	LIMIT 1;`
+
+	var nextSessNum int
+	if err := tx.QueryRow(ctx, q, max, sess.UserID).Scan(&nextSessNum); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrSessionLimit
+		}
+		return err
+	}
+
+	sql, args := psql.Insert(
+		im.Into("auth.sessions",
+			"id", "token", "user_id", "user_ip", "session_number", "created_at", "expires_at",
+		),
+		im.Values(psql.Arg(sess.ID, sess.Token, sess.UserID, sess.UserIP, nextSessNum, sess.CreatedAt, sess.ExpiresAt)),
+	).MustBuild()
+
+	_, err := tx.Exec(ctx, sql, args...)
+	return err
+}
+
+func (r pgSessionStore) Renew(ctx context.Context, id string, expiresAt time.Time) error {
+	sql, args := psql.Update(
+		um.Table("auth.sessions"),
+		um.Set("expires_at").ToArg(expiresAt),
+		um.Where(psql.Quote("id").EQ(psql.Arg(id))),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (r pgSessionStore) ActivateRoles(ctx context.Context, id string, roleIDs []string) (int, error) {
+	sql, args := psql.Select(
+		sm.From("auth.sessions"),
+		sm.Columns("user_id"),
+		sm.Where(psql.Quote("id").EQ(psql.Arg(id))),
+	).MustBuild()
+
+	var userID string
+	if err := r.s.db.QueryRow(ctx, sql, args...).Scan(&userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrSessionNotFound
+		}
+		return 0, err
+	}
+
+	held := psql.Select(
+		sm.Columns("s.token", "ur.role_id"),
+		sm.From("auth.sessions").As("s"),
+		sm.InnerJoin("auth.user_roles").As("ur").OnEQ(
+			psql.Raw("ur.user_id"), psql.Raw("s.user_id"),
+		),
+		sm.Where(psql.Quote("s", "id").EQ(psql.Arg(id))),
+	)
+	if roleIDs != nil {
+		held.Apply(
+			sm.Where(psql.Quote("ur", "role_id").In(psql.Arg(sqlutil.AnySlice(roleIDs)...))),
+		)
+		sql, args := psql.Select(
+			sm.From("auth.user_roles"),
+			sm.Columns(psql.Raw("count(*)")),
+			sm.Where(psql.Quote("user_id").EQ(psql.Arg(userID))),
+			sm.Where(psql.Quote("role_id").In(psql.Arg(sqlutil.AnySlice(roleIDs)...))),
+		).MustBuild()
+
+		var n int
+		if err := r.s.db.QueryRow(ctx, sql, args...).Scan(&n); err != nil {
+			return 0, err
+		}
+		if n != len(roleIDs) {
+			return 0, ErrRoleNotFound
+		}
+	}
+
+	sql, args = psql.Insert(
+		im.Into("auth.session_roles", "session_id", "role_id"),
+		im.Query(held),
+		im.OnConflict("session_id", "role_id").DoNothing(),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+func (r pgSessionStore) DropRoles(ctx context.Context, id string, roleIDs []string) (int, error) {
+	sql, args := psql.Delete(
+		dm.From("auth.session_roles"),
+		dm.Where(psql.Raw("session_id = (SELECT token FROM auth.sessions WHERE id = ?)", id)),
+		dm.Where(psql.Quote("role_id").In(psql.Arg(sqlutil.AnySlice(roleIDs)...))),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+func (r pgSessionStore) Grants(ctx context.Context, id string) ([]RoleGrant, error) {
+	sql, args := psql.Select(
+		sm.Columns("r.id", "r.name", "p.id", "p.name"),
+		sm.From("auth.session_roles").As("sr"),
+		sm.InnerJoin("auth.sessions").As("s").OnEQ(
+			psql.Raw("s.token"), psql.Raw("sr.session_id"),
+		),
+		sm.InnerJoin("auth.user_roles").As("ur").On(
+			psql.Raw("ur.user_id = s.user_id"), psql.Raw("ur.role_id = sr.role_id"),
+		),
+		sm.InnerJoin("auth.roles").As("r").OnEQ(
+			psql.Raw("r.id"), psql.Raw("sr.role_id"),
+		),
+		sm.InnerJoin("auth.role_permissions").As("rp").OnEQ(
+			psql.Raw("rp.role_id"), psql.Raw("sr.role_id"),
+		),
+		sm.InnerJoin("auth.permissions").As("p").OnEQ(
+			psql.Raw("p.id"), psql.Raw("rp.permission_id"),
+		),
+		sm.Where(psql.Quote("s", "id").EQ(psql.Arg(id))),
+		sm.OrderBy("p.name"),
+		sm.OrderBy("r.name"),
+	).MustBuild()
+
+	return pgGrants(ctx, r.s.db, sql, args...)
+}
+
+// pgGrants returns the role grants selected by sql, whose columns are the id
+// and name of the role and of the permission.
+func pgGrants(ctx context.Context, db sqlutil.DB, sql string, args ...any) ([]RoleGrant, error) {
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []RoleGrant{}
+	for rows.Next() {
+		var g RoleGrant
+		if err := rows.Scan(&g.RoleID, &g.RoleName, &g.PermissionID, &g.PermissionName); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+func (r pgSessionStore) Delete(ctx context.Context, ids []string, userID string) (int, error) {
+	if len(ids) == 0 && userID == "" {
+		return 0, nil
+	}
+	q := psql.Delete(dm.From("auth.sessions"))
+	if ids != nil {
+		q.Apply(dm.Where(
+			psql.Quote("id").In(
+				psql.Arg(sqlutil.AnySlice(ids)...)),
+		))
+	}
+	if userID != "" {
+		q.Apply(dm.Where(
+			psql.Quote("user_id").EQ(psql.Arg(userID)),
+		))
+	}
+	sql, args := q.MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+func (r pgSessionStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	sql, args := psql.Delete(
+		dm.From("auth.sessions"),
+		dm.Where(psql.Quote("expires_at").LTE(psql.Arg(before))),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+type pgTokenStore struct{ s *postgresStore }
+
+func (r pgTokenStore) Get(ctx context.Context, id, kind string, now time.Time) (Token, error) {
+	q := psql.Select(
+		sm.From("auth.tokens"),
+		sm.Columns("user_id", "meta", "created_at", "expires_at"),
+		psql.WhereAnd(
+			sm.Where(psql.Quote("id").EQ(psql.Arg(id))),
+			sm.Where(psql.Quote("kind").EQ(psql.Arg(kind))),
+			sm.Where(psql.Quote("expires_at").GT(psql.Arg(now))),
+		),
+	)
+	q.Apply(r.s.lock()...)
+	sql, args := q.MustBuild()
+
+	var userID string
+	var meta map[string]*string
+	var createdAt time.Time
+	var expiresAt time.Time
+	if err := r.s.db.QueryRow(ctx, sql, args...).Scan(&userID, &meta, &createdAt, &expiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Token{}, ErrInvalidToken
+		}
+		return Token{}, fmt.Errorf("auth.TokenStore.Get: %w", err)
+	}
+
+	token := Token{
+		ID:        id,
+		UserID:    userID,
+		Kind:      kind,
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+		Meta:      meta,
+	}
+	return token, nil
+}
+
+func (r pgTokenStore) ByUser(ctx context.Context, userID, kind string) (Token, bool, error) {
+	sql, args := psql.Select(
+		sm.From("auth.tokens"),
+		sm.Columns("id", "meta", "created_at", "expires_at"),
+		psql.WhereAnd(
+			sm.Where(psql.Quote("user_id").EQ(psql.Arg(userID))),
+			sm.Where(psql.Quote("kind").EQ(psql.Arg(kind))),
+		),
+	).MustBuild()
+
+	token := Token{UserID: userID, Kind: kind}
+	if err := r.s.db.QueryRow(ctx, sql, args...).Scan(&token.ID, &token.Meta, &token.CreatedAt, &token.ExpiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Token{}, false, nil
+		}
+		return Token{}, false, fmt.Errorf("auth.TokenStore.ByUser: %w", err)
+	}
+	return token, true, nil
+}
+
+func (r pgTokenStore) Save(ctx context.Context, token Token) error {
+	sql, args := psql.Insert(
+		im.Into("auth.tokens",
+			"id", "user_id", "kind", "created_at", "expires_at", "meta",
+		),
+		im.Values(psql.Arg(token.ID, token.UserID, token.Kind, token.CreatedAt, token.ExpiresAt, token.Meta)),
+		im.OnConflict("user_id", "kind").DoUpdate().SetExcluded("id", "created_at", "expires_at", "meta"),
+	).MustBuild()
+
+	if _, err := r.s.db.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("auth.TokenStore.Save: %w", err)
+	}
+	return nil
+}
+
+func (r pgTokenStore) Delete(ctx context.Context, id string) error {
+	sql, args := psql.Delete(
+		dm.From("auth.tokens"),
+		dm.Where(psql.Quote("id").EQ(psql.Arg(id))),
+	).MustBuild()
+
+	if _, err := r.s.db.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("auth.TokenStore.Delete: %w", err)
+	}
+	return nil
+}
+
+func (r pgTokenStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	sql, args := psql.Delete(
+		dm.From("auth.tokens"),
+		dm.Where(psql.Quote("expires_at").LTE(psql.Arg(before))),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+// rbacQuery returns the query selecting roles or permissions from table as r,
+// joined with the users who created and updated them if detailed is set.
+func rbacQuery(table, id, name string, detailed bool, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	cols := []any{"r.id", "r.name", "r.description", "r.created_at", "r.updated_at"}
+	if detailed {
+		cols = append(cols, "cb.id", "cb.username", "cb.email", "ub.id", "ub.username", "ub.email")
+	}
+	q := psql.Select(
+		sm.Columns(cols...),
+		sm.From(table).As("r"),
+	)
+	if detailed {
+		q.Apply(
+			sm.LeftJoin("auth.users").As("cb").OnEQ(
+				psql.Raw("r.created_by"), psql.Raw("cb.id"),
+			),
+			sm.LeftJoin("auth.users").As("ub").OnEQ(
+				psql.Raw("r.updated_by"), psql.Raw("ub.id"),
+			),
+		)
+	}
+	if id != "" {
+		q.Apply(
+			sm.Where(psql.Quote("r", "id").EQ(psql.Arg(id))),
+		)
+	}
+	if name != "" {
+		q.Apply(
+			sm.Where(psql.Quote("r", "name").EQ(psql.Arg(name))),
+		)
+	}
+	q.Apply(whereMods(roleFields, p.Filters)...)
+	return q
+}
+
+// listRBAC runs a query of rbacQuery and returns its rows as roles.
+func listRBAC(ctx context.Context, db sqlutil.DB, q bob.BaseQuery[*dialect.SelectQuery], detailed bool, p ListParams) ([]Role, error) {
+	q.Apply(pageMods(p)...)
+	sql, args := q.MustBuild()
+
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var id string
+		var name string
+		var description *string
+		var createdAt time.Time
+		var updatedAt *time.Time
+		var createdByID *string
+		var createdByUsername *string
+		var createdByEmail *string
+		var updatedByID *string
+		var updatedByUsername *string
+		var updatedByEmail *string
+
+		dest := []any{&id, &name, &description, &createdAt, &updatedAt}
+		if detailed {
+			dest = append(dest, &createdByID, &createdByUsername, &createdByEmail, &updatedByID, &updatedByUsername, &updatedByEmail)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		role := Role{
+			ID:          id,
+			Name:        name,
+			Description: description,
+			CreatedAt:   createdAt,
+			UpdatedAt:   updatedAt,
+		}
+		if detailed {
+			role.Meta = &RoleMeta{
+				CreatedByID:       createdByID,
+				CreatedByUsername: createdByUsername,
+				CreatedByEmail:    createdByEmail,
+				UpdatedByID:       updatedByID,
+				UpdatedByUsername: updatedByUsername,
+				UpdatedByEmail:    updatedByEmail,
+			}
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+type pgRoleStore struct{ s *postgresStore }
+
+func (r pgRoleStore) query(q RoleQuery, detailed bool, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := rbacQuery("auth.roles", q.ID, q.Name, detailed, p)
+	if q.PermissionID != "" {
+		query.Apply(
+			sm.Where(psql.Raw("r.id IN (SELECT role_id FROM auth.role_permissions WHERE permission_id = ?)", q.PermissionID)),
+		)
+	}
+	return query
+}
+
+func (r pgRoleStore) List(ctx context.Context, q RoleQuery, p ListParams) ([]Role, error) {
+	return listRBAC(ctx, r.s.db, r.query(q, q.Detailed, p), q.Detailed, p)
+}
+
+func (r pgRoleStore) Count(ctx context.Context, q RoleQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, false, p).MustBuild()
+	filtered := q.ID != "" || q.Name != "" || q.PermissionID != "" || len(p.Filters) != 0
+	return sqlutil.Count(ctx, r.s.db, "auth.roles", filtered, sql, args...)
+}
+
+func (r pgRoleStore) Create(ctx context.Context, role Role, by *string) error {
+	sql, args := psql.Insert(
+		im.Into("auth.roles",
+			"id", "name", "description", "created_at", "created_by",
+		),
+		im.Values(psql.Arg(role.ID, role.Name, role.Description, role.CreatedAt, by)),
+	).MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{constraintRoleName: ErrRoleExists}, sql, args...)
+	return err
+}
+
+// updateRBAC updates the name and description of the role or permission
+// in table, if they are not empty. It fails with notFound or, if the name
+// violates the unique constraint, with exists.
+func (s *postgresStore) updateRBAC(ctx context.Context, table, constraint string, exists, notFound error, in UpdateRoleInput, at time.Time, by *string) error {
+	q := psql.Update(
+		um.Table(table),
+		um.Set("updated_at").ToArg(at),
+		um.Set("updated_by").ToArg(by),
+		um.Where(psql.Quote("id").EQ(psql.Arg(in.ID))),
+	)
+	if in.Name != "" {
+		q.Apply(
+			um.Set("name").ToArg(in.Name),
+		)
+	}
+	if in.Description != "" {
+		q.Apply(
+			um.Set("description").ToArg(in.Description),
+		)
+	}
+	sql, args := q.MustBuild()
+
+	res, err := s.exec(ctx, map[string]error{constraint: exists}, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// deleteRBAC deletes the roles or permissions with ids from table and
+// returns the ids of the deleted rows.
+func (s *postgresStore) deleteRBAC(ctx context.Context, table string, ids []string) ([]string, error) {
+	sql, args := psql.Delete(
+		dm.From(table),
+		dm.Where(
+			psql.Quote("id").In(
+				psql.Arg(sqlutil.AnySlice(ids)...),
+			),
+		),
+		dm.Returning("id"),
+	).MustBuild()
+
+	return s.returningIDs(ctx, sql, args...)
+}
+
+func (r pgRoleStore) Update(ctx context.Context, in UpdateRoleInput, at time.Time, by *string) error {
+	return r.s.updateRBAC(ctx, "auth.roles", constraintRoleName, ErrRoleExists, ErrRoleNotFound, in, at, by)
+}
+
+func (r pgRoleStore) Delete(ctx context.Context, ids []string) ([]string, error) {
+	return r.s.deleteRBAC(ctx, "auth.roles", ids)
+}
+
+// exists locks the row with id in table against deletion and reports whether
+// it exists.
+func (r pgRoleStore) exists(ctx context.Context, table, id string) (bool, error) {
+	sql, args := psql.Select(
+		sm.From(table),
+		sm.Columns("id"),
+		sm.Where(psql.Quote("id").EQ(psql.Arg(id))),
+		sm.ForKeyShare(),
+	).MustBuild()
+
+	var exists bool
+	err := r.s.db.QueryRow(ctx, sqlutil.Exists(sql), args...).Scan(&exists)
+	return exists, err
+}
+
+// pgUserRef returns the id of the user with id, or NULL if there is none, to
+// record who made a change without failing on unknown users.
+func pgUserRef(id *string) bob.Expression {
+	return psql.Raw("(SELECT id FROM auth.users WHERE id = ?)", id)
+}
+
+// count returns the number of rows of table with ids.
+func (r pgRoleStore) count(ctx context.Context, table string, ids []string) (int, error) {
+	sql, args := psql.Select(
+		sm.From(table),
+		sm.Columns(psql.Raw("count(*)")),
+		sm.Where(psql.Quote("id").In(psql.Arg(sqlutil.AnySlice(ids)...))),
+	).MustBuild()
+
+	var n int
+	err := r.s.db.QueryRow(ctx, sql, args...).Scan(&n)
+	return n, err
+}
+
+func (r pgRoleStore) Grant(ctx context.Context, roleID string, permissionIDs []string, at time.Time, by *string) (int, error) {
+	roleExists, err := r.exists(ctx, "auth.roles", roleID)
+	if err != nil {
+		return 0, err
+	}
+	if !roleExists {
+		return 0, ErrRoleNotFound
+	}
+	permissionCount, err := r.count(ctx, "auth.permissions", permissionIDs)
+	if err != nil {
+		return 0, err
+	}
+	if permissionCount != len(permissionIDs) {
+		return 0, ErrPermissionNotFound
+	}
+
+	q := psql.Insert(
+		im.Into("auth.role_permissions", "id", "role_id", "permission_id", "created_at", "created_by"),
+		im.OnConflict("role_id", "permission_id").DoNothing(),
+	)
+	for _, permissionID := range permissionIDs {
+		q.Apply(
+			im.Values(psql.Arg(uuid.New().String(), roleID, permissionID, at), pgUserRef(by)),
+		)
+	}
+	sql, args := q.MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+func (r pgRoleStore) Revoke(ctx context.Context, roleID string, permissionIDs []string) (int, error) {
+	sql, args := psql.Delete(
+		dm.From("auth.role_permissions"),
+		dm.Where(psql.Quote("role_id").EQ(psql.Arg(roleID))),
+		dm.Where(psql.Quote("permission_id").In(psql.Arg(sqlutil.AnySlice(permissionIDs)...))),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+func (r pgRoleStore) Assign(ctx context.Context, userID string, roleIDs []string, at time.Time, by *string) (int, error) {
+	userExists, err := r.exists(ctx, "auth.users", userID)
+	if err != nil {
+		return 0, err
+	}
+	if !userExists {
+		return 0, ErrUserNotFound
+	}
+	roleCount, err := r.count(ctx, "auth.roles", roleIDs)
+	if err != nil {
+		return 0, err
+	}
+	if roleCount != len(roleIDs) {
+		return 0, ErrRoleNotFound
+	}
+
+	q := psql.Insert(
+		im.Into("auth.user_roles", "id", "user_id", "role_id", "created_at", "created_by"),
+		im.OnConflict("user_id", "role_id").DoNothing(),
+	)
+	for _, roleID := range roleIDs {
+		q.Apply(
+			im.Values(psql.Arg(uuid.New().String(), userID, roleID, at), pgUserRef(by)),
+		)
+	}
+	sql, args := q.MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+func (r pgRoleStore) Unassign(ctx context.Context, userID string, roleIDs []string) (int, error) {
+	sql, args := psql.Delete(
+		dm.From("auth.user_roles"),
+		dm.Where(psql.Quote("user_id").EQ(psql.Arg(userID))),
+		dm.Where(psql.Quote("role_id").In(psql.Arg(sqlutil.AnySlice(roleIDs)...))),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+type pgPermissionStore struct{ s *postgresStore }
+
+func (r pgPermissionStore) query(q PermissionQuery, detailed bool, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := rbacQuery("auth.permissions", q.ID, q.Name, detailed, p)
+	if q.RoleID != "" {
+		query.Apply(
+			sm.Where(psql.Raw("r.id IN (SELECT permission_id FROM auth.role_permissions WHERE role_id = ?)", q.RoleID)),
+		)
+	}
+	return query
+}
+
+func (r pgPermissionStore) List(ctx context.Context, q PermissionQuery, p ListParams) ([]Permission, error) {
+	roles, err := listRBAC(ctx, r.s.db, r.query(q, q.Detailed, p), q.Detailed, p)
+	if err != nil {
+		return nil, err
+	}
+	permissions := make([]Permission, len(roles))
+	for i, role := range roles {
+		permissions[i] = Permission{
+			ID:          role.ID,
+			Name:        role.Name,
+			Description: role.Description,
+			CreatedAt:   role.CreatedAt,
+			UpdatedAt:   role.UpdatedAt,
+			Meta:        (*PermissionMeta)(role.Meta),
+		}
+	}
+	return permissions, nil
+}
+
+func (r pgPermissionStore) Count(ctx context.Context, q PermissionQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, false, p).MustBuild()
+	filtered := q.ID != "" || q.Name != "" || q.RoleID != "" || len(p.Filters) != 0
+	return sqlutil.Count(ctx, r.s.db, "auth.permissions", filtered, sql, args...)
+}
+
+func (r pgPermissionStore) Existing(ctx context.Context, names []string) ([]string, error) {
+	sql, args := psql.Select(
+		sm.Columns("name"),
+		sm.From("auth.permissions"),
+		sm.Where(psql.Quote("name").In(psql.Arg(sqlutil.AnySlice(names)...))),
+		sm.OrderBy("name"),
+	).MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (r pgPermissionStore) Create(ctx context.Context, permission Permission, by *string) error {
+	sql, args := psql.Insert(
+		im.Into("auth.permissions",
+			"id", "name", "description", "created_at", "created_by",
+		),
+		im.Values(psql.Arg(permission.ID, permission.Name, permission.Description, permission.CreatedAt, by)),
+	).MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{constraintPermissionName: ErrPermissionExists}, sql, args...)
+	return err
+}
+
+func (r pgPermissionStore) Update(ctx context.Context, in UpdatePermissionInput, at time.Time, by *string) error {
+	return r.s.updateRBAC(ctx, "auth.permissions", constraintPermissionName, ErrPermissionExists, ErrPermissionNotFound, UpdateRoleInput(in), at, by)
+}
+
+func (r pgPermissionStore) Delete(ctx context.Context, ids []string) ([]string, error) {
+	return r.s.deleteRBAC(ctx, "auth.permissions", ids)
+}
+
+type pgUserRoleStore struct{ s *postgresStore }
+
+func (r pgUserRoleStore) query(q UserRoleQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	cols := []any{"ur.id", "ur.user_id", "u.username", "u.email", "ur.role_id", "r.name", "ur.created_at"}
+	if q.Detailed {
+		cols = append(cols, "cb.id", "cb.username", "cb.email")
+	}
+	query := psql.Select(
+		sm.Columns(cols...),
+		sm.From("auth.user_roles").As("ur"),
+		sm.InnerJoin("auth.users").As("u").OnEQ(
+			psql.Raw("ur.user_id"), psql.Raw("u.id"),
+		),
+		sm.InnerJoin("auth.roles").As("r").OnEQ(
+			psql.Raw("ur.role_id"), psql.Raw("r.id"),
+		),
+	)
+	if q.Detailed {
+		query.Apply(
+			sm.LeftJoin("auth.users").As("cb").OnEQ(
+				psql.Raw("ur.created_by"), psql.Raw("cb.id"),
+			),
+		)
+	}
+	if q.UserID != "" {
+		query.Apply(
+			sm.Where(psql.Quote("ur", "user_id").EQ(psql.Arg(q.UserID))),
+		)
+	}
+	if q.RoleID != "" {
+		query.Apply(
+			sm.Where(psql.Quote("ur", "role_id").EQ(psql.Arg(q.RoleID))),
+		)
+	}
+	if q.RoleName != "" {
+		query.Apply(
+			sm.Where(psql.Quote("r", "name").EQ(psql.Arg(q.RoleName))),
+		)
+	}
+	query.Apply(whereMods(userRoleFields, p.Filters)...)
+	return query
+}
+
+func (r pgUserRoleStore) List(ctx context.Context, q UserRoleQuery, p ListParams) ([]UserRole, error) {
+	query := r.query(q, p)
+	query.Apply(pageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userRoles := []UserRole{}
+	for rows.Next() {
+		var ur UserRole
+		var createdByID *string
+		var createdByUsername *string
+		var createdByEmail *string
+
+		dest := []any{&ur.ID, &ur.UserID, &ur.Username, &ur.Email, &ur.RoleID, &ur.RoleName, &ur.CreatedAt}
+		if q.Detailed {
+			dest = append(dest, &createdByID, &createdByUsername, &createdByEmail)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if q.Detailed {
+			ur.Meta = &UserRoleMeta{
+				CreatedByID:       createdByID,
+				CreatedByUsername: createdByUsername,
+				CreatedByEmail:    createdByEmail,
+			}
+		}
+		userRoles = append(userRoles, ur)
+	}
+	return userRoles, rows.Err()
+}
+
+func (r pgUserRoleStore) Count(ctx context.Context, q UserRoleQuery, p ListParams) (int64, bool, error) {
+	q.Detailed = false
+	sql, args := r.query(q, p).MustBuild()
+	filtered := q.UserID != "" || q.RoleID != "" || q.RoleName != "" || len(p.Filters) != 0
+	return sqlutil.Count(ctx, r.s.db, "auth.user_roles", filtered, sql, args...)
+}
+
+func (r pgUserRoleStore) Held(ctx context.Context, roleName string) (bool, error) {
+	sql, args := psql.Select(
+		sm.Columns(psql.Raw("1")),
+		sm.From("auth.user_roles").As("ur"),
+		sm.InnerJoin("auth.roles").As("r").OnEQ(
+			psql.Raw("ur.role_id"), psql.Raw("r.id"),
+		),
+		sm.Where(psql.Quote("r", "name").EQ(psql.Arg(roleName))),
+		sm.Limit(1),
+	).MustBuild()
+
+	var one int
+	if err := r.s.db.QueryRow(ctx, sql, args...).Scan(&one); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (r pgUserRoleStore) Grants(ctx context.Context, userID string) ([]RoleGrant, error) {
+	sql, args := psql.Select(
+		sm.Columns("r.id", "r.name", "p.id", "p.name"),
+		sm.From("auth.user_roles").As("ur"),
+		sm.InnerJoin("auth.roles").As("r").OnEQ(
+			psql.Raw("r.id"), psql.Raw("ur.role_id"),
+		),
+		sm.InnerJoin("auth.role_permissions").As("rp").OnEQ(
+			psql.Raw("rp.role_id"), psql.Raw("ur.role_id"),
+		),
+		sm.InnerJoin("auth.permissions").As("p").OnEQ(
+			psql.Raw("p.id"), psql.Raw("rp.permission_id"),
+		),
+		sm.Where(psql.Quote("ur", "user_id").EQ(psql.Arg(userID))),
+		sm.OrderBy("p.name"),
+		sm.OrderBy("r.name"),
+	).MustBuild()
+
+	return pgGrants(ctx, r.s.db, sql, args...)
+}
+
+type pgRolePermissionStore struct{ s *postgresStore }
+
+func (r pgRolePermissionStore) query(q RolePermissionQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	cols := []any{"rp.id", "rp.role_id", "r.name", "rp.permission_id", "p.name", "rp.created_at"}
+	if q.Detailed {
+		cols = append(cols, "cb.id", "cb.username", "cb.email")
+	}
+	query := psql.Select(
+		sm.Columns(cols...),
+		sm.From("auth.role_permissions").As("rp"),
+		sm.InnerJoin("auth.roles").As("r").OnEQ(
+			psql.Raw("rp.role_id"), psql.Raw("r.id"),
+		),
+		sm.InnerJoin("auth.permissions").As("p").OnEQ(
+			psql.Raw("rp.permission_id"), psql.Raw("p.id"),
+		),
+		sm.Where(psql.Quote("rp", "role_id").EQ(psql.Arg(q.RoleID))),
+	)
+	if q.Detailed {
+		query.Apply(
+			sm.LeftJoin("auth.users").As("cb").OnEQ(
+				psql.Raw("rp.created_by"), psql.Raw("cb.id"),
+			),
+		)
+	}
+	query.Apply(whereMods(rolePermissionFields, p.Filters)...)
+	return query
+}
+
+func (r pgRolePermissionStore) List(ctx context.Context, q RolePermissionQuery, p ListParams) ([]RolePermission, error) {
+	query := r.query(q, p)
+	query.Apply(pageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rolePermissions := []RolePermission{}
+	for rows.Next() {
+		var rp RolePermission
+		var meta RolePermissionMeta
+		dest := []any{&rp.ID, &rp.RoleID, &rp.RoleName, &rp.PermissionID, &rp.PermissionName, &rp.CreatedAt}
+		if q.Detailed {
+			dest = append(dest, &meta.CreatedByID, &meta.CreatedByUsername, &meta.CreatedByEmail)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if q.Detailed {
+			rp.Meta = &meta
+		}
+		rolePermissions = append(rolePermissions, rp)
+	}
+	return rolePermissions, rows.Err()
+}
+
+func (r pgRolePermissionStore) Count(ctx context.Context, q RolePermissionQuery, p ListParams) (int64, bool, error) {
+	q.Detailed = false
+	sql, args := r.query(q, p).MustBuild()
+	return sqlutil.Count(ctx, r.s.db, "auth.role_permissions", true, sql, args...)
+}
+
+type pgBanStore struct{ s *postgresStore }
+
+func (r pgBanStore) query(q BanQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	cols := []any{"b.user_id", "b.reason", "b.description", "b.banned_at", "b.unbanned_at"}
+	if q.Detailed {
+		cols = append(cols, "bb.id", "bb.username", "bb.email")
+	}
+	query := psql.Select(
+		sm.Columns(cols...),
+		sm.From("auth.bans").As("b"),
+	)
+	if q.Detailed {
+		query.Apply(
+			sm.LeftJoin("auth.users").As("bb").OnEQ(
+				psql.Raw("b.banned_by"), psql.Raw("bb.id"),
+			),
+		)
+	}
+	if q.UserID != "" {
+		query.Apply(
+			sm.Where(psql.Quote("b", "user_id").EQ(psql.Arg(q.UserID))),
+		)
+	}
+	if !q.IncludeExpired {
+		query.Apply(
+			psql.WhereOr(
+				sm.Where(psql.Quote("b", "unbanned_at").GT(psql.Arg(p.Now))),
+				sm.Where(psql.Quote("b", "banned_at").EQ(psql.Quote("b", "unbanned_at"))),
+			),
+		)
+	}
+	query.Apply(whereMods(banFields, p.Filters)...)
+	return query
+}
+
+func (r pgBanStore) List(ctx context.Context, q BanQuery, p ListParams) ([]Ban, error) {
+	query := r.query(q, p)
+	query.Apply(pageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []Ban{}
+	for rows.Next() {
+		var userID string
+		var reason string
+		var description *string
+		var bannedAt time.Time
+		var unbannedAt time.Time
+		var bannedByID *string
+		var bannedByUsername *string
+		var bannedByEmail *string
+
+		dest := []any{&userID, &reason, &description, &bannedAt, &unbannedAt}
+		if q.Detailed {
+			dest = append(dest, &bannedByID, &bannedByUsername, &bannedByEmail)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		ban := Ban{
+			UserID:      userID,
+			Reason:      reason,
+			Description: description,
+			BannedAt:    bannedAt,
+			UnbannedAt:  unbannedAt,
+		}
+		if q.Detailed {
+			ban.Meta = &BanMeta{
+				BannedByID:       bannedByID,
+				BannedByUsername: bannedByUsername,
+				BannedByEmail:    bannedByEmail,
+			}
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+func (r pgBanStore) Count(ctx context.Context, q BanQuery, p ListParams) (int64, bool, error) {
+	q.Detailed = false
+	sql, args := r.query(q, p).MustBuild()
+	filtered := q.UserID != "" || !q.IncludeExpired || len(p.Filters) != 0
+	return sqlutil.Count(ctx, r.s.db, "auth.bans", filtered, sql, args...)
+}
+
+func (r pgBanStore) Active(ctx context.Context, userID string, now time.Time) (bool, error) {
+	q := psql.Select(
+		sm.From("auth.bans"),
+		sm.Columns("user_id"),
+		psql.WhereAnd(
+			sm.Where(psql.Quote("user_id").EQ(psql.Arg(userID))),
+			psql.WhereOr(
+				sm.Where(psql.Quote("unbanned_at").GT(psql.Arg(now))),
+				sm.Where(psql.Quote("banned_at").EQ(psql.Quote("unbanned_at"))),
+			),
+		),
+	)
+	q.Apply(r.s.lock()...)
+	sql, args := q.MustBuild()
+
+	var banned bool
+	if err := r.s.db.QueryRow(ctx, sqlutil.Exists(sql), args...).Scan(&banned); err != nil {
+		return false, err
+	}
+	return banned, nil
+}
+
+func (r pgBanStore) Put(ctx context.Context, ban Ban, by *string) error {
+	sql, args := psql.Insert(
+		im.Into("auth.bans", "user_id", "reason", "description", "banned_by", "banned_at", "unbanned_at"),
+		im.Values(psql.Arg(ban.UserID, ban.Reason, ban.Description, by, ban.BannedAt, ban.UnbannedAt)),
+		im.OnConflict("user_id").DoUpdate().SetExcluded("reason", "description", "banned_by", "banned_at", "unbanned_at"),
+	).MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{constraintBanUser: ErrUserNotFound}, sql, args...)
+	return err
+}
+
+func (r pgBanStore) Delete(ctx context.Context, userID string) error {
+	sql, args := psql.Delete(
+		dm.From("auth.bans"),
+		dm.Where(psql.Quote("user_id").EQ(psql.Arg(userID))),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrBanNotFound
+	}
+	return nil
+}
+
+func (r pgBanStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	sql, args := psql.Delete(
+		dm.From("auth.bans"),
+		dm.Where(psql.Quote("unbanned_at").LTE(psql.Arg(before))),
+		dm.Where(psql.Quote("banned_at").NE(psql.Quote("unbanned_at"))),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+type pgOutboxStore struct{ s *postgresStore }
+
+func (r pgOutboxStore) Add(ctx context.Context, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	q := psql.Insert(
+		im.Into("auth.outbox",
+			"id", "type", "aggregate_id", "actor_id", "payload", "created_at", "next_attempt_at",
+		),
+	)
+	for _, e := range events {
+		q.Apply(im.Values(psql.Arg(e.ID, e.Type, e.AggregateID, e.ActorID, e.Payload, e.CreatedAt, e.CreatedAt)))
+	}
+	sql, args := q.MustBuild()
+
+	_, err := r.s.db.Exec(ctx, sql, args...)
+	return err
+}
+
+// Claim skips the events locked by concurrent claims, which are leased when
+// these commit.
+func (r pgOutboxStore) Claim(ctx context.Context, limit int, now, until time.Time) ([]Event, error) {
+	q := `
+	WITH claimed AS (
+		UPDATE auth.outbox SET locked_until = $1
+		WHERE seq IN (
+			SELECT o.seq FROM auth.outbox o
+			WHERE o.state = 'pending'
+			AND o.next_attempt_at <= $2
+			AND (o.locked_until IS NULL OR o.locked_until <= $2)
+			AND NOT EXISTS (
+				SELECT 1 FROM auth.outbox p
+				WHERE p.aggregate_id = o.aggregate_id AND p.state = 'pending' AND p.seq < o.seq
+			)
+			ORDER BY o.seq
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING seq, id, type, aggregate_id, actor_id, payload, created_at, attempts
+	)
+	SELECT id, type, aggregate_id, actor_id, payload, created_at, attempts FROM claimed
+	ORDER BY seq;`
+
+	rows, err := r.s.db.Query(postgres.ReadYourWrites(ctx), q, until, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(
+			&e.ID,
+			&e.Type,
+			&e.AggregateID,
+			&e.ActorID,
+			&e.Payload,
+			&e.CreatedAt,
+			&e.Attempts,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (r pgOutboxStore) Delivered(ctx context.Context, id string, at time.Time) error {
+	sql, args := psql.Update(
+		um.Table("auth.outbox"),
+		um.Set("state").ToArg(eventDelivered),
+		um.Set("delivered_at").ToArg(at),
+		um.Set("locked_until").ToArg(nil),
+		um.Where(psql.Quote("id").EQ(psql.Arg(id))),
+	).MustBuild()
+
+	return r.update(ctx, sql, args...)
+}
+
+func (r pgOutboxStore) Failed(ctx context.Context, id, reason string, next *time.Time) error {
+	q := psql.Update(
+		um.Table("auth.outbox"),
+		um.Set("attempts").To(psql.Raw("attempts + 1")),
+		um.Set("last_error").ToArg(reason),
+		um.Set("locked_until").ToArg(nil),
+		um.Where(psql.Quote("id").EQ(psql.Arg(id))),
+	)
+	if next == nil {
+		q.Apply(um.Set("state").ToArg(eventDead))
+	} else {
+		q.Apply(um.Set("next_attempt_at").ToArg(*next))
+	}
+	sql, args := q.MustBuild()
+
+	return r.update(ctx, sql, args...)
+}
+
+func (r pgOutboxStore) update(ctx context.Context, sql string, args ...any) error {
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrEventNotFound
+	}
+	return nil
+}
+
+func (r pgOutboxStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	sql, args := psql.Delete(
+		dm.From("auth.outbox"),
+		dm.Where(psql.Quote("state").EQ(psql.Arg(eventDelivered))),
+		dm.Where(psql.Quote("delivered_at").LT(psql.Arg(before))),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+type pgWebhookStore struct{ s *postgresStore }
+
+func (r pgWebhookStore) query(q WebhookQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := psql.Select(
+		sm.Columns(
+			"id",
+			"url",
+			"events",
+			"description",
+			"enabled",
+			"failures",
+			"created_at",
+			"updated_at",
+			"disabled_at",
+		),
+		sm.From("auth.webhooks"),
+	)
+	if q.ID != "" {
+		query.Apply(
+			sm.Where(psql.Quote("id").EQ(psql.Arg(q.ID))),
+		)
+	}
+	if q.Enabled != nil {
+		query.Apply(
+			sm.Where(psql.Quote("enabled").EQ(psql.Arg(*q.Enabled))),
+		)
+	}
+	query.Apply(whereMods(webhookFields, p.Filters)...)
+	return query
+}
+
+func (r pgWebhookStore) List(ctx context.Context, q WebhookQuery, p ListParams) ([]Webhook, error) {
+	query := r.query(q, p)
+	query.Apply(pageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		if err := rows.Scan(
+			&w.ID,
+			&w.URL,
+			&w.Events,
+			&w.Description,
+			&w.Enabled,
+			&w.Failures,
+			&w.CreatedAt,
+			&w.UpdatedAt,
+			&w.DisabledAt,
+		); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func (r pgWebhookStore) Count(ctx context.Context, q WebhookQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, p).MustBuild()
+	filtered := q.ID != "" || q.Enabled != nil || len(p.Filters) != 0
+	return sqlutil.Count(ctx, r.s.db, "auth.webhooks", filtered, sql, args...)
+}
+
+func (r pgWebhookStore) Get(ctx context.Context, id string) (Webhook, error) {
+	q := psql.Select(
+		sm.Columns(
+			"url",
+			"secret",
+			"events",
+			"description",
+			"enabled",
+			"failures",
+			"created_at",
+			"updated_at",
+			"disabled_at",
+		),
+		sm.From("auth.webhooks"),
+		sm.Where(psql.Quote("id").EQ(psql.Arg(id))),
+	)
+	q.Apply(r.s.lock()...)
+	sql, args := q.MustBuild()
+
+	w := Webhook{ID: id}
+	if err := r.s.db.QueryRow(ctx, sql, args...).Scan(
+		&w.URL,
+		&w.Secret,
+		&w.Events,
+		&w.Description,
+		&w.Enabled,
+		&w.Failures,
+		&w.CreatedAt,
+		&w.UpdatedAt,
+		&w.DisabledAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Webhook{}, ErrWebhookNotFound
+		}
+		return Webhook{}, fmt.Errorf("auth.WebhookStore.Get: %w", err)
+	}
+	return w, nil
+}
+
+func (r pgWebhookStore) Create(ctx context.Context, w Webhook) error {
+	sql, args := psql.Insert(
+		im.Into("auth.webhooks",
+			"id", "url", "secret", "events", "description", "enabled", "created_at",
+		),
+		im.Values(psql.Arg(w.ID, w.URL, w.Secret, w.Events, w.Description, w.Enabled, w.CreatedAt)),
+	).MustBuild()
+
+	_, err := r.s.db.Exec(ctx, sql, args...)
+	return err
+}
+
+func (r pgWebhookStore) Update(ctx context.Context, id string, in WebhookUpdate, at time.Time) error {
+	q := psql.Update(
+		um.Table("auth.webhooks"),
+		um.Set("updated_at").ToArg(at),
+		um.Where(psql.Quote("id").EQ(psql.Arg(id))),
+	)
+	if in.URL != nil {
+		q.Apply(um.Set("url").ToArg(*in.URL))
+	}
+	if in.Secret != nil {
+		q.Apply(um.Set("secret").ToArg(*in.Secret))
+	}
+	if in.Events != nil {
+		q.Apply(um.Set("events").ToArg(in.Events))
+	}
+	if in.Description != nil {
+		q.Apply(um.Set("description").ToArg(*in.Description))
+	}
+	if in.Enabled != nil {
+		var disabledAt *time.Time
+		if !*in.Enabled {
+			disabledAt = &at
+		}
+		// The failures and disabled_at only change with the state.
+		q.Apply(
+			um.Set("failures").To(psql.Raw("CASE WHEN enabled = ? THEN failures ELSE 0 END", *in.Enabled)),
+			um.Set("disabled_at").To(psql.Raw("CASE WHEN enabled = ? THEN disabled_at ELSE ?::timestamptz END", *in.Enabled, disabledAt)),
+			um.Set("enabled").ToArg(*in.Enabled),
+		)
+	}
+	sql, args := q.MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (r pgWebhookStore) Delete(ctx context.Context, ids []string) (int, error) {
+	sql, args := psql.Delete(
+		dm.From("auth.webhooks"),
+		dm.Where(psql.Quote("id").In(psql.Arg(sqlutil.AnySlice(ids)...))),
+	).MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.RowsAffected()), nil
+}
+
+func (r pgWebhookStore) Subscribers(ctx context.Context, typ string) ([]string, error) {
+	sql, args := psql.Select(
+		sm.Columns("id"),
+		sm.From("auth.webhooks"),
+		sm.Where(psql.Quote("enabled")),
+		sm.Where(psql.Raw("events && ?", []string{typ, WebhookAllEvents})),
+		sm.OrderBy("id"),
+	).MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (r pgWebhookStore) Report(ctx context.Context, id string, ok bool, at time.Time, maxFailures int) (bool, error) {
+	if ok {
+		sql, args := psql.Update(
+			um.Table("auth.webhooks"),
+			um.Set("failures").ToArg(0),
+			um.Where(psql.Quote("id").EQ(psql.Arg(id))),
+		).MustBuild()
+
+		res, err := r.s.db.Exec(ctx, sql, args...)
+		if err != nil {
+			return false, err
+		}
+		if res.RowsAffected() == 0 {
+			return false, ErrWebhookNotFound
+		}
+		return false, nil
+	}
+
+	// RETURNING sees the updated row, so the previous state is selected
+	// separately.
+	q := `
+	UPDATE auth.webhooks w SET
+		failures = w.failures + 1,
+		enabled = w.enabled AND w.failures + 1 < $2,
+		disabled_at = CASE WHEN w.enabled AND w.failures + 1 >= $2 THEN $3 ELSE w.disabled_at END
+	FROM (SELECT id, enabled FROM auth.webhooks WHERE id = $1 FOR UPDATE) prev
+	WHERE w.id = prev.id
+	RETURNING prev.enabled AND NOT w.enabled;`
+
+	var disabled bool
+	if err := r.s.db.QueryRow(postgres.ReadYourWrites(ctx), q, id, maxFailures, at).Scan(&disabled); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, ErrWebhookNotFound
+		}
+		return false, err
+	}
+	return disabled, nil
+}
+
+type pgDeliveryStore struct{ s *postgresStore }
+
+func (r pgDeliveryStore) query(q WebhookDeliveryQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := psql.Select(
+		sm.Columns(
+			"id",
+			"webhook_id",
+			"event_id",
+			"event_type",
+			"state",
+			"attempts",
+			"created_at",
+			"next_attempt_at",
+			"last_attempt_at",
+			"status_code",
+			"error",
+			"duration_ms",
+			"delivered_at",
+		),
+		sm.From("auth.webhook_deliveries"),
+		sm.Where(psql.Quote("webhook_id").EQ(psql.Arg(q.WebhookID))),
+	)
+	if q.ID != "" {
+		query.Apply(
+			sm.Where(psql.Quote("id").EQ(psql.Arg(q.ID))),
+		)
+	}
+	if q.State != "" {
+		query.Apply(
+			sm.Where(psql.Quote("state").EQ(psql.Arg(q.State))),
+		)
+	}
+	query.Apply(whereMods(deliveryFields, p.Filters)...)
+	return query
+}
+
+func (r pgDeliveryStore) List(ctx context.Context, q WebhookDeliveryQuery, p ListParams) ([]WebhookDelivery, error) {
+	query := r.query(q, p)
+	query.Apply(pageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(
+			&d.ID,
+			&d.WebhookID,
+			&d.EventID,
+			&d.EventType,
+			&d.State,
+			&d.Attempts,
+			&d.CreatedAt,
+			&d.NextAttemptAt,
+			&d.LastAttemptAt,
+			&d.StatusCode,
+			&d.Error,
+			&d.DurationMS,
+			&d.DeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r pgDeliveryStore) Count(ctx context.Context, q WebhookDeliveryQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, p).MustBuild()
+	return sqlutil.Count(ctx, r.s.db, "auth.webhook_deliveries", true, sql, args...)
+}
+
+func (r pgDeliveryStore) Add(ctx context.Context, deliveries ...WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	q := psql.Insert(
+		im.Into("auth.webhook_deliveries",
+			"id", "webhook_id", "event_id", "event_type", "payload", "created_at", "next_attempt_at",
+		),
+		im.OnConflict("webhook_id", "event_id").DoNothing(),
+	)
+	for _, d := range deliveries {
+		q.Apply(im.Values(psql.Arg(d.ID, d.WebhookID, d.EventID, d.EventType, d.Payload, d.CreatedAt, d.CreatedAt)))
+	}
+	sql, args := q.MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{constraintDeliveryWebhook: ErrWebhookNotFound}, sql, args...)
+	return err
+}
+
+// Claim skips the deliveries locked by concurrent claims, which are leased
+// when these commit.
+func (r pgDeliveryStore) Claim(ctx context.Context, limit int, now, until time.Time) ([]ClaimedDelivery, error) {
+	q := `
+	WITH claimed AS (
+		UPDATE auth.webhook_deliveries SET locked_until = $1
+		WHERE id IN (
+			SELECT d.id FROM auth.webhook_deliveries d
+			JOIN auth.webhooks w ON w.id = d.webhook_id
+			WHERE d.state = 'pending'
+			AND d.next_attempt_at <= $2
+			AND (d.locked_until IS NULL OR d.locked_until <= $2)
+			AND w.enabled
+			ORDER BY d.next_attempt_at, d.id
+			LIMIT $3
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		RETURNING id, webhook_id, event_id, event_type, payload, attempts, created_at, next_attempt_at
+	)
+	SELECT c.id, c.webhook_id, c.event_id, c.event_type, c.payload, c.attempts, c.created_at, c.next_attempt_at, w.url, w.secret
+	FROM claimed c
+	JOIN auth.webhooks w ON w.id = c.webhook_id
+	ORDER BY c.next_attempt_at, c.id;`
+
+	rows, err := r.s.db.Query(postgres.ReadYourWrites(ctx), q, until, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []ClaimedDelivery
+	for rows.Next() {
+		d := ClaimedDelivery{WebhookDelivery: WebhookDelivery{State: DeliveryPending}}
+		if err := rows.Scan(
+			&d.ID,
+			&d.WebhookID,
+			&d.EventID,
+			&d.EventType,
+			&d.Payload,
+			&d.Attempts,
+			&d.CreatedAt,
+			&d.NextAttemptAt,
+			&d.URL,
+			&d.Secret,
+		); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, d)
+	}
+	return claimed, rows.Err()
+}
+
+func (r pgDeliveryStore) Attempted(ctx context.Context, id string, a DeliveryAttempt, next *time.Time) error {
+	q := psql.Update(
+		um.Table("auth.webhook_deliveries"),
+		um.Set("attempts").To(psql.Raw("attempts + 1")),
+		um.Set("last_attempt_at").ToArg(a.At),
+		um.Set("status_code").ToArg(a.StatusCode),
+		um.Set("error").ToArg(a.Error),
+		um.Set("duration_ms").ToArg(a.Duration.Milliseconds()),
+		um.Set("locked_until").ToArg(nil),
+		um.Where(psql.Quote("id").EQ(psql.Arg(id))),
+	)
+	switch {
+	case a.OK:
+		q.Apply(
+			um.Set("state").ToArg(DeliveryDelivered),
+			um.Set("delivered_at").ToArg(a.At),
+			um.Set("next_attempt_at").ToArg(nil),
+		)
+	case next == nil:
+		q.Apply(
+			um.Set("state").ToArg(DeliveryFailed),
+			um.Set("next_attempt_at").ToArg(nil),
+		)
+	default:
+		q.Apply(um.Set("next_attempt_at").ToArg(*next))
+	}
+	sql, args := q.MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+func (r pgDeliveryStore) Redeliver(ctx context.Context, id string, now time.Time) (string, error) {
+	sql, args := psql.Update(
+		um.Table("auth.webhook_deliveries"),
+		um.Set("state").ToArg(DeliveryPending),
+		um.Set("attempts").ToArg(0),
+		um.Set("next_attempt_at").ToArg(now),
+		um.Set("locked_until").ToArg(nil),
+		um.Where(psql.Quote("id").EQ(psql.Arg(id))),
+		um.Returning("webhook_id"),
+	).MustBuild()
+
+	ids, err := r.s.returningIDs(ctx, sql, args...)
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", ErrDeliveryNotFound
+	}
+	return ids[0], nil
+}
+
+type pgEmailStore struct{ s *postgresStore }
+
+func (r pgEmailStore) query(q EmailQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := psql.Select(
+		sm.Columns(
+			"id",
+			"user_id",
+			"kind",
+			"recipient",
+			"subject",
+			"state",
+			"attempts",
+			"created_at",
+			"next_attempt_at",
+			"last_attempt_at",
+			"error",
+			"sent_at",
+		),
+		sm.From("auth.emails"),
+	)
+	if q.ID != "" {
+		query.Apply(
+			sm.Where(psql.Quote("id").EQ(psql.Arg(q.ID))),
+		)
+	}
+	if q.UserID != "" {
+		query.Apply(
+			sm.Where(psql.Quote("user_id").EQ(psql.Arg(q.UserID))),
+		)
+	}
+	if q.Kind != "" {
+		query.Apply(
+			sm.Where(psql.Quote("kind").EQ(psql.Arg(q.Kind))),
+		)
+	}
+	if q.State != "" {
+		query.Apply(
+			sm.Where(psql.Quote("state").EQ(psql.Arg(q.State))),
+		)
+	}
+	query.Apply(whereMods(emailFields, p.Filters)...)
+	return query
+}
+
+func (r pgEmailStore) List(ctx context.Context, q EmailQuery, p ListParams) ([]Email, error) {
+	query := r.query(q, p)
+	query.Apply(pageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []Email{}
+	for rows.Next() {
+		var e Email
+		if err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.Kind,
+			&e.To,
+			&e.Subject,
+			&e.State,
+			&e.Attempts,
+			&e.CreatedAt,
+			&e.NextAttemptAt,
+			&e.LastAttemptAt,
+			&e.Error,
+			&e.SentAt,
+		); err != nil {
+			return nil, err
+		}
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
+
+func (r pgEmailStore) Count(ctx context.Context, q EmailQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, p).MustBuild()
+	return sqlutil.Count(ctx, r.s.db, "auth.emails", true, sql, args...)
+}
+
+func (r pgEmailStore) Add(ctx context.Context, emails ...Email) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	q := psql.Insert(
+		im.Into("auth.emails",
+			"id", "user_id", "kind", "recipient", "subject", "text_body", "html_body", "created_at", "next_attempt_at",
+		),
+	)
+	for _, e := range emails {
+		q.Apply(im.Values(psql.Arg(e.ID, e.UserID, e.Kind, e.To, e.Subject, e.Text, e.HTML, e.CreatedAt, e.CreatedAt)))
+	}
+	sql, args := q.MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{constraintEmailUser: ErrUserNotFound}, sql, args...)
+	return err
+}
+
+// Claim skips the emails locked by concurrent claims, which are leased when
+// these commit.
+func (r pgEmailStore) Claim(ctx context.Context, limit int, now, until time.Time) ([]Email, error) {
+	q := `
+	UPDATE auth.emails SET locked_until = $1
+	WHERE id IN (
+		SELECT id FROM auth.emails
+		WHERE state = 'pending'
+		AND next_attempt_at <= $2
+		AND (locked_until IS NULL OR locked_until <= $2)
+		ORDER BY next_attempt_at, id
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, user_id, kind, recipient, subject, text_body, html_body, attempts, created_at, next_attempt_at;`
+
+	rows, err := r.s.db.Query(postgres.ReadYourWrites(ctx), q, until, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []Email
+	for rows.Next() {
+		e := Email{State: EmailPending}
+		if err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.Kind,
+			&e.To,
+			&e.Subject,
+			&e.Text,
+			&e.HTML,
+			&e.Attempts,
+			&e.CreatedAt,
+			&e.NextAttemptAt,
+		); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not preserve the order of the subquery.
+	slices.SortFunc(claimed, compareEmailsDue)
+	return claimed, nil
+}
+
+func (r pgEmailStore) Attempted(ctx context.Context, id string, at time.Time, errMsg *string, next *time.Time) error {
+	q := psql.Update(
+		um.Table("auth.emails"),
+		um.Set("attempts").To(psql.Raw("attempts + 1")),
+		um.Set("last_attempt_at").ToArg(at),
+		um.Set("error").ToArg(errMsg),
+		um.Set("locked_until").ToArg(nil),
+		um.Where(psql.Quote("id").EQ(psql.Arg(id))),
+	)
+	switch {
+	case errMsg == nil:
+		q.Apply(
+			um.Set("state").ToArg(EmailSent),
+			um.Set("sent_at").ToArg(at),
+			um.Set("next_attempt_at").ToArg(nil),
+			um.Set("text_body").ToArg(""),
+			um.Set("html_body").ToArg(""),
+		)
+	case next == nil:
+		q.Apply(
+			um.Set("state").ToArg(EmailFailed),
+			um.Set("next_attempt_at").ToArg(nil),
+		)
+	default:
+		q.Apply(um.Set("next_attempt_at").ToArg(*next))
+	}
+	sql, args := q.MustBuild()
+
+	res, err := r.s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrEmailNotFound
+	}
+	return nil
+}
@@ -0,0 +1,2230 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	sqlitedb "glut/common/sqlite"
+	"glut/common/sqlutil"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stephenafamo/bob"
+	"github.com/stephenafamo/bob/dialect/sqlite"
+	"github.com/stephenafamo/bob/dialect/sqlite/dialect"
+	"github.com/stephenafamo/bob/dialect/sqlite/dm"
+	"github.com/stephenafamo/bob/dialect/sqlite/im"
+	"github.com/stephenafamo/bob/dialect/sqlite/sm"
+	"github.com/stephenafamo/bob/dialect/sqlite/um"
+	"github.com/stephenafamo/bob/mods"
+)
+
+// Unique constraints of the SQLite schema mapped to errors. SQLite names them
+// by their columns.
+const (
+	sqliteConstraintUsername       = "auth_users.username"
+	sqliteConstraintRoleName       = "auth_roles.name"
+	sqliteConstraintPermissionName = "auth_permissions.name"
+)
+
+// sqliteDB is the database or transaction of a sqliteStore.
+type sqliteDB interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqliteStore is a Store on the auth tables of db/migrations/sqlite. SQLite
+// has no row locks; instead, transactions lock the database from their
+// start, as set up by sqlite.Open.
+type sqliteStore struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewSQLiteStore returns a Store on the auth tables of db, which must be
+// opened with sqlite.Open.
+func NewSQLiteStore(db *sql.DB) Store {
+	return &sqliteStore{db: db}
+}
+
+// Tx ignores opts: SQLite transactions are serializable and wait for each
+// other instead of failing, so they are never retried.
+func (s *sqliteStore) Tx(ctx context.Context, opts sqlutil.TxOptions, fn func(tx Store) error) error {
+	return s.withTx(ctx, func(tx *sqliteStore) error {
+		return fn(tx)
+	})
+}
+
+// withTx runs fn in a transaction, or in a savepoint if the store runs in
+// one.
+func (s *sqliteStore) withTx(ctx context.Context, fn func(tx *sqliteStore) error) error {
+	if s.tx != nil {
+		if _, err := s.tx.ExecContext(ctx, "SAVEPOINT tx"); err != nil {
+			return err
+		}
+		if err := fn(s); err != nil {
+			s.tx.ExecContext(ctx, "ROLLBACK TO tx")
+			s.tx.ExecContext(ctx, "RELEASE tx")
+			return err
+		}
+		_, err := s.tx.ExecContext(ctx, "RELEASE tx")
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&sqliteStore{db: s.db, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Users() UserStore             { return sqliteUserStore{s} }
+func (s *sqliteStore) Sessions() SessionStore       { return sqliteSessionStore{s} }
+func (s *sqliteStore) Tokens() TokenStore           { return sqliteTokenStore{s} }
+func (s *sqliteStore) Roles() RoleStore             { return sqliteRoleStore{s} }
+func (s *sqliteStore) Permissions() PermissionStore { return sqlitePermissionStore{s} }
+func (s *sqliteStore) UserRoles() UserRoleStore     { return sqliteUserRoleStore{s} }
+func (s *sqliteStore) RolePermissions() RolePermissionStore {
+	return sqliteRolePermissionStore{s}
+}
+func (s *sqliteStore) Bans() BanStore         { return sqliteBanStore{s} }
+func (s *sqliteStore) Outbox() OutboxStore    { return sqliteOutboxStore{s} }
+func (s *sqliteStore) Webhooks() WebhookStore { return sqliteWebhookStore{s} }
+func (s *sqliteStore) WebhookDeliveries() WebhookDeliveryStore {
+	return sqliteDeliveryStore{s}
+}
+func (s *sqliteStore) Emails() EmailStore { return sqliteEmailStore{s} }
+
+// conn returns the transaction of the store, or its database.
+func (s *sqliteStore) conn() sqliteDB {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// exec executes query and returns the number of affected rows, or the error
+// mapped to the constraint it violates, if any.
+func (s *sqliteStore) exec(ctx context.Context, violations map[string]error, query string, args ...any) (int64, error) {
+	res, err := s.conn().ExecContext(ctx, query, sqlitedb.Args(args)...)
+	if err != nil {
+		if mapped, ok := violations[sqlitedb.Constraint(err)]; ok {
+			return 0, mapped
+		}
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqliteStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.conn().QueryContext(ctx, query, sqlitedb.Args(args)...)
+}
+
+func (s *sqliteStore) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return s.conn().QueryRowContext(ctx, query, sqlitedb.Args(args)...)
+}
+
+// returningIDs runs query, a statement returning ids, and returns the ids.
+func (s *sqliteStore) returningIDs(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// count returns the number of rows selected by query. SQLite tables are
+// always counted exactly.
+func (s *sqliteStore) count(ctx context.Context, query string, args ...any) (int64, bool, error) {
+	var n int64
+	if err := s.queryRow(ctx, "SELECT count(*) FROM ("+query+") AS t", args...).Scan(&n); err != nil {
+		return 0, false, err
+	}
+	return n, false, nil
+}
+
+// grants returns the role grants selected by query, whose columns are the id
+// and name of the role and of the permission.
+func (s *sqliteStore) grants(ctx context.Context, query string, args ...any) ([]RoleGrant, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []RoleGrant{}
+	for rows.Next() {
+		var g RoleGrant
+		if err := rows.Scan(&g.RoleID, &g.RoleName, &g.PermissionID, &g.PermissionName); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// sqlitePageMods returns the mods ordering and limiting the rows of a list
+// query.
+func sqlitePageMods(p ListParams) []bob.Mod[*dialect.SelectQuery] {
+	var qm []bob.Mod[*dialect.SelectQuery]
+	reverse := false
+	if p.Paginated && p.Cursor != nil {
+		qm = append(qm, sm.Where(p.Keyset.Condition(p.Cursor)))
+		reverse = p.Cursor.Before
+	}
+	for _, def := range p.Keyset.Order(reverse) {
+		qm = append(qm, mods.OrderBy[*dialect.SelectQuery](def))
+	}
+	qm = append(qm, sm.Limit(p.Limit+1))
+	if !p.Paginated && p.Offset != 0 {
+		qm = append(qm, sm.Offset(p.Offset))
+	}
+	return qm
+}
+
+// sqliteWhereMods returns the mods applying validated filters.
+func sqliteWhereMods(fields sqlutil.Fields, filters []sqlutil.Filter) []bob.Mod[*dialect.SelectQuery] {
+	conds, _ := fields.Conditions(filters)
+	qm := make([]bob.Mod[*dialect.SelectQuery], len(conds))
+	for i, cond := range conds {
+		qm[i] = sm.Where(cond)
+	}
+	return qm
+}
+
+// sqliteILike returns the case insensitive LIKE condition on column.
+func sqliteILike(column, pattern string) bob.Expression {
+	return sqlite.Raw("lower("+column+") LIKE lower(?)", pattern)
+}
+
+// jsonMeta is token meta stored as a JSON object.
+type jsonMeta map[string]*string
+
+func (m *jsonMeta) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case []byte:
+		return json.Unmarshal(v, m)
+	}
+	return fmt.Errorf("unsupported meta type %T", src)
+}
+
+func (m jsonMeta) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+// jsonStrings is a list of strings stored as a JSON array.
+type jsonStrings []string
+
+func (l *jsonStrings) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	}
+	return fmt.Errorf("unsupported list type %T", src)
+}
+
+func (l jsonStrings) Value() (driver.Value, error) {
+	if l == nil {
+		l = jsonStrings{}
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+type sqliteUserStore struct{ s *sqliteStore }
+
+func (r sqliteUserStore) query(q UserQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := sqlite.Select(
+		sm.Columns(
+			"id",
+			"username",
+			"email",
+			"created_at",
+			"last_login_at",
+			"last_login_ip",
+		),
+		sm.From("auth_users"),
+	)
+	if q.ID != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("id").EQ(sqlite.Arg(q.ID))),
+		)
+	}
+	if q.Email != "" {
+		query.Apply(
+			sm.Where(sqliteILike("email", sqlutil.Like(q.Email))),
+		)
+	}
+	if q.Username != "" {
+		query.Apply(
+			sm.Where(sqliteILike("username", sqlutil.Like(q.Username))),
+		)
+	}
+	query.Apply(sqliteWhereMods(userFields, p.Filters)...)
+	return query
+}
+
+func (r sqliteUserStore) List(ctx context.Context, q UserQuery, p ListParams) ([]User, error) {
+	query := r.query(q, p)
+	query.Apply(sqlitePageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(
+			&u.ID,
+			&u.Username,
+			&u.Email,
+			&u.CreatedAt,
+			&u.LastLoginAt,
+			&u.LastLoginIP,
+		); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (r sqliteUserStore) Count(ctx context.Context, q UserQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, p).MustBuild()
+	return r.s.count(ctx, sql, args...)
+}
+
+func (r sqliteUserStore) get(ctx context.Context, column, value string) (User, error) {
+	query, args := sqlite.Select(
+		sm.From("auth_users"),
+		sm.Columns("id", "username", "email", "password_hash", "created_at", "verified_at", "last_login_at", "last_login_ip"),
+		sm.Where(sqlite.Quote(column).EQ(sqlite.Arg(value))),
+	).MustBuild()
+
+	var u User
+	if err := r.s.queryRow(ctx, query, args...).Scan(
+		&u.ID,
+		&u.Username,
+		&u.Email,
+		&u.Password,
+		&u.CreatedAt,
+		&u.VerifiedAt,
+		&u.LastLoginAt,
+		&u.LastLoginIP,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (r sqliteUserStore) Get(ctx context.Context, id string) (User, error) {
+	return r.get(ctx, "id", id)
+}
+
+func (r sqliteUserStore) GetByUsername(ctx context.Context, username string) (User, error) {
+	return r.get(ctx, "username", username)
+}
+
+func (r sqliteUserStore) UsernamesByEmail(ctx context.Context, email string) ([]string, error) {
+	sql, args := sqlite.Select(
+		sm.From("auth_users"),
+		sm.Columns("username"),
+		sm.Where(sqlite.Quote("email").EQ(sqlite.Arg(email))),
+		sm.OrderBy("username"),
+	).MustBuild()
+
+	rows, err := r.s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usernames []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, rows.Err()
+}
+
+func (r sqliteUserStore) Create(ctx context.Context, u User) error {
+	sql, args := sqlite.Insert(
+		im.Into("auth_users",
+			"id", "username", "email", "password_hash", "created_at",
+		),
+		im.Values(sqlite.Arg(u.ID, u.Username, u.Email, u.Password, u.CreatedAt)),
+	).MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{sqliteConstraintUsername: ErrUserExists}, sql, args...)
+	return err
+}
+
+func (r sqliteUserStore) Update(ctx context.Context, id string, in UserUpdate) error {
+	q := sqlite.Update(
+		um.Table("auth_users"),
+		um.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	)
+	set := false
+	// The columns are set in a fixed order so that updates of the same
+	// columns build the same statement.
+	for _, c := range []struct {
+		column string
+		value  any
+		isSet  bool
+	}{
+		{"email", in.Email, in.Email != nil},
+		{"password_hash", in.PasswordHash, in.PasswordHash != nil},
+		{"verified_at", in.VerifiedAt, in.VerifiedAt != nil},
+		{"last_login_at", in.LastLoginAt, in.LastLoginAt != nil},
+		{"last_login_ip", in.LastLoginIP, in.LastLoginIP != nil},
+	} {
+		if !c.isSet {
+			continue
+		}
+		q.Apply(um.Set(c.column).ToArg(c.value))
+		set = true
+	}
+	if !set {
+		return nil
+	}
+	sql, args := q.MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	if err != nil {
+		return fmt.Errorf("auth.UserStore.Update: %w", err)
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r sqliteUserStore) Delete(ctx context.Context, ids []string) ([]string, error) {
+	sql, args := sqlite.Delete(
+		dm.From("auth_users"),
+		dm.Where(sqlite.Quote("id").In(sqlite.Arg(sqlutil.AnySlice(ids)...))),
+		dm.Returning("id"),
+	).MustBuild()
+
+	return r.s.returningIDs(ctx, sql, args...)
+}
+
+type sqliteSessionStore struct{ s *sqliteStore }
+
+func (r sqliteSessionStore) query(q SessionQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := sqlite.Select(
+		sm.Columns(
+			"id",
+			"token",
+			"user_id",
+			"user_ip",
+			"created_at",
+			"expires_at",
+		),
+		sm.From("auth_sessions"),
+	)
+	if q.ID != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("id").EQ(sqlite.Arg(q.ID))),
+		)
+	}
+	if q.UserID != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("user_id").EQ(sqlite.Arg(q.UserID))),
+		)
+	}
+	if !q.IncludeExpired {
+		query.Apply(
+			sm.Where(sqlite.Quote("expires_at").GT(sqlite.Arg(p.Now))),
+		)
+	}
+	query.Apply(sqliteWhereMods(sessionFields, p.Filters)...)
+	return query
+}
+
+func (r sqliteSessionStore) List(ctx context.Context, q SessionQuery, p ListParams) ([]Session, error) {
+	query := r.query(q, p)
+	query.Apply(sqlitePageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(
+			&sess.ID,
+			&sess.Token,
+			&sess.UserID,
+			&sess.UserIP,
+			&sess.CreatedAt,
+			&sess.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+func (r sqliteSessionStore) Count(ctx context.Context, q SessionQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, p).MustBuild()
+	return r.s.count(ctx, sql, args...)
+}
+
+func (r sqliteSessionStore) Authenticate(ctx context.Context, token string, now time.Time) (Session, error) {
+	query, args := sqlite.Select(
+		sm.From("auth_sessions"),
+		sm.Columns("id", "token", "user_id", "user_ip", "created_at", "expires_at"),
+		sqlite.WhereAnd(
+			sm.Where(sqlite.Quote("token").EQ(sqlite.Arg(token))),
+			sm.Where(sqlite.Quote("expires_at").GT(sqlite.Arg(now))),
+		),
+	).MustBuild()
+
+	var sess Session
+	if err := r.s.queryRow(ctx, query, args...).Scan(
+		&sess.ID,
+		&sess.Token,
+		&sess.UserID,
+		&sess.UserIP,
+		&sess.CreatedAt,
+		&sess.ExpiresAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Session{}, ErrSessionNotFound
+		}
+		return Session{}, err
+	}
+	return sess, nil
+}
+
+// Create numbers the session with the lowest number the user does not use.
+// Concurrent logins cannot pick the same number since their transactions
+// lock the database.
+func (r sqliteSessionStore) Create(ctx context.Context, sess Session, max int) error {
+	return r.s.withTx(ctx, func(tx *sqliteStore) error {
+		rows, err := tx.query(ctx, `SELECT session_number FROM auth_sessions WHERE user_id = ?;`, sess.UserID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		used := make(map[int]bool)
+		for rows.Next() {
+			var n int
+			if err := rows.Scan(&n); err != nil {
+				return err
+			}
+			used[n] = true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		nextSessNum := 0
+		for n := 1; n <= max && len(used) < max; n++ {
+			if !used[n] {
+				nextSessNum = n
+				break
+			}
+		}
+		if nextSessNum == 0 {
+			return ErrSessionLimit
+		}
+
+		sql, args := sqlite.Insert(
+			im.Into("auth_sessions",
+				"id", "token", "user_id", "user_ip", "session_number", "created_at", "expires_at",
+			),
+			im.Values(sqlite.Arg(sess.ID, sess.Token, sess.UserID, sess.UserIP, nextSessNum, sess.CreatedAt, sess.ExpiresAt)),
+		).MustBuild()
+
+		_, err = tx.exec(ctx, nil, sql, args...)
+		return err
+	})
+}
+
+func (r sqliteSessionStore) Renew(ctx context.Context, id string, expiresAt time.Time) error {
+	sql, args := sqlite.Update(
+		um.Table("auth_sessions"),
+		um.Set("expires_at").ToArg(expiresAt),
+		um.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (r sqliteSessionStore) ActivateRoles(ctx context.Context, id string, roleIDs []string) (int, error) {
+	query, args := sqlite.Select(
+		sm.From("auth_sessions"),
+		sm.Columns("user_id"),
+		sm.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	).MustBuild()
+
+	var userID string
+	if err := r.s.queryRow(ctx, query, args...).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrSessionNotFound
+		}
+		return 0, err
+	}
+
+	// The WHERE clause of the select resolves the ambiguity of ON in
+	// INSERT ... SELECT ... ON CONFLICT.
+	held := sqlite.Select(
+		sm.Columns("s.token", "ur.role_id"),
+		sm.From("auth_sessions").As("s"),
+		sm.InnerJoin("auth_user_roles").As("ur").OnEQ(
+			sqlite.Raw("ur.user_id"), sqlite.Raw("s.user_id"),
+		),
+		sm.Where(sqlite.Quote("s", "id").EQ(sqlite.Arg(id))),
+	)
+	if roleIDs != nil {
+		held.Apply(
+			sm.Where(sqlite.Quote("ur", "role_id").In(sqlite.Arg(sqlutil.AnySlice(roleIDs)...))),
+		)
+		query, args := sqlite.Select(
+			sm.From("auth_user_roles"),
+			sm.Columns(sqlite.Raw("count(*)")),
+			sm.Where(sqlite.Quote("user_id").EQ(sqlite.Arg(userID))),
+			sm.Where(sqlite.Quote("role_id").In(sqlite.Arg(sqlutil.AnySlice(roleIDs)...))),
+		).MustBuild()
+
+		var n int
+		if err := r.s.queryRow(ctx, query, args...).Scan(&n); err != nil {
+			return 0, err
+		}
+		if n != len(roleIDs) {
+			return 0, ErrRoleNotFound
+		}
+	}
+
+	query, args = sqlite.Insert(
+		im.Into("auth_session_roles", "session_id", "role_id"),
+		im.Query(held),
+		im.OnConflict("session_id", "role_id").DoNothing(),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, query, args...)
+	return int(n), err
+}
+
+func (r sqliteSessionStore) DropRoles(ctx context.Context, id string, roleIDs []string) (int, error) {
+	query, args := sqlite.Delete(
+		dm.From("auth_session_roles"),
+		dm.Where(sqlite.Raw("session_id = (SELECT token FROM auth_sessions WHERE id = ?)", id)),
+		dm.Where(sqlite.Quote("role_id").In(sqlite.Arg(sqlutil.AnySlice(roleIDs)...))),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, query, args...)
+	return int(n), err
+}
+
+func (r sqliteSessionStore) Grants(ctx context.Context, id string) ([]RoleGrant, error) {
+	query, args := sqlite.Select(
+		sm.Columns("r.id", "r.name", "p.id", "p.name"),
+		sm.From("auth_session_roles").As("sr"),
+		sm.InnerJoin("auth_sessions").As("s").OnEQ(
+			sqlite.Raw("s.token"), sqlite.Raw("sr.session_id"),
+		),
+		sm.InnerJoin("auth_user_roles").As("ur").On(
+			sqlite.Raw("ur.user_id = s.user_id"), sqlite.Raw("ur.role_id = sr.role_id"),
+		),
+		sm.InnerJoin("auth_roles").As("r").OnEQ(
+			sqlite.Raw("r.id"), sqlite.Raw("sr.role_id"),
+		),
+		sm.InnerJoin("auth_role_permissions").As("rp").OnEQ(
+			sqlite.Raw("rp.role_id"), sqlite.Raw("sr.role_id"),
+		),
+		sm.InnerJoin("auth_permissions").As("p").OnEQ(
+			sqlite.Raw("p.id"), sqlite.Raw("rp.permission_id"),
+		),
+		sm.Where(sqlite.Quote("s", "id").EQ(sqlite.Arg(id))),
+		sm.OrderBy("p.name"),
+		sm.OrderBy("r.name"),
+	).MustBuild()
+
+	return r.s.grants(ctx, query, args...)
+}
+
+func (r sqliteSessionStore) Delete(ctx context.Context, ids []string, userID string) (int, error) {
+	if len(ids) == 0 && userID == "" {
+		return 0, nil
+	}
+	q := sqlite.Delete(dm.From("auth_sessions"))
+	if ids != nil {
+		q.Apply(dm.Where(
+			sqlite.Quote("id").In(
+				sqlite.Arg(sqlutil.AnySlice(ids)...)),
+		))
+	}
+	if userID != "" {
+		q.Apply(dm.Where(
+			sqlite.Quote("user_id").EQ(sqlite.Arg(userID)),
+		))
+	}
+	sql, args := q.MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	return int(n), err
+}
+
+func (r sqliteSessionStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	sql, args := sqlite.Delete(
+		dm.From("auth_sessions"),
+		dm.Where(sqlite.Quote("expires_at").LTE(sqlite.Arg(before))),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	return int(n), err
+}
+
+type sqliteTokenStore struct{ s *sqliteStore }
+
+func (r sqliteTokenStore) Get(ctx context.Context, id, kind string, now time.Time) (Token, error) {
+	query, args := sqlite.Select(
+		sm.From("auth_tokens"),
+		sm.Columns("user_id", "meta", "created_at", "expires_at"),
+		sqlite.WhereAnd(
+			sm.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+			sm.Where(sqlite.Quote("kind").EQ(sqlite.Arg(kind))),
+			sm.Where(sqlite.Quote("expires_at").GT(sqlite.Arg(now))),
+		),
+	).MustBuild()
+
+	token := Token{ID: id, Kind: kind}
+	if err := r.s.queryRow(ctx, query, args...).Scan(&token.UserID, (*jsonMeta)(&token.Meta), &token.CreatedAt, &token.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Token{}, ErrInvalidToken
+		}
+		return Token{}, fmt.Errorf("auth.TokenStore.Get: %w", err)
+	}
+	return token, nil
+}
+
+func (r sqliteTokenStore) ByUser(ctx context.Context, userID, kind string) (Token, bool, error) {
+	query, args := sqlite.Select(
+		sm.From("auth_tokens"),
+		sm.Columns("id", "meta", "created_at", "expires_at"),
+		sqlite.WhereAnd(
+			sm.Where(sqlite.Quote("user_id").EQ(sqlite.Arg(userID))),
+			sm.Where(sqlite.Quote("kind").EQ(sqlite.Arg(kind))),
+		),
+	).MustBuild()
+
+	token := Token{UserID: userID, Kind: kind}
+	if err := r.s.queryRow(ctx, query, args...).Scan(&token.ID, (*jsonMeta)(&token.Meta), &token.CreatedAt, &token.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Token{}, false, nil
+		}
+		return Token{}, false, fmt.Errorf("auth.TokenStore.ByUser: %w", err)
+	}
+	return token, true, nil
+}
+
+func (r sqliteTokenStore) Save(ctx context.Context, token Token) error {
+	sql, args := sqlite.Insert(
+		im.Into("auth_tokens",
+			"id", "user_id", "kind", "created_at", "expires_at", "meta",
+		),
+		im.Values(sqlite.Arg(token.ID, token.UserID, token.Kind, token.CreatedAt, token.ExpiresAt, jsonMeta(token.Meta))),
+		im.OnConflict("user_id", "kind").DoUpdate().SetExcluded("id", "created_at", "expires_at", "meta"),
+	).MustBuild()
+
+	if _, err := r.s.exec(ctx, nil, sql, args...); err != nil {
+		return fmt.Errorf("auth.TokenStore.Save: %w", err)
+	}
+	return nil
+}
+
+func (r sqliteTokenStore) Delete(ctx context.Context, id string) error {
+	sql, args := sqlite.Delete(
+		dm.From("auth_tokens"),
+		dm.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	).MustBuild()
+
+	if _, err := r.s.exec(ctx, nil, sql, args...); err != nil {
+		return fmt.Errorf("auth.TokenStore.Delete: %w", err)
+	}
+	return nil
+}
+
+func (r sqliteTokenStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	sql, args := sqlite.Delete(
+		dm.From("auth_tokens"),
+		dm.Where(sqlite.Quote("expires_at").LTE(sqlite.Arg(before))),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	return int(n), err
+}
+
+// sqliteRBACQuery returns the query selecting roles or permissions from table
+// as r, joined with the users who created and updated them if detailed is
+// set.
+func sqliteRBACQuery(table, id, name string, detailed bool, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	cols := []any{"r.id", "r.name", "r.description", "r.created_at", "r.updated_at"}
+	if detailed {
+		cols = append(cols, "cb.id", "cb.username", "cb.email", "ub.id", "ub.username", "ub.email")
+	}
+	q := sqlite.Select(
+		sm.Columns(cols...),
+		sm.From(table).As("r"),
+	)
+	if detailed {
+		q.Apply(
+			sm.LeftJoin("auth_users").As("cb").OnEQ(
+				sqlite.Raw("r.created_by"), sqlite.Raw("cb.id"),
+			),
+			sm.LeftJoin("auth_users").As("ub").OnEQ(
+				sqlite.Raw("r.updated_by"), sqlite.Raw("ub.id"),
+			),
+		)
+	}
+	if id != "" {
+		q.Apply(
+			sm.Where(sqlite.Quote("r", "id").EQ(sqlite.Arg(id))),
+		)
+	}
+	if name != "" {
+		q.Apply(
+			sm.Where(sqlite.Quote("r", "name").EQ(sqlite.Arg(name))),
+		)
+	}
+	q.Apply(sqliteWhereMods(roleFields, p.Filters)...)
+	return q
+}
+
+// sqliteListRBAC runs a query of sqliteRBACQuery and returns its rows as
+// roles.
+func sqliteListRBAC(ctx context.Context, s *sqliteStore, q bob.BaseQuery[*dialect.SelectQuery], detailed bool, p ListParams) ([]Role, error) {
+	q.Apply(sqlitePageMods(p)...)
+	sql, args := q.MustBuild()
+
+	rows, err := s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		var meta RoleMeta
+		dest := []any{&role.ID, &role.Name, &role.Description, &role.CreatedAt, &role.UpdatedAt}
+		if detailed {
+			dest = append(dest,
+				&meta.CreatedByID, &meta.CreatedByUsername, &meta.CreatedByEmail,
+				&meta.UpdatedByID, &meta.UpdatedByUsername, &meta.UpdatedByEmail,
+			)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if detailed {
+			role.Meta = &meta
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+type sqliteRoleStore struct{ s *sqliteStore }
+
+func (r sqliteRoleStore) query(q RoleQuery, detailed bool, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := sqliteRBACQuery("auth_roles", q.ID, q.Name, detailed, p)
+	if q.PermissionID != "" {
+		query.Apply(
+			sm.Where(sqlite.Raw("r.id IN (SELECT role_id FROM auth_role_permissions WHERE permission_id = ?)", q.PermissionID)),
+		)
+	}
+	return query
+}
+
+func (r sqliteRoleStore) List(ctx context.Context, q RoleQuery, p ListParams) ([]Role, error) {
+	return sqliteListRBAC(ctx, r.s, r.query(q, q.Detailed, p), q.Detailed, p)
+}
+
+func (r sqliteRoleStore) Count(ctx context.Context, q RoleQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, false, p).MustBuild()
+	return r.s.count(ctx, sql, args...)
+}
+
+func (r sqliteRoleStore) Create(ctx context.Context, role Role, by *string) error {
+	sql, args := sqlite.Insert(
+		im.Into("auth_roles",
+			"id", "name", "description", "created_at", "created_by",
+		),
+		im.Values(sqlite.Arg(role.ID, role.Name, role.Description, role.CreatedAt, by)),
+	).MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{sqliteConstraintRoleName: ErrRoleExists}, sql, args...)
+	return err
+}
+
+// updateRBAC updates the name and description of the role or permission
+// in table, if they are not empty. It fails with notFound or, if the name
+// violates the unique constraint, with exists.
+func (s *sqliteStore) updateRBAC(ctx context.Context, table, constraint string, exists, notFound error, in UpdateRoleInput, at time.Time, by *string) error {
+	q := sqlite.Update(
+		um.Table(table),
+		um.Set("updated_at").ToArg(at),
+		um.Set("updated_by").ToArg(by),
+		um.Where(sqlite.Quote("id").EQ(sqlite.Arg(in.ID))),
+	)
+	if in.Name != "" {
+		q.Apply(
+			um.Set("name").ToArg(in.Name),
+		)
+	}
+	if in.Description != "" {
+		q.Apply(
+			um.Set("description").ToArg(in.Description),
+		)
+	}
+	sql, args := q.MustBuild()
+
+	n, err := s.exec(ctx, map[string]error{constraint: exists}, sql, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// deleteRBAC deletes the roles or permissions with ids from table and
+// returns the ids of the deleted rows.
+func (s *sqliteStore) deleteRBAC(ctx context.Context, table string, ids []string) ([]string, error) {
+	sql, args := sqlite.Delete(
+		dm.From(table),
+		dm.Where(
+			sqlite.Quote("id").In(
+				sqlite.Arg(sqlutil.AnySlice(ids)...),
+			),
+		),
+		dm.Returning("id"),
+	).MustBuild()
+
+	return s.returningIDs(ctx, sql, args...)
+}
+
+func (r sqliteRoleStore) Update(ctx context.Context, in UpdateRoleInput, at time.Time, by *string) error {
+	return r.s.updateRBAC(ctx, "auth_roles", sqliteConstraintRoleName, ErrRoleExists, ErrRoleNotFound, in, at, by)
+}
+
+func (r sqliteRoleStore) Delete(ctx context.Context, ids []string) ([]string, error) {
+	return r.s.deleteRBAC(ctx, "auth_roles", ids)
+}
+
+// exists reports whether the row with id in table exists.
+func (r sqliteRoleStore) exists(ctx context.Context, table, id string) (bool, error) {
+	sql, args := sqlite.Select(
+		sm.From(table),
+		sm.Columns("id"),
+		sm.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	).MustBuild()
+
+	var exists bool
+	err := r.s.queryRow(ctx, sqlutil.Exists(sql), args...).Scan(&exists)
+	return exists, err
+}
+
+// sqliteUserRef returns the id of the user with id, or NULL if there is none, to
+// record who made a change without failing on unknown users.
+func sqliteUserRef(id *string) bob.Expression {
+	return sqlite.Raw("(SELECT id FROM auth_users WHERE id = ?)", id)
+}
+
+// count returns the number of rows of table with ids.
+func (r sqliteRoleStore) count(ctx context.Context, table string, ids []string) (int, error) {
+	sql, args := sqlite.Select(
+		sm.From(table),
+		sm.Columns(sqlite.Raw("count(*)")),
+		sm.Where(sqlite.Quote("id").In(sqlite.Arg(sqlutil.AnySlice(ids)...))),
+	).MustBuild()
+
+	var n int
+	err := r.s.queryRow(ctx, sql, args...).Scan(&n)
+	return n, err
+}
+
+// link inserts the rows of table linking the row with id to each of ids,
+// skipping existing links. The checks of Grant and Assign run in the same
+// transaction as the insert.
+func (r sqliteRoleStore) link(ctx context.Context, table, column, id, linkColumn string, ids []string, at time.Time, by *string, check func(tx sqliteRoleStore) error) (int, error) {
+	var n int64
+	err := r.s.withTx(ctx, func(tx *sqliteStore) error {
+		if err := check(sqliteRoleStore{tx}); err != nil {
+			return err
+		}
+		q := sqlite.Insert(
+			im.Into(table, "id", column, linkColumn, "created_at", "created_by"),
+			im.OnConflict(column, linkColumn).DoNothing(),
+		)
+		for _, linkID := range ids {
+			q.Apply(
+				im.Values(sqlite.Arg(uuid.New().String(), id, linkID, at), sqliteUserRef(by)),
+			)
+		}
+		sql, args := q.MustBuild()
+
+		var err error
+		n, err = tx.exec(ctx, nil, sql, args...)
+		return err
+	})
+	return int(n), err
+}
+
+func (r sqliteRoleStore) Grant(ctx context.Context, roleID string, permissionIDs []string, at time.Time, by *string) (int, error) {
+	return r.link(ctx, "auth_role_permissions", "role_id", roleID, "permission_id", permissionIDs, at, by, func(tx sqliteRoleStore) error {
+		roleExists, err := tx.exists(ctx, "auth_roles", roleID)
+		if err != nil {
+			return err
+		}
+		if !roleExists {
+			return ErrRoleNotFound
+		}
+		permissionCount, err := tx.count(ctx, "auth_permissions", permissionIDs)
+		if err != nil {
+			return err
+		}
+		if permissionCount != len(permissionIDs) {
+			return ErrPermissionNotFound
+		}
+		return nil
+	})
+}
+
+func (r sqliteRoleStore) Revoke(ctx context.Context, roleID string, permissionIDs []string) (int, error) {
+	sql, args := sqlite.Delete(
+		dm.From("auth_role_permissions"),
+		dm.Where(sqlite.Quote("role_id").EQ(sqlite.Arg(roleID))),
+		dm.Where(sqlite.Quote("permission_id").In(sqlite.Arg(sqlutil.AnySlice(permissionIDs)...))),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	return int(n), err
+}
+
+func (r sqliteRoleStore) Assign(ctx context.Context, userID string, roleIDs []string, at time.Time, by *string) (int, error) {
+	return r.link(ctx, "auth_user_roles", "user_id", userID, "role_id", roleIDs, at, by, func(tx sqliteRoleStore) error {
+		userExists, err := tx.exists(ctx, "auth_users", userID)
+		if err != nil {
+			return err
+		}
+		if !userExists {
+			return ErrUserNotFound
+		}
+		roleCount, err := tx.count(ctx, "auth_roles", roleIDs)
+		if err != nil {
+			return err
+		}
+		if roleCount != len(roleIDs) {
+			return ErrRoleNotFound
+		}
+		return nil
+	})
+}
+
+func (r sqliteRoleStore) Unassign(ctx context.Context, userID string, roleIDs []string) (int, error) {
+	sql, args := sqlite.Delete(
+		dm.From("auth_user_roles"),
+		dm.Where(sqlite.Quote("user_id").EQ(sqlite.Arg(userID))),
+		dm.Where(sqlite.Quote("role_id").In(sqlite.Arg(sqlutil.AnySlice(roleIDs)...))),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	return int(n), err
+}
+
+type sqlitePermissionStore struct{ s *sqliteStore }
+
+func (r sqlitePermissionStore) query(q PermissionQuery, detailed bool, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := sqliteRBACQuery("auth_permissions", q.ID, q.Name, detailed, p)
+	if q.RoleID != "" {
+		query.Apply(
+			sm.Where(sqlite.Raw("r.id IN (SELECT permission_id FROM auth_role_permissions WHERE role_id = ?)", q.RoleID)),
+		)
+	}
+	return query
+}
+
+func (r sqlitePermissionStore) List(ctx context.Context, q PermissionQuery, p ListParams) ([]Permission, error) {
+	roles, err := sqliteListRBAC(ctx, r.s, r.query(q, q.Detailed, p), q.Detailed, p)
+	if err != nil {
+		return nil, err
+	}
+	permissions := make([]Permission, len(roles))
+	for i, role := range roles {
+		permissions[i] = Permission{
+			ID:          role.ID,
+			Name:        role.Name,
+			Description: role.Description,
+			CreatedAt:   role.CreatedAt,
+			UpdatedAt:   role.UpdatedAt,
+			Meta:        (*PermissionMeta)(role.Meta),
+		}
+	}
+	return permissions, nil
+}
+
+func (r sqlitePermissionStore) Count(ctx context.Context, q PermissionQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, false, p).MustBuild()
+	return r.s.count(ctx, sql, args...)
+}
+
+func (r sqlitePermissionStore) Existing(ctx context.Context, names []string) ([]string, error) {
+	sql, args := sqlite.Select(
+		sm.Columns("name"),
+		sm.From("auth_permissions"),
+		sm.Where(sqlite.Quote("name").In(sqlite.Arg(sqlutil.AnySlice(names)...))),
+		sm.OrderBy("name"),
+	).MustBuild()
+
+	return r.s.returningIDs(ctx, sql, args...)
+}
+
+func (r sqlitePermissionStore) Create(ctx context.Context, permission Permission, by *string) error {
+	sql, args := sqlite.Insert(
+		im.Into("auth_permissions",
+			"id", "name", "description", "created_at", "created_by",
+		),
+		im.Values(sqlite.Arg(permission.ID, permission.Name, permission.Description, permission.CreatedAt, by)),
+	).MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{sqliteConstraintPermissionName: ErrPermissionExists}, sql, args...)
+	return err
+}
+
+func (r sqlitePermissionStore) Update(ctx context.Context, in UpdatePermissionInput, at time.Time, by *string) error {
+	return r.s.updateRBAC(ctx, "auth_permissions", sqliteConstraintPermissionName, ErrPermissionExists, ErrPermissionNotFound, UpdateRoleInput(in), at, by)
+}
+
+func (r sqlitePermissionStore) Delete(ctx context.Context, ids []string) ([]string, error) {
+	return r.s.deleteRBAC(ctx, "auth_permissions", ids)
+}
+
+type sqliteUserRoleStore struct{ s *sqliteStore }
+
+func (r sqliteUserRoleStore) query(q UserRoleQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	cols := []any{"ur.id", "ur.user_id", "u.username", "u.email", "ur.role_id", "r.name", "ur.created_at"}
+	if q.Detailed {
+		cols = append(cols, "cb.id", "cb.username", "cb.email")
+	}
+	query := sqlite.Select(
+		sm.Columns(cols...),
+		sm.From("auth_user_roles").As("ur"),
+		sm.InnerJoin("auth_users").As("u").OnEQ(
+			sqlite.Raw("ur.user_id"), sqlite.Raw("u.id"),
+		),
+		sm.InnerJoin("auth_roles").As("r").OnEQ(
+			sqlite.Raw("ur.role_id"), sqlite.Raw("r.id"),
+		),
+	)
+	if q.Detailed {
+		query.Apply(
+			sm.LeftJoin("auth_users").As("cb").OnEQ(
+				sqlite.Raw("ur.created_by"), sqlite.Raw("cb.id"),
+			),
+		)
+	}
+	if q.UserID != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("ur", "user_id").EQ(sqlite.Arg(q.UserID))),
+		)
+	}
+	if q.RoleID != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("ur", "role_id").EQ(sqlite.Arg(q.RoleID))),
+		)
+	}
+	if q.RoleName != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("r", "name").EQ(sqlite.Arg(q.RoleName))),
+		)
+	}
+	query.Apply(sqliteWhereMods(userRoleFields, p.Filters)...)
+	return query
+}
+
+func (r sqliteUserRoleStore) List(ctx context.Context, q UserRoleQuery, p ListParams) ([]UserRole, error) {
+	query := r.query(q, p)
+	query.Apply(sqlitePageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userRoles := []UserRole{}
+	for rows.Next() {
+		var ur UserRole
+		var meta UserRoleMeta
+		dest := []any{&ur.ID, &ur.UserID, &ur.Username, &ur.Email, &ur.RoleID, &ur.RoleName, &ur.CreatedAt}
+		if q.Detailed {
+			dest = append(dest, &meta.CreatedByID, &meta.CreatedByUsername, &meta.CreatedByEmail)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if q.Detailed {
+			ur.Meta = &meta
+		}
+		userRoles = append(userRoles, ur)
+	}
+	return userRoles, rows.Err()
+}
+
+func (r sqliteUserRoleStore) Count(ctx context.Context, q UserRoleQuery, p ListParams) (int64, bool, error) {
+	q.Detailed = false
+	sql, args := r.query(q, p).MustBuild()
+	return r.s.count(ctx, sql, args...)
+}
+
+func (r sqliteUserRoleStore) Held(ctx context.Context, roleName string) (bool, error) {
+	query, args := sqlite.Select(
+		sm.Columns(sqlite.Raw("1")),
+		sm.From("auth_user_roles").As("ur"),
+		sm.InnerJoin("auth_roles").As("r").OnEQ(
+			sqlite.Raw("ur.role_id"), sqlite.Raw("r.id"),
+		),
+		sm.Where(sqlite.Quote("r", "name").EQ(sqlite.Arg(roleName))),
+		sm.Limit(1),
+	).MustBuild()
+
+	var one int
+	if err := r.s.queryRow(ctx, query, args...).Scan(&one); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (r sqliteUserRoleStore) Grants(ctx context.Context, userID string) ([]RoleGrant, error) {
+	query, args := sqlite.Select(
+		sm.Columns("r.id", "r.name", "p.id", "p.name"),
+		sm.From("auth_user_roles").As("ur"),
+		sm.InnerJoin("auth_roles").As("r").OnEQ(
+			sqlite.Raw("r.id"), sqlite.Raw("ur.role_id"),
+		),
+		sm.InnerJoin("auth_role_permissions").As("rp").OnEQ(
+			sqlite.Raw("rp.role_id"), sqlite.Raw("ur.role_id"),
+		),
+		sm.InnerJoin("auth_permissions").As("p").OnEQ(
+			sqlite.Raw("p.id"), sqlite.Raw("rp.permission_id"),
+		),
+		sm.Where(sqlite.Quote("ur", "user_id").EQ(sqlite.Arg(userID))),
+		sm.OrderBy("p.name"),
+		sm.OrderBy("r.name"),
+	).MustBuild()
+
+	return r.s.grants(ctx, query, args...)
+}
+
+type sqliteRolePermissionStore struct{ s *sqliteStore }
+
+func (r sqliteRolePermissionStore) query(q RolePermissionQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	cols := []any{"rp.id", "rp.role_id", "r.name", "rp.permission_id", "p.name", "rp.created_at"}
+	if q.Detailed {
+		cols = append(cols, "cb.id", "cb.username", "cb.email")
+	}
+	query := sqlite.Select(
+		sm.Columns(cols...),
+		sm.From("auth_role_permissions").As("rp"),
+		sm.InnerJoin("auth_roles").As("r").OnEQ(
+			sqlite.Raw("rp.role_id"), sqlite.Raw("r.id"),
+		),
+		sm.InnerJoin("auth_permissions").As("p").OnEQ(
+			sqlite.Raw("rp.permission_id"), sqlite.Raw("p.id"),
+		),
+		sm.Where(sqlite.Quote("rp", "role_id").EQ(sqlite.Arg(q.RoleID))),
+	)
+	if q.Detailed {
+		query.Apply(
+			sm.LeftJoin("auth_users").As("cb").OnEQ(
+				sqlite.Raw("rp.created_by"), sqlite.Raw("cb.id"),
+			),
+		)
+	}
+	query.Apply(sqliteWhereMods(rolePermissionFields, p.Filters)...)
+	return query
+}
+
+func (r sqliteRolePermissionStore) List(ctx context.Context, q RolePermissionQuery, p ListParams) ([]RolePermission, error) {
+	query := r.query(q, p)
+	query.Apply(sqlitePageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rolePermissions := []RolePermission{}
+	for rows.Next() {
+		var rp RolePermission
+		var meta RolePermissionMeta
+		dest := []any{&rp.ID, &rp.RoleID, &rp.RoleName, &rp.PermissionID, &rp.PermissionName, &rp.CreatedAt}
+		if q.Detailed {
+			dest = append(dest, &meta.CreatedByID, &meta.CreatedByUsername, &meta.CreatedByEmail)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if q.Detailed {
+			rp.Meta = &meta
+		}
+		rolePermissions = append(rolePermissions, rp)
+	}
+	return rolePermissions, rows.Err()
+}
+
+func (r sqliteRolePermissionStore) Count(ctx context.Context, q RolePermissionQuery, p ListParams) (int64, bool, error) {
+	q.Detailed = false
+	sql, args := r.query(q, p).MustBuild()
+	return r.s.count(ctx, sql, args...)
+}
+
+type sqliteBanStore struct{ s *sqliteStore }
+
+func (r sqliteBanStore) query(q BanQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	cols := []any{"b.user_id", "b.reason", "b.description", "b.banned_at", "b.unbanned_at"}
+	if q.Detailed {
+		cols = append(cols, "bb.id", "bb.username", "bb.email")
+	}
+	query := sqlite.Select(
+		sm.Columns(cols...),
+		sm.From("auth_bans").As("b"),
+	)
+	if q.Detailed {
+		query.Apply(
+			sm.LeftJoin("auth_users").As("bb").OnEQ(
+				sqlite.Raw("b.banned_by"), sqlite.Raw("bb.id"),
+			),
+		)
+	}
+	if q.UserID != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("b", "user_id").EQ(sqlite.Arg(q.UserID))),
+		)
+	}
+	if !q.IncludeExpired {
+		query.Apply(
+			sqlite.WhereOr(
+				sm.Where(sqlite.Quote("b", "unbanned_at").GT(sqlite.Arg(p.Now))),
+				sm.Where(sqlite.Quote("b", "banned_at").EQ(sqlite.Quote("b", "unbanned_at"))),
+			),
+		)
+	}
+	query.Apply(sqliteWhereMods(banFields, p.Filters)...)
+	return query
+}
+
+func (r sqliteBanStore) List(ctx context.Context, q BanQuery, p ListParams) ([]Ban, error) {
+	query := r.query(q, p)
+	query.Apply(sqlitePageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []Ban{}
+	for rows.Next() {
+		var ban Ban
+		var meta BanMeta
+		dest := []any{&ban.UserID, &ban.Reason, &ban.Description, &ban.BannedAt, &ban.UnbannedAt}
+		if q.Detailed {
+			dest = append(dest, &meta.BannedByID, &meta.BannedByUsername, &meta.BannedByEmail)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if q.Detailed {
+			ban.Meta = &meta
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+func (r sqliteBanStore) Count(ctx context.Context, q BanQuery, p ListParams) (int64, bool, error) {
+	q.Detailed = false
+	sql, args := r.query(q, p).MustBuild()
+	return r.s.count(ctx, sql, args...)
+}
+
+func (r sqliteBanStore) Active(ctx context.Context, userID string, now time.Time) (bool, error) {
+	sql, args := sqlite.Select(
+		sm.From("auth_bans"),
+		sm.Columns("user_id"),
+		sqlite.WhereAnd(
+			sm.Where(sqlite.Quote("user_id").EQ(sqlite.Arg(userID))),
+			sqlite.WhereOr(
+				sm.Where(sqlite.Quote("unbanned_at").GT(sqlite.Arg(now))),
+				sm.Where(sqlite.Quote("banned_at").EQ(sqlite.Quote("unbanned_at"))),
+			),
+		),
+	).MustBuild()
+
+	var banned bool
+	if err := r.s.queryRow(ctx, sqlutil.Exists(sql), args...).Scan(&banned); err != nil {
+		return false, err
+	}
+	return banned, nil
+}
+
+func (r sqliteBanStore) Put(ctx context.Context, ban Ban, by *string) error {
+	sql, args := sqlite.Insert(
+		im.Into("auth_bans", "user_id", "reason", "description", "banned_by", "banned_at", "unbanned_at"),
+		im.Values(sqlite.Arg(ban.UserID, ban.Reason, ban.Description, by, ban.BannedAt, ban.UnbannedAt)),
+		im.OnConflict("user_id").DoUpdate().SetExcluded("reason", "description", "banned_by", "banned_at", "unbanned_at"),
+	).MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{sqlitedb.ForeignKey: ErrUserNotFound}, sql, args...)
+	return err
+}
+
+func (r sqliteBanStore) Delete(ctx context.Context, userID string) error {
+	sql, args := sqlite.Delete(
+		dm.From("auth_bans"),
+		dm.Where(sqlite.Quote("user_id").EQ(sqlite.Arg(userID))),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrBanNotFound
+	}
+	return nil
+}
+
+func (r sqliteBanStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	sql, args := sqlite.Delete(
+		dm.From("auth_bans"),
+		dm.Where(sqlite.Quote("unbanned_at").LTE(sqlite.Arg(before))),
+		dm.Where(sqlite.Quote("banned_at").NE(sqlite.Quote("unbanned_at"))),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	return int(n), err
+}
+
+type sqliteOutboxStore struct{ s *sqliteStore }
+
+func (r sqliteOutboxStore) Add(ctx context.Context, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	q := sqlite.Insert(
+		im.Into("auth_outbox",
+			"id", "type", "aggregate_id", "actor_id", "payload", "created_at", "next_attempt_at",
+		),
+	)
+	for _, e := range events {
+		q.Apply(im.Values(sqlite.Arg(e.ID, e.Type, e.AggregateID, e.ActorID, string(e.Payload), e.CreatedAt, e.CreatedAt)))
+	}
+	sql, args := q.MustBuild()
+
+	_, err := r.s.exec(ctx, nil, sql, args...)
+	return err
+}
+
+// Claim leases the events in one statement, which SQLite runs atomically.
+func (r sqliteOutboxStore) Claim(ctx context.Context, limit int, now, until time.Time) ([]Event, error) {
+	q := `
+	UPDATE auth_outbox SET locked_until = ?1
+	WHERE seq IN (
+		SELECT o.seq FROM auth_outbox o
+		WHERE o.state = 'pending'
+		AND o.next_attempt_at <= ?2
+		AND (o.locked_until IS NULL OR o.locked_until <= ?2)
+		AND NOT EXISTS (
+			SELECT 1 FROM auth_outbox p
+			WHERE p.aggregate_id = o.aggregate_id AND p.state = 'pending' AND p.seq < o.seq
+		)
+		ORDER BY o.seq
+		LIMIT ?3
+	)
+	RETURNING seq, id, type, aggregate_id, actor_id, payload, created_at, attempts;`
+
+	rows, err := r.s.query(ctx, q, until, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type claimed struct {
+		seq int64
+		Event
+	}
+	var claims []claimed
+	for rows.Next() {
+		var c claimed
+		var payload string
+		if err := rows.Scan(
+			&c.seq,
+			&c.ID,
+			&c.Type,
+			&c.AggregateID,
+			&c.ActorID,
+			&payload,
+			&c.CreatedAt,
+			&c.Attempts,
+		); err != nil {
+			return nil, err
+		}
+		c.Payload = json.RawMessage(payload)
+		claims = append(claims, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not preserve the order of the subquery.
+	slices.SortFunc(claims, func(a, b claimed) int { return cmp.Compare(a.seq, b.seq) })
+	events := make([]Event, len(claims))
+	for i, c := range claims {
+		events[i] = c.Event
+	}
+	return events, nil
+}
+
+func (r sqliteOutboxStore) Delivered(ctx context.Context, id string, at time.Time) error {
+	sql, args := sqlite.Update(
+		um.Table("auth_outbox"),
+		um.Set("state").ToArg(eventDelivered),
+		um.Set("delivered_at").ToArg(at),
+		um.Set("locked_until").ToArg(nil),
+		um.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	).MustBuild()
+
+	return r.update(ctx, sql, args...)
+}
+
+func (r sqliteOutboxStore) Failed(ctx context.Context, id, reason string, next *time.Time) error {
+	q := sqlite.Update(
+		um.Table("auth_outbox"),
+		um.Set("attempts").To(sqlite.Raw("attempts + 1")),
+		um.Set("last_error").ToArg(reason),
+		um.Set("locked_until").ToArg(nil),
+		um.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	)
+	if next == nil {
+		q.Apply(um.Set("state").ToArg(eventDead))
+	} else {
+		q.Apply(um.Set("next_attempt_at").ToArg(*next))
+	}
+	sql, args := q.MustBuild()
+
+	return r.update(ctx, sql, args...)
+}
+
+func (r sqliteOutboxStore) update(ctx context.Context, sql string, args ...any) error {
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEventNotFound
+	}
+	return nil
+}
+
+func (r sqliteOutboxStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	sql, args := sqlite.Delete(
+		dm.From("auth_outbox"),
+		dm.Where(sqlite.Quote("state").EQ(sqlite.Arg(eventDelivered))),
+		dm.Where(sqlite.Quote("delivered_at").LT(sqlite.Arg(before))),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	return int(n), err
+}
+
+type sqliteWebhookStore struct{ s *sqliteStore }
+
+func (r sqliteWebhookStore) query(q WebhookQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := sqlite.Select(
+		sm.Columns(
+			"id",
+			"url",
+			"events",
+			"description",
+			"enabled",
+			"failures",
+			"created_at",
+			"updated_at",
+			"disabled_at",
+		),
+		sm.From("auth_webhooks"),
+	)
+	if q.ID != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("id").EQ(sqlite.Arg(q.ID))),
+		)
+	}
+	if q.Enabled != nil {
+		query.Apply(
+			sm.Where(sqlite.Quote("enabled").EQ(sqlite.Arg(*q.Enabled))),
+		)
+	}
+	query.Apply(sqliteWhereMods(webhookFields, p.Filters)...)
+	return query
+}
+
+func (r sqliteWebhookStore) List(ctx context.Context, q WebhookQuery, p ListParams) ([]Webhook, error) {
+	query := r.query(q, p)
+	query.Apply(sqlitePageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		if err := rows.Scan(
+			&w.ID,
+			&w.URL,
+			(*jsonStrings)(&w.Events),
+			&w.Description,
+			&w.Enabled,
+			&w.Failures,
+			&w.CreatedAt,
+			&w.UpdatedAt,
+			&w.DisabledAt,
+		); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func (r sqliteWebhookStore) Count(ctx context.Context, q WebhookQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, p).MustBuild()
+	return r.s.count(ctx, sql, args...)
+}
+
+func (r sqliteWebhookStore) Get(ctx context.Context, id string) (Webhook, error) {
+	query, args := sqlite.Select(
+		sm.Columns(
+			"url",
+			"secret",
+			"events",
+			"description",
+			"enabled",
+			"failures",
+			"created_at",
+			"updated_at",
+			"disabled_at",
+		),
+		sm.From("auth_webhooks"),
+		sm.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	).MustBuild()
+
+	w := Webhook{ID: id}
+	if err := r.s.queryRow(ctx, query, args...).Scan(
+		&w.URL,
+		&w.Secret,
+		(*jsonStrings)(&w.Events),
+		&w.Description,
+		&w.Enabled,
+		&w.Failures,
+		&w.CreatedAt,
+		&w.UpdatedAt,
+		&w.DisabledAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, ErrWebhookNotFound
+		}
+		return Webhook{}, fmt.Errorf("auth.WebhookStore.Get: %w", err)
+	}
+	return w, nil
+}
+
+func (r sqliteWebhookStore) Create(ctx context.Context, w Webhook) error {
+	sql, args := sqlite.Insert(
+		im.Into("auth_webhooks",
+			"id", "url", "secret", "events", "description", "enabled", "created_at",
+		),
+		im.Values(sqlite.Arg(w.ID, w.URL, w.Secret, jsonStrings(w.Events), w.Description, w.Enabled, w.CreatedAt)),
+	).MustBuild()
+
+	_, err := r.s.exec(ctx, nil, sql, args...)
+	return err
+}
+
+func (r sqliteWebhookStore) Update(ctx context.Context, id string, in WebhookUpdate, at time.Time) error {
+	q := sqlite.Update(
+		um.Table("auth_webhooks"),
+		um.Set("updated_at").ToArg(at),
+		um.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	)
+	if in.URL != nil {
+		q.Apply(um.Set("url").ToArg(*in.URL))
+	}
+	if in.Secret != nil {
+		q.Apply(um.Set("secret").ToArg(*in.Secret))
+	}
+	if in.Events != nil {
+		q.Apply(um.Set("events").ToArg(jsonStrings(in.Events)))
+	}
+	if in.Description != nil {
+		q.Apply(um.Set("description").ToArg(*in.Description))
+	}
+	if in.Enabled != nil {
+		var disabledAt *time.Time
+		if !*in.Enabled {
+			disabledAt = &at
+		}
+		// The failures and disabled_at only change with the state.
+		q.Apply(
+			um.Set("failures").To(sqlite.Raw("CASE WHEN enabled = ? THEN failures ELSE 0 END", *in.Enabled)),
+			um.Set("disabled_at").To(sqlite.Raw("CASE WHEN enabled = ? THEN disabled_at ELSE ? END", *in.Enabled, disabledAt)),
+			um.Set("enabled").ToArg(*in.Enabled),
+		)
+	}
+	sql, args := q.MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (r sqliteWebhookStore) Delete(ctx context.Context, ids []string) (int, error) {
+	sql, args := sqlite.Delete(
+		dm.From("auth_webhooks"),
+		dm.Where(sqlite.Quote("id").In(sqlite.Arg(sqlutil.AnySlice(ids)...))),
+	).MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	return int(n), err
+}
+
+func (r sqliteWebhookStore) Subscribers(ctx context.Context, typ string) ([]string, error) {
+	sql, args := sqlite.Select(
+		sm.Columns("id"),
+		sm.From("auth_webhooks"),
+		sm.Where(sqlite.Quote("enabled")),
+		sm.Where(sqlite.Raw("EXISTS (SELECT 1 FROM json_each(events) WHERE value IN (?, ?))", typ, WebhookAllEvents)),
+		sm.OrderBy("id"),
+	).MustBuild()
+
+	return r.s.returningIDs(ctx, sql, args...)
+}
+
+func (r sqliteWebhookStore) Report(ctx context.Context, id string, ok bool, at time.Time, maxFailures int) (bool, error) {
+	var disabled bool
+	err := r.s.withTx(ctx, func(tx *sqliteStore) error {
+		var enabled bool
+		var failures int
+		if err := tx.queryRow(ctx,
+			"SELECT enabled, failures FROM auth_webhooks WHERE id = ?", id,
+		).Scan(&enabled, &failures); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrWebhookNotFound
+			}
+			return err
+		}
+
+		q := sqlite.Update(
+			um.Table("auth_webhooks"),
+			um.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+		)
+		if ok {
+			q.Apply(um.Set("failures").ToArg(0))
+		} else {
+			q.Apply(um.Set("failures").ToArg(failures + 1))
+			if enabled && failures+1 >= maxFailures {
+				q.Apply(
+					um.Set("enabled").ToArg(false),
+					um.Set("disabled_at").ToArg(at),
+				)
+				disabled = true
+			}
+		}
+		sql, args := q.MustBuild()
+
+		_, err := tx.exec(ctx, nil, sql, args...)
+		return err
+	})
+	return disabled, err
+}
+
+type sqliteDeliveryStore struct{ s *sqliteStore }
+
+func (r sqliteDeliveryStore) query(q WebhookDeliveryQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := sqlite.Select(
+		sm.Columns(
+			"id",
+			"webhook_id",
+			"event_id",
+			"event_type",
+			"state",
+			"attempts",
+			"created_at",
+			"next_attempt_at",
+			"last_attempt_at",
+			"status_code",
+			"error",
+			"duration_ms",
+			"delivered_at",
+		),
+		sm.From("auth_webhook_deliveries"),
+		sm.Where(sqlite.Quote("webhook_id").EQ(sqlite.Arg(q.WebhookID))),
+	)
+	if q.ID != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("id").EQ(sqlite.Arg(q.ID))),
+		)
+	}
+	if q.State != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("state").EQ(sqlite.Arg(q.State))),
+		)
+	}
+	query.Apply(sqliteWhereMods(deliveryFields, p.Filters)...)
+	return query
+}
+
+func (r sqliteDeliveryStore) List(ctx context.Context, q WebhookDeliveryQuery, p ListParams) ([]WebhookDelivery, error) {
+	query := r.query(q, p)
+	query.Apply(sqlitePageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(
+			&d.ID,
+			&d.WebhookID,
+			&d.EventID,
+			&d.EventType,
+			&d.State,
+			&d.Attempts,
+			&d.CreatedAt,
+			&d.NextAttemptAt,
+			&d.LastAttemptAt,
+			&d.StatusCode,
+			&d.Error,
+			&d.DurationMS,
+			&d.DeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r sqliteDeliveryStore) Count(ctx context.Context, q WebhookDeliveryQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, p).MustBuild()
+	return r.s.count(ctx, sql, args...)
+}
+
+func (r sqliteDeliveryStore) Add(ctx context.Context, deliveries ...WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	q := sqlite.Insert(
+		im.Into("auth_webhook_deliveries",
+			"id", "webhook_id", "event_id", "event_type", "payload", "created_at", "next_attempt_at",
+		),
+		im.OnConflict("webhook_id", "event_id").DoNothing(),
+	)
+	for _, d := range deliveries {
+		q.Apply(im.Values(sqlite.Arg(d.ID, d.WebhookID, d.EventID, d.EventType, string(d.Payload), d.CreatedAt, d.CreatedAt)))
+	}
+	sql, args := q.MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{sqlitedb.ForeignKey: ErrWebhookNotFound}, sql, args...)
+	return err
+}
+
+// Claim leases the deliveries in one statement, which SQLite runs
+// atomically.
+func (r sqliteDeliveryStore) Claim(ctx context.Context, limit int, now, until time.Time) ([]ClaimedDelivery, error) {
+	q := `
+	UPDATE auth_webhook_deliveries SET locked_until = ?1
+	WHERE id IN (
+		SELECT d.id FROM auth_webhook_deliveries d
+		JOIN auth_webhooks w ON w.id = d.webhook_id
+		WHERE d.state = 'pending'
+		AND d.next_attempt_at <= ?2
+		AND (d.locked_until IS NULL OR d.locked_until <= ?2)
+		AND w.enabled
+		ORDER BY d.next_attempt_at, d.id
+		LIMIT ?3
+	)
+	RETURNING id, webhook_id, event_id, event_type, payload, attempts, created_at, next_attempt_at,
+		(SELECT w.url FROM auth_webhooks w WHERE w.id = auth_webhook_deliveries.webhook_id),
+		(SELECT w.secret FROM auth_webhooks w WHERE w.id = auth_webhook_deliveries.webhook_id);`
+
+	rows, err := r.s.query(ctx, q, until, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []ClaimedDelivery
+	for rows.Next() {
+		d := ClaimedDelivery{WebhookDelivery: WebhookDelivery{State: DeliveryPending}}
+		var payload string
+		if err := rows.Scan(
+			&d.ID,
+			&d.WebhookID,
+			&d.EventID,
+			&d.EventType,
+			&payload,
+			&d.Attempts,
+			&d.CreatedAt,
+			&d.NextAttemptAt,
+			&d.URL,
+			&d.Secret,
+		); err != nil {
+			return nil, err
+		}
+		d.Payload = []byte(payload)
+		claimed = append(claimed, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not preserve the order of the subquery.
+	slices.SortFunc(claimed, func(a, b ClaimedDelivery) int {
+		if c := a.NextAttemptAt.Compare(*b.NextAttemptAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return claimed, nil
+}
+
+func (r sqliteDeliveryStore) Attempted(ctx context.Context, id string, a DeliveryAttempt, next *time.Time) error {
+	q := sqlite.Update(
+		um.Table("auth_webhook_deliveries"),
+		um.Set("attempts").To(sqlite.Raw("attempts + 1")),
+		um.Set("last_attempt_at").ToArg(a.At),
+		um.Set("status_code").ToArg(a.StatusCode),
+		um.Set("error").ToArg(a.Error),
+		um.Set("duration_ms").ToArg(a.Duration.Milliseconds()),
+		um.Set("locked_until").ToArg(nil),
+		um.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	)
+	switch {
+	case a.OK:
+		q.Apply(
+			um.Set("state").ToArg(DeliveryDelivered),
+			um.Set("delivered_at").ToArg(a.At),
+			um.Set("next_attempt_at").ToArg(nil),
+		)
+	case next == nil:
+		q.Apply(
+			um.Set("state").ToArg(DeliveryFailed),
+			um.Set("next_attempt_at").ToArg(nil),
+		)
+	default:
+		q.Apply(um.Set("next_attempt_at").ToArg(*next))
+	}
+	sql, args := q.MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+func (r sqliteDeliveryStore) Redeliver(ctx context.Context, id string, now time.Time) (string, error) {
+	sql, args := sqlite.Update(
+		um.Table("auth_webhook_deliveries"),
+		um.Set("state").ToArg(DeliveryPending),
+		um.Set("attempts").ToArg(0),
+		um.Set("next_attempt_at").ToArg(now),
+		um.Set("locked_until").ToArg(nil),
+		um.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+		um.Returning("webhook_id"),
+	).MustBuild()
+
+	ids, err := r.s.returningIDs(ctx, sql, args...)
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", ErrDeliveryNotFound
+	}
+	return ids[0], nil
+}
+
+type sqliteEmailStore struct{ s *sqliteStore }
+
+func (r sqliteEmailStore) query(q EmailQuery, p ListParams) bob.BaseQuery[*dialect.SelectQuery] {
+	query := sqlite.Select(
+		sm.Columns(
+			"id",
+			"user_id",
+			"kind",
+			"recipient",
+			"subject",
+			"state",
+			"attempts",
+			"created_at",
+			"next_attempt_at",
+			"last_attempt_at",
+			"error",
+			"sent_at",
+		),
+		sm.From("auth_emails"),
+	)
+	if q.ID != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("id").EQ(sqlite.Arg(q.ID))),
+		)
+	}
+	if q.UserID != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("user_id").EQ(sqlite.Arg(q.UserID))),
+		)
+	}
+	if q.Kind != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("kind").EQ(sqlite.Arg(q.Kind))),
+		)
+	}
+	if q.State != "" {
+		query.Apply(
+			sm.Where(sqlite.Quote("state").EQ(sqlite.Arg(q.State))),
+		)
+	}
+	query.Apply(sqliteWhereMods(emailFields, p.Filters)...)
+	return query
+}
+
+func (r sqliteEmailStore) List(ctx context.Context, q EmailQuery, p ListParams) ([]Email, error) {
+	query := r.query(q, p)
+	query.Apply(sqlitePageMods(p)...)
+	sql, args := query.MustBuild()
+
+	rows, err := r.s.query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []Email{}
+	for rows.Next() {
+		var e Email
+		if err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.Kind,
+			&e.To,
+			&e.Subject,
+			&e.State,
+			&e.Attempts,
+			&e.CreatedAt,
+			&e.NextAttemptAt,
+			&e.LastAttemptAt,
+			&e.Error,
+			&e.SentAt,
+		); err != nil {
+			return nil, err
+		}
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
+
+func (r sqliteEmailStore) Count(ctx context.Context, q EmailQuery, p ListParams) (int64, bool, error) {
+	sql, args := r.query(q, p).MustBuild()
+	return r.s.count(ctx, sql, args...)
+}
+
+func (r sqliteEmailStore) Add(ctx context.Context, emails ...Email) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	q := sqlite.Insert(
+		im.Into("auth_emails",
+			"id", "user_id", "kind", "recipient", "subject", "text_body", "html_body", "created_at", "next_attempt_at",
+		),
+	)
+	for _, e := range emails {
+		q.Apply(im.Values(sqlite.Arg(e.ID, e.UserID, e.Kind, e.To, e.Subject, e.Text, e.HTML, e.CreatedAt, e.CreatedAt)))
+	}
+	sql, args := q.MustBuild()
+
+	_, err := r.s.exec(ctx, map[string]error{sqlitedb.ForeignKey: ErrUserNotFound}, sql, args...)
+	return err
+}
+
+// Claim leases the emails in one statement, which SQLite runs atomically.
+func (r sqliteEmailStore) Claim(ctx context.Context, limit int, now, until time.Time) ([]Email, error) {
+	q := `
+	UPDATE auth_emails SET locked_until = ?1
+	WHERE id IN (
+		SELECT id FROM auth_emails
+		WHERE state = 'pending'
+		AND next_attempt_at <= ?2
+		AND (locked_until IS NULL OR locked_until <= ?2)
+		ORDER BY next_attempt_at, id
+		LIMIT ?3
+	)
+	RETURNING id, user_id, kind, recipient, subject, text_body, html_body, attempts, created_at, next_attempt_at;`
+
+	rows, err := r.s.query(ctx, q, until, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []Email
+	for rows.Next() {
+		e := Email{State: EmailPending}
+		if err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.Kind,
+			&e.To,
+			&e.Subject,
+			&e.Text,
+			&e.HTML,
+			&e.Attempts,
+			&e.CreatedAt,
+			&e.NextAttemptAt,
+		); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not preserve the order of the subquery.
+	slices.SortFunc(claimed, compareEmailsDue)
+	return claimed, nil
+}
+
+func (r sqliteEmailStore) Attempted(ctx context.Context, id string, at time.Time, errMsg *string, next *time.Time) error {
+	q := sqlite.Update(
+		um.Table("auth_emails"),
+		um.Set("attempts").To(sqlite.Raw("attempts + 1")),
+		um.Set("last_attempt_at").ToArg(at),
+		um.Set("error").ToArg(errMsg),
+		um.Set("locked_until").ToArg(nil),
+		um.Where(sqlite.Quote("id").EQ(sqlite.Arg(id))),
+	)
+	switch {
+	case errMsg == nil:
+		q.Apply(
+			um.Set("state").ToArg(EmailSent),
+			um.Set("sent_at").ToArg(at),
+			um.Set("next_attempt_at").ToArg(nil),
+			um.Set("text_body").ToArg(""),
+			um.Set("html_body").ToArg(""),
+		)
+	case next == nil:
+		q.Apply(
+			um.Set("state").ToArg(EmailFailed),
+			um.Set("next_attempt_at").ToArg(nil),
+		)
+	default:
+		q.Apply(um.Set("next_attempt_at").ToArg(*next))
+	}
+	sql, args := q.MustBuild()
+
+	n, err := r.s.exec(ctx, nil, sql, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEmailNotFound
+	}
+	return nil
+}
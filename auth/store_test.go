@@ -0,0 +1,1464 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"glut/common/flux"
+	"glut/common/migrate"
+	"glut/common/postgres"
+	"glut/common/sqlite"
+	"glut/common/sqlutil"
+	"glut/db/migrations"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
+
+// TestPostgresStore runs the conformance suite against the database of
+// GLUT_TEST_DATABASE_URL, which is migrated and whose auth tables are
+// truncated before each test.
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("GLUT_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("GLUT_TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate.New(conn, all, logger).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := postgres.NewCluster(ctx, &postgres.Config{URL: url, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	testStore(t, func(t *testing.T) Store {
+		if _, err := db.Exec(ctx, `TRUNCATE auth.users, auth.roles, auth.permissions, auth.outbox, auth.webhooks, auth.emails CASCADE`); err != nil {
+			t.Fatal(err)
+		}
+		return NewPostgresStore(db)
+	})
+}
+
+// TestSQLiteStore runs the conformance suite against migrated SQLite
+// databases in temporary files, emptied of the rows seeded by migrations.
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	all, err := migrations.SQLite()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, func(t *testing.T) Store {
+		db, err := sqlite.Open(ctx, &sqlite.Config{URL: filepath.Join(t.TempDir(), "glut.db")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := migrate.NewSQLite(db, all, logger).Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// The migrations grant the admin permissions to the admin role.
+		for _, table := range []string{"auth_roles", "auth_permissions"} {
+			if _, err := db.Exec(`DELETE FROM ` + table); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return NewSQLiteStore(db)
+	})
+}
+
+// testStore runs the tests every Store must pass on stores created by
+// newStore, which must be empty.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s Store)
+	}{
+		{"Users", testStoreUsers},
+		{"Sessions", testStoreSessions},
+		{"Tokens", testStoreTokens},
+		{"Roles", testStoreRoles},
+		{"Permissions", testStorePermissions},
+		{"RolePermissions", testStoreRolePermissions},
+		{"RolePermissionGrants", testStoreRolePermissionGrants},
+		{"UserRoles", testStoreUserRoles},
+		{"SessionRoles", testStoreSessionRoles},
+		{"Bans", testStoreBans},
+		{"DeleteUsers", testStoreDeleteUsers},
+		{"List", testStoreList},
+		{"Tx", testStoreTx},
+		{"Outbox", testStoreOutbox},
+		{"Webhooks", testStoreWebhooks},
+		{"Emails", testStoreEmails},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// testNow is the time of the tests, truncated to the precision of Postgres.
+var testNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func createTestUser(t *testing.T, s Store, username string) User {
+	t.Helper()
+	u := User{
+		ID:        uuid.New().String(),
+		Username:  username,
+		Email:     username + "@example.com",
+		Password:  "hash",
+		CreatedAt: testNow,
+	}
+	if err := s.Users().Create(context.Background(), u); err != nil {
+		t.Fatalf("Users().Create(%q): %v", username, err)
+	}
+	return u
+}
+
+func createTestRole(t *testing.T, s Store, name string, by *string) Role {
+	t.Helper()
+	r := Role{ID: uuid.New().String(), Name: name, CreatedAt: testNow}
+	if err := s.Roles().Create(context.Background(), r, by); err != nil {
+		t.Fatalf("Roles().Create(%q): %v", name, err)
+	}
+	return r
+}
+
+func createTestPermission(t *testing.T, s Store, name string) Permission {
+	t.Helper()
+	p := Permission{ID: uuid.New().String(), Name: name, CreatedAt: testNow}
+	if err := s.Permissions().Create(context.Background(), p, nil); err != nil {
+		t.Fatalf("Permissions().Create(%q): %v", name, err)
+	}
+	return p
+}
+
+// testRBAC are the roles and permissions created by createTestRBAC.
+type testRBAC struct {
+	admin, editor Role
+	read, write   Permission
+}
+
+// createTestRBAC creates the admin role, granted posts.read and posts.write,
+// and the editor role, granted posts.read, and assigns both to the users.
+func createTestRBAC(t *testing.T, s Store, userIDs ...string) testRBAC {
+	t.Helper()
+	ctx := context.Background()
+	rbac := testRBAC{
+		admin:  createTestRole(t, s, "admin", nil),
+		editor: createTestRole(t, s, "editor", nil),
+		read:   createTestPermission(t, s, "posts.read"),
+		write:  createTestPermission(t, s, "posts.write"),
+	}
+	if _, err := s.Roles().Grant(ctx, rbac.admin.ID, []string{rbac.read.ID, rbac.write.ID}, testNow, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Roles().Grant(ctx, rbac.editor.ID, []string{rbac.read.ID}, testNow, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range userIDs {
+		if _, err := s.Roles().Assign(ctx, id, []string{rbac.admin.ID, rbac.editor.ID}, testNow, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rbac
+}
+
+func createTestSession(s Store, userID string, max int) (Session, error) {
+	sess := Session{
+		ID:        uuid.New().String(),
+		Token:     mustGenerateToken(defaultTokenLength),
+		UserID:    userID,
+		UserIP:    "127.0.0.1",
+		CreatedAt: testNow,
+		ExpiresAt: testNow.Add(time.Hour),
+	}
+	return sess, s.Sessions().Create(context.Background(), sess, max)
+}
+
+func testStoreUsers(t *testing.T, s Store) {
+	ctx := context.Background()
+	u := createTestUser(t, s, "alice")
+
+	dup := u
+	dup.ID = uuid.New().String()
+	if err := s.Users().Create(ctx, dup); !errors.Is(err, ErrUserExists) {
+		t.Errorf("creating a duplicate username: got %v, want ErrUserExists", err)
+	}
+
+	got, err := s.Users().GetByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != u.ID || got.Password != "hash" {
+		t.Errorf("GetByUsername = %+v, want user %s with password hash", got, u.ID)
+	}
+	if _, err := s.Users().Get(ctx, uuid.New().String()); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("getting a missing user: got %v, want ErrUserNotFound", err)
+	}
+
+	email := "new@example.com"
+	if err := s.Users().Update(ctx, u.ID, UserUpdate{Email: &email, VerifiedAt: &testNow}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Users().Get(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != email || got.VerifiedAt == nil || !got.VerifiedAt.Equal(testNow) {
+		t.Errorf("updated user = %+v", got)
+	}
+	if err := s.Users().Update(ctx, uuid.New().String(), UserUpdate{Email: &email}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("updating a missing user: got %v, want ErrUserNotFound", err)
+	}
+
+	for _, name := range []string{"zoe", "bob"} {
+		other := createTestUser(t, s, name)
+		if err := s.Users().Update(ctx, other.ID, UserUpdate{Email: &email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usernames, err := s.Users().UsernamesByEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alice", "bob", "zoe"}; !slices.Equal(usernames, want) {
+		t.Errorf("UsernamesByEmail = %v, want %v", usernames, want)
+	}
+}
+
+func testStoreSessions(t *testing.T, s Store) {
+	ctx := context.Background()
+	u := createTestUser(t, s, "alice")
+
+	first, err := createTestSession(s, u.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createTestSession(s, u.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createTestSession(s, u.ID, 2); !errors.Is(err, ErrSessionLimit) {
+		t.Errorf("exceeding the session limit: got %v, want ErrSessionLimit", err)
+	}
+
+	got, err := s.Sessions().Authenticate(ctx, first.Token, testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != first.ID || got.UserID != u.ID {
+		t.Errorf("Authenticate = %+v, want session %s", got, first.ID)
+	}
+	if _, err := s.Sessions().Authenticate(ctx, first.Token, first.ExpiresAt); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("authenticating an expired session: got %v, want ErrSessionNotFound", err)
+	}
+
+	expiresAt := testNow.Add(2 * time.Hour)
+	if err := s.Sessions().Renew(ctx, first.ID, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Sessions().Authenticate(ctx, first.Token, first.ExpiresAt); err != nil {
+		t.Errorf("authenticating a renewed session: %v", err)
+	}
+
+	n, err := s.Sessions().Delete(ctx, []string{first.ID}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Delete = %d, want 1", n)
+	}
+	// The number of the deleted session is free again.
+	if _, err := createTestSession(s, u.ID, 2); err != nil {
+		t.Errorf("creating a session after deleting one: %v", err)
+	}
+
+	n, err = s.Sessions().Delete(ctx, nil, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("deleting the sessions of a user = %d, want 2", n)
+	}
+
+	if _, err := createTestSession(s, u.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		before time.Time
+		want   int
+	}{
+		{testNow, 0},
+		{testNow.Add(time.Hour), 1},
+	} {
+		if n, err := s.Sessions().Purge(ctx, tt.before); err != nil || n != tt.want {
+			t.Errorf("Purge before %v = %d, %v, want %d", tt.before, n, err, tt.want)
+		}
+	}
+}
+
+func testStoreTokens(t *testing.T, s Store) {
+	ctx := context.Background()
+	u := createTestUser(t, s, "alice")
+	email := "new@example.com"
+
+	first := Token{
+		ID:        mustGenerateToken(defaultTokenLength),
+		UserID:    u.ID,
+		Kind:      tokenKindChangeEmail,
+		CreatedAt: testNow,
+		ExpiresAt: testNow.Add(time.Hour),
+		Meta:      map[string]*string{tokenMetaNewEmail: &email},
+	}
+	if err := s.Tokens().Save(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Tokens().Get(ctx, first.ID, tokenKindChangeEmail, testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != u.ID || got.Get(tokenMetaNewEmail) != email {
+		t.Errorf("Get = %+v, want token of %s for %s", got, u.ID, email)
+	}
+	if _, err := s.Tokens().Get(ctx, first.ID, tokenKindVerifyUser, testNow); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("getting a token of another kind: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := s.Tokens().Get(ctx, first.ID, tokenKindChangeEmail, first.ExpiresAt); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("getting an expired token: got %v, want ErrInvalidToken", err)
+	}
+
+	// A user has one token of each kind.
+	second := first
+	second.ID = mustGenerateToken(defaultTokenLength)
+	if err := s.Tokens().Save(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Tokens().Get(ctx, first.ID, tokenKindChangeEmail, testNow); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("getting a replaced token: got %v, want ErrInvalidToken", err)
+	}
+	got, ok, err := s.Tokens().ByUser(ctx, u.ID, tokenKindChangeEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || got.ID != second.ID {
+		t.Errorf("ByUser = %+v, %v, want token %s", got, ok, second.ID)
+	}
+
+	if err := s.Tokens().Delete(ctx, second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.Tokens().ByUser(ctx, u.ID, tokenKindChangeEmail); ok {
+		t.Error("ByUser found a deleted token")
+	}
+
+	if err := s.Tokens().Save(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		before time.Time
+		want   int
+	}{
+		{testNow, 0},
+		{first.ExpiresAt, 1},
+	} {
+		if n, err := s.Tokens().Purge(ctx, tt.before); err != nil || n != tt.want {
+			t.Errorf("Purge before %v = %d, %v, want %d", tt.before, n, err, tt.want)
+		}
+	}
+}
+
+func testStoreRoles(t *testing.T, s Store) {
+	ctx := context.Background()
+	u := createTestUser(t, s, "alice")
+	admin := createTestRole(t, s, "admin", &u.ID)
+	editor := createTestRole(t, s, "editor", nil)
+
+	if err := s.Roles().Create(ctx, Role{ID: uuid.New().String(), Name: "admin", CreatedAt: testNow}, nil); !errors.Is(err, ErrRoleExists) {
+		t.Errorf("creating a duplicate role: got %v, want ErrRoleExists", err)
+	}
+	if err := s.Roles().Update(ctx, UpdateRoleInput{ID: editor.ID, Name: "admin"}, testNow, nil); !errors.Is(err, ErrRoleExists) {
+		t.Errorf("renaming a role to a taken name: got %v, want ErrRoleExists", err)
+	}
+	if err := s.Roles().Update(ctx, UpdateRoleInput{ID: uuid.New().String(), Name: "x"}, testNow, nil); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("updating a missing role: got %v, want ErrRoleNotFound", err)
+	}
+	if err := s.Roles().Update(ctx, UpdateRoleInput{ID: editor.ID, Name: "writer"}, testNow, &u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Permissions().Create(ctx, Permission{ID: uuid.New().String(), Name: "p", CreatedAt: testNow}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Permissions().Create(ctx, Permission{ID: uuid.New().String(), Name: "p", CreatedAt: testNow}, nil); !errors.Is(err, ErrPermissionExists) {
+		t.Errorf("creating a duplicate permission: got %v, want ErrPermissionExists", err)
+	}
+
+	perm := createTestPermission(t, s, "users.read")
+	if _, err := s.Roles().Grant(ctx, uuid.New().String(), []string{perm.ID}, testNow, nil); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("granting to a missing role: got %v, want ErrRoleNotFound", err)
+	}
+	if _, err := s.Roles().Grant(ctx, admin.ID, []string{perm.ID, uuid.New().String()}, testNow, nil); !errors.Is(err, ErrPermissionNotFound) {
+		t.Errorf("granting a missing permission: got %v, want ErrPermissionNotFound", err)
+	}
+	for want := 1; want >= 0; want-- {
+		n, err := s.Roles().Grant(ctx, admin.ID, []string{perm.ID}, testNow, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("Grant = %d, want %d", n, want)
+		}
+	}
+
+	if _, err := s.Roles().Assign(ctx, uuid.New().String(), []string{admin.ID}, testNow, nil); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("assigning to a missing user: got %v, want ErrUserNotFound", err)
+	}
+	if _, err := s.Roles().Assign(ctx, u.ID, []string{uuid.New().String()}, testNow, nil); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("assigning a missing role: got %v, want ErrRoleNotFound", err)
+	}
+	n, err := s.Roles().Assign(ctx, u.ID, []string{admin.ID, editor.ID}, testNow, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Assign = %d, want 2", n)
+	}
+
+	// Deleting a role removes its assignments.
+	if ids, err := s.Roles().Delete(ctx, []string{admin.ID}); err != nil || !slices.Equal(ids, []string{admin.ID}) {
+		t.Fatalf("Delete = %v, %v, want [%s]", ids, err, admin.ID)
+	}
+	n, err = s.Roles().Unassign(ctx, u.ID, []string{admin.ID, editor.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Unassign = %d, want 1", n)
+	}
+
+	roles, err := s.Roles().List(ctx, RoleQuery{Detailed: true}, testListParams(roleKeyset, defaultRoleSort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 || roles[0].Name != "writer" || roles[0].UpdatedAt == nil {
+		t.Fatalf("List = %+v, want the updated writer role", roles)
+	}
+	if meta := roles[0].Meta; meta == nil || meta.UpdatedByUsername == nil || *meta.UpdatedByUsername != "alice" || meta.CreatedByID != nil {
+		t.Errorf("Meta = %+v, want updated by alice", meta)
+	}
+}
+
+func testStorePermissions(t *testing.T, s Store) {
+	ctx := context.Background()
+	u := createTestUser(t, s, "alice")
+	admin := createTestRole(t, s, "admin", nil)
+	read := createTestPermission(t, s, "users.read")
+	write := createTestPermission(t, s, "users.write")
+
+	if err := s.Permissions().Update(ctx, UpdatePermissionInput{ID: write.ID, Name: "users.read"}, testNow, nil); !errors.Is(err, ErrPermissionExists) {
+		t.Errorf("renaming a permission to a taken name: got %v, want ErrPermissionExists", err)
+	}
+	if err := s.Permissions().Update(ctx, UpdatePermissionInput{ID: uuid.New().String(), Name: "x"}, testNow, nil); !errors.Is(err, ErrPermissionNotFound) {
+		t.Errorf("updating a missing permission: got %v, want ErrPermissionNotFound", err)
+	}
+	if err := s.Permissions().Update(ctx, UpdatePermissionInput{ID: write.ID, Name: "users.edit", Description: "Edit users."}, testNow, &u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Roles().Grant(ctx, admin.ID, []string{read.ID, write.ID}, testNow, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting a permission revokes it from its roles: granting a permission
+	// with the same id again is a new grant.
+	if ids, err := s.Permissions().Delete(ctx, []string{read.ID, uuid.New().String()}); err != nil || !slices.Equal(ids, []string{read.ID}) {
+		t.Fatalf("Delete = %v, %v, want [%s]", ids, err, read.ID)
+	}
+	if _, err := s.Roles().Grant(ctx, admin.ID, []string{read.ID}, testNow, nil); !errors.Is(err, ErrPermissionNotFound) {
+		t.Errorf("granting a deleted permission: got %v, want ErrPermissionNotFound", err)
+	}
+	if err := s.Permissions().Create(ctx, read, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Roles().Grant(ctx, admin.ID, []string{read.ID, write.ID}, testNow, nil); err != nil || n != 1 {
+		t.Errorf("Grant after deleting a granted permission = %d, %v, want 1", n, err)
+	}
+
+	permissions, err := s.Permissions().List(ctx, PermissionQuery{ID: write.ID, Detailed: true}, testListParams(permissionKeyset, defaultPermissionSort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 1 || permissions[0].Name != "users.edit" || permissions[0].Description == nil || *permissions[0].Description != "Edit users." || permissions[0].UpdatedAt == nil {
+		t.Fatalf("List = %+v, want the updated users.edit permission", permissions)
+	}
+	if meta := permissions[0].Meta; meta == nil || meta.UpdatedByUsername == nil || *meta.UpdatedByUsername != "alice" {
+		t.Errorf("Meta = %+v, want updated by alice", meta)
+	}
+
+	if names, err := s.Permissions().Existing(ctx, []string{"users.write", "users.read", "users.edit"}); err != nil || !slices.Equal(names, []string{"users.edit", "users.read"}) {
+		t.Errorf("Existing = %v, %v, want [users.edit users.read]", names, err)
+	}
+}
+
+func testStoreRolePermissions(t *testing.T, s Store) {
+	ctx := context.Background()
+	admin := createTestRole(t, s, "admin", nil)
+	editor := createTestRole(t, s, "editor", nil)
+	read := createTestPermission(t, s, "users.read")
+	write := createTestPermission(t, s, "users.write")
+	for _, grant := range []struct {
+		role        Role
+		permissions []string
+	}{
+		{admin, []string{read.ID, write.ID}},
+		{editor, []string{read.ID}},
+	} {
+		if _, err := s.Roles().Grant(ctx, grant.role.ID, grant.permissions, testNow, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rolesOf := func(permissionID string) []string {
+		t.Helper()
+		roles, err := s.Roles().List(ctx, RoleQuery{PermissionID: permissionID}, testListParams(roleKeyset, defaultRoleSort))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := s.Roles().Count(ctx, RoleQuery{PermissionID: permissionID}, ListParams{})
+		if err != nil || n != int64(len(roles)) {
+			t.Errorf("Count = %d, %v, want %d", n, err, len(roles))
+		}
+		names := make([]string, len(roles))
+		for i, r := range roles {
+			names[i] = r.Name
+		}
+		slices.Sort(names)
+		return names
+	}
+	permissionsOf := func(roleID string) []string {
+		t.Helper()
+		permissions, err := s.Permissions().List(ctx, PermissionQuery{RoleID: roleID}, testListParams(permissionKeyset, defaultPermissionSort))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := s.Permissions().Count(ctx, PermissionQuery{RoleID: roleID}, ListParams{})
+		if err != nil || n != int64(len(permissions)) {
+			t.Errorf("Count = %d, %v, want %d", n, err, len(permissions))
+		}
+		names := make([]string, len(permissions))
+		for i, p := range permissions {
+			names[i] = p.Name
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	if got, want := rolesOf(read.ID), []string{"admin", "editor"}; !slices.Equal(got, want) {
+		t.Errorf("roles granted users.read = %v, want %v", got, want)
+	}
+	if got, want := permissionsOf(admin.ID), []string{"users.read", "users.write"}; !slices.Equal(got, want) {
+		t.Errorf("permissions of admin = %v, want %v", got, want)
+	}
+
+	if n, err := s.Roles().Revoke(ctx, admin.ID, []string{read.ID, uuid.New().String()}); err != nil || n != 1 {
+		t.Errorf("Revoke = %d, %v, want 1", n, err)
+	}
+	if n, err := s.Roles().Revoke(ctx, admin.ID, []string{read.ID}); err != nil || n != 0 {
+		t.Errorf("revoking again = %d, %v, want 0", n, err)
+	}
+	if got, want := rolesOf(read.ID), []string{"editor"}; !slices.Equal(got, want) {
+		t.Errorf("roles granted users.read after revoking = %v, want %v", got, want)
+	}
+	if got, want := permissionsOf(admin.ID), []string{"users.write"}; !slices.Equal(got, want) {
+		t.Errorf("permissions of admin after revoking = %v, want %v", got, want)
+	}
+	if got := permissionsOf(uuid.New().String()); len(got) != 0 {
+		t.Errorf("permissions of a missing role = %v, want none", got)
+	}
+}
+
+func testStoreRolePermissionGrants(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice := createTestUser(t, s, "alice")
+	admin := createTestRole(t, s, "admin", nil)
+	read := createTestPermission(t, s, "users.read")
+	write := createTestPermission(t, s, "users.write")
+	if _, err := s.Roles().Grant(ctx, admin.ID, []string{write.ID}, testNow, &alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Grants by unknown users are recorded without a creator.
+	unknown := uuid.New().String()
+	if _, err := s.Roles().Grant(ctx, admin.ID, []string{read.ID}, testNow.Add(time.Minute), &unknown); err != nil {
+		t.Fatal(err)
+	}
+
+	p := testListParams(rolePermissionKeyset, []sqlutil.SortKey{{Name: "permission_name", Column: "p.name"}})
+	grants, err := s.RolePermissions().List(ctx, RolePermissionQuery{RoleID: admin.ID, Detailed: true}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 2 || grants[0].PermissionName != "users.read" || grants[1].PermissionName != "users.write" {
+		t.Fatalf("grants of admin = %+v, want users.read and users.write", grants)
+	}
+	got := grants[1]
+	if got.ID == "" || got.RoleID != admin.ID || got.RoleName != "admin" || got.PermissionID != write.ID || !got.CreatedAt.Equal(testNow) {
+		t.Errorf("grant of users.write = %+v", got)
+	}
+	if meta := got.Meta; meta == nil || meta.CreatedByUsername == nil || *meta.CreatedByUsername != "alice" || meta.CreatedByEmail == nil || *meta.CreatedByEmail != "alice@example.com" {
+		t.Errorf("Meta = %+v, want created by alice", meta)
+	}
+	if got := grants[0]; !got.CreatedAt.Equal(testNow.Add(time.Minute)) || got.Meta == nil || got.Meta.CreatedByID != nil {
+		t.Errorf("grant of users.read = %+v, want no creator", got)
+	}
+	if grants, err := s.RolePermissions().List(ctx, RolePermissionQuery{RoleID: admin.ID}, p); err != nil || len(grants) != 2 || grants[0].Meta != nil {
+		t.Errorf("List without details = %+v, %v, want 2 grants without meta", grants, err)
+	}
+
+	svc := NewService(s, &Config{})
+	f := &flux.Flow{Ctx: ctx, Time: testNow}
+	for _, tt := range []struct {
+		filters []sqlutil.Filter
+		want    []string
+	}{
+		{nil, []string{"users.read", "users.write"}},
+		{[]sqlutil.Filter{{Field: "created_by", Op: sqlutil.OpEq, Value: alice.ID}}, []string{"users.write"}},
+		{[]sqlutil.Filter{{Field: "created_by", Op: sqlutil.OpIsNull, Value: true}}, []string{"users.read"}},
+	} {
+		page, err := svc.RolePermissions(f, RolePermissionQuery{RoleID: admin.ID, Filters: tt.filters, Sort: "permission_name", Envelope: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, rp := range page.Items {
+			got = append(got, rp.PermissionName)
+		}
+		if !slices.Equal(got, tt.want) || page.Total == nil || *page.Total != int64(len(tt.want)) {
+			t.Errorf("grants with filters %v = %v, total %v, want %v", tt.filters, got, page.Total, tt.want)
+		}
+	}
+	if _, err := svc.RolePermissions(f, RolePermissionQuery{RoleID: uuid.New().String()}); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("grants of a missing role: got %v, want ErrRoleNotFound", err)
+	}
+
+	// Deleting the user who granted a permission keeps the grant.
+	if _, err := s.Users().Delete(ctx, []string{alice.ID}); err != nil {
+		t.Fatal(err)
+	}
+	grants, err = s.RolePermissions().List(ctx, RolePermissionQuery{RoleID: admin.ID, Detailed: true}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 2 || grants[1].Meta == nil || grants[1].Meta.CreatedByID != nil {
+		t.Errorf("grants of admin after deleting alice = %+v, want users.write without creator", grants)
+	}
+}
+
+func testStoreUserRoles(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice := createTestUser(t, s, "alice")
+	bob := createTestUser(t, s, "bob")
+	admin := createTestRole(t, s, "admin", nil)
+	editor := createTestRole(t, s, "editor", nil)
+	if _, err := s.Roles().Assign(ctx, alice.ID, []string{admin.ID, editor.ID}, testNow, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Roles().Assign(ctx, bob.ID, []string{editor.ID}, testNow.Add(time.Minute), &alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	p := testListParams(userRoleKeyset, []sqlutil.SortKey{{Name: "username", Column: "u.username"}})
+	members, err := s.UserRoles().List(ctx, UserRoleQuery{RoleName: "editor", Detailed: true}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].Username != "alice" || members[1].Username != "bob" {
+		t.Fatalf("members of editor = %+v, want alice and bob", members)
+	}
+	got := members[1]
+	if got.UserID != bob.ID || got.Email != "bob@example.com" || got.RoleID != editor.ID || got.RoleName != "editor" || !got.CreatedAt.Equal(testNow.Add(time.Minute)) {
+		t.Errorf("assignment of bob = %+v", got)
+	}
+	if meta := got.Meta; meta == nil || meta.CreatedByUsername == nil || *meta.CreatedByUsername != "alice" {
+		t.Errorf("Meta = %+v, want created by alice", meta)
+	}
+	if meta := members[0].Meta; meta == nil || meta.CreatedByID != nil {
+		t.Errorf("Meta = %+v, want no creator", meta)
+	}
+
+	for _, tt := range []struct {
+		q    UserRoleQuery
+		want int64
+	}{
+		{UserRoleQuery{UserID: alice.ID}, 2},
+		{UserRoleQuery{RoleID: admin.ID}, 1},
+		{UserRoleQuery{UserID: bob.ID, RoleName: "admin"}, 0},
+	} {
+		if n, _, err := s.UserRoles().Count(ctx, tt.q, ListParams{}); err != nil || n != tt.want {
+			t.Errorf("Count(%+v) = %d, %v, want %d", tt.q, n, err, tt.want)
+		}
+	}
+
+	for name, want := range map[string]bool{"admin": true, "editor": true, "other": false} {
+		if held, err := s.UserRoles().Held(ctx, name); err != nil || held != want {
+			t.Errorf("Held(%q) = %v, %v, want %v", name, held, err, want)
+		}
+	}
+
+	// Deleting the user who assigned a role keeps the assignment.
+	if _, err := s.Users().Delete(ctx, []string{alice.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := s.UserRoles().Held(ctx, "admin"); err != nil || held {
+		t.Errorf("Held(admin) after deleting alice = %v, %v, want false", held, err)
+	}
+	members, err = s.UserRoles().List(ctx, UserRoleQuery{RoleID: editor.ID, Detailed: true}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Meta == nil || members[0].Meta.CreatedByID != nil {
+		t.Errorf("members of editor after deleting alice = %+v, want bob without creator", members)
+	}
+}
+
+func testStoreSessionRoles(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice := createTestUser(t, s, "alice")
+	rbac := createTestRBAC(t, s, alice.ID)
+	admin, editor := rbac.admin, rbac.editor
+	other := createTestRole(t, s, "other", nil)
+	sess, err := createTestSession(s, alice.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grants := func() []string {
+		t.Helper()
+		gs, err := s.Sessions().Grants(ctx, sess.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res []string
+		for _, g := range gs {
+			res = append(res, g.PermissionName+"/"+g.RoleName)
+		}
+		return res
+	}
+	if got := grants(); len(got) != 0 {
+		t.Errorf("grants without active roles = %v, want none", got)
+	}
+
+	if _, err := s.Sessions().ActivateRoles(ctx, uuid.New().String(), nil); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("activating roles of a missing session: got %v, want ErrSessionNotFound", err)
+	}
+	if _, err := s.Sessions().ActivateRoles(ctx, sess.ID, []string{editor.ID, other.ID}); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("activating a role not held: got %v, want ErrRoleNotFound", err)
+	}
+	if n, err := s.Sessions().ActivateRoles(ctx, sess.ID, []string{editor.ID}); err != nil || n != 1 {
+		t.Errorf("ActivateRoles(editor) = %d, %v, want 1", n, err)
+	}
+	if got, want := grants(), []string{"posts.read/editor"}; !slices.Equal(got, want) {
+		t.Errorf("grants = %v, want %v", got, want)
+	}
+	if n, err := s.Sessions().ActivateRoles(ctx, sess.ID, nil); err != nil || n != 1 {
+		t.Errorf("ActivateRoles(nil) = %d, %v, want 1", n, err)
+	}
+	if got, want := grants(), []string{"posts.read/admin", "posts.read/editor", "posts.write/admin"}; !slices.Equal(got, want) {
+		t.Errorf("grants = %v, want %v", got, want)
+	}
+
+	if n, err := s.Sessions().DropRoles(ctx, sess.ID, []string{admin.ID, other.ID}); err != nil || n != 1 {
+		t.Errorf("DropRoles(admin, other) = %d, %v, want 1", n, err)
+	}
+	if got, want := grants(), []string{"posts.read/editor"}; !slices.Equal(got, want) {
+		t.Errorf("grants after dropping admin = %v, want %v", got, want)
+	}
+
+	// Unassigned roles stop granting permissions even while active.
+	if _, err := s.Roles().Unassign(ctx, alice.ID, []string{editor.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if got := grants(); len(got) != 0 {
+		t.Errorf("grants after unassigning editor = %v, want none", got)
+	}
+
+	// The grants of a user come from all of the roles assigned to them.
+	userGrants, err := s.UserRoles().Grants(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userGrants) != 2 || userGrants[0] != (RoleGrant{admin.ID, "admin", rbac.read.ID, "posts.read"}) || userGrants[1].PermissionName != "posts.write" {
+		t.Errorf("grants of alice = %+v, want posts.read and posts.write by admin", userGrants)
+	}
+}
+
+func testStoreBans(t *testing.T, s Store) {
+	ctx := context.Background()
+	u := createTestUser(t, s, "alice")
+
+	ban := Ban{UserID: u.ID, Reason: "spam", BannedAt: testNow, UnbannedAt: testNow.Add(time.Hour)}
+	missing := ban
+	missing.UserID = uuid.New().String()
+	if err := s.Bans().Put(ctx, missing, nil); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("banning a missing user: got %v, want ErrUserNotFound", err)
+	}
+	if err := s.Bans().Put(ctx, ban, &u.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		at   time.Time
+		want bool
+	}{
+		{testNow, true},
+		{ban.UnbannedAt, false},
+	} {
+		active, err := s.Bans().Active(ctx, u.ID, tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active != tt.want {
+			t.Errorf("Active at %v = %v, want %v", tt.at, active, tt.want)
+		}
+	}
+
+	// Bans with equal start and end times are permanent.
+	ban.Reason = "abuse"
+	ban.UnbannedAt = ban.BannedAt
+	if err := s.Bans().Put(ctx, ban, nil); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := s.Bans().Active(ctx, u.ID, testNow.Add(24*time.Hour)); !active {
+		t.Error("permanent ban is not active")
+	}
+	bans, err := s.Bans().List(ctx, BanQuery{Detailed: true}, testListParams(banKeyset, defaultBanSort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 1 || bans[0].Reason != "abuse" || bans[0].Meta == nil || bans[0].Meta.BannedByID != nil {
+		t.Errorf("List = %+v, want the replacing ban", bans)
+	}
+
+	if err := s.Bans().Delete(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Bans().Delete(ctx, u.ID); !errors.Is(err, ErrBanNotFound) {
+		t.Errorf("deleting a missing ban: got %v, want ErrBanNotFound", err)
+	}
+
+	// Permanent bans are never purged.
+	other := createTestUser(t, s, "bob")
+	for _, b := range []Ban{
+		{UserID: u.ID, Reason: "spam", BannedAt: testNow, UnbannedAt: testNow.Add(time.Hour)},
+		{UserID: other.ID, Reason: "abuse", BannedAt: testNow, UnbannedAt: testNow},
+	} {
+		if err := s.Bans().Put(ctx, b, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := s.Bans().Purge(ctx, testNow.Add(24*time.Hour)); err != nil || n != 1 {
+		t.Errorf("Purge = %d, %v, want 1", n, err)
+	}
+	if active, _ := s.Bans().Active(ctx, other.ID, testNow.Add(24*time.Hour)); !active {
+		t.Error("permanent ban was purged")
+	}
+}
+
+func testStoreDeleteUsers(t *testing.T, s Store) {
+	ctx := context.Background()
+	u := createTestUser(t, s, "alice")
+	other := createTestUser(t, s, "bob")
+	role := createTestRole(t, s, "admin", &u.ID)
+
+	sess, err := createTestSession(s, u.ID, maxSessionsPerUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := Token{ID: mustGenerateToken(defaultTokenLength), UserID: u.ID, Kind: tokenKindVerifyUser, CreatedAt: testNow, ExpiresAt: testNow.Add(time.Hour)}
+	if err := s.Tokens().Save(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Bans().Put(ctx, Ban{UserID: u.ID, Reason: "spam", BannedAt: testNow, UnbannedAt: testNow}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Bans().Put(ctx, Ban{UserID: other.ID, Reason: "spam", BannedAt: testNow, UnbannedAt: testNow}, &u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Roles().Assign(ctx, u.ID, []string{role.ID}, testNow, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := s.Users().Delete(ctx, []string{u.ID, uuid.New().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []string{u.ID}) {
+		t.Errorf("Delete = %v, want [%s]", ids, u.ID)
+	}
+
+	if _, err := s.Users().Get(ctx, u.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("getting a deleted user: got %v, want ErrUserNotFound", err)
+	}
+	if _, err := s.Sessions().Authenticate(ctx, sess.Token, testNow); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("authenticating a session of a deleted user: got %v, want ErrSessionNotFound", err)
+	}
+	if _, err := s.Tokens().Get(ctx, token.ID, token.Kind, testNow); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("getting a token of a deleted user: got %v, want ErrInvalidToken", err)
+	}
+	if active, _ := s.Bans().Active(ctx, u.ID, testNow); active {
+		t.Error("ban of a deleted user is active")
+	}
+	if n, _ := s.Roles().Unassign(ctx, u.ID, []string{role.ID}); n != 0 {
+		t.Error("role of a deleted user is still assigned")
+	}
+
+	// References to the deleted user are cleared.
+	roles, err := s.Roles().List(ctx, RoleQuery{Detailed: true}, testListParams(roleKeyset, defaultRoleSort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 || roles[0].Meta.CreatedByID != nil {
+		t.Errorf("roles = %+v, want the role without creator", roles)
+	}
+	bans, err := s.Bans().List(ctx, BanQuery{Detailed: true}, testListParams(banKeyset, defaultBanSort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 1 || bans[0].UserID != other.ID || bans[0].Meta.BannedByID != nil {
+		t.Errorf("bans = %+v, want the ban of bob without banning user", bans)
+	}
+}
+
+func testStoreList(t *testing.T, s Store) {
+	svc := NewService(s, &Config{})
+	f := &flux.Flow{Ctx: context.Background(), Time: testNow}
+
+	names := []string{"ann", "anna", "bob", "carl", "cora"}
+	for i, name := range names {
+		u := createTestUser(t, s, name)
+		at := testNow.Add(time.Duration(i) * time.Minute)
+		if i%2 == 0 {
+			if err := s.Users().Update(f.Ctx, u.ID, UserUpdate{LastLoginAt: &at}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	page, err := svc.Users(f, UserQuery{
+		Filters:  []sqlutil.Filter{{Field: "username", Op: sqlutil.OpPrefix, Value: "an"}},
+		Sort:     "username",
+		Envelope: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := usernames(page.Items); !slices.Equal(got, []string{"ann", "anna"}) || page.Total == nil || *page.Total != 2 {
+		t.Errorf("prefix filter = %v, total %v, want [ann anna], 2", got, page.Total)
+	}
+
+	page, err = svc.Users(f, UserQuery{
+		Filters: []sqlutil.Filter{{Field: "last_login_at", Op: sqlutil.OpIsNull, Value: true}},
+		Sort:    "username,desc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := usernames(page.Items); !slices.Equal(got, []string{"carl", "anna"}) {
+		t.Errorf("is_null filter = %v, want [carl anna]", got)
+	}
+
+	// Nulls are last in ascending order.
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(names) {
+			t.Fatal("pagination does not end")
+		}
+		c := cursor
+		page, err := svc.Users(f, UserQuery{Sort: "last_login_at,username", Limit: 2, Cursor: &c})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, usernames(page.Items)...)
+		if page.NextCursor == nil {
+			break
+		}
+		cursor = *page.NextCursor
+	}
+	if want := []string{"ann", "bob", "cora", "anna", "carl"}; !slices.Equal(got, want) {
+		t.Errorf("pages = %v, want %v", got, want)
+	}
+
+	page, err = svc.Users(f, UserQuery{Sort: "username", Limit: 2, Offset: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := usernames(page.Items); !slices.Equal(got, []string{"carl", "cora"}) || page.HasMore {
+		t.Errorf("offset page = %v, has more %v, want [carl cora], false", got, page.HasMore)
+	}
+}
+
+func testStoreTx(t *testing.T, s Store) {
+	ctx := context.Background()
+	errRollback := errors.New("rollback")
+
+	err := s.Tx(ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		createTestUser(t, tx, "alice")
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Tx = %v, want errRollback", err)
+	}
+	if _, err := s.Users().GetByUsername(ctx, "alice"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("user of a rolled back transaction: got %v, want ErrUserNotFound", err)
+	}
+
+	err = s.Tx(ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		createTestUser(t, tx, "bob")
+		// A failed nested transaction leaves the outer one usable.
+		err := tx.Tx(ctx, sqlutil.TxOptions{}, func(tx Store) error {
+			createTestUser(t, tx, "carl")
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Errorf("nested Tx = %v, want errRollback", err)
+		}
+		if err := tx.Users().Create(ctx, User{ID: uuid.New().String(), Username: "bob", CreatedAt: testNow}); !errors.Is(err, ErrUserExists) {
+			t.Errorf("creating a duplicate user in a transaction: got %v, want ErrUserExists", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Users().GetByUsername(ctx, "bob"); err != nil {
+		t.Errorf("user of a committed transaction: %v", err)
+	}
+	if _, err := s.Users().GetByUsername(ctx, "carl"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("user of a rolled back nested transaction: got %v, want ErrUserNotFound", err)
+	}
+}
+
+func testStoreOutbox(t *testing.T, s Store) {
+	ctx := context.Background()
+	actor := uuid.New().String()
+	userA, userB := uuid.New().String(), uuid.New().String()
+	newEvent := func(aggregateID string) Event {
+		return Event{
+			ID:          uuid.New().String(),
+			Type:        EventUserCreated,
+			AggregateID: aggregateID,
+			ActorID:     &actor,
+			Payload:     json.RawMessage(`{"user_id":"` + aggregateID + `"}`),
+			CreatedAt:   testNow,
+		}
+	}
+	a1, a2, b1 := newEvent(userA), newEvent(userA), newEvent(userB)
+	if err := s.Outbox().Add(ctx, a1, a2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Outbox().Add(ctx, b1); err != nil {
+		t.Fatal(err)
+	}
+
+	claim := func(now time.Time) []string {
+		t.Helper()
+		events, err := s.Outbox().Claim(ctx, 10, now, now.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(events))
+		for i, e := range events {
+			ids[i] = e.ID
+		}
+		return ids
+	}
+
+	// Only the oldest event of an aggregate is claimed.
+	events, err := s.Outbox().Claim(ctx, 10, testNow, testNow.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].ID != a1.ID || events[1].ID != b1.ID {
+		t.Fatalf("Claim = %v, want [a1 b1]", events)
+	}
+	if e := events[0]; e.Type != a1.Type || e.AggregateID != userA || e.ActorID == nil || *e.ActorID != actor ||
+		string(e.Payload) != string(a1.Payload) || !e.CreatedAt.Equal(testNow) || e.Attempts != 0 {
+		t.Errorf("claimed event = %+v, want %+v", e, a1)
+	}
+	// Leased events are not claimed again until their lease expires.
+	if ids := claim(testNow.Add(time.Second)); len(ids) != 0 {
+		t.Errorf("Claim of leased events = %v, want none", ids)
+	}
+
+	// A failed event is retried at its next attempt and holds back the
+	// following events of its aggregate.
+	next := testNow.Add(10 * time.Minute)
+	if err := s.Outbox().Failed(ctx, a1.ID, "unavailable", &next); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Outbox().Delivered(ctx, b1.ID, testNow); err != nil {
+		t.Fatal(err)
+	}
+	if ids := claim(testNow.Add(5 * time.Minute)); len(ids) != 0 {
+		t.Errorf("Claim before the next attempt = %v, want none", ids)
+	}
+	events, err = s.Outbox().Claim(ctx, 10, next, next.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ID != a1.ID || events[0].Attempts != 1 {
+		t.Fatalf("Claim at the next attempt = %+v, want a1 with 1 attempt", events)
+	}
+	if err := s.Outbox().Delivered(ctx, a1.ID, next); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dead events are not claimed.
+	if ids := claim(next); !slices.Equal(ids, []string{a2.ID}) {
+		t.Fatalf("Claim after delivering a1 = %v, want [a2]", ids)
+	}
+	if err := s.Outbox().Failed(ctx, a2.ID, "rejected", nil); err != nil {
+		t.Fatal(err)
+	}
+	if ids := claim(next.Add(time.Hour)); len(ids) != 0 {
+		t.Errorf("Claim after a2 died = %v, want none", ids)
+	}
+
+	if err := s.Outbox().Delivered(ctx, uuid.New().String(), testNow); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("delivering a missing event: got %v, want ErrEventNotFound", err)
+	}
+
+	// Purge deletes the events delivered before a time.
+	if n, err := s.Outbox().Purge(ctx, testNow.Add(time.Minute)); err != nil || n != 1 {
+		t.Errorf("Purge = %d, %v, want 1", n, err)
+	}
+	if n, err := s.Outbox().Purge(ctx, next.Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("second Purge = %d, %v, want 1", n, err)
+	}
+}
+
+func testStoreWebhooks(t *testing.T, s Store) {
+	ctx := context.Background()
+	newWebhook := func(url string, events ...string) Webhook {
+		t.Helper()
+		w := Webhook{
+			ID:        uuid.New().String(),
+			URL:       url,
+			Secret:    "0123456789abcdef",
+			Events:    events,
+			Enabled:   true,
+			CreatedAt: testNow,
+		}
+		if err := s.Webhooks().Create(ctx, w); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	all := newWebhook("https://example.com/all", WebhookAllEvents)
+	users := newWebhook("https://example.com/users", EventUserCreated, EventUserDeleted)
+	roles := newWebhook("https://example.com/roles", EventRoleCreated)
+
+	got, err := s.Webhooks().Get(ctx, users.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.URL != users.URL || got.Secret != users.Secret || !slices.Equal(got.Events, users.Events) || !got.Enabled || !got.CreatedAt.Equal(testNow) {
+		t.Errorf("Get = %+v, want %+v", got, users)
+	}
+	if _, err := s.Webhooks().Get(ctx, uuid.New().String()); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Get of a missing webhook: got %v, want ErrWebhookNotFound", err)
+	}
+	webhooks, err := s.Webhooks().List(ctx, WebhookQuery{}, testListParams(webhookKeyset, defaultWebhookSort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 3 || webhooks[0].Secret != "" {
+		t.Errorf("List = %+v, want 3 webhooks without secrets", webhooks)
+	}
+
+	subscribers := func(typ string) []string {
+		t.Helper()
+		ids, err := s.Webhooks().Subscribers(ctx, typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	want := []string{all.ID, users.ID}
+	slices.Sort(want)
+	if ids := subscribers(EventUserCreated); !slices.Equal(ids, want) {
+		t.Errorf("Subscribers(%s) = %v, want %v", EventUserCreated, ids, want)
+	}
+
+	// Disabled webhooks have no subscriptions and their deliveries are not
+	// claimed.
+	disabled := false
+	if err := s.Webhooks().Update(ctx, roles.ID, WebhookUpdate{Enabled: &disabled}, testNow); err != nil {
+		t.Fatal(err)
+	}
+	if ids := subscribers(EventRoleCreated); !slices.Equal(ids, []string{all.ID}) {
+		t.Errorf("Subscribers(%s) with a disabled webhook = %v, want [all]", EventRoleCreated, ids)
+	}
+	if got, err := s.Webhooks().Get(ctx, roles.ID); err != nil || got.Enabled || got.DisabledAt == nil {
+		t.Errorf("Get of a disabled webhook = %+v, %v", got, err)
+	}
+
+	eventID := uuid.New().String()
+	newDelivery := func(webhookID string) WebhookDelivery {
+		return WebhookDelivery{
+			ID:        uuid.New().String(),
+			WebhookID: webhookID,
+			EventID:   eventID,
+			EventType: EventUserCreated,
+			CreatedAt: testNow,
+			Payload:   []byte(`{"id":"` + eventID + `"}`),
+		}
+	}
+	dAll, dUsers, dRoles := newDelivery(all.ID), newDelivery(users.ID), newDelivery(roles.ID)
+	if err := s.WebhookDeliveries().Add(ctx, dAll, dUsers, dRoles); err != nil {
+		t.Fatal(err)
+	}
+	// Deliveries of an event to the same webhook are skipped.
+	if err := s.WebhookDeliveries().Add(ctx, newDelivery(all.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WebhookDeliveries().Add(ctx, newDelivery(uuid.New().String())); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Add to a missing webhook: got %v, want ErrWebhookNotFound", err)
+	}
+
+	claim := func(now time.Time) []ClaimedDelivery {
+		t.Helper()
+		claimed, err := s.WebhookDeliveries().Claim(ctx, 10, now, now.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claimed
+	}
+	claimed := claim(testNow)
+	if len(claimed) != 2 {
+		t.Fatalf("Claim = %+v, want the deliveries of the enabled webhooks", claimed)
+	}
+	for _, d := range claimed {
+		w := all
+		if d.WebhookID == users.ID {
+			w = users
+		}
+		if d.URL != w.URL || d.Secret != w.Secret || d.EventID != eventID || string(d.Payload) != string(dAll.Payload) || d.Attempts != 0 {
+			t.Errorf("claimed delivery = %+v", d)
+		}
+	}
+	if claimed := claim(testNow.Add(time.Second)); len(claimed) != 0 {
+		t.Errorf("Claim of leased deliveries = %+v, want none", claimed)
+	}
+
+	// A failed attempt is retried at next; a successful one delivers.
+	status := 500
+	reason := "unexpected status 500"
+	next := testNow.Add(10 * time.Minute)
+	if err := s.WebhookDeliveries().Attempted(ctx, dAll.ID, DeliveryAttempt{At: testNow, StatusCode: &status, Error: &reason, Duration: time.Second}, &next); err != nil {
+		t.Fatal(err)
+	}
+	ok := 200
+	if err := s.WebhookDeliveries().Attempted(ctx, dUsers.ID, DeliveryAttempt{At: testNow, OK: true, StatusCode: &ok}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if claimed := claim(testNow.Add(5 * time.Minute)); len(claimed) != 0 {
+		t.Errorf("Claim before the next attempt = %+v, want none", claimed)
+	}
+	claimed = claim(next)
+	if len(claimed) != 1 || claimed[0].ID != dAll.ID || claimed[0].Attempts != 1 {
+		t.Fatalf("Claim at the next attempt = %+v, want dAll with 1 attempt", claimed)
+	}
+	if err := s.WebhookDeliveries().Attempted(ctx, dAll.ID, DeliveryAttempt{At: next, Error: &reason}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if claimed := claim(next.Add(time.Hour)); len(claimed) != 0 {
+		t.Errorf("Claim after dAll failed = %+v, want none", claimed)
+	}
+
+	deliveries, err := s.WebhookDeliveries().List(ctx, WebhookDeliveryQuery{WebhookID: all.ID}, testListParams(deliveryKeyset, defaultDeliverySort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("List = %+v, want dAll", deliveries)
+	}
+	if d := deliveries[0]; d.State != DeliveryFailed || d.Attempts != 2 || d.NextAttemptAt != nil || d.Error == nil || *d.Error != reason ||
+		d.LastAttemptAt == nil || !d.LastAttemptAt.Equal(next) || d.Payload != nil {
+		t.Errorf("failed delivery = %+v", d)
+	}
+	failed, err := s.WebhookDeliveries().List(ctx, WebhookDeliveryQuery{WebhookID: users.ID, State: DeliveryFailed}, testListParams(deliveryKeyset, defaultDeliverySort))
+	if err != nil || len(failed) != 0 {
+		t.Errorf("List of failed deliveries of users = %+v, %v, want none", failed, err)
+	}
+
+	// Redelivered deliveries are claimed again without attempts.
+	webhookID, err := s.WebhookDeliveries().Redeliver(ctx, dAll.ID, next.Add(time.Hour))
+	if err != nil || webhookID != all.ID {
+		t.Fatalf("Redeliver = %q, %v, want %q", webhookID, err, all.ID)
+	}
+	if claimed := claim(next.Add(time.Hour)); len(claimed) != 1 || claimed[0].ID != dAll.ID || claimed[0].Attempts != 0 {
+		t.Errorf("Claim after Redeliver = %+v, want dAll without attempts", claimed)
+	}
+	if _, err := s.WebhookDeliveries().Redeliver(ctx, uuid.New().String(), testNow); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("Redeliver of a missing delivery: got %v, want ErrDeliveryNotFound", err)
+	}
+
+	// Report disables webhooks after maxFailures consecutive failures.
+	for i, want := range []bool{false, false, true, false} {
+		if i == 1 {
+			if disabled, err := s.Webhooks().Report(ctx, users.ID, true, testNow, 2); err != nil || disabled {
+				t.Fatalf("Report of a success = %v, %v", disabled, err)
+			}
+		}
+		disabled, err := s.Webhooks().Report(ctx, users.ID, false, testNow, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if disabled != want {
+			t.Errorf("Report of failure %d = %v, want %v", i+1, disabled, want)
+		}
+	}
+	if got, err := s.Webhooks().Get(ctx, users.ID); err != nil || got.Enabled || got.Failures != 3 || got.DisabledAt == nil {
+		t.Errorf("Get of an auto-disabled webhook = %+v, %v", got, err)
+	}
+	// Enabling a webhook resets its failures.
+	enabled := true
+	if err := s.Webhooks().Update(ctx, users.ID, WebhookUpdate{Enabled: &enabled, Events: []string{EventUserDeleted}}, testNow); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Webhooks().Get(ctx, users.ID); err != nil || !got.Enabled || got.Failures != 0 || got.DisabledAt != nil ||
+		!slices.Equal(got.Events, []string{EventUserDeleted}) {
+		t.Errorf("Get of a re-enabled webhook = %+v, %v", got, err)
+	}
+	if err := s.Webhooks().Update(ctx, uuid.New().String(), WebhookUpdate{Enabled: &enabled}, testNow); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Update of a missing webhook: got %v, want ErrWebhookNotFound", err)
+	}
+
+	// Deleting webhooks deletes their deliveries.
+	if n, err := s.Webhooks().Delete(ctx, []string{all.ID, uuid.New().String()}); err != nil || n != 1 {
+		t.Errorf("Delete = %d, %v, want 1", n, err)
+	}
+	if _, err := s.WebhookDeliveries().Redeliver(ctx, dAll.ID, testNow); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("Redeliver of a deleted webhook's delivery: got %v, want ErrDeliveryNotFound", err)
+	}
+}
+
+// testListParams returns the params listing the first rows ordered by def.
+func testListParams(k sqlutil.Keyset, def []sqlutil.SortKey) ListParams {
+	k.Keys = def
+	return ListParams{Keyset: k, Limit: 100, Now: testNow}
+}
+
+func usernames(users []User) []string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = u.Username
+	}
+	return names
+}
+
+func testStoreEmails(t *testing.T, s Store) {
+	ctx := context.Background()
+	u := createTestUser(t, s, "alice")
+
+	missing := uuid.New().String()
+	if err := s.Emails().Add(ctx, Email{ID: uuid.New().String(), UserID: &missing, Kind: EmailVerifyUser, To: "x@example.com", CreatedAt: testNow}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("adding an email of a missing user: got %v, want ErrUserNotFound", err)
+	}
+	verify := Email{ID: uuid.New().String(), UserID: &u.ID, Kind: EmailVerifyUser, To: u.Email, Subject: "Verify", Text: "token", HTML: "<p>token</p>", CreatedAt: testNow}
+	forgot := Email{ID: uuid.New().String(), Kind: EmailForgotUsername, To: u.Email, Subject: "Usernames", Text: "alice", CreatedAt: testNow.Add(time.Second)}
+	if err := s.Emails().Add(ctx, verify, forgot); err != nil {
+		t.Fatal(err)
+	}
+
+	claim := func(now time.Time) []Email {
+		t.Helper()
+		claimed, err := s.Emails().Claim(ctx, 10, now, now.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claimed
+	}
+	claimed := claim(testNow)
+	if len(claimed) != 1 || claimed[0].ID != verify.ID || claimed[0].Text != verify.Text || claimed[0].HTML != verify.HTML ||
+		claimed[0].UserID == nil || *claimed[0].UserID != u.ID {
+		t.Fatalf("Claim = %+v, want the due email with its bodies", claimed)
+	}
+	if claimed := claim(testNow.Add(time.Second)); len(claimed) != 1 || claimed[0].ID != forgot.ID {
+		t.Errorf("Claim of leased emails = %+v, want only forgot", claimed)
+	}
+
+	// A failed attempt is retried at next; a sent email loses its bodies.
+	reason := "connection refused"
+	next := testNow.Add(10 * time.Minute)
+	if err := s.Emails().Attempted(ctx, verify.ID, testNow, &reason, &next); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Emails().Attempted(ctx, forgot.ID, testNow, &reason, nil); err != nil {
+		t.Fatal(err)
+	}
+	if claimed := claim(testNow.Add(5 * time.Minute)); len(claimed) != 0 {
+		t.Errorf("Claim before the next attempt = %+v, want none", claimed)
+	}
+	if claimed := claim(next); len(claimed) != 1 || claimed[0].ID != verify.ID || claimed[0].Attempts != 1 {
+		t.Fatalf("Claim at the next attempt = %+v, want verify with 1 attempt", claimed)
+	}
+	if err := s.Emails().Attempted(ctx, verify.ID, next, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Emails().Attempted(ctx, uuid.New().String(), next, nil, nil); !errors.Is(err, ErrEmailNotFound) {
+		t.Errorf("Attempted of a missing email: got %v, want ErrEmailNotFound", err)
+	}
+
+	emails, err := s.Emails().List(ctx, EmailQuery{UserID: u.ID}, testListParams(emailKeyset, defaultEmailSort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 1 {
+		t.Fatalf("List of the emails of alice = %+v, want verify", emails)
+	}
+	if e := emails[0]; e.State != EmailSent || e.Attempts != 2 || e.SentAt == nil || !e.SentAt.Equal(next) || e.NextAttemptAt != nil || e.Text != "" || e.To != u.Email {
+		t.Errorf("sent email = %+v", e)
+	}
+	failed, err := s.Emails().List(ctx, EmailQuery{State: EmailFailed}, testListParams(emailKeyset, defaultEmailSort))
+	if err != nil || len(failed) != 1 || failed[0].ID != forgot.ID || failed[0].Error == nil || *failed[0].Error != reason {
+		t.Errorf("List of failed emails = %+v, %v, want forgot", failed, err)
+	}
+
+	// Deleting a user deletes its emails.
+	if _, err := s.Users().Delete(ctx, []string{u.ID}); err != nil {
+		t.Fatal(err)
+	}
+	emails, err = s.Emails().List(ctx, EmailQuery{}, testListParams(emailKeyset, defaultEmailSort))
+	if err != nil || len(emails) != 1 || emails[0].ID != forgot.ID {
+		t.Errorf("List after deleting alice = %+v, %v, want forgot", emails, err)
+	}
+}
@@ -1,26 +1,18 @@
 package auth
 
 import (
-	"context"
 	"crypto/rand"
-	"errors"
 	"fmt"
-	"glut/common/flux"
 	"time"
-
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/stephenafamo/bob/dialect/psql"
-	"github.com/stephenafamo/bob/dialect/psql/dm"
-	"github.com/stephenafamo/bob/dialect/psql/im"
-	"github.com/stephenafamo/bob/dialect/psql/sm"
 )
 
 const (
 	tokenKindVerifyUser    = "verify_user"
 	tokenKindResetPassword = "reset_password"
 	tokenKindChangeEmail   = "change_email"
+	tokenKindRevertEmail   = "revert_email"
 	tokenMetaNewEmail      = "new_email"
+	tokenMetaOldEmail      = "old_email"
 )
 
 type Token struct {
@@ -42,73 +34,12 @@ func (t *Token) Get(key string) string {
 
 func (s *Service) createUserVerificationToken(userID string, now time.Time) Token {
 	return Token{
-		ID:        mustGenerateToken(s.cfg.TokenLength),
+		ID:        mustGenerateToken(s.config().TokenLength),
 		UserID:    userID,
 		Kind:      tokenKindVerifyUser,
 		CreatedAt: now,
-		ExpiresAt: now.Add(s.cfg.VerificationTokenDuration),
-	}
-}
-
-func getToken(f *flux.Flow, tx pgx.Tx, id, kind string) (Token, error) {
-	sql, args := psql.Select(
-		sm.From("auth.tokens"),
-		sm.Columns("user_id", "meta", "created_at", "expires_at"),
-		psql.WhereAnd(
-			sm.Where(psql.Quote("id").EQ(psql.Arg(id))),
-			sm.Where(psql.Quote("kind").EQ(psql.Arg(kind))),
-			sm.Where(psql.Quote("expires_at").GT(psql.Arg(f.Time))),
-		),
-		sm.ForNoKeyUpdate(),
-	).MustBuild()
-
-	var userID string
-	var meta pgtype.Hstore
-	var createdAt time.Time
-	var expiresAt time.Time
-	if err := tx.QueryRow(f.Ctx, sql, args...).Scan(&userID, &meta, &createdAt, &expiresAt); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return Token{}, ErrInvalidToken
-		}
-		return Token{}, fmt.Errorf("auth.getToken: %w", err)
-	}
-
-	token := Token{
-		ID:        id,
-		UserID:    userID,
-		Kind:      kind,
-		CreatedAt: createdAt,
-		ExpiresAt: expiresAt,
-		Meta:      meta,
-	}
-	return token, nil
-}
-
-func saveToken(f *flux.Flow, tx pgx.Tx, token Token) error {
-	sql, args := psql.Insert(
-		im.Into("auth.tokens",
-			"id", "user_id", "kind", "created_at", "expires_at", "meta",
-		),
-		im.Values(psql.Arg(token.ID, token.UserID, token.Kind, token.CreatedAt, token.ExpiresAt, token.Meta)),
-		im.OnConflict("user_id", "kind").DoUpdate().SetExcluded("id", "created_at", "expires_at", "meta"),
-	).MustBuild()
-
-	if _, err := tx.Exec(f.Ctx, sql, args...); err != nil {
-		return fmt.Errorf("auth.saveToken: %w", err)
-	}
-	return nil
-}
-
-func deleteToken(ctx context.Context, tx pgx.Tx, id string) error {
-	sql, args := psql.Delete(
-		dm.From("auth.tokens"),
-		dm.Where(psql.Quote("id").EQ(psql.Arg(id))),
-	).MustBuild()
-
-	if _, err := tx.Exec(ctx, sql, args...); err != nil {
-		return fmt.Errorf("auth.deleteToken: %w", err)
+		ExpiresAt: now.Add(s.config().VerificationTokenDuration),
 	}
-	return nil
 }
 
 const tokenChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
@@ -4,21 +4,14 @@ import (
 	"glut/common/flux"
 	"glut/common/sqlutil"
 	"glut/common/valid"
-	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/stephenafamo/bob/dialect/psql"
-	"github.com/stephenafamo/bob/dialect/psql/dm"
-	"github.com/stephenafamo/bob/dialect/psql/im"
-	"github.com/stephenafamo/bob/dialect/psql/sm"
 )
 
 const (
 	defaultUserQueryLimit = 20
 	maxUserQueryLimit     = 100
-	defaultUserSortBy     = "created_at"
-	defaultUserSortDir    = sqlutil.SortDirAsc
 )
 
 type User struct {
@@ -33,12 +26,15 @@ type User struct {
 }
 
 type UserQuery struct {
-	ID       string `json:"id"`
-	Limit    int    `json:"limit"`
-	Offset   int    `json:"offset"`
-	Sort     string `json:"sort"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
+	ID       string           `json:"id"`
+	Limit    int              `json:"limit"`
+	Offset   int              `json:"offset"`
+	Cursor   *string          `json:"cursor"`
+	Envelope bool             `json:"envelope"`
+	Sort     string           `json:"sort"`
+	Filters  []sqlutil.Filter `json:"filters"`
+	Email    string           `json:"email"`
+	Username string           `json:"username"`
 }
 
 type CreateUserInput struct {
@@ -51,43 +47,29 @@ type DeleteUsersInput struct {
 	IDs []string `json:"ids"`
 }
 
-var userSortByMap map[string]struct{} = map[string]struct{}{
-	"username":      {},
-	"email":         {},
-	"created_at":    {},
-	"last_login_at": {},
-}
-
-func getUserSort(value string) (string, string, bool) {
-	if value == "" {
-		return defaultUserSortBy, defaultUserSortDir, true
-	}
-	parts := strings.Split(value, ",")
-	if len(parts) != 2 {
-		return "", "", false
-	}
-	sortBy := parts[0]
-	sortDir := strings.ToUpper(parts[1])
-	if _, ok := userSortByMap[sortBy]; !ok {
-		return "", "", false
+// sortValue returns the value of u for the sort key name.
+func (u User) sortValue(name string) any {
+	switch name {
+	case "username":
+		return u.Username
+	case "email":
+		return u.Email
+	case "last_login_at":
+		return u.LastLoginAt
+	default:
+		return u.CreatedAt
 	}
-	if sortDir != sqlutil.SortDirAsc && sortDir != sqlutil.SortDirDesc {
-		return "", "", false
-	}
-	return sortBy, sortDir, true
 }
 
-func (s *Service) Users(f *flux.Flow, in UserQuery) ([]User, error) {
+func (s *Service) Users(f *flux.Flow, in UserQuery) (sqlutil.Page[User], error) {
 	var errs valid.Errors
 	if in.ID != "" && !valid.IsUUID(in.ID) {
-		errs = append(errs, valid.Error{Field: "id", Error: "Invalid id."})
-	}
-	sortBy, sortDir, ok := getUserSort(in.Sort)
-	if !ok {
-		errs = append(errs, valid.Error{Field: "sort", Error: "Invalid sort."})
+		errs = append(errs, valid.InvalidID("id"))
 	}
+	p, listErrs := s.listParams(userKeyset, userFields, defaultUserSort, in.Filters, in.Sort, in.Cursor, in.Offset)
+	errs = append(errs, listErrs...)
 	if len(errs) != 0 {
-		return nil, errs
+		return sqlutil.Page[User]{}, errs
 	}
 
 	if in.Limit <= 0 || in.Limit > maxUserQueryLimit {
@@ -96,126 +78,46 @@ func (s *Service) Users(f *flux.Flow, in UserQuery) ([]User, error) {
 	if in.Offset < 0 {
 		in.Offset = 0
 	}
+	p.Limit, p.Offset, p.Now = in.Limit, in.Offset, f.Time
 
-	q := psql.Select(
-		sm.Columns(
-			"id",
-			"username",
-			"email",
-			"created_at",
-			"last_login_at",
-			"last_login_ip",
-		),
-		sm.From("auth.users"),
-	)
-	if in.ID != "" {
-		q.Apply(
-			sm.Where(psql.Quote("id").EQ(psql.Arg(in.ID))),
-		)
-	}
-	if in.Email != "" {
-		q.Apply(
-			sm.Where(psql.Quote("email").ILike(
-				psql.Arg(sqlutil.Like(in.Email)),
-			)),
-		)
-	}
-	if in.Username != "" {
-		q.Apply(
-			sm.Where(psql.Quote("username").ILike(
-				psql.Arg(sqlutil.Like(in.Username)),
-			)),
-		)
-	}
-	if sortDir == sqlutil.SortDirAsc {
-		q.Apply(sm.OrderBy(sortBy).Asc())
-	} else {
-		q.Apply(sm.OrderBy(sortBy).Desc())
-	}
-	if in.Limit != 0 {
-		q.Apply(
-			sm.Limit(in.Limit),
-		)
+	page, err := list(f, s, s.store.Users(), in, p, in.Envelope, in.Cursor, func(u User) string { return u.ID }, User.sortValue)
+	if err != nil {
+		return sqlutil.Page[User]{}, err
 	}
-	if in.Offset != 0 {
-		q.Apply(
-			sm.Offset(in.Offset),
-		)
+	if in.ID != "" && len(page.Items) == 0 {
+		return sqlutil.Page[User]{}, ErrUserNotFound
 	}
-	sql, args := q.MustBuild()
+	return page, nil
+}
 
-	rows, err := s.db.Query(f.Ctx, sql, args...)
+// UserID returns the id of the user with exactly the given username. It fails
+// with ErrUserNotFound.
+func (s *Service) UserID(f *flux.Flow, username string) (string, error) {
+	user, err := s.store.Users().GetByUsername(f.Ctx, username)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer rows.Close()
-
-	users := []User{}
-	for rows.Next() {
-		var id string
-		var username string
-		var email string
-		var createdAt time.Time
-		var lastLoginAt *time.Time
-		var lastLoginIP *string
-
-		if err := rows.Scan(
-			&id,
-			&username,
-			&email,
-			&createdAt,
-			&lastLoginAt,
-			&lastLoginIP,
-		); err != nil {
-			return nil, err
-		}
-		users = append(users, User{
-			ID:          id,
-			Username:    username,
-			Email:       email,
-			CreatedAt:   createdAt,
-			LastLoginAt: lastLoginAt,
-			LastLoginIP: lastLoginIP,
-		})
-	}
-	if in.ID != "" && len(users) == 0 {
-		return nil, ErrUserNotFound
-	}
-	return users, nil
+	return user.ID, nil
 }
 
 func (s *Service) CreateUser(f *flux.Flow, in CreateUserInput) (User, error) {
 	var errs valid.Errors
 	if in.Username == "" {
-		errs = append(errs, valid.Error{Field: "username", Error: "Required."})
+		errs = append(errs, valid.Required("username"))
 	}
 	if in.Email == "" {
-		errs = append(errs, valid.Error{Field: "email", Error: "Required."})
+		errs = append(errs, valid.Required("email"))
 	}
 	if in.Password == "" {
-		errs = append(errs, valid.Error{Field: "password", Error: "Required."})
+		errs = append(errs, valid.Required("password"))
 	}
 	if len(errs) != 0 {
 		return User{}, errs
 	}
 
-	sql, args := psql.Select(
-		sm.Columns("id"),
-		sm.From("auth.users"),
-		sm.Where(psql.Quote("username").EQ(psql.Arg(in.Username))),
-	).MustBuild()
-
-	var userExists bool
-	if err := s.db.QueryRow(f.Ctx, sqlutil.Exists(sql), args...).Scan(&userExists); err != nil {
-		return User{}, err
-	}
-	if userExists {
-		return User{}, ErrUserExists
-	}
-
 	passwordHash, err := hashPassword(in.Password)
 	if err != nil {
-		return User{}, nil
+		return User{}, err
 	}
 	user := User{
 		ID:        uuid.New().String(),
@@ -225,31 +127,21 @@ func (s *Service) CreateUser(f *flux.Flow, in CreateUserInput) (User, error) {
 		CreatedAt: f.Time,
 	}
 
-	tx, err := s.db.Begin(f.Ctx)
-	if err != nil {
-		return User{}, err
-	}
-	defer tx.Rollback(f.Ctx)
-
-	sql, args = psql.Insert(
-		im.Into("auth.users",
-			"id", "username", "email", "password_hash", "created_at",
-		),
-		im.Values(psql.Arg(user.ID, user.Username, user.Email, user.Password, user.CreatedAt)),
-	).MustBuild()
-
-	if _, err := tx.Exec(f.Ctx, sql, args...); err != nil {
-		return User{}, err
-	}
-
-	token := s.createUserVerificationToken(f.Session.User, f.Time)
-	if err := saveToken(f, tx, token); err != nil {
-		return User{}, err
-	}
+	if err := s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		if err := tx.Users().Create(f.Ctx, user); err != nil {
+			return err
+		}
+		if err := emit(f, tx, EventUserCreated, user.ID, user); err != nil {
+			return err
+		}
 
-	// TODO: send user verification email with token
+		token := s.createUserVerificationToken(user.ID, f.Time)
+		if err := tx.Tokens().Save(f.Ctx, token); err != nil {
+			return err
+		}
 
-	if err := tx.Commit(f.Ctx); err != nil {
+		return s.queueEmail(f, tx, &user.ID, EmailVerifyUser, tokenEmailData(user, token))
+	}); err != nil {
 		return User{}, err
 	}
 	return user, nil
@@ -258,27 +150,35 @@ func (s *Service) CreateUser(f *flux.Flow, in CreateUserInput) (User, error) {
 func (s *Service) DeleteUsers(f *flux.Flow, in DeleteUsersInput) (int, error) {
 	var errs valid.Errors
 	if len(in.IDs) == 0 {
-		errs = append(errs, valid.Error{Field: "ids", Error: "Required."})
+		errs = append(errs, valid.Required("ids"))
 	}
 	if !valid.IsUUIDSlice(in.IDs) {
-		errs = append(errs, valid.Error{Field: "ids", Error: "Contains invalid id."})
+		errs = append(errs, valid.InvalidIDs("ids"))
 	}
 	if len(errs) != 0 {
 		return 0, errs
 	}
 
-	q := psql.Delete(dm.From("auth.users"))
-	if in.IDs != nil {
-		q.Apply(dm.Where(
-			psql.Quote("id").In(
-				psql.Arg(sqlutil.AnySlice(in.IDs)...)),
-		))
-	}
-	sql, args := q.MustBuild()
-
-	res, err := s.db.Exec(f.Ctx, sql, args...)
-	if err != nil {
+	var n int
+	if err := s.store.Tx(f.Ctx, protectedRolesTx, func(tx Store) error {
+		var ids []string
+		err := s.keepRoleHolders(f, tx, func() error {
+			var err error
+			ids, err = tx.Users().Delete(f.Ctx, in.IDs)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := emit(f, tx, EventUserDeleted, id, UserChange{UserID: id}); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	}); err != nil {
 		return 0, err
 	}
-	return int(res.RowsAffected()), nil
+	return n, nil
 }
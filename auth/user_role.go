@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"glut/common/flux"
+	"glut/common/sqlutil"
+	"glut/common/valid"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultUserRoleQueryLimit = 20
+	maxUserRoleQueryLimit     = 100
+)
+
+// UserRole is the assignment of a role to a user.
+type UserRole struct {
+	ID        string        `json:"id"`
+	UserID    string        `json:"user_id"`
+	Username  string        `json:"username"`
+	Email     string        `json:"email"`
+	RoleID    string        `json:"role_id"`
+	RoleName  string        `json:"role_name"`
+	CreatedAt time.Time     `json:"created_at"`
+	Meta      *UserRoleMeta `json:"meta,omitempty"`
+}
+
+// UserRoleMeta is the user who assigned a role.
+type UserRoleMeta struct {
+	CreatedByID       *string `json:"created_by_id"`
+	CreatedByUsername *string `json:"created_by_username"`
+	CreatedByEmail    *string `json:"created_by_email"`
+}
+
+type UserRoleQuery struct {
+	UserID string `json:"user_id"`
+	// RoleID or RoleName select the holders of a role.
+	RoleID   string           `json:"role_id"`
+	RoleName string           `json:"role_name"`
+	Limit    int              `json:"limit"`
+	Offset   int              `json:"offset"`
+	Cursor   *string          `json:"cursor"`
+	Envelope bool             `json:"envelope"`
+	Sort     string           `json:"sort"`
+	Filters  []sqlutil.Filter `json:"filters"`
+	Detailed bool             `json:"detailed"`
+}
+
+type AssignRolesInput struct {
+	UserID  string   `json:"user_id"`
+	RoleIDs []string `json:"role_ids"`
+}
+
+type UnassignRolesInput struct {
+	UserID  string   `json:"user_id"`
+	RoleIDs []string `json:"role_ids"`
+}
+
+// sortValue returns the value of ur for the sort key name.
+func (ur UserRole) sortValue(name string) any {
+	switch name {
+	case "username":
+		return ur.Username
+	case "role_name":
+		return ur.RoleName
+	default:
+		return ur.CreatedAt
+	}
+}
+
+// UserRoles returns the roles assigned to a user.
+func (s *Service) UserRoles(f *flux.Flow, in UserRoleQuery) (sqlutil.Page[UserRole], error) {
+	var errs valid.Errors
+	if in.UserID == "" {
+		errs = append(errs, valid.Required("user_id"))
+	}
+	page, err := s.userRoles(f, in, errs)
+	if err != nil {
+		return sqlutil.Page[UserRole]{}, err
+	}
+	if len(page.Items) == 0 {
+		if _, err := s.store.Users().Get(f.Ctx, in.UserID); err != nil {
+			return sqlutil.Page[UserRole]{}, err
+		}
+	}
+	return page, nil
+}
+
+// RoleMembers returns the users holding a role, selected by id or name.
+func (s *Service) RoleMembers(f *flux.Flow, in UserRoleQuery) (sqlutil.Page[UserRole], error) {
+	var errs valid.Errors
+	if in.RoleID == "" && in.RoleName == "" {
+		errs = append(errs, valid.Required("role_id"))
+	}
+	page, err := s.userRoles(f, in, errs)
+	if err != nil {
+		return sqlutil.Page[UserRole]{}, err
+	}
+	if len(page.Items) == 0 {
+		n, _, err := s.store.Roles().Count(f.Ctx, RoleQuery{ID: in.RoleID, Name: in.RoleName}, ListParams{})
+		if err != nil {
+			return sqlutil.Page[UserRole]{}, err
+		}
+		if n == 0 {
+			return sqlutil.Page[UserRole]{}, ErrRoleNotFound
+		}
+	}
+	return page, nil
+}
+
+// userRoles validates and runs a query of role assignments, adding its
+// errors to errs.
+func (s *Service) userRoles(f *flux.Flow, in UserRoleQuery, errs valid.Errors) (sqlutil.Page[UserRole], error) {
+	if in.UserID != "" && !valid.IsUUID(in.UserID) {
+		errs = append(errs, valid.InvalidID("user_id"))
+	}
+	if in.RoleID != "" && !valid.IsUUID(in.RoleID) {
+		errs = append(errs, valid.InvalidID("role_id"))
+	}
+	p, listErrs := s.listParams(userRoleKeyset, userRoleFields, defaultUserRoleSort, in.Filters, in.Sort, in.Cursor, in.Offset)
+	errs = append(errs, listErrs...)
+	if len(errs) != 0 {
+		return sqlutil.Page[UserRole]{}, errs
+	}
+
+	if in.Limit <= 0 || in.Limit > maxUserRoleQueryLimit {
+		in.Limit = defaultUserRoleQueryLimit
+	}
+	if in.Offset < 0 {
+		in.Offset = 0
+	}
+	p.Limit, p.Offset, p.Now = in.Limit, in.Offset, f.Time
+
+	return list(f, s, s.store.UserRoles(), in, p, in.Envelope, in.Cursor, func(ur UserRole) string { return ur.ID }, UserRole.sortValue)
+}
+
+// AssignRoles assigns roles to a user. Roles the user already has are
+// skipped. It returns the number of newly assigned roles.
+func (s *Service) AssignRoles(f *flux.Flow, in AssignRolesInput) (int, error) {
+	var errs valid.Errors
+	if in.UserID == "" {
+		errs = append(errs, valid.Required("user_id"))
+	} else if !valid.IsUUID(in.UserID) {
+		errs = append(errs, valid.InvalidID("user_id"))
+	}
+	if len(in.RoleIDs) == 0 {
+		errs = append(errs, valid.Required("role_ids"))
+	}
+	if !valid.IsUUIDSlice(in.RoleIDs) {
+		errs = append(errs, valid.InvalidIDs("role_ids"))
+	}
+	if len(errs) != 0 {
+		return 0, errs
+	}
+	roleIDs := slices.Clone(in.RoleIDs)
+	slices.Sort(roleIDs)
+	roleIDs = slices.Compact(roleIDs)
+
+	var n int
+	if err := s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		var err error
+		n, err = tx.Roles().Assign(f.Ctx, in.UserID, roleIDs, f.Time, sessionUser(f))
+		if err != nil || n == 0 {
+			return err
+		}
+		return emit(f, tx, EventUserRolesAssigned, in.UserID, AssignRolesInput{UserID: in.UserID, RoleIDs: roleIDs})
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// UnassignRoles removes roles from a user. It returns the number of removed
+// roles, or fails with ErrLastRoleHolder if the user is the last holder of a
+// protected role.
+func (s *Service) UnassignRoles(f *flux.Flow, in UnassignRolesInput) (int, error) {
+	var errs valid.Errors
+	if in.UserID == "" {
+		errs = append(errs, valid.Required("user_id"))
+	} else if !valid.IsUUID(in.UserID) {
+		errs = append(errs, valid.InvalidID("user_id"))
+	}
+	if len(in.RoleIDs) == 0 {
+		errs = append(errs, valid.Required("role_ids"))
+	}
+	if !valid.IsUUIDSlice(in.RoleIDs) {
+		errs = append(errs, valid.InvalidIDs("role_ids"))
+	}
+	if len(errs) != 0 {
+		return 0, errs
+	}
+
+	var n int
+	if err := s.store.Tx(f.Ctx, protectedRolesTx, func(tx Store) error {
+		err := s.keepRoleHolders(f, tx, func() error {
+			var err error
+			n, err = tx.Roles().Unassign(f.Ctx, in.UserID, in.RoleIDs)
+			return err
+		})
+		if err != nil || n == 0 {
+			return err
+		}
+		return emit(f, tx, EventUserRolesUnassigned, in.UserID, UnassignRolesInput{UserID: in.UserID, RoleIDs: in.RoleIDs})
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// protectedRolesTx are the options of transactions checked by
+// keepRoleHolders. They are serializable so that concurrent transactions
+// cannot each remove one of the last two holders of a role.
+var protectedRolesTx = sqlutil.TxOptions{IsoLevel: pgx.Serializable}
+
+// keepRoleHolders runs fn in tx and fails with ErrLastRoleHolder if fn
+// removes the last holder of a protected role, e.g. by deleting or renaming
+// the role or by unassigning it from or deleting its holders.
+func (s *Service) keepRoleHolders(f *flux.Flow, tx Store, fn func() error) error {
+	names := s.config().ProtectedRoles
+	held := make([]bool, len(names))
+	for i, name := range names {
+		ok, err := tx.UserRoles().Held(f.Ctx, name)
+		if err != nil {
+			return err
+		}
+		held[i] = ok
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	for i, name := range names {
+		if !held[i] {
+			continue
+		}
+		ok, err := tx.UserRoles().Held(f.Ctx, name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrLastRoleHolder
+		}
+	}
+	return nil
+}
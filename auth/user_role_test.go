@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"glut/common/flux"
+	"testing"
+)
+
+func TestProtectedRoles(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	svc := NewService(store, &Config{})
+	f := &flux.Flow{Ctx: ctx, Time: testNow}
+
+	alice := createTestUser(t, store, "alice")
+	bob := createTestUser(t, store, "bob")
+	rbac := createTestRBAC(t, store, alice.ID, bob.ID)
+	admin, editor := rbac.admin, rbac.editor
+
+	if n, err := svc.UnassignRoles(f, UnassignRolesInput{UserID: bob.ID, RoleIDs: []string{admin.ID, editor.ID}}); err != nil || n != 2 {
+		t.Fatalf("UnassignRoles = %d, %v, want 2", n, err)
+	}
+	for name, try := range map[string]func() error{
+		"unassigning": func() error {
+			_, err := svc.UnassignRoles(f, UnassignRolesInput{UserID: alice.ID, RoleIDs: []string{admin.ID}})
+			return err
+		},
+		"deleting the holder": func() error {
+			_, err := svc.DeleteUsers(f, DeleteUsersInput{IDs: []string{alice.ID}})
+			return err
+		},
+		"deleting the role": func() error {
+			_, err := svc.DeleteRole(f, DeleteRoleInput{IDs: []string{admin.ID}})
+			return err
+		},
+		"renaming the role": func() error {
+			return svc.UpdateRole(f, UpdateRoleInput{ID: admin.ID, Name: "owner"})
+		},
+	} {
+		if err := try(); !errors.Is(err, ErrLastRoleHolder) {
+			t.Errorf("%s the last admin: got %v, want ErrLastRoleHolder", name, err)
+		}
+	}
+	page, err := svc.RoleMembers(f, UserRoleQuery{RoleName: "admin"})
+	if err != nil || len(page.Items) != 1 || page.Items[0].UserID != alice.ID {
+		t.Fatalf("RoleMembers(admin) = %+v, %v, want alice", page.Items, err)
+	}
+
+	// Unprotected roles may lose all their holders.
+	if _, err := svc.UnassignRoles(f, UnassignRolesInput{UserID: alice.ID, RoleIDs: []string{editor.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if page, err := svc.RoleMembers(f, UserRoleQuery{RoleID: editor.ID}); err != nil || len(page.Items) != 0 {
+		t.Errorf("RoleMembers(editor) = %+v, %v, want none", page.Items, err)
+	}
+
+	svc.SetConfig(&Config{ProtectedRoles: []string{}})
+	if _, err := svc.UnassignRoles(f, UnassignRolesInput{UserID: alice.ID, RoleIDs: []string{admin.ID}}); err != nil {
+		t.Errorf("unassigning the last admin without protected roles: %v", err)
+	}
+	if page, err := svc.UserRoles(f, UserRoleQuery{UserID: alice.ID}); err != nil || len(page.Items) != 0 {
+		t.Errorf("UserRoles(alice) = %+v, %v, want none", page.Items, err)
+	}
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"glut/common/flux"
+	"testing"
+)
+
+func TestUserID(t *testing.T) {
+	store := NewMemoryStore()
+	svc := NewService(store, &Config{})
+	f := &flux.Flow{Ctx: context.Background(), Time: testNow}
+
+	al := createTestUser(t, store, "al")
+	createTestUser(t, store, "alice")
+	createTestUser(t, store, "sal")
+	if id, err := svc.UserID(f, "al"); err != nil || id != al.ID {
+		t.Errorf("UserID(al) = %q, %v, want %q", id, err, al.ID)
+	}
+	if _, err := svc.UserID(f, "a"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("UserID(a): got %v, want ErrUserNotFound", err)
+	}
+}
@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"glut/common/flux"
+	"glut/common/sqlutil"
+	"glut/common/valid"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultWebhookQueryLimit = 20
+	maxWebhookQueryLimit     = 100
+
+	defaultDeliveryQueryLimit = 20
+	maxDeliveryQueryLimit     = 100
+
+	minWebhookSecretLength = 16
+	maxWebhookURLLength    = 2048
+)
+
+// WebhookAllEvents subscribes a webhook to all event types.
+const WebhookAllEvents = "*"
+
+// States of webhook deliveries. Failed deliveries are not retried unless
+// they are redelivered.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint the events of the outbox are posted to. Webhooks
+// are disabled after failing too many consecutive delivery attempts.
+type Webhook struct {
+	ID          string     `json:"id"`
+	URL         string     `json:"url"`
+	Secret      string     `json:"-"`
+	Events      []string   `json:"events"`
+	Description *string    `json:"description"`
+	Enabled     bool       `json:"enabled"`
+	Failures    int        `json:"failures"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+	DisabledAt  *time.Time `json:"disabled_at"`
+}
+
+// WebhookDelivery is the delivery of an event to a webhook, with the result
+// of its last attempt.
+type WebhookDelivery struct {
+	ID            string     `json:"id"`
+	WebhookID     string     `json:"webhook_id"`
+	EventID       string     `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	State         string     `json:"state"`
+	Attempts      int        `json:"attempts"`
+	CreatedAt     time.Time  `json:"created_at"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	StatusCode    *int       `json:"status_code"`
+	Error         *string    `json:"error"`
+	DurationMS    *int64     `json:"duration_ms"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	// Payload is the body posted to the webhook. It is only set on claimed
+	// deliveries.
+	Payload []byte `json:"-"`
+}
+
+type WebhookQuery struct {
+	ID       string           `json:"id"`
+	Enabled  *bool            `json:"enabled"`
+	Limit    int              `json:"limit"`
+	Offset   int              `json:"offset"`
+	Cursor   *string          `json:"cursor"`
+	Envelope bool             `json:"envelope"`
+	Sort     string           `json:"sort"`
+	Filters  []sqlutil.Filter `json:"filters"`
+}
+
+type WebhookDeliveryQuery struct {
+	WebhookID string           `json:"webhook_id"`
+	ID        string           `json:"id"`
+	State     string           `json:"state"`
+	Limit     int              `json:"limit"`
+	Offset    int              `json:"offset"`
+	Cursor    *string          `json:"cursor"`
+	Envelope  bool             `json:"envelope"`
+	Sort      string           `json:"sort"`
+	Filters   []sqlutil.Filter `json:"filters"`
+}
+
+type CreateWebhookInput struct {
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret"`
+	Events      []string `json:"events"`
+	Description *string  `json:"description"`
+}
+
+// UpdateWebhookInput changes the fields of a webhook that are set. Enabling
+// a webhook resets its failures.
+type UpdateWebhookInput struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret"`
+	Events      []string `json:"events"`
+	Description *string  `json:"description"`
+	Enabled     *bool    `json:"enabled"`
+}
+
+type DeleteWebhooksInput struct {
+	IDs []string `json:"ids"`
+}
+
+type RedeliverWebhookInput struct {
+	ID string `json:"id"`
+}
+
+// sortValue returns the value of w for the sort key name.
+func (w Webhook) sortValue(name string) any {
+	switch name {
+	case "url":
+		return w.URL
+	case "updated_at":
+		return w.UpdatedAt
+	default:
+		return w.CreatedAt
+	}
+}
+
+// sortValue returns the value of d for the sort key name.
+func (d WebhookDelivery) sortValue(name string) any {
+	switch name {
+	case "event_type":
+		return d.EventType
+	case "last_attempt_at":
+		return d.LastAttemptAt
+	default:
+		return d.CreatedAt
+	}
+}
+
+func (s *Service) Webhooks(f *flux.Flow, in WebhookQuery) (sqlutil.Page[Webhook], error) {
+	var errs valid.Errors
+	if in.ID != "" && !valid.IsUUID(in.ID) {
+		errs = append(errs, valid.InvalidID("id"))
+	}
+	p, listErrs := s.listParams(webhookKeyset, webhookFields, defaultWebhookSort, in.Filters, in.Sort, in.Cursor, in.Offset)
+	errs = append(errs, listErrs...)
+	if len(errs) != 0 {
+		return sqlutil.Page[Webhook]{}, errs
+	}
+
+	if in.Limit <= 0 || in.Limit > maxWebhookQueryLimit {
+		in.Limit = defaultWebhookQueryLimit
+	}
+	if in.Offset < 0 {
+		in.Offset = 0
+	}
+	p.Limit, p.Offset, p.Now = in.Limit, in.Offset, f.Time
+
+	page, err := list(f, s, s.store.Webhooks(), in, p, in.Envelope, in.Cursor, func(w Webhook) string { return w.ID }, Webhook.sortValue)
+	if err != nil {
+		return sqlutil.Page[Webhook]{}, err
+	}
+	if in.ID != "" && len(page.Items) == 0 {
+		return sqlutil.Page[Webhook]{}, ErrWebhookNotFound
+	}
+	return page, nil
+}
+
+func (s *Service) CreateWebhook(f *flux.Flow, in CreateWebhookInput) (Webhook, error) {
+	var errs valid.Errors
+	if in.URL == "" {
+		errs = append(errs, valid.Required("url"))
+	} else {
+		errs = append(errs, validateWebhookURL(in.URL)...)
+	}
+	if in.Secret == "" {
+		errs = append(errs, valid.Required("secret"))
+	} else if len(in.Secret) < minWebhookSecretLength {
+		errs = append(errs, valid.MinLength("secret", minWebhookSecretLength))
+	}
+	if len(in.Events) == 0 {
+		errs = append(errs, valid.Required("events"))
+	} else {
+		errs = append(errs, validateWebhookEvents(in.Events)...)
+	}
+	if len(errs) != 0 {
+		return Webhook{}, errs
+	}
+
+	webhook := Webhook{
+		ID:          uuid.New().String(),
+		URL:         in.URL,
+		Secret:      in.Secret,
+		Events:      compactEvents(in.Events),
+		Description: in.Description,
+		Enabled:     true,
+		CreatedAt:   f.Time,
+	}
+	if err := s.store.Webhooks().Create(f.Ctx, webhook); err != nil {
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (s *Service) UpdateWebhook(f *flux.Flow, in UpdateWebhookInput) error {
+	var errs valid.Errors
+	if in.ID == "" {
+		errs = append(errs, valid.Required("id"))
+	} else if !valid.IsUUID(in.ID) {
+		errs = append(errs, valid.InvalidID("id"))
+	}
+	if in.URL == "" && in.Secret == "" && in.Events == nil && in.Description == nil && in.Enabled == nil {
+		errs = append(errs, valid.InputRequired())
+	}
+	if in.URL != "" {
+		errs = append(errs, validateWebhookURL(in.URL)...)
+	}
+	if in.Secret != "" && len(in.Secret) < minWebhookSecretLength {
+		errs = append(errs, valid.MinLength("secret", minWebhookSecretLength))
+	}
+	if in.Events != nil {
+		if len(in.Events) == 0 {
+			errs = append(errs, valid.Required("events"))
+		} else {
+			errs = append(errs, validateWebhookEvents(in.Events)...)
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+
+	update := WebhookUpdate{
+		Description: in.Description,
+		Enabled:     in.Enabled,
+	}
+	if in.URL != "" {
+		update.URL = &in.URL
+	}
+	if in.Secret != "" {
+		update.Secret = &in.Secret
+	}
+	if in.Events != nil {
+		update.Events = compactEvents(in.Events)
+	}
+	return s.store.Webhooks().Update(f.Ctx, in.ID, update, f.Time)
+}
+
+func (s *Service) DeleteWebhooks(f *flux.Flow, in DeleteWebhooksInput) (int, error) {
+	var errs valid.Errors
+	if len(in.IDs) == 0 {
+		errs = append(errs, valid.Required("ids"))
+	}
+	if !valid.IsUUIDSlice(in.IDs) {
+		errs = append(errs, valid.InvalidIDs("ids"))
+	}
+	if len(errs) != 0 {
+		return 0, errs
+	}
+	return s.store.Webhooks().Delete(f.Ctx, in.IDs)
+}
+
+// WebhookDeliveries returns the deliveries of a webhook, which are its
+// delivery log.
+func (s *Service) WebhookDeliveries(f *flux.Flow, in WebhookDeliveryQuery) (sqlutil.Page[WebhookDelivery], error) {
+	var errs valid.Errors
+	if in.WebhookID == "" {
+		errs = append(errs, valid.Required("webhook_id"))
+	} else if !valid.IsUUID(in.WebhookID) {
+		errs = append(errs, valid.InvalidID("webhook_id"))
+	}
+	if in.ID != "" && !valid.IsUUID(in.ID) {
+		errs = append(errs, valid.InvalidID("id"))
+	}
+	switch in.State {
+	case "", DeliveryPending, DeliveryDelivered, DeliveryFailed:
+	default:
+		errs = append(errs, valid.Invalid("state"))
+	}
+	p, listErrs := s.listParams(deliveryKeyset, deliveryFields, defaultDeliverySort, in.Filters, in.Sort, in.Cursor, in.Offset)
+	errs = append(errs, listErrs...)
+	if len(errs) != 0 {
+		return sqlutil.Page[WebhookDelivery]{}, errs
+	}
+
+	if in.Limit <= 0 || in.Limit > maxDeliveryQueryLimit {
+		in.Limit = defaultDeliveryQueryLimit
+	}
+	if in.Offset < 0 {
+		in.Offset = 0
+	}
+	p.Limit, p.Offset, p.Now = in.Limit, in.Offset, f.Time
+
+	if _, err := s.store.Webhooks().Get(f.Ctx, in.WebhookID); err != nil {
+		return sqlutil.Page[WebhookDelivery]{}, err
+	}
+	page, err := list(f, s, s.store.WebhookDeliveries(), in, p, in.Envelope, in.Cursor, func(d WebhookDelivery) string { return d.ID }, WebhookDelivery.sortValue)
+	if err != nil {
+		return sqlutil.Page[WebhookDelivery]{}, err
+	}
+	if in.ID != "" && len(page.Items) == 0 {
+		return sqlutil.Page[WebhookDelivery]{}, ErrDeliveryNotFound
+	}
+	return page, nil
+}
+
+// RedeliverWebhook queues a delivery to be sent again right away, with all
+// attempts of a new delivery. It fails with ErrWebhookDisabled if the
+// webhook of the delivery is disabled.
+func (s *Service) RedeliverWebhook(f *flux.Flow, in RedeliverWebhookInput) error {
+	var errs valid.Errors
+	if in.ID == "" {
+		errs = append(errs, valid.Required("id"))
+	} else if !valid.IsUUID(in.ID) {
+		errs = append(errs, valid.InvalidID("id"))
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+
+	return s.store.Tx(f.Ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		webhookID, err := tx.WebhookDeliveries().Redeliver(f.Ctx, in.ID, f.Time)
+		if err != nil {
+			return err
+		}
+		webhook, err := tx.Webhooks().Get(f.Ctx, webhookID)
+		if err != nil {
+			return err
+		}
+		if !webhook.Enabled {
+			return ErrWebhookDisabled
+		}
+		return nil
+	})
+}
+
+// validateWebhookURL validates the URL of a webhook, which must be an
+// absolute http or https URL.
+func validateWebhookURL(rawURL string) valid.Errors {
+	if len(rawURL) > maxWebhookURLLength {
+		return valid.Errors{valid.MaxLength("url", maxWebhookURLLength)}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return valid.Errors{valid.Invalid("url")}
+	}
+	return nil
+}
+
+// validateWebhookEvents validates the event types a webhook subscribes to.
+func validateWebhookEvents(events []string) valid.Errors {
+	for _, e := range events {
+		if e != WebhookAllEvents && !slices.Contains(eventTypes, e) {
+			return valid.Errors{valid.Invalid("events")}
+		}
+	}
+	return nil
+}
+
+// compactEvents returns the sorted event types without duplicates.
+func compactEvents(events []string) []string {
+	events = slices.Clone(events)
+	slices.Sort(events)
+	return slices.Compact(events)
+}
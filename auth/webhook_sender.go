@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"glut/common/sqlutil"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultWebhookPollInterval = time.Second
+	defaultWebhookBatchSize    = 20
+	defaultWebhookLease        = time.Minute
+	defaultWebhookTimeout      = 10 * time.Second
+	defaultWebhookMaxAttempts  = 10
+	defaultWebhookMinBackoff   = 10 * time.Second
+	defaultWebhookMaxBackoff   = time.Hour
+	defaultWebhookMaxFailures  = 50
+
+	// maxWebhookErrorLength bounds the errors recorded on deliveries.
+	maxWebhookErrorLength = 512
+)
+
+// Headers of webhook requests. The signature is "v1=" followed by the hex
+// HMAC-SHA256, keyed with the secret of the webhook, of the timestamp, a dot
+// and the body.
+const (
+	WebhookIDHeader        = "Glut-Webhook-Id"
+	WebhookDeliveryHeader  = "Glut-Delivery-Id"
+	WebhookEventIDHeader   = "Glut-Event-Id"
+	WebhookEventTypeHeader = "Glut-Event-Type"
+	WebhookTimestampHeader = "Glut-Timestamp"
+	WebhookSignatureHeader = "Glut-Signature"
+)
+
+// WebhookSenderOptions...
+type WebhookSenderOptions struct {
+	Logger *slog.Logger
+	// Client sends the requests. By default, redirects are not followed.
+	Client *http.Client
+	// PollInterval is how long the sender waits for new deliveries after
+	// finding none.
+	PollInterval time.Duration
+	// BatchSize is the number of deliveries claimed, and sent concurrently,
+	// at once.
+	BatchSize int
+	// Lease is how long claimed deliveries are reserved for the sender.
+	Lease time.Duration
+	// Timeout bounds each request.
+	Timeout time.Duration
+	// MaxAttempts is the number of failed attempts after which a delivery
+	// fails.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the delay before retrying a failed
+	// attempt, which doubles with each attempt.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxFailures is the number of consecutive failed attempts after which
+	// a webhook is disabled.
+	MaxFailures int
+}
+
+// WebhookSender posts events to the webhooks subscribed to them. As a Sink
+// of a Dispatcher, it adds a delivery per subscribed webhook; Run then sends
+// the deliveries, retrying failed ones with exponential backoff.
+type WebhookSender struct {
+	store  Store
+	opts   WebhookSenderOptions
+	logger *slog.Logger
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewWebhookSender returns a WebhookSender of the webhooks of store. Zero
+// options are replaced by defaults.
+func NewWebhookSender(store Store, opts *WebhookSenderOptions) *WebhookSender {
+	w := &WebhookSender{
+		store:  store,
+		opts:   *opts,
+		logger: opts.Logger,
+		client: opts.Client,
+		now:    time.Now,
+	}
+	if w.logger == nil {
+		w.logger = slog.Default()
+	}
+	if w.client == nil {
+		w.client = &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	if w.opts.PollInterval <= 0 {
+		w.opts.PollInterval = defaultWebhookPollInterval
+	}
+	if w.opts.BatchSize <= 0 {
+		w.opts.BatchSize = defaultWebhookBatchSize
+	}
+	if w.opts.Lease <= 0 {
+		w.opts.Lease = defaultWebhookLease
+	}
+	if w.opts.Timeout <= 0 {
+		w.opts.Timeout = defaultWebhookTimeout
+	}
+	if w.opts.MaxAttempts <= 0 {
+		w.opts.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if w.opts.MinBackoff <= 0 {
+		w.opts.MinBackoff = defaultWebhookMinBackoff
+	}
+	if w.opts.MaxBackoff < w.opts.MinBackoff {
+		w.opts.MaxBackoff = max(defaultWebhookMaxBackoff, w.opts.MinBackoff)
+	}
+	if w.opts.MaxFailures <= 0 {
+		w.opts.MaxFailures = defaultWebhookMaxFailures
+	}
+	return w
+}
+
+// Deliver adds the deliveries of e to the webhooks subscribed to its type.
+// The body of the deliveries is e in JSON.
+func (w *WebhookSender) Deliver(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return w.store.Tx(ctx, sqlutil.TxOptions{}, func(tx Store) error {
+		ids, err := tx.Webhooks().Subscribers(ctx, e.Type)
+		if err != nil {
+			return err
+		}
+		deliveries := make([]WebhookDelivery, len(ids))
+		for i, id := range ids {
+			deliveries[i] = WebhookDelivery{
+				ID:        uuid.New().String(),
+				WebhookID: id,
+				EventID:   e.ID,
+				EventType: e.Type,
+				CreatedAt: w.now(),
+				Payload:   body,
+			}
+		}
+		return tx.WebhookDeliveries().Add(ctx, deliveries...)
+	})
+}
+
+// Run sends deliveries until ctx is done.
+func (w *WebhookSender) Run(ctx context.Context) {
+	for {
+		n, err := w.Send(ctx)
+		if err != nil && ctx.Err() == nil {
+			w.logger.Error("Failed to send webhook deliveries.", slog.String("error", err.Error()))
+		}
+
+		// Claim more deliveries right away if the batch was full.
+		wait := w.opts.PollInterval
+		if err == nil && n == w.opts.BatchSize {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Send claims a batch of due deliveries and sends them concurrently. It
+// returns the number of claimed deliveries.
+func (w *WebhookSender) Send(ctx context.Context) (int, error) {
+	now := w.now()
+	deliveries, err := w.store.WebhookDeliveries().Claim(ctx, w.opts.BatchSize, now, now.Add(w.opts.Lease))
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(deliveries))
+	for i, d := range deliveries {
+		wg.Add(1)
+		go func(i int, d ClaimedDelivery) {
+			defer wg.Done()
+			errs[i] = w.attempt(ctx, d)
+		}(i, d)
+	}
+	wg.Wait()
+	return len(deliveries), errors.Join(errs...)
+}
+
+// attempt sends d and records the attempt on the delivery and its webhook.
+func (w *WebhookSender) attempt(ctx context.Context, d ClaimedDelivery) error {
+	a := w.send(ctx, d)
+	if ctx.Err() != nil {
+		// The lease expires and the delivery is claimed again.
+		return ctx.Err()
+	}
+
+	logger := w.logger.With(
+		slog.String("webhook_id", d.WebhookID),
+		slog.String("delivery_id", d.ID),
+		slog.String("event_type", d.EventType),
+	)
+	var next *time.Time
+	if !a.OK {
+		attempts := d.Attempts + 1
+		logger = logger.With(slog.Int("attempts", attempts), slog.String("error", *a.Error))
+		if attempts < w.opts.MaxAttempts {
+			at := a.At.Add(backoff(attempts, w.opts.MinBackoff, w.opts.MaxBackoff))
+			next = &at
+			logger.Warn("Failed to deliver webhook; retrying.", slog.Time("next_attempt_at", at))
+		} else {
+			logger.Error("Failed to deliver webhook; giving up.")
+		}
+	}
+	if err := w.store.WebhookDeliveries().Attempted(ctx, d.ID, a, next); err != nil {
+		return err
+	}
+
+	disabled, err := w.store.Webhooks().Report(ctx, d.WebhookID, a.OK, a.At, w.opts.MaxFailures)
+	if err != nil {
+		return err
+	}
+	if disabled {
+		logger.Error("Disabled webhook after consecutive failures.", slog.Int("failures", w.opts.MaxFailures))
+	}
+	return nil
+}
+
+// send posts the payload of d to its webhook. Responses with a 2xx status
+// are successes.
+func (w *WebhookSender) send(ctx context.Context, d ClaimedDelivery) DeliveryAttempt {
+	a := DeliveryAttempt{At: w.now()}
+	fail := func(err error) DeliveryAttempt {
+		msg := err.Error()
+		if len(msg) > maxWebhookErrorLength {
+			msg = msg[:maxWebhookErrorLength]
+		}
+		a.Error = &msg
+		return a
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "glut-webhooks")
+	req.Header.Set(WebhookIDHeader, d.WebhookID)
+	req.Header.Set(WebhookDeliveryHeader, d.ID)
+	req.Header.Set(WebhookEventIDHeader, d.EventID)
+	req.Header.Set(WebhookEventTypeHeader, d.EventType)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(a.At.Unix(), 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(d.Secret, a.At, d.Payload))
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	a.Duration = time.Since(start)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	a.StatusCode = &resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fail(fmt.Errorf("unexpected status %d", resp.StatusCode))
+	}
+	a.OK = true
+	return a
+}
+
+// SignWebhook returns the signature of a webhook request with body sent at
+// ts, as set in the WebhookSignatureHeader.
+func SignWebhook(secret string, ts time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook verifies the signature of a webhook request with header and
+// body, received at now. Requests sent more than tolerance before or after
+// now are rejected, to prevent replays. It fails with ErrInvalidSignature.
+func VerifyWebhook(secret string, header http.Header, body []byte, now time.Time, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(header.Get(WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	ts := time.Unix(unix, 0)
+	if ts.Before(now.Add(-tolerance)) || ts.After(now.Add(tolerance)) {
+		return ErrInvalidSignature
+	}
+
+	want := SignWebhook(secret, ts, body)
+	for _, sig := range strings.Split(header.Get(WebhookSignatureHeader), ",") {
+		if hmac.Equal([]byte(strings.TrimSpace(sig)), []byte(want)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"glut/common/flux"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookSender(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	svc := NewService(store, &Config{})
+	f := &flux.Flow{Ctx: ctx, Time: testNow}
+	const secret = "0123456789abcdef"
+
+	// now is the time of the sender and the receiver.
+	var mu sync.Mutex
+	now := testNow
+	setNow := func(t time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = t
+	}
+	getNow := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	// The receiver verifies the signatures and rejects the first request.
+	var received []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		now := getNow()
+		if err := VerifyWebhook(secret, r.Header, body, now, 5*time.Minute); err != nil {
+			t.Errorf("VerifyWebhook: %v", err)
+		}
+		if err := VerifyWebhook(secret, r.Header, append(body, ' '), now, 5*time.Minute); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("VerifyWebhook of a changed body: got %v, want ErrInvalidSignature", err)
+		}
+		if err := VerifyWebhook(secret, r.Header, body, now.Add(time.Hour), 5*time.Minute); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("VerifyWebhook of a replay: got %v, want ErrInvalidSignature", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r.Header.Get(WebhookEventTypeHeader))
+		if len(received) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	users, err := svc.CreateWebhook(f, CreateWebhookInput{URL: receiver.URL, Secret: secret, Events: []string{EventUserCreated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := svc.CreateWebhook(f, CreateWebhookInput{URL: broken.URL, Secret: secret, Events: []string{WebhookAllEvents}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sender := NewWebhookSender(store, &WebhookSenderOptions{
+		Logger:      logger,
+		MinBackoff:  time.Minute,
+		MaxFailures: 2,
+	})
+	sender.now = getNow
+	dispatcher := NewDispatcher(store, &DispatcherOptions{Logger: logger})
+	dispatcher.Register(sender)
+
+	if _, err := svc.CreateUser(f, CreateUserInput{Username: "alice", Email: "alice@example.com", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := dispatcher.Dispatch(ctx); err != nil || n != 1 {
+		t.Fatalf("Dispatch = %d, %v, want 1", n, err)
+	}
+
+	send := func() int {
+		t.Helper()
+		n, err := sender.Send(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := send(); n != 2 {
+		t.Errorf("first Send claimed %d deliveries, want 2", n)
+	}
+	if n := send(); n != 0 {
+		t.Errorf("Send before the retries claimed %d deliveries, want 0", n)
+	}
+	setNow(testNow.Add(time.Minute))
+	if n := send(); n != 2 {
+		t.Errorf("Send of the retries claimed %d deliveries, want 2", n)
+	}
+	if len(received) != 2 || received[1] != EventUserCreated {
+		t.Errorf("received %v, want two %s", received, EventUserCreated)
+	}
+
+	page, err := svc.WebhookDeliveries(f, WebhookDeliveryQuery{WebhookID: users.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].State != DeliveryDelivered || page.Items[0].Attempts != 2 ||
+		page.Items[0].StatusCode == nil || *page.Items[0].StatusCode != http.StatusOK {
+		t.Errorf("deliveries of users = %+v, want one delivered in 2 attempts", page.Items)
+	}
+
+	// The broken webhook is disabled after 2 failures, and its deliveries
+	// are neither sent nor redelivered.
+	webhooks, err := svc.Webhooks(f, WebhookQuery{ID: all.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := webhooks.Items[0]; w.Enabled || w.Failures != 2 {
+		t.Errorf("broken webhook = %+v, want disabled after 2 failures", w)
+	}
+	setNow(testNow.Add(time.Hour))
+	if n := send(); n != 0 {
+		t.Errorf("Send after disabling claimed %d deliveries, want 0", n)
+	}
+	page, err = svc.WebhookDeliveries(f, WebhookDeliveryQuery{WebhookID: all.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].State != DeliveryPending || page.Items[0].Attempts != 2 {
+		t.Fatalf("deliveries of the broken webhook = %+v, want one pending after 2 attempts", page.Items)
+	}
+	if err := svc.RedeliverWebhook(f, RedeliverWebhookInput{ID: page.Items[0].ID}); !errors.Is(err, ErrWebhookDisabled) {
+		t.Errorf("RedeliverWebhook of a disabled webhook: got %v, want ErrWebhookDisabled", err)
+	}
+
+	// Once enabled again, redelivered deliveries are sent right away.
+	enabled := true
+	if err := svc.UpdateWebhook(f, UpdateWebhookInput{ID: all.ID, URL: receiver.URL, Enabled: &enabled}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RedeliverWebhook(f, RedeliverWebhookInput{ID: page.Items[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if n := send(); n != 1 || len(received) != 3 {
+		t.Errorf("Send after redelivering claimed %d deliveries with %d received, want 1 and 3", n, len(received))
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"glut/auth"
+	"glut/common/flux"
+	"glut/common/postgres"
+	"glut/common/scheduler"
+	"glut/common/sqlite"
+	"glut/common/valid"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a glut subcommand. A command either runs or dispatches to its
+// subcommands.
+type command struct {
+	name        string
+	usage       string
+	subcommands []*command
+	run         func(ctx context.Context, a *app, args []string) error
+}
+
+// errUsage is returned when a command is invoked with invalid arguments. The
+// usage has already been printed when it is returned.
+var errUsage = errors.New("invalid usage")
+
+// app holds the state shared by all commands.
+type app struct {
+	configPath string
+	logger     *slog.Logger
+	stdin      io.Reader
+	stdout     io.Writer
+	cfg        *Config
+}
+
+// config returns the loaded config.
+func (a *app) config() (*Config, error) {
+	if a.cfg != nil {
+		return a.cfg, nil
+	}
+	cfg, err := loadConfig(a.configPath)
+	if err != nil {
+		return nil, err
+	}
+	a.cfg = cfg
+	return cfg, nil
+}
+
+// database is an open database of the configured driver.
+type database struct {
+	store auth.Store
+	jobs  scheduler.Store
+	// tracer traces the statements of PostgreSQL databases; it is nil for
+	// SQLite.
+	tracer *postgres.Tracer
+	close  func()
+}
+
+// openDB connects to the database of the config and, with PostgreSQL, its
+// replicas.
+func (a *app) openDB(ctx context.Context) (*database, error) {
+	cfg, err := a.config()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Database.Driver == driverSQLite {
+		db, err := sqlite.Open(ctx, cfg.sqliteConfig())
+		if err != nil {
+			return nil, err
+		}
+		return &database{
+			store: auth.NewSQLiteStore(db),
+			jobs:  scheduler.NewSQLiteStore(db),
+			close: func() { db.Close() },
+		}, nil
+	}
+
+	pgCfg := cfg.postgresConfig()
+	pgCfg.Logger = a.logger
+	db, err := postgres.NewCluster(ctx, pgCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &database{
+		store:  auth.NewPostgresStore(db),
+		jobs:   scheduler.NewPostgresStore(db.Primary()),
+		tracer: db.Tracer(),
+		close:  db.Close,
+	}, nil
+}
+
+// service connects to the database and creates an auth service. The
+// returned function closes the database connection.
+func (a *app) service(ctx context.Context) (*auth.Service, func(), error) {
+	cfg, err := a.config()
+	if err != nil {
+		return nil, nil, err
+	}
+	templates, err := cfg.emailTemplates()
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := a.openDB(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	authCfg := cfg.authConfig()
+	authCfg.EmailTemplates = templates
+	return auth.NewService(db.store, authCfg), db.close, nil
+}
+
+// flow creates a flow for calling services from the command line. Commands
+// often read what they or a previous command just wrote, so reads go to the
+// primary database.
+func (a *app) flow(ctx context.Context) *flux.Flow {
+	return flux.NewFlow(postgres.ReadYourWrites(ctx), a.logger)
+}
+
+// printf writes formatted output to stdout.
+func (a *app) printf(format string, args ...any) {
+	fmt.Fprintf(a.stdout, format, args...)
+}
+
+// table returns a writer formatting tab separated columns.
+func (a *app) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(a.stdout, 0, 0, 2, ' ', 0)
+}
+
+// readPassword returns the password flag value or, if it is empty, reads the
+// password from the first line of stdin.
+func (a *app) readPassword(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+	line, err := bufio.NewReader(a.stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// resolveUser returns the id of the user identified by an id or username.
+func (a *app) resolveUser(f *flux.Flow, s *auth.Service, user string) (string, error) {
+	if valid.IsUUID(user) {
+		return user, nil
+	}
+	id, err := s.UserID(f, user)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return "", fmt.Errorf("user %q not found", user)
+		}
+		return "", err
+	}
+	return id, nil
+}
+
+// resolveRole returns the id of the role identified by an id or name.
+func (a *app) resolveRole(f *flux.Flow, s *auth.Service, role string) (string, error) {
+	if valid.IsUUID(role) {
+		return role, nil
+	}
+	roles, err := s.Roles(f, auth.RoleQuery{Name: role})
+	if err != nil {
+		if errors.Is(err, auth.ErrRoleNotFound) {
+			return "", fmt.Errorf("role %q not found", role)
+		}
+		return "", err
+	}
+	return roles.Items[0].ID, nil
+}
+
+// runCommand runs the command named by args[0] from cmds.
+func (a *app) runCommand(ctx context.Context, path string, cmds []*command, args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		a.printCommands(path, cmds)
+		if len(args) == 0 {
+			return errUsage
+		}
+		return nil
+	}
+	for _, cmd := range cmds {
+		if cmd.name != args[0] {
+			continue
+		}
+		if cmd.subcommands != nil {
+			return a.runCommand(ctx, path+" "+cmd.name, cmd.subcommands, args[1:])
+		}
+		return cmd.run(ctx, a, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "%s: unknown command %q\n", path, args[0])
+	a.printCommands(path, cmds)
+	return errUsage
+}
+
+// printCommands prints the usage of cmds.
+func (a *app) printCommands(path string, cmds []*command) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [arguments]\n\nCommands:\n", path)
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.usage)
+	}
+	w.Flush()
+}
+
+// parseFlags parses the arguments of a command. It returns errUsage if the
+// arguments are invalid and flag.ErrHelp if help was requested.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
+
+// newFlagSet creates a flag set for the command at path.
+func newFlagSet(path, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s\n", strings.TrimSpace(path+" [flags] "+args))
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// serviceError converts service validation errors into a readable error.
+func serviceError(err error) error {
+	var verrs valid.Errors
+	if errors.As(err, &verrs) {
+		var sb strings.Builder
+		sb.WriteString("invalid input:")
+		for _, verr := range verrs {
+			if verr.Field != "" {
+				sb.WriteString(fmt.Sprintf("\n  %s: %s", verr.Field, verr.Error))
+			} else {
+				sb.WriteString("\n  " + verr.Error)
+			}
+		}
+		return errors.New(sb.String())
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"glut/auth"
+	"strings"
+)
+
+// commands are the commands of the glut binary.
+var commands = []*command{
+	{name: "serve", usage: "start the server", run: serve},
+	{name: "migrate", usage: "manage database migrations", subcommands: []*command{
+		{name: "up", usage: "apply all pending migrations", run: migrateUp},
+		{name: "down", usage: "revert the last applied migrations", run: migrateDown},
+		{name: "status", usage: "list migrations and their state", run: migrateStatus},
+		{name: "baseline", usage: "record migrations as applied without running them", run: migrateBaseline},
+	}},
+	{name: "user", usage: "manage users", subcommands: []*command{
+		{name: "create", usage: "create a user", run: userCreate},
+		{name: "list", usage: "list users", run: userList},
+		{name: "delete", usage: "delete users", run: userDelete},
+		{name: "reset-password", usage: "set the password of a user", run: userResetPassword},
+	}},
+	{name: "role", usage: "manage roles", subcommands: []*command{
+		{name: "create", usage: "create a role", run: roleCreate},
+		{name: "grant", usage: "grant a role to a user", run: roleGrant},
+		{name: "revoke", usage: "revoke a role from a user", run: roleRevoke},
+	}},
+	{name: "session", usage: "manage sessions", subcommands: []*command{
+		{name: "clear", usage: "delete sessions", run: sessionClear},
+	}},
+	{name: "job", usage: "inspect background jobs", subcommands: []*command{
+		{name: "history", usage: "list the last runs of jobs", run: jobHistory},
+	}},
+	{name: "email", usage: "manage emails", subcommands: []*command{
+		{name: "preview", usage: "render an email template with sample data", run: emailPreview},
+	}},
+	{name: "seed", usage: "load fixtures and generate test data", run: seedData},
+	{name: "config", usage: "manage configuration", subcommands: []*command{
+		{name: "check", usage: "validate the config file", run: configCheck},
+	}},
+}
+
+// configCheck loads and validates the config and the email templates.
+func configCheck(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut config check", "")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := a.config()
+	if err != nil {
+		return err
+	}
+	if _, err := cfg.emailTemplates(); err != nil {
+		return err
+	}
+	a.printf("Config %s is valid.\n", a.configPath)
+	return nil
+}
+
+// sessionClear deletes sessions by id or all sessions of a user.
+func sessionClear(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut session clear", "")
+	user := fs.String("user", "", "delete all sessions of the user with this id or username")
+	ids := fs.String("ids", "", "comma separated ids of sessions to delete")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *user == "" && *ids == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	s, done, err := a.service(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	f := a.flow(ctx)
+
+	in := auth.ClearSessionInput{IDs: splitList(*ids)}
+	if *user != "" {
+		if in.UserID, err = a.resolveUser(f, s, *user); err != nil {
+			return err
+		}
+	}
+	count, err := s.ClearSessions(f, in)
+	if err != nil {
+		return serviceError(err)
+	}
+	a.printf("Deleted %d sessions.\n", count)
+	return nil
+}
+
+// splitList splits a comma separated list, omitting empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,709 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"glut/auth"
+	"glut/common/flux"
+	"glut/common/mail"
+	"glut/common/postgres"
+	"glut/common/scheduler"
+	"glut/common/sqlite"
+	"glut/common/valid"
+	"io"
+	netmail "net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of environment variables overriding config values.
+// A value at path server.port is overridden by GLUT_SERVER_PORT. String values
+// may also be read from a file named by the variable with a _FILE suffix
+// (e.g. GLUT_DATABASE_URL_FILE), which is meant for secrets.
+const envPrefix = "GLUT"
+
+const (
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+)
+
+const (
+	mailTransportSMTP = "smtp"
+	mailTransportFile = "file"
+)
+
+const (
+	ipExtractorDirect = "direct"
+	ipExtractorRealIP = "real_ip"
+	ipExtractorXFF    = "xff"
+)
+
+// Config is the configuration of glut. Fields tagged with reload:"true" can
+// be changed by reloading the config while glut is running; changes to other
+// fields require a restart.
+type Config struct {
+	Debug bool `yaml:"debug" reload:"true"`
+	// Instance names this glut instance, e.g. in the application_name of
+	// database connections. It defaults to glut-<hostname>.
+	Instance   string          `yaml:"instance"`
+	LocalesDir string          `yaml:"locales_dir"`
+	Server     ServerConfig    `yaml:"server"`
+	Database   DatabaseConfig  `yaml:"database"`
+	Auth       AuthConfig      `yaml:"auth"`
+	Outbox     OutboxConfig    `yaml:"outbox"`
+	Webhooks   WebhooksConfig  `yaml:"webhooks"`
+	Scheduler  SchedulerConfig `yaml:"scheduler"`
+	Mail       MailConfig      `yaml:"mail"`
+}
+
+type ServerConfig struct {
+	Port              int           `yaml:"port"`
+	TLS               bool          `yaml:"tls"`
+	IPExtractor       string        `yaml:"ip_extractor"`
+	CORSOrigins       []string      `yaml:"cors_origins" reload:"true"`
+	RateLimit         int           `yaml:"rate_limit" reload:"true"`
+	RateLimitPeriod   time.Duration `yaml:"rate_limit_period" reload:"true"`
+	MaxRequestSize    int64         `yaml:"max_request_size"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+}
+
+type DatabaseConfig struct {
+	// Driver is postgres or sqlite. The URL of a sqlite database is the path
+	// of its file; replicas are not supported.
+	Driver            string        `yaml:"driver"`
+	URL               string        `yaml:"url"`
+	AutoMigrate       bool          `yaml:"auto_migrate"`
+	MinOpenConns      int           `yaml:"min_open_conns"`
+	MaxOpenConns      int           `yaml:"max_open_conns"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period"`
+	// SlowQueryThreshold is the duration above which statements are logged;
+	// 0 disables slow query logging.
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" reload:"true"`
+	// ReplicaURLs are read replicas used for queries outside transactions.
+	ReplicaURLs        []string      `yaml:"replica_urls"`
+	MaxReplicaLag      time.Duration `yaml:"max_replica_lag"`
+	ReplicaCheckPeriod time.Duration `yaml:"replica_check_period"`
+}
+
+type AuthConfig struct {
+	TokenLength                int           `yaml:"token_length" reload:"true"`
+	SessionTokenDuration       time.Duration `yaml:"session_token_duration" reload:"true"`
+	VerificationTokenDuration  time.Duration `yaml:"verification_token_duration" reload:"true"`
+	VerificationTokenWaitTime  time.Duration `yaml:"verification_token_wait_time" reload:"true"`
+	ChangeEmailTokenDuration   time.Duration `yaml:"change_email_token_duration" reload:"true"`
+	RevertEmailTokenDuration   time.Duration `yaml:"revert_email_token_duration" reload:"true"`
+	ResetPasswordTokenDuration time.Duration `yaml:"reset_password_token_duration" reload:"true"`
+	// ProtectedRoles are the roles whose last holder cannot be removed.
+	ProtectedRoles []string `yaml:"protected_roles" reload:"true"`
+	// CursorSecret signs pagination cursors. Instances serving the same
+	// clients must share it; a random secret is used if it is empty.
+	CursorSecret string `yaml:"cursor_secret"`
+}
+
+// OutboxConfig configures the delivery of the events of the outbox.
+type OutboxConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size"`
+	// MaxAttempts is the number of failed deliveries after which an event
+	// is dead.
+	MaxAttempts int           `yaml:"max_attempts"`
+	MinBackoff  time.Duration `yaml:"min_backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+	// Retention is how long delivered events are kept.
+	Retention time.Duration `yaml:"retention"`
+}
+
+// WebhooksConfig configures the delivery of webhooks.
+type WebhooksConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size"`
+	Timeout      time.Duration `yaml:"timeout"`
+	// MaxAttempts is the number of failed attempts after which a delivery
+	// fails.
+	MaxAttempts int           `yaml:"max_attempts"`
+	MinBackoff  time.Duration `yaml:"min_backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+	// MaxFailures is the number of consecutive failed attempts after which
+	// a webhook is disabled.
+	MaxFailures int `yaml:"max_failures"`
+}
+
+// SchedulerConfig configures the background jobs.
+type SchedulerConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// JobTimeout bounds each job run.
+	JobTimeout time.Duration `yaml:"job_timeout"`
+	// Retention is how long the history of job runs is kept.
+	Retention time.Duration `yaml:"retention"`
+	// CleanupInterval is how often expired sessions, tokens and bans are
+	// deleted.
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+// MailConfig configures the sending of emails.
+type MailConfig struct {
+	// From is the sender address of emails, e.g. "Glut <no-reply@example.com>".
+	From string `yaml:"from"`
+	// PublicURL is the URL of the application that links in emails point
+	// to, e.g. https://example.com/account.
+	PublicURL string `yaml:"public_url"`
+	// TemplatesDir contains email templates overriding the embedded ones.
+	TemplatesDir string `yaml:"templates_dir"`
+	// Transport is smtp, or file to write emails to Dir instead of sending
+	// them, for development.
+	Transport    string         `yaml:"transport"`
+	Dir          string         `yaml:"dir"`
+	SMTP         SMTPMailConfig `yaml:"smtp"`
+	PollInterval time.Duration  `yaml:"poll_interval"`
+	BatchSize    int            `yaml:"batch_size"`
+	Timeout      time.Duration  `yaml:"timeout"`
+	// MaxAttempts is the number of failed attempts after which an email
+	// fails.
+	MaxAttempts int           `yaml:"max_attempts"`
+	MinBackoff  time.Duration `yaml:"min_backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+}
+
+type SMTPMailConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// TLS is starttls, tls or none.
+	TLS string `yaml:"tls"`
+}
+
+// defaultConfig returns the config used for values missing from the config
+// file and the environment.
+func defaultConfig() *Config {
+	return &Config{
+		Instance: defaultInstance(),
+		Server: ServerConfig{
+			Port:              8000,
+			IPExtractor:       ipExtractorDirect,
+			RateLimitPeriod:   time.Minute,
+			MaxRequestSize:    1024 * 1024,
+			MaxHeaderBytes:    1024 * 1024,
+			ReadTimeout:       5 * time.Second,
+			ReadHeaderTimeout: 3 * time.Second,
+			WriteTimeout:      10 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   5 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Driver:             driverPostgres,
+			MinOpenConns:       5,
+			MaxOpenConns:       10,
+			MaxConnLifetime:    10 * time.Minute,
+			MaxConnIdleTime:    5 * time.Minute,
+			HealthCheckPeriod:  5 * time.Minute,
+			SlowQueryThreshold: 200 * time.Millisecond,
+			MaxReplicaLag:      5 * time.Second,
+			ReplicaCheckPeriod: 5 * time.Second,
+		},
+		Auth: AuthConfig{
+			TokenLength:                24,
+			SessionTokenDuration:       30 * time.Minute,
+			VerificationTokenDuration:  24 * time.Hour,
+			VerificationTokenWaitTime:  5 * time.Minute,
+			ChangeEmailTokenDuration:   3 * time.Hour,
+			RevertEmailTokenDuration:   7 * 24 * time.Hour,
+			ResetPasswordTokenDuration: 3 * time.Hour,
+			ProtectedRoles:             []string{"admin"},
+		},
+		Outbox: OutboxConfig{
+			PollInterval: time.Second,
+			BatchSize:    100,
+			MaxAttempts:  10,
+			MinBackoff:   time.Second,
+			MaxBackoff:   time.Hour,
+			Retention:    7 * 24 * time.Hour,
+		},
+		Webhooks: WebhooksConfig{
+			PollInterval: time.Second,
+			BatchSize:    20,
+			Timeout:      10 * time.Second,
+			MaxAttempts:  10,
+			MinBackoff:   10 * time.Second,
+			MaxBackoff:   time.Hour,
+			MaxFailures:  50,
+		},
+		Scheduler: SchedulerConfig{
+			PollInterval:    10 * time.Second,
+			JobTimeout:      10 * time.Minute,
+			Retention:       30 * 24 * time.Hour,
+			CleanupInterval: time.Hour,
+		},
+		Mail: MailConfig{
+			From:      "Glut <no-reply@localhost>",
+			PublicURL: "http://localhost:8000",
+			Transport: mailTransportFile,
+			Dir:       "mail",
+			SMTP: SMTPMailConfig{
+				Port: 587,
+				TLS:  mail.TLSStartTLS,
+			},
+			PollInterval: time.Second,
+			BatchSize:    10,
+			Timeout:      30 * time.Second,
+			MaxAttempts:  10,
+			MinBackoff:   10 * time.Second,
+			MaxBackoff:   time.Hour,
+		},
+	}
+}
+
+// loadConfig loads the config file at path over the defaults, applies
+// environment variable overrides and validates the result. Unknown keys in
+// the config file are reported as errors.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), envPrefix, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that all config values are usable.
+func (c *Config) Validate() error {
+	var errs valid.Errors
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, valid.OutOfRange("server.port", 1, 65535))
+	}
+	switch c.Server.IPExtractor {
+	case ipExtractorDirect, ipExtractorRealIP, ipExtractorXFF:
+	default:
+		errs = append(errs, valid.Error{
+			Field:  "server.ip_extractor",
+			Code:   valid.CodeInvalid,
+			Params: map[string]any{"allowed": []string{ipExtractorDirect, ipExtractorRealIP, ipExtractorXFF}},
+			Error:  fmt.Sprintf("Must be one of %s, %s or %s.", ipExtractorDirect, ipExtractorRealIP, ipExtractorXFF),
+		})
+	}
+	errs = appendNonNegative(errs, "server.rate_limit", int64(c.Server.RateLimit))
+	errs = appendNonNegative(errs, "server.rate_limit_period", c.Server.RateLimitPeriod)
+	errs = appendNonNegative(errs, "server.max_request_size", c.Server.MaxRequestSize)
+	errs = appendNonNegative(errs, "server.max_header_bytes", int64(c.Server.MaxHeaderBytes))
+	errs = appendNonNegative(errs, "server.read_timeout", c.Server.ReadTimeout)
+	errs = appendNonNegative(errs, "server.read_header_timeout", c.Server.ReadHeaderTimeout)
+	errs = appendNonNegative(errs, "server.write_timeout", c.Server.WriteTimeout)
+	errs = appendNonNegative(errs, "server.idle_timeout", c.Server.IdleTimeout)
+	errs = appendNonNegative(errs, "server.shutdown_timeout", c.Server.ShutdownTimeout)
+
+	switch c.Database.Driver {
+	case driverPostgres, driverSQLite:
+	default:
+		errs = append(errs, valid.Error{
+			Field:  "database.driver",
+			Code:   valid.CodeInvalid,
+			Params: map[string]any{"allowed": []string{driverPostgres, driverSQLite}},
+			Error:  fmt.Sprintf("Must be %s or %s.", driverPostgres, driverSQLite),
+		})
+	}
+	if c.Database.URL == "" {
+		errs = append(errs, valid.Required("database.url"))
+	}
+	errs = appendNonNegative(errs, "database.min_open_conns", int64(c.Database.MinOpenConns))
+	errs = appendNonNegative(errs, "database.max_open_conns", int64(c.Database.MaxOpenConns))
+	if c.Database.MaxOpenConns > 0 && c.Database.MinOpenConns > c.Database.MaxOpenConns {
+		errs = append(errs, valid.Error{
+			Field: "database.min_open_conns",
+			Code:  valid.CodeInvalid,
+			Error: "Must not exceed database.max_open_conns.",
+		})
+	}
+	errs = appendNonNegative(errs, "database.max_conn_lifetime", c.Database.MaxConnLifetime)
+	errs = appendNonNegative(errs, "database.max_conn_idle_time", c.Database.MaxConnIdleTime)
+	errs = appendNonNegative(errs, "database.health_check_period", c.Database.HealthCheckPeriod)
+	if c.Database.Driver == driverSQLite && len(c.Database.ReplicaURLs) != 0 {
+		errs = append(errs, valid.Error{
+			Field: "database.replica_urls",
+			Code:  valid.CodeInvalid,
+			Error: "Not supported by the sqlite driver.",
+		})
+	}
+	for i, url := range c.Database.ReplicaURLs {
+		if url == "" {
+			errs = append(errs, valid.Required(fmt.Sprintf("database.replica_urls[%d]", i)))
+		}
+	}
+	errs = appendNonNegative(errs, "database.slow_query_threshold", c.Database.SlowQueryThreshold)
+	errs = appendNonNegative(errs, "database.max_replica_lag", c.Database.MaxReplicaLag)
+	errs = appendNonNegative(errs, "database.replica_check_period", c.Database.ReplicaCheckPeriod)
+
+	errs = appendNonNegative(errs, "outbox.poll_interval", c.Outbox.PollInterval)
+	errs = appendNonNegative(errs, "outbox.batch_size", int64(c.Outbox.BatchSize))
+	errs = appendNonNegative(errs, "outbox.max_attempts", int64(c.Outbox.MaxAttempts))
+	errs = appendNonNegative(errs, "outbox.min_backoff", c.Outbox.MinBackoff)
+	errs = appendNonNegative(errs, "outbox.max_backoff", c.Outbox.MaxBackoff)
+	errs = appendNonNegative(errs, "outbox.retention", c.Outbox.Retention)
+
+	errs = appendNonNegative(errs, "webhooks.poll_interval", c.Webhooks.PollInterval)
+	errs = appendNonNegative(errs, "webhooks.batch_size", int64(c.Webhooks.BatchSize))
+	errs = appendNonNegative(errs, "webhooks.timeout", c.Webhooks.Timeout)
+	errs = appendNonNegative(errs, "webhooks.max_attempts", int64(c.Webhooks.MaxAttempts))
+	errs = appendNonNegative(errs, "webhooks.min_backoff", c.Webhooks.MinBackoff)
+	errs = appendNonNegative(errs, "webhooks.max_backoff", c.Webhooks.MaxBackoff)
+	errs = appendNonNegative(errs, "webhooks.max_failures", int64(c.Webhooks.MaxFailures))
+
+	errs = appendNonNegative(errs, "scheduler.poll_interval", c.Scheduler.PollInterval)
+	errs = appendNonNegative(errs, "scheduler.job_timeout", c.Scheduler.JobTimeout)
+	errs = appendNonNegative(errs, "scheduler.retention", c.Scheduler.Retention)
+	errs = appendNonNegative(errs, "scheduler.cleanup_interval", c.Scheduler.CleanupInterval)
+
+	if c.Mail.From == "" {
+		errs = append(errs, valid.Required("mail.from"))
+	} else if _, err := netmail.ParseAddress(c.Mail.From); err != nil {
+		errs = append(errs, valid.Invalid("mail.from"))
+	}
+	if u, err := url.Parse(c.Mail.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, valid.Error{
+			Field: "mail.public_url",
+			Code:  valid.CodeInvalid,
+			Error: "Must be an absolute http or https URL.",
+		})
+	}
+	switch c.Mail.Transport {
+	case mailTransportSMTP:
+		if c.Mail.SMTP.Host == "" {
+			errs = append(errs, valid.Required("mail.smtp.host"))
+		}
+		if c.Mail.SMTP.Port < 1 || c.Mail.SMTP.Port > 65535 {
+			errs = append(errs, valid.OutOfRange("mail.smtp.port", 1, 65535))
+		}
+		switch c.Mail.SMTP.TLS {
+		case mail.TLSStartTLS, mail.TLSImplicit, mail.TLSNone:
+		default:
+			errs = append(errs, valid.Error{
+				Field:  "mail.smtp.tls",
+				Code:   valid.CodeInvalid,
+				Params: map[string]any{"allowed": []string{mail.TLSStartTLS, mail.TLSImplicit, mail.TLSNone}},
+				Error:  fmt.Sprintf("Must be one of %s, %s or %s.", mail.TLSStartTLS, mail.TLSImplicit, mail.TLSNone),
+			})
+		}
+	case mailTransportFile:
+		if c.Mail.Dir == "" {
+			errs = append(errs, valid.Required("mail.dir"))
+		}
+	default:
+		errs = append(errs, valid.Error{
+			Field:  "mail.transport",
+			Code:   valid.CodeInvalid,
+			Params: map[string]any{"allowed": []string{mailTransportSMTP, mailTransportFile}},
+			Error:  fmt.Sprintf("Must be %s or %s.", mailTransportSMTP, mailTransportFile),
+		})
+	}
+	errs = appendNonNegative(errs, "mail.poll_interval", c.Mail.PollInterval)
+	errs = appendNonNegative(errs, "mail.batch_size", int64(c.Mail.BatchSize))
+	errs = appendNonNegative(errs, "mail.timeout", c.Mail.Timeout)
+	errs = appendNonNegative(errs, "mail.max_attempts", int64(c.Mail.MaxAttempts))
+	errs = appendNonNegative(errs, "mail.min_backoff", c.Mail.MinBackoff)
+	errs = appendNonNegative(errs, "mail.max_backoff", c.Mail.MaxBackoff)
+
+	if err := c.authConfig().Validate(); err != nil {
+		var verrs valid.Errors
+		if !errors.As(err, &verrs) {
+			return err
+		}
+		for _, verr := range verrs {
+			verr.Field = "auth." + verr.Field
+			errs = append(errs, verr)
+		}
+	}
+
+	if len(errs) != 0 {
+		return &configError{errs}
+	}
+	return nil
+}
+
+// defaultInstance returns the default instance name, derived from the host
+// name.
+func defaultInstance() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "glut"
+	}
+	return "glut-" + host
+}
+
+// serverOptions returns the flux server options of the config.
+func (c *Config) serverOptions() *flux.ServerOptions {
+	opts := &flux.ServerOptions{
+		Debug:             c.Debug,
+		Port:              c.Server.Port,
+		TLS:               c.Server.TLS,
+		MaxRequestSize:    c.Server.MaxRequestSize,
+		MaxHeaderBytes:    c.Server.MaxHeaderBytes,
+		ReadTimeout:       c.Server.ReadTimeout,
+		ReadHeaderTimeout: c.Server.ReadHeaderTimeout,
+		WriteTimeout:      c.Server.WriteTimeout,
+		IdleTimeout:       c.Server.IdleTimeout,
+		ShutdownTimeout:   c.Server.ShutdownTimeout,
+		SecurityPolicy:    c.securityPolicy(),
+	}
+	switch c.Server.IPExtractor {
+	case ipExtractorRealIP:
+		opts.IPExtractor = flux.ExtractIPFromRealIPHeader()
+	case ipExtractorXFF:
+		opts.IPExtractor = flux.ExtractIPFromXFFHeader()
+	default:
+		opts.IPExtractor = flux.ExtractIPDirect()
+	}
+	return opts
+}
+
+// securityPolicy returns the flux security policy of the config.
+func (c *Config) securityPolicy() *flux.SecurityPolicy {
+	return &flux.SecurityPolicy{
+		CORSOrigins:     c.Server.CORSOrigins,
+		RateLimit:       c.Server.RateLimit,
+		RateLimitPeriod: c.Server.RateLimitPeriod,
+	}
+}
+
+// postgresConfig returns the database config of the config.
+func (c *Config) postgresConfig() *postgres.Config {
+	return &postgres.Config{
+		URL:                c.Database.URL,
+		MinOpenConns:       c.Database.MinOpenConns,
+		MaxOpenConns:       c.Database.MaxOpenConns,
+		MaxConnLifetime:    c.Database.MaxConnLifetime,
+		MaxConnIdleTime:    c.Database.MaxConnIdleTime,
+		HealthCheckPeriod:  c.Database.HealthCheckPeriod,
+		ApplicationName:    c.Instance,
+		SlowQueryThreshold: c.Database.SlowQueryThreshold,
+		ReplicaURLs:        c.Database.ReplicaURLs,
+		MaxReplicaLag:      c.Database.MaxReplicaLag,
+		ReplicaCheckPeriod: c.Database.ReplicaCheckPeriod,
+	}
+}
+
+// sqliteConfig returns the database config of the config for the sqlite
+// driver.
+func (c *Config) sqliteConfig() *sqlite.Config {
+	return &sqlite.Config{
+		URL:             c.Database.URL,
+		MaxOpenConns:    c.Database.MaxOpenConns,
+		MaxConnLifetime: c.Database.MaxConnLifetime,
+		MaxConnIdleTime: c.Database.MaxConnIdleTime,
+	}
+}
+
+// authConfig returns the auth service config of the config.
+func (c *Config) authConfig() *auth.Config {
+	return &auth.Config{
+		TokenLength:                c.Auth.TokenLength,
+		SessionTokenDuration:       c.Auth.SessionTokenDuration,
+		VerificationTokenDuration:  c.Auth.VerificationTokenDuration,
+		VerificationTokenWaitTime:  c.Auth.VerificationTokenWaitTime,
+		ChangeEmailTokenDuration:   c.Auth.ChangeEmailTokenDuration,
+		RevertEmailTokenDuration:   c.Auth.RevertEmailTokenDuration,
+		ResetPasswordTokenDuration: c.Auth.ResetPasswordTokenDuration,
+		ProtectedRoles:             c.Auth.ProtectedRoles,
+		CursorKey:                  []byte(c.Auth.CursorSecret),
+	}
+}
+
+// dispatcherOptions returns the outbox dispatcher options of the config.
+func (c *Config) dispatcherOptions() *auth.DispatcherOptions {
+	return &auth.DispatcherOptions{
+		PollInterval: c.Outbox.PollInterval,
+		BatchSize:    c.Outbox.BatchSize,
+		MaxAttempts:  c.Outbox.MaxAttempts,
+		MinBackoff:   c.Outbox.MinBackoff,
+		MaxBackoff:   c.Outbox.MaxBackoff,
+		Retention:    c.Outbox.Retention,
+	}
+}
+
+// webhookSenderOptions returns the webhook sender options of the config.
+func (c *Config) webhookSenderOptions() *auth.WebhookSenderOptions {
+	return &auth.WebhookSenderOptions{
+		PollInterval: c.Webhooks.PollInterval,
+		BatchSize:    c.Webhooks.BatchSize,
+		Timeout:      c.Webhooks.Timeout,
+		MaxAttempts:  c.Webhooks.MaxAttempts,
+		MinBackoff:   c.Webhooks.MinBackoff,
+		MaxBackoff:   c.Webhooks.MaxBackoff,
+		MaxFailures:  c.Webhooks.MaxFailures,
+	}
+}
+
+// schedulerOptions returns the scheduler options of the config.
+func (c *Config) schedulerOptions() *scheduler.Options {
+	return &scheduler.Options{
+		Instance:     c.Instance,
+		PollInterval: c.Scheduler.PollInterval,
+		Timeout:      c.Scheduler.JobTimeout,
+		Retention:    c.Scheduler.Retention,
+	}
+}
+
+// mailer returns the mailer of the config.
+func (c *Config) mailer() mail.Mailer {
+	if c.Mail.Transport == mailTransportFile {
+		return mail.NewFileMailer(c.Mail.Dir)
+	}
+	return mail.NewSMTPMailer(&mail.SMTPConfig{
+		Host:     c.Mail.SMTP.Host,
+		Port:     c.Mail.SMTP.Port,
+		Username: c.Mail.SMTP.Username,
+		Password: c.Mail.SMTP.Password,
+		TLS:      c.Mail.SMTP.TLS,
+	})
+}
+
+// emailTemplates loads the email templates of the config.
+func (c *Config) emailTemplates() (*auth.EmailTemplates, error) {
+	return auth.LoadEmailTemplates(c.Mail.TemplatesDir, c.Mail.PublicURL)
+}
+
+// mailSenderOptions returns the mail sender options of the config.
+func (c *Config) mailSenderOptions() *auth.MailSenderOptions {
+	return &auth.MailSenderOptions{
+		From:         c.Mail.From,
+		PollInterval: c.Mail.PollInterval,
+		BatchSize:    c.Mail.BatchSize,
+		Timeout:      c.Mail.Timeout,
+		MaxAttempts:  c.Mail.MaxAttempts,
+		MinBackoff:   c.Mail.MinBackoff,
+		MaxBackoff:   c.Mail.MaxBackoff,
+	}
+}
+
+// configError is returned when a config fails validation.
+type configError struct {
+	errs valid.Errors
+}
+
+// Error satisfies the error interface.
+func (e *configError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("invalid config:")
+	for _, err := range e.errs {
+		sb.WriteString(fmt.Sprintf("\n  %s: %s", err.Field, err.Error))
+	}
+	return sb.String()
+}
+
+// appendNonNegative appends an error to errs if val is negative.
+func appendNonNegative[T int64 | time.Duration](errs valid.Errors, field string, val T) valid.Errors {
+	if val < 0 {
+		return append(errs, valid.Error{Field: field, Code: valid.CodeInvalid, Error: "Must not be negative."})
+	}
+	return errs
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides the fields of v with environment variables named after
+// their yaml keys. Nested structs are walked recursively.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnv(fv, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := lookup(name)
+		if path, fileOK := lookup(name + "_FILE"); fileOK && fv.Kind() == reflect.String {
+			if ok {
+				return fmt.Errorf("invalid config: both %s and %s_FILE are set", name, name)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("invalid config: %s_FILE: %w", name, err)
+			}
+			value, ok = strings.TrimRight(string(b), "\r\n"), true
+		}
+		if !ok {
+			continue
+		}
+		if err := setValue(fv, value); err != nil {
+			return fmt.Errorf("invalid config: %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setValue parses s into v according to the kind of v.
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
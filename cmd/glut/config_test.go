@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file with content and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  port: 9000
+  cors_origins: [https://example.com]
+database:
+  url: postgres://localhost/glut
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9000 || !reflect.DeepEqual(cfg.Server.CORSOrigins, []string{"https://example.com"}) {
+		t.Errorf("server config = %+v, want the values of the file", cfg.Server)
+	}
+	// Values missing from the file keep their defaults.
+	def := defaultConfig()
+	if cfg.Server.ReadTimeout != def.Server.ReadTimeout || cfg.Database.MaxOpenConns != def.Database.MaxOpenConns ||
+		cfg.Database.Driver != driverPostgres || !reflect.DeepEqual(cfg.Auth.ProtectedRoles, []string{"admin"}) {
+		t.Errorf("config = %+v, want defaults for missing values", cfg)
+	}
+}
+
+func TestLoadConfigMissingSections(t *testing.T) {
+	for name, content := range map[string]string{
+		"no server":    "database:\n  url: glut.db\n  driver: sqlite\n",
+		"empty server": "server:\ndatabase:\n  url: glut.db\n  driver: sqlite\n",
+	} {
+		cfg, err := loadConfig(writeConfig(t, content))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if cfg.Server.Port != 8000 || cfg.Server.IPExtractor != ipExtractorDirect {
+			t.Errorf("%s: server config = %+v, want the defaults", name, cfg.Server)
+		}
+	}
+	if _, err := loadConfig(writeConfig(t, "")); err == nil || !strings.Contains(err.Error(), "database.url") {
+		t.Errorf("empty config: got %v, want an error on database.url", err)
+	}
+}
+
+func TestLoadConfigUnknownFields(t *testing.T) {
+	for _, content := range []string{
+		"database:\n  url: glut.db\nservr:\n  port: 9000\n",
+		"database:\n  url: glut.db\nserver:\n  prot: 9000\n",
+	} {
+		if _, err := loadConfig(writeConfig(t, content)); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("loadConfig(%q) = %v, want an unknown field error", content, err)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("postgres://user:pass@db/glut\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"GLUT_DEBUG":                   "true",
+		"GLUT_SERVER_PORT":             "9000",
+		"GLUT_SERVER_CORS_ORIGINS":     "https://a.example.com, https://b.example.com,",
+		"GLUT_SERVER_READ_TIMEOUT":     "1m",
+		"GLUT_SERVER_MAX_REQUEST_SIZE": "2048",
+		"GLUT_DATABASE_URL_FILE":       secret,
+		"GLUT_MAIL_SMTP_HOST":          "smtp.example.com",
+		"GLUT_AUTH_PROTECTED_ROLES":    "admin,owner",
+		"GLUT_AUTH_TOKEN_LENGTH_FILE":  secret, // only strings are read from files
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	cfg := defaultConfig()
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), envPrefix, lookup); err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string]struct{ got, want any }{
+		"debug":                {cfg.Debug, true},
+		"server.port":          {cfg.Server.Port, 9000},
+		"server.cors_origins":  {cfg.Server.CORSOrigins, []string{"https://a.example.com", "https://b.example.com"}},
+		"server.read_timeout":  {cfg.Server.ReadTimeout, time.Minute},
+		"server.max_request":   {cfg.Server.MaxRequestSize, int64(2048)},
+		"database.url":         {cfg.Database.URL, "postgres://user:pass@db/glut"},
+		"mail.smtp.host":       {cfg.Mail.SMTP.Host, "smtp.example.com"},
+		"auth.protected_roles": {cfg.Auth.ProtectedRoles, []string{"admin", "owner"}},
+		"auth.token_length":    {cfg.Auth.TokenLength, defaultConfig().Auth.TokenLength},
+		"server.write_timeout": {cfg.Server.WriteTimeout, 10 * time.Second},
+	} {
+		if !reflect.DeepEqual(got.got, got.want) {
+			t.Errorf("%s = %v, want %v", name, got.got, got.want)
+		}
+	}
+
+	for name, env := range map[string]map[string]string{
+		"invalid int":      {"GLUT_SERVER_PORT": "http"},
+		"invalid bool":     {"GLUT_SERVER_TLS": "sometimes"},
+		"invalid duration": {"GLUT_OUTBOX_RETENTION": "7 days"},
+		"value and file":   {"GLUT_DATABASE_URL": "glut.db", "GLUT_DATABASE_URL_FILE": secret},
+		"missing file":     {"GLUT_DATABASE_URL_FILE": filepath.Join(t.TempDir(), "missing")},
+	} {
+		lookup := func(name string) (string, bool) {
+			v, ok := env[name]
+			return v, ok
+		}
+		err := applyEnv(reflect.ValueOf(defaultConfig()).Elem(), envPrefix, lookup)
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid config: ") || !strings.Contains(err.Error(), "GLUT_") {
+			t.Errorf("%s: got %v, want an error naming the variable", name, err)
+		}
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	path := writeConfig(t, "server:\n  port: 9000\n")
+	t.Setenv("GLUT_SERVER_PORT", "9001")
+	t.Setenv("GLUT_DATABASE_URL", "postgres://localhost/glut")
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9001 || cfg.Database.URL != "postgres://localhost/glut" {
+		t.Errorf("port %d and database URL %q, want the environment to override the file", cfg.Server.Port, cfg.Database.URL)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Database.URL = "postgres://localhost/glut"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate of the defaults = %v", err)
+	}
+
+	for _, tt := range []struct {
+		set   func(c *Config)
+		error string
+	}{
+		{func(c *Config) { c.Server.Port = 0 }, "server.port: "},
+		{func(c *Config) { c.Server.Port = 70000 }, "server.port: "},
+		{func(c *Config) { c.Server.IPExtractor = "forwarded" }, "server.ip_extractor: Must be one of direct, real_ip or xff."},
+		{func(c *Config) { c.Server.ReadTimeout = -time.Second }, "server.read_timeout: Must not be negative."},
+		{func(c *Config) { c.Database.Driver = "mysql" }, "database.driver: Must be postgres or sqlite."},
+		{func(c *Config) { c.Database.URL = "" }, "database.url: "},
+		{func(c *Config) { c.Database.MinOpenConns = 20 }, "database.min_open_conns: Must not exceed database.max_open_conns."},
+		{func(c *Config) {
+			c.Database.Driver = driverSQLite
+			c.Database.ReplicaURLs = []string{"glut-replica.db"}
+		}, "database.replica_urls: Not supported by the sqlite driver."},
+		{func(c *Config) { c.Database.ReplicaURLs = []string{""} }, "database.replica_urls[0]: "},
+		{func(c *Config) { c.Mail.From = "no-reply" }, "mail.from: "},
+		{func(c *Config) { c.Mail.PublicURL = "/account" }, "mail.public_url: Must be an absolute http or https URL."},
+		{func(c *Config) { c.Mail.Transport = mailTransportSMTP }, "mail.smtp.host: "},
+		{func(c *Config) { c.Mail.Transport = "sendmail" }, "mail.transport: Must be smtp or file."},
+		{func(c *Config) { c.Auth.TokenLength = -1 }, "auth.token_length: "},
+	} {
+		cfg := defaultConfig()
+		cfg.Database.URL = "postgres://localhost/glut"
+		tt.set(cfg)
+		err := cfg.Validate()
+		var cerr *configError
+		if !errors.As(err, &cerr) || !strings.HasPrefix(err.Error(), "invalid config:\n  ") || !strings.Contains(err.Error(), "\n  "+tt.error) {
+			t.Errorf("Validate = %v, want an error containing %q", err, tt.error)
+		}
+	}
+
+	// All errors are reported.
+	cfg = defaultConfig()
+	cfg.Server.Port = 0
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "server.port") || !strings.Contains(err.Error(), "database.url") {
+		t.Errorf("Validate = %v, want errors on server.port and database.url", err)
+	}
+}
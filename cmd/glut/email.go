@@ -0,0 +1,43 @@
+package main
+
+import "context"
+
+// emailPreview renders an email template with sample data, as configured
+// with mail.templates_dir and mail.public_url.
+func emailPreview(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut email preview", "<kind>")
+	locale := fs.String("locale", "en", "locale to render the email in")
+	alert := fs.String("alert", "", "security alert of security_alert emails, password_changed or email_changed")
+	html := fs.Bool("html", false, "print the HTML body instead of the text body")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	cfg, err := a.config()
+	if err != nil {
+		return err
+	}
+	templates, err := cfg.emailTemplates()
+	if err != nil {
+		return err
+	}
+	e, err := templates.Preview(*locale, fs.Arg(0), *alert)
+	if err != nil {
+		return err
+	}
+
+	if *html {
+		if e.HTML == "" {
+			a.printf("The %s email has no HTML body.\n", e.Kind)
+			return nil
+		}
+		a.printf("%s", e.HTML)
+		return nil
+	}
+	a.printf("To: %s\nSubject: %s\n\n%s", e.To, e.Subject, e.Text)
+	return nil
+}
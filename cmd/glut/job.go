@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// jobHistory lists the last runs of background jobs.
+func jobHistory(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut job history", "")
+	job := fs.String("job", "", "only list runs of the job with this name")
+	limit := fs.Int("limit", 20, "maximum number of runs to list")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := a.openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	runs, err := db.jobs.Runs(ctx, *job, *limit)
+	if err != nil {
+		return err
+	}
+	w := a.table()
+	fmt.Fprintln(w, "JOB\tSTATUS\tINSTANCE\tSTARTED\tFINISHED\tERROR")
+	for _, r := range runs {
+		finished, msg := "-", "-"
+		if r.FinishedAt != nil {
+			finished = r.FinishedAt.Format(time.RFC3339)
+		}
+		if r.Error != nil {
+			msg = *r.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Job, r.Status, r.Instance, r.StartedAt.Format(time.RFC3339), finished, msg)
+	}
+	return w.Flush()
+}
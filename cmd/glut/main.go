@@ -2,20 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
-	"glut/auth"
-	authapi "glut/auth/api"
-	"glut/common/flux"
+	"fmt"
 	"glut/common/log"
-	"glut/common/postgres"
 	"log/slog"
 	"os"
 	"os/signal"
 	"runtime/debug"
 	"syscall"
-	"time"
-
-	"gopkg.in/yaml.v3"
 )
 
 func main() {
@@ -23,13 +18,16 @@ func main() {
 	defer stop()
 
 	logger := log.New()
-	if err := run(ctx, logger); err != nil {
+	if err := run(ctx, logger, os.Args[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 }
 
-func run(ctx context.Context, logger *slog.Logger) error {
+func run(ctx context.Context, logger *slog.Logger, args []string) error {
 	defer func() {
 		// Recover from panics and log the error.
 		if x := recover(); x != nil {
@@ -40,84 +38,44 @@ func run(ctx context.Context, logger *slog.Logger) error {
 		}
 	}()
 
-	var configPath string
-	flag.StringVar(&configPath, "config", "config.yaml", "path to config file")
-	flag.Parse()
-
-	cfg, err := loadConfig(configPath)
-	if err != nil {
-		return err
-	}
-
-	if cfg.Debug {
-		log.SetDebug()
-		logger.Debug("Debugging enabled.")
+	fs := flag.NewFlagSet("glut", flag.ContinueOnError)
+	configPath := fs.String("config", configPathFromEnv(), "path to config file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: glut [-config path] <command> [arguments]\n\nFlags:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nRun 'glut help' for a list of commands.\n")
 	}
-
-	db, err := postgres.New(ctx, &postgres.Config{
-		URL: cfg.Database.URL,
-	})
-	if err != nil {
-		return err
-	}
-	if err := db.Ping(ctx); err != nil {
+	if err := parseFlags(fs, args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
 		return err
 	}
-	defer db.Close()
 
-	s := flux.NewServer(&flux.ServerOptions{
-		Debug:             true,
-		Logger:            logger,
-		Port:              cfg.Server.Port,
-		ReadTimeout:       cfg.Server.ReadTimeout,
-		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
-		WriteTimeout:      cfg.Server.WriteTimeout,
-		IdleTimeout:       cfg.Server.IdleTimeout,
-		ShutdownTimeout:   cfg.Server.ShutdownTimeout,
-		Authenticator:     auth.NewAuthenticator(db),
-	})
-
-	authapi.Handler(s, auth.NewService(db, &auth.Config{}))
-
-	if err := s.Start(ctx); err != nil {
-		return err
+	a := &app{
+		configPath: *configPath,
+		logger:     logger,
+		stdin:      os.Stdin,
+		stdout:     os.Stdout,
 	}
-	defer s.Stop()
-	return nil
-}
 
-func loadConfig(path string) (*Config, error) {
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+	// Serve if no command is given.
+	args = fs.Args()
+	if len(args) == 0 {
+		args = []string{"serve"}
 	}
-	var cfg *Config
-	if err := yaml.Unmarshal(b, &cfg); err != nil {
-		return nil, err
+	err := a.runCommand(ctx, "glut", commands, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
 	}
-	return cfg, nil
+	return err
 }
 
-type Config struct {
-	Debug    bool            `yaml:"debug"`
-	Server   *ServerConfig   `yaml:"server"`
-	Database *DatabaseConfig `yaml:"database"`
-}
-
-type ServerConfig struct {
-	Port              int           `yaml:"port"`
-	ReadTimeout       time.Duration `yaml:"read_timeout"`
-	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
-	WriteTimeout      time.Duration `yaml:"write_timeout"`
-	IdleTimeout       time.Duration `yaml:"idle_timeout"`
-	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
-}
-
-type DatabaseConfig struct {
-	URL               string        `yaml:"url"`
-	MinOpenConns      int           `yaml:"min_open_conns"`
-	MaxOpenConns      int           `yaml:"max_open_conns"`
-	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime"`
-	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time"`
-	HealthcheckPeriod time.Duration `yaml:"healthcheck_period"`
+// configPathFromEnv returns the config path set by the GLUT_CONFIG
+// environment variable or the default path.
+func configPathFromEnv() string {
+	if path, ok := os.LookupEnv(envPrefix + "_CONFIG"); ok {
+		return path
+	}
+	return "config.yaml"
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"glut/common/migrate"
+	"glut/common/postgres"
+	"glut/common/sqlite"
+	"glut/db/migrations"
+	"strconv"
+	"time"
+)
+
+// migrator connects to the database and creates a migrator for the embedded
+// migrations. The returned function closes the connection.
+func (a *app) migrator(ctx context.Context) (*migrate.Migrator, func(), error) {
+	cfg, err := a.config()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Database.Driver == driverSQLite {
+		all, err := migrations.SQLite()
+		if err != nil {
+			return nil, nil, err
+		}
+		db, err := sqlite.Open(ctx, cfg.sqliteConfig())
+		if err != nil {
+			return nil, nil, err
+		}
+		return migrate.NewSQLite(db, all, a.logger), func() { db.Close() }, nil
+	}
+
+	all, err := migrations.All()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := postgres.Connect(ctx, cfg.Database.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	done := func() { conn.Close(context.Background()) }
+	return migrate.New(conn, all, a.logger), done, nil
+}
+
+// migrateUp applies all pending migrations.
+func migrateUp(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut migrate up", "")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	m, done, err := a.migrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	count, err := m.Up(ctx)
+	if err != nil {
+		return err
+	}
+	a.printf("Applied %d migrations.\n", count)
+	return nil
+}
+
+// migrateDown reverts the most recently applied migrations.
+func migrateDown(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut migrate down", "")
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *steps < 1 {
+		fs.Usage()
+		return errUsage
+	}
+	m, done, err := a.migrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	count, err := m.Down(ctx, *steps)
+	if err != nil {
+		return err
+	}
+	a.printf("Reverted %d migrations.\n", count)
+	return nil
+}
+
+// migrateBaseline records the migrations up to a version as applied
+// without running them, to adopt a database created before migrations were
+// used.
+func migrateBaseline(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut migrate baseline", "<version>")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	version, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil || version < 1 {
+		fs.Usage()
+		return errUsage
+	}
+	m, done, err := a.migrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	count, err := m.Baseline(ctx, version)
+	if err != nil {
+		return err
+	}
+	a.printf("Recorded %d migrations as applied.\n", count)
+	return nil
+}
+
+// migrateStatus lists migrations and whether they are applied.
+func migrateStatus(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut migrate status", "")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	m, done, err := a.migrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := a.table()
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED")
+	for _, st := range statuses {
+		appliedAt := "-"
+		if st.AppliedAt != nil {
+			appliedAt = st.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", st.Version, st.Name, st.State, appliedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// reloader reloads the config file on SIGHUP and, if enabled, when the file
+// is modified. Only changes to settings tagged with reload:"true" are
+// applied; a reload changing any other setting is rejected as a whole.
+type reloader struct {
+	path    string
+	logger  *slog.Logger
+	apply   func(*Config)
+	cfg     *Config
+	modTime time.Time
+}
+
+// configChange is a changed config setting.
+type configChange struct {
+	key        string
+	old        string
+	new        string
+	reloadable bool
+}
+
+// newReloader creates a reloader for the config at path, currently loaded as
+// cfg. The apply function is called with each accepted config.
+func newReloader(path string, cfg *Config, logger *slog.Logger, apply func(*Config)) *reloader {
+	r := &reloader{
+		path:   path,
+		logger: logger,
+		apply:  apply,
+		cfg:    cfg,
+	}
+	r.modTime, _ = r.fileModTime()
+	return r
+}
+
+// run reloads the config until ctx is done. The config file is polled for
+// changes every watchInterval if it is greater than zero.
+func (r *reloader) run(ctx context.Context, watchInterval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if watchInterval > 0 {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.logger.Info("Received SIGHUP; reloading config.")
+			r.reload()
+		case <-tick:
+			modTime, err := r.fileModTime()
+			if err != nil || modTime.Equal(r.modTime) {
+				continue
+			}
+			r.modTime = modTime
+			r.logger.Info("Config file changed; reloading config.")
+			r.reload()
+		}
+	}
+}
+
+// reload loads and validates the config file and applies it if it only
+// changes reloadable settings.
+func (r *reloader) reload() {
+	cfg, err := loadConfig(r.path)
+	if err != nil {
+		r.logger.Error("Failed to reload config; keeping current config.", slog.String("error", err.Error()))
+		return
+	}
+
+	changes := diffConfig(r.cfg, cfg)
+	if len(changes) == 0 {
+		r.logger.Info("Config unchanged.")
+		return
+	}
+	var rejected []string
+	for _, c := range changes {
+		if !c.reloadable {
+			rejected = append(rejected, c.key)
+		}
+	}
+	if len(rejected) != 0 {
+		r.logger.Error("Config reload rejected; changed settings require a restart.", slog.Any("settings", rejected))
+		return
+	}
+
+	r.apply(cfg)
+	r.cfg = cfg
+	for _, c := range changes {
+		r.logger.Info("Config setting changed.",
+			slog.String("setting", c.key),
+			slog.String("old", c.old),
+			slog.String("new", c.new))
+	}
+}
+
+// fileModTime returns the modification time of the config file.
+func (r *reloader) fileModTime() (time.Time, error) {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// diffConfig returns the settings that differ between old and new.
+func diffConfig(old, new *Config) []configChange {
+	var changes []configChange
+	diffValues(reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), "", &changes)
+	return changes
+}
+
+// diffValues appends the differing fields of the structs a and b to changes.
+// Fields are named after their dotted yaml keys.
+func diffValues(a, b reflect.Value, prefix string, changes *[]configChange) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		av, bv := a.Field(i), b.Field(i)
+		if av.Kind() == reflect.Struct {
+			diffValues(av, bv, key, changes)
+			continue
+		}
+		if reflect.DeepEqual(av.Interface(), bv.Interface()) {
+			continue
+		}
+		*changes = append(*changes, configChange{
+			key:        key,
+			old:        fmt.Sprint(av.Interface()),
+			new:        fmt.Sprint(bv.Interface()),
+			reloadable: field.Tag.Get("reload") == "true",
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old := defaultConfig()
+	if changes := diffConfig(old, defaultConfig()); len(changes) != 0 {
+		t.Errorf("diffConfig of equal configs = %+v, want none", changes)
+	}
+
+	new := defaultConfig()
+	new.Debug = true
+	new.Server.Port = 9000
+	new.Server.CORSOrigins = []string{"https://example.com"}
+	new.Server.RateLimitPeriod = time.Hour
+	new.Database.MaxOpenConns = 20
+	want := []configChange{
+		{key: "debug", old: "false", new: "true", reloadable: true},
+		{key: "server.port", old: "8000", new: "9000"},
+		{key: "server.cors_origins", old: "[]", new: "[https://example.com]", reloadable: true},
+		{key: "server.rate_limit_period", old: "1m0s", new: "1h0m0s", reloadable: true},
+		{key: "database.max_open_conns", old: "10", new: "20"},
+	}
+	if got := diffConfig(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffConfig = %+v, want %+v", got, want)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := writeConfig(t, "database:\n  url: glut.db\n")
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	var applied []*Config
+	r := newReloader(path, cfg, slog.New(slog.NewTextHandler(&logs, nil)), func(cfg *Config) {
+		applied = append(applied, cfg)
+	})
+	reload := func(content string) string {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		logs.Reset()
+		r.reload()
+		return logs.String()
+	}
+
+	// Changes of non-reloadable settings reject the whole reload.
+	out := reload("database:\n  url: glut.db\nserver:\n  port: 9000\n  rate_limit: 10\n")
+	if len(applied) != 0 || r.cfg != cfg {
+		t.Errorf("reload changing server.port was applied")
+	}
+	if !strings.Contains(out, "Config reload rejected") || !strings.Contains(out, "settings=[server.port]") {
+		t.Errorf("rejected reload logged %q, want the rejected settings", out)
+	}
+
+	out = reload("database:\n  url: glut.db\nserver:\n  rate_limit: 10\n")
+	if len(applied) != 1 || applied[0].Server.RateLimit != 10 || r.cfg != applied[0] {
+		t.Fatalf("reload changing server.rate_limit was not applied")
+	}
+	if !strings.Contains(out, "setting=server.rate_limit old=0 new=10") {
+		t.Errorf("applied reload logged %q, want the changed setting", out)
+	}
+
+	for content, want := range map[string]string{
+		"database:\n  url: glut.db\nserver:\n  rate_limit: 10\n": "Config unchanged.",
+		"database:\n  url: glut.db\nserver:\n  rate_limit: -1\n": "Failed to reload config",
+		"database: [": "Failed to reload config",
+	} {
+		if out := reload(content); !strings.Contains(out, want) {
+			t.Errorf("reload of %q logged %q, want %q", content, out, want)
+		}
+	}
+	if len(applied) != 1 {
+		t.Errorf("%d configs applied, want 1", len(applied))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"glut/auth"
+)
+
+// roleCreate creates a role.
+func roleCreate(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut role create", "<name>")
+	description := fs.String("description", "", "description of the role")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	s, done, err := a.service(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	in := auth.CreateRoleInput{Name: fs.Arg(0)}
+	if *description != "" {
+		in.Description = description
+	}
+	role, err := s.CreateRole(a.flow(ctx), in)
+	if err != nil {
+		if errors.Is(err, auth.ErrRoleExists) {
+			return fmt.Errorf("role %q already exists", in.Name)
+		}
+		return serviceError(err)
+	}
+	a.printf("Created role %s (%s).\n", role.Name, role.ID)
+	return nil
+}
+
+// roleGrant grants roles to a user.
+func roleGrant(ctx context.Context, a *app, args []string) error {
+	return roleAssign(ctx, a, "glut role grant", args, true)
+}
+
+// roleRevoke revokes roles from a user.
+func roleRevoke(ctx context.Context, a *app, args []string) error {
+	return roleAssign(ctx, a, "glut role revoke", args, false)
+}
+
+// roleAssign grants or revokes roles identified by id or name to or from a
+// user identified by id or username.
+func roleAssign(ctx context.Context, a *app, path string, args []string, grant bool) error {
+	fs := newFlagSet(path, "<user> <role>...")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	s, done, err := a.service(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	f := a.flow(ctx)
+
+	userID, err := a.resolveUser(f, s, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	roleIDs := make([]string, fs.NArg()-1)
+	for i, role := range fs.Args()[1:] {
+		if roleIDs[i], err = a.resolveRole(f, s, role); err != nil {
+			return err
+		}
+	}
+
+	if grant {
+		count, err := s.AssignRoles(f, auth.AssignRolesInput{UserID: userID, RoleIDs: roleIDs})
+		if err != nil {
+			if errors.Is(err, auth.ErrUserNotFound) {
+				return fmt.Errorf("user %q not found", fs.Arg(0))
+			}
+			if errors.Is(err, auth.ErrRoleNotFound) {
+				return errors.New("role not found")
+			}
+			return serviceError(err)
+		}
+		a.printf("Granted %d roles to %s.\n", count, fs.Arg(0))
+		return nil
+	}
+
+	count, err := s.UnassignRoles(f, auth.UnassignRolesInput{UserID: userID, RoleIDs: roleIDs})
+	if err != nil {
+		return serviceError(err)
+	}
+	a.printf("Revoked %d roles from %s.\n", count, fs.Arg(0))
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"glut/auth/seed"
+	"sort"
+)
+
+// seedData loads fixtures and generates users and sessions. Fixtures are
+// loaded before users are generated.
+func seedData(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut seed", "")
+	fixtures := fs.String("fixtures", "", "path of a YAML fixtures file to load")
+	in := seed.GenerateInput{}
+	fs.IntVar(&in.Users, "users", 0, "number of users to generate")
+	fs.IntVar(&in.Sessions, "sessions", 0, "number of sessions to generate per user")
+	fs.StringVar(&in.Password, "password", seed.DefaultPassword, "password of the generated users")
+	fs.IntVar(&in.Concurrency, "concurrency", 4, "number of users generated in parallel")
+	fs.Int64Var(&in.Seed, "seed", 0, "seed of the generated users, random if 0")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *fixtures == "" && in.Users == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	if in.Sessions < 0 || in.Sessions > seed.MaxGeneratedSessions {
+		return fmt.Errorf("sessions must be between 0 and %d", seed.MaxGeneratedSessions)
+	}
+
+	var fx *seed.Fixtures
+	if *fixtures != "" {
+		var err error
+		if fx, err = seed.ReadFixtures(*fixtures); err != nil {
+			return err
+		}
+	}
+
+	s, done, err := a.service(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if fx != nil {
+		res, err := seed.Load(ctx, s, a.logger, fx)
+		if err != nil {
+			return serviceError(err)
+		}
+		for _, name := range sortedKeys(res.Created) {
+			a.printf("Created %d %s.\n", res.Created[name], name)
+		}
+		for _, name := range sortedKeys(res.Skipped) {
+			a.printf("Skipped %d existing %s.\n", res.Skipped[name], name)
+		}
+	}
+	if in.Users > 0 {
+		res, err := seed.Generate(ctx, s, a.logger, in)
+		a.printf("Generated %d users and %d sessions.\n", res.Users, res.Sessions)
+		if err != nil {
+			return serviceError(err)
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
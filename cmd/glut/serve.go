@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"glut/auth"
+	authapi "glut/auth/api"
+	"glut/common/flux"
+	"glut/common/i18n"
+	"glut/common/log"
+	"glut/common/postgres"
+	"glut/common/scheduler"
+	"log/slog"
+	"time"
+)
+
+// serve starts the server and blocks until ctx is done.
+func serve(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut serve", "")
+	watchConfig := fs.Bool("watch", false, "reload config when the config file changes")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	cfg, err := a.config()
+	if err != nil {
+		return err
+	}
+	logger := a.logger
+
+	if cfg.Debug {
+		log.SetDebug()
+		logger.Debug("Debugging enabled.")
+	}
+
+	if cfg.Database.AutoMigrate {
+		m, done, err := a.migrator(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = m.Up(ctx)
+		done()
+		if err != nil {
+			return err
+		}
+	}
+
+	db, err := a.openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.close()
+	if db.tracer != nil {
+		defer logStatementStats(logger, db.tracer)
+	}
+
+	catalog := i18n.NewCatalog()
+	if cfg.LocalesDir != "" {
+		catalog, err = i18n.LoadCatalog(cfg.LocalesDir)
+		if err != nil {
+			return err
+		}
+		logger.Debug("Loaded message catalog.", slog.Any("locales", catalog.Locales()))
+	}
+
+	opts := cfg.serverOptions()
+	opts.Logger = logger
+	opts.Authenticator = auth.NewAuthenticator(db.store)
+	opts.Catalog = catalog
+	s := flux.NewServer(opts)
+
+	templates, err := cfg.emailTemplates()
+	if err != nil {
+		return err
+	}
+	logger.Debug("Loaded email templates.", slog.Any("locales", templates.Locales()))
+	authCfg := cfg.authConfig()
+	authCfg.EmailTemplates = templates
+	service := auth.NewService(db.store, authCfg)
+	authapi.Handler(s, service)
+
+	dispatcherOpts := cfg.dispatcherOptions()
+	dispatcherOpts.Logger = logger
+	dispatcher := auth.NewDispatcher(db.store, dispatcherOpts)
+
+	senderOpts := cfg.webhookSenderOptions()
+	senderOpts.Logger = logger
+	sender := auth.NewWebhookSender(db.store, senderOpts)
+	dispatcher.Register(sender)
+	go dispatcher.Run(ctx)
+	go sender.Run(ctx)
+
+	mailSenderOpts := cfg.mailSenderOptions()
+	mailSenderOpts.Logger = logger
+	go auth.NewMailSender(db.store, cfg.mailer(), mailSenderOpts).Run(ctx)
+
+	schedulerOpts := cfg.schedulerOptions()
+	schedulerOpts.Logger = logger
+	jobs := scheduler.New(db.jobs, schedulerOpts)
+	for _, job := range auth.CleanupJobs(db.store, cfg.Scheduler.CleanupInterval, logger) {
+		if err := jobs.Add(job); err != nil {
+			return err
+		}
+	}
+	jobs.Start(ctx)
+	s.OnStop(jobs.Stop)
+
+	// Reload config on SIGHUP and, if enabled, on config file changes.
+	var watchInterval time.Duration
+	if *watchConfig {
+		watchInterval = 2 * time.Second
+	}
+	go newReloader(a.configPath, cfg, logger, func(cfg *Config) {
+		if cfg.Debug {
+			log.SetDebug()
+		} else {
+			log.SetLevel(slog.LevelInfo)
+		}
+		s.SetDebug(cfg.Debug)
+		s.SetSecurityPolicy(cfg.securityPolicy())
+		if db.tracer != nil {
+			db.tracer.SetThreshold(cfg.Database.SlowQueryThreshold)
+		}
+		service.SetConfig(cfg.authConfig())
+	}).run(ctx, watchInterval)
+
+	if err := s.Start(ctx); err != nil {
+		return err
+	}
+	defer s.Stop()
+	return nil
+}
+
+// logStatementStats logs the counters of the statements with the longest
+// total duration at debug level.
+func logStatementStats(logger *slog.Logger, tracer *postgres.Tracer) {
+	const maxStatements = 10
+	stats := tracer.Stats()
+	if len(stats) > maxStatements {
+		stats = stats[:maxStatements]
+	}
+	for _, st := range stats {
+		logger.Debug("Statement stats.",
+			slog.String("sql", st.SQL),
+			slog.Int64("calls", st.Calls),
+			slog.Int64("errors", st.Errors),
+			slog.Int64("slow", st.Slow),
+			slog.Int64("rows", st.Rows),
+			slog.Int64("total_ms", st.Total.Milliseconds()),
+			slog.Int64("max_ms", st.Max.Milliseconds()))
+	}
+}
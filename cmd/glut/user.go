@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"glut/auth"
+	"time"
+)
+
+// userCreate creates a user. The password is read from stdin if the
+// -password flag is not set.
+func userCreate(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut user create", "")
+	username := fs.String("username", "", "username of the user")
+	email := fs.String("email", "", "email of the user")
+	password := fs.String("password", "", "password of the user; read from stdin if not set")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	pw, err := a.readPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	s, done, err := a.service(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	user, err := s.CreateUser(a.flow(ctx), auth.CreateUserInput{
+		Username: *username,
+		Email:    *email,
+		Password: pw,
+	})
+	if err != nil {
+		if errors.Is(err, auth.ErrUserExists) {
+			return fmt.Errorf("user %q already exists", *username)
+		}
+		return serviceError(err)
+	}
+	a.printf("Created user %s (%s).\n", user.Username, user.ID)
+	return nil
+}
+
+// userList lists users.
+func userList(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut user list", "")
+	in := auth.UserQuery{}
+	fs.IntVar(&in.Limit, "limit", 20, "maximum number of users to list")
+	fs.IntVar(&in.Offset, "offset", 0, "number of users to skip")
+	fs.StringVar(&in.Sort, "sort", "", "sort order as field,direction (e.g. created_at,desc)")
+	fs.StringVar(&in.Username, "username", "", "only list users with a username containing this value")
+	fs.StringVar(&in.Email, "email", "", "only list users with an email containing this value")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	s, done, err := a.service(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	users, err := s.Users(a.flow(ctx), in)
+	if err != nil {
+		return serviceError(err)
+	}
+	w := a.table()
+	fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tCREATED\tLAST LOGIN")
+	for _, u := range users.Items {
+		lastLogin := "-"
+		if u.LastLoginAt != nil {
+			lastLogin = u.LastLoginAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", u.ID, u.Username, u.Email, u.CreatedAt.Format(time.RFC3339), lastLogin)
+	}
+	return w.Flush()
+}
+
+// userDelete deletes users identified by id or username.
+func userDelete(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut user delete", "<user>...")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	s, done, err := a.service(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	f := a.flow(ctx)
+
+	ids := make([]string, fs.NArg())
+	for i, user := range fs.Args() {
+		if ids[i], err = a.resolveUser(f, s, user); err != nil {
+			return err
+		}
+	}
+	count, err := s.DeleteUsers(f, auth.DeleteUsersInput{IDs: ids})
+	if err != nil {
+		return serviceError(err)
+	}
+	a.printf("Deleted %d users.\n", count)
+	return nil
+}
+
+// userResetPassword sets the password of a user and deletes all of the
+// user's sessions. The password is read from stdin if the -password flag is
+// not set.
+func userResetPassword(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("glut user reset-password", "<user>")
+	password := fs.String("password", "", "new password; read from stdin if not set")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	pw, err := a.readPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	s, done, err := a.service(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	f := a.flow(ctx)
+
+	userID, err := a.resolveUser(f, s, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := s.SetPassword(f, auth.SetPasswordInput{UserID: userID, Password: pw}); err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return fmt.Errorf("user %q not found", fs.Arg(0))
+		}
+		return serviceError(err)
+	}
+	a.printf("Password of %s changed.\n", fs.Arg(0))
+	return nil
+}
@@ -2,6 +2,7 @@ package flux
 
 import (
 	"net/http"
+	"slices"
 	"strings"
 )
 
@@ -10,6 +11,18 @@ type Session struct {
 	ID   string
 	IP   string
 	User string
+	// Permissions are the names of the permissions granted to the session.
+	Permissions []string
+}
+
+// HasPermissions reports whether s is granted all of the permissions.
+func (s *Session) HasPermissions(permissions ...string) bool {
+	for _, p := range permissions {
+		if !slices.Contains(s.Permissions, p) {
+			return false
+		}
+	}
+	return true
 }
 
 // Authenticator...
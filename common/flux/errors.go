@@ -2,6 +2,7 @@ package flux
 
 import (
 	"fmt"
+	"glut/common/valid"
 	"log/slog"
 	"net/http"
 )
@@ -11,15 +12,18 @@ type Error struct {
 	Code     string
 	Status   int
 	Message  string
+	Params   map[string]any
 	Errors   any
 	Internal error
 }
 
 // Errors
 var (
-	InternalError     = NewError("internal", http.StatusInternalServerError, "Something went wrong.")
-	UnauthorizedError = NewError("unauthorized", http.StatusUnauthorized, "Unauthorized")
-	InvalidError      = func(format string, args ...any) *Error {
+	InternalError        = NewError("internal", http.StatusInternalServerError, "Something went wrong.")
+	UnauthorizedError    = NewError("unauthorized", http.StatusUnauthorized, "Unauthorized")
+	ForbiddenError       = NewError("forbidden", http.StatusForbidden, "Forbidden.")
+	TooManyRequestsError = NewError("too_many_requests", http.StatusTooManyRequests, "Too many requests.")
+	InvalidError         = func(format string, args ...any) *Error {
 		return &Error{
 			Code:    "invalid",
 			Status:  http.StatusBadRequest,
@@ -80,6 +84,14 @@ func (e *Error) SetInternal(err error) *Error {
 	return e
 }
 
+// SetParams sets the parameters of Error. Parameters are returned to the
+// client and used to format localized messages. A "resource" parameter
+// selects a resource-specific message from the catalog.
+func (e *Error) SetParams(params map[string]any) *Error {
+	e.Params = params
+	return e
+}
+
 // handleError handles errors that occur during an HTTP request.
 func (s *Server) handleError(f *Flow, err error) {
 	e, ok := err.(*Error)
@@ -91,13 +103,18 @@ func (s *Server) handleError(f *Flow, err error) {
 	res := map[string]any{
 		"code":    e.Code,
 		"status":  e.Status,
-		"message": e.Message,
+		"message": s.catalog.Error(f.Locale, e.Code, e.Params, e.Message),
+	}
+	if e.Params != nil {
+		res["params"] = e.Params
 	}
-	if e.Errors != nil {
+	if verrs, ok := e.Errors.(valid.Errors); ok {
+		res["errors"] = s.localizeValidation(f.Locale, verrs)
+	} else if e.Errors != nil {
 		res["errors"] = e.Errors
 	}
 
-	if s.debug {
+	if s.debug.Load() {
 		if e.Internal != nil {
 			res["internal"] = e.Internal.Error()
 		} else if !ok {
@@ -106,3 +123,13 @@ func (s *Server) handleError(f *Flow, err error) {
 	}
 	f.Respond(e.Status, res)
 }
+
+// localizeValidation returns a copy of errs with messages in the given locale.
+func (s *Server) localizeValidation(locale string, errs valid.Errors) valid.Errors {
+	localized := make(valid.Errors, len(errs))
+	for i, err := range errs {
+		err.Error = s.catalog.Validation(locale, err.Code, err.Params, err.Error)
+		localized[i] = err
+	}
+	return localized
+}
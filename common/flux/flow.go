@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"glut/common/i18n"
+	"glut/common/log"
 	"io"
 	"log/slog"
 	"net/http"
@@ -21,19 +23,39 @@ type Flow struct {
 	Logger  *slog.Logger
 	ID      string
 	IP      string
+	Locale  string
 	Time    time.Time
 	Session *Session
 }
 
+// NewFlow creates a Flow that is not bound to an HTTP request. It is used to
+// call services outside of handlers, such as from command line tools. The
+// flow has no session unless one is set by the caller.
+func NewFlow(ctx context.Context, logger *slog.Logger) *Flow {
+	id := uuid.New().String()
+	logger = logger.With(slog.String("request_id", id))
+	return &Flow{
+		Ctx:    log.NewContext(ctx, logger),
+		Logger: logger,
+		ID:     id,
+		Locale: i18n.DefaultLocale,
+		Time:   time.Now().UTC(),
+	}
+}
+
 // Bind...
 func (f *Flow) Bind(v any) error {
 	dec := json.NewDecoder(f.r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(v); err != nil {
 		if ute, ok := err.(*json.UnmarshalTypeError); ok {
-			return InvalidError("Unmarshal type error: expected=%v, got=%v, field=%v, offset=%v", ute.Type, ute.Value, ute.Field, ute.Offset).SetInternal(err)
+			return InvalidError("Unmarshal type error: expected=%v, got=%v, field=%v, offset=%v", ute.Type, ute.Value, ute.Field, ute.Offset).
+				SetParams(map[string]any{"expected": ute.Type.String(), "got": ute.Value, "field": ute.Field, "offset": ute.Offset}).
+				SetInternal(err)
 		} else if se, ok := err.(*json.SyntaxError); ok {
-			return InvalidError("Syntax error: offset=%v, error=%v", se.Offset, se.Error()).SetInternal(err)
+			return InvalidError("Syntax error: offset=%v, error=%v", se.Offset, se.Error()).
+				SetParams(map[string]any{"offset": se.Offset}).
+				SetInternal(err)
 		} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
 			return InvalidError("Invalid input.").SetInternal(err)
 		}
@@ -64,6 +86,7 @@ func (f *Flow) init(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	id := uuid.New().String()
 	ip := f.s.ipExtractor(r)
+	locale := f.s.catalog.Match(r.Header.Get(HeaderAcceptLanguage))
 	now := time.Now().UTC()
 
 	logger := f.s.logger.With(
@@ -74,10 +97,11 @@ func (f *Flow) init(w http.ResponseWriter, r *http.Request) {
 	)
 
 	f.r = r
-	f.Ctx = ctx
+	f.Ctx = log.NewContext(ctx, logger)
 	f.Logger = logger
 	f.ID = id
 	f.IP = ip
+	f.Locale = locale
 	f.Time = now
 	f.Session = nil
 }
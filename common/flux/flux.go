@@ -20,10 +20,10 @@ type Options struct {
 	// server will respond with a 401 Unauthorized error. If this option
 	// is set, then a value must be provided for ServerOptions.Authenticator.
 	RequireAuth bool
-	// Set the required permissions for this handler. This option requires
-	// the Authenticate option to also be set. If this option is set and
-	// the authenticated user does not have all required permissions, then
-	// the server will respond with a 403 Forbidden error.
+	// Set the required permissions for this handler. This option implies
+	// RequireAuth. If this option is set and the authenticated session does
+	// not have all required permissions, then the server will respond with
+	// a 403 Forbidden error.
 	Permissions []string
 	// Set a maximum request size for this handler. This option overrides
 	// any value set for ServerOptions.MaxRequestSize.
@@ -45,7 +45,7 @@ func (f *Flux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer f.server.pool.Put(flow)
 	flow.init(w, r)
 
-	if f.server.debug {
+	if f.server.debug.Load() {
 		defer func() {
 			flow.Logger.With(
 				slog.Int64("elapsed_ms", time.Since(flow.Time).Milliseconds()),
@@ -66,7 +66,13 @@ func (f *Flux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add(HeaderReferrerPolicy, "same-origin")
 	w.Header().Add(HeaderXFrameOptions, "DENY")
 
-	// TODO: rate limit requests using request IP
+	f.server.setCORSHeaders(w, r)
+
+	// Rate limit requests using request IP.
+	if limiter := f.server.policy.Load().limiter; limiter != nil && !limiter.allow(flow.IP, flow.Time) {
+		f.server.handleError(flow, TooManyRequestsError)
+		return
+	}
 
 	// Limit the size of incoming request bodies.
 	if f.options.MaxRequestSize != 0 {
@@ -87,11 +93,16 @@ func (f *Flux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		flow.Session = session
 	}
 
-	if f.options.RequireAuth && flow.Session == nil {
+	if (f.options.RequireAuth || len(f.options.Permissions) != 0) && flow.Session == nil {
 		f.server.handleError(flow, UnauthorizedError)
 		return
 	}
 
+	if !flow.Session.HasPermissions(f.options.Permissions...) {
+		f.server.handleError(flow, ForbiddenError)
+		return
+	}
+
 	if err := f.handler(flow); err != nil {
 		f.server.handleError(flow, err)
 	}
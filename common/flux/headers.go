@@ -2,6 +2,7 @@ package flux
 
 // Headers
 const (
+	HeaderAcceptLanguage       = "Accept-Language"
 	HeaderAuthorization        = "Authorization"
 	HeaderContentEncoding      = "Content-Encoding"
 	HeaderContentLength        = "Content-Length"
@@ -10,6 +11,8 @@ const (
 	HeaderXForwardedFor        = "X-Forwarded-For"
 	HeaderXRealIP              = "X-Real-Ip"
 	HeaderXRequestID           = "X-Request-Id"
+	HeaderOrigin               = "Origin"
+	HeaderVary                 = "Vary"
 
 	// Access control
 	HeaderAccessControlRequestMethod    = "Access-Control-Request-Method"
@@ -8,7 +8,7 @@ import (
 // DefaultLogger creates the default logger used by the server.
 func (s *Server) DefaultLogger() *slog.Logger {
 	logLevel := &slog.LevelVar{}
-	if s.debug {
+	if s.debug.Load() {
 		logLevel.Set(slog.LevelDebug)
 	}
 	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
@@ -0,0 +1,162 @@
+package flux
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRateLimitPeriod = time.Minute
+
+// SecurityPolicy holds the security settings of a Server that can be
+// replaced while the server is running using Server.SetSecurityPolicy.
+type SecurityPolicy struct {
+	// CORSOrigins are the origins allowed to make cross-origin requests.
+	// The value "*" allows any other origin to make requests without
+	// credentials. Cross-origin requests are rejected by browsers if no
+	// origins are set.
+	CORSOrigins []string
+	// RateLimit is the maximum number of requests accepted from a single
+	// IP address within RateLimitPeriod. Rate limiting is disabled if zero.
+	RateLimit int
+	// RateLimitPeriod is the period over which RateLimit applies. It is one
+	// minute by default.
+	RateLimitPeriod time.Duration
+}
+
+// policy is the compiled form of a SecurityPolicy.
+type policy struct {
+	anyOrigin bool
+	origins   map[string]struct{}
+	limiter   *rateLimiter
+}
+
+// newPolicy compiles a SecurityPolicy.
+func newPolicy(sp *SecurityPolicy) *policy {
+	p := &policy{origins: make(map[string]struct{})}
+	if sp == nil {
+		return p
+	}
+	for _, origin := range sp.CORSOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		p.origins[strings.TrimSuffix(origin, "/")] = struct{}{}
+	}
+	if sp.RateLimit > 0 {
+		period := sp.RateLimitPeriod
+		if period <= 0 {
+			period = defaultRateLimitPeriod
+		}
+		p.limiter = newRateLimiter(sp.RateLimit, period)
+	}
+	return p
+}
+
+// allowOrigin reports whether cross-origin requests from origin are allowed
+// and whether they may include credentials, which only listed origins may.
+func (p *policy) allowOrigin(origin string) (allowed, credentials bool) {
+	if origin == "" {
+		return false, false
+	}
+	if _, ok := p.origins[origin]; ok {
+		return true, true
+	}
+	return p.anyOrigin, false
+}
+
+// SetSecurityPolicy atomically replaces the security policy of the server.
+// Rate limit counters are kept unless the rate limit or its period changes,
+// so that replacing the policy does not reset the limits of clients.
+func (s *Server) SetSecurityPolicy(sp *SecurityPolicy) {
+	p := newPolicy(sp)
+	if old := s.policy.Load(); old != nil && old.limiter != nil && p.limiter != nil &&
+		old.limiter.limit == p.limiter.limit && old.limiter.period == p.limiter.period {
+		p.limiter = old.limiter
+	}
+	s.policy.Store(p)
+}
+
+// setCORSHeaders sets the CORS response headers for an allowed origin.
+func (s *Server) setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get(HeaderOrigin)
+	allowed, credentials := s.policy.Load().allowOrigin(origin)
+	if !allowed {
+		return false
+	}
+	if credentials {
+		w.Header().Set(HeaderAccessControlAllowOrigin, origin)
+		w.Header().Set(HeaderAccessControlAllowCredentials, "true")
+	} else {
+		w.Header().Set(HeaderAccessControlAllowOrigin, "*")
+	}
+	w.Header().Set(HeaderAccessControlExposeHeaders, HeaderXRequestID)
+	w.Header().Add(HeaderVary, HeaderOrigin)
+	return true
+}
+
+// preflight responds to a CORS preflight request.
+func (s *Server) preflight(w http.ResponseWriter, r *http.Request) {
+	if !s.setCORSHeaders(w, r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	w.Header().Set(HeaderAccessControlAllowMethods, http.MethodPost)
+	w.Header().Set(HeaderAccessControlAllowHeaders, strings.Join([]string{HeaderAuthorization, HeaderContentType, HeaderAcceptLanguage}, ", "))
+	w.Header().Set(HeaderAccessControlMaxAge, strconv.Itoa(int(time.Hour.Seconds())))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rateLimiter limits the number of requests per key using fixed windows.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	period    time.Duration
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+// window counts the requests of a key since start.
+type window struct {
+	start time.Time
+	count int
+}
+
+// newRateLimiter creates a new rateLimiter.
+func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		period:  period,
+		windows: make(map[string]*window),
+	}
+}
+
+// allow reports whether a request for key at now is within the limit.
+func (rl *rateLimiter) allow(key string, now time.Time) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Remove expired windows so the map does not grow without bound.
+	if now.Sub(rl.lastSweep) > rl.period {
+		for k, w := range rl.windows {
+			if now.Sub(w.start) > rl.period {
+				delete(rl.windows, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	w, ok := rl.windows[key]
+	if !ok || now.Sub(w.start) > rl.period {
+		rl.windows[key] = &window{start: now, count: 1}
+		return true
+	}
+	if w.count >= rl.limit {
+		return false
+	}
+	w.count++
+	return true
+}
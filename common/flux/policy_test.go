@@ -0,0 +1,122 @@
+package flux
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(2, time.Minute)
+	for _, tt := range []struct {
+		key   string
+		after time.Duration
+		want  bool
+	}{
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", time.Second, true},
+		{"10.0.0.1", 2 * time.Second, false},
+		{"10.0.0.2", 2 * time.Second, true}, // keys have their own windows
+		{"10.0.0.1", time.Minute, false},    // the window includes its end
+		{"10.0.0.1", time.Minute + time.Second, true},
+		{"10.0.0.1", time.Minute + 2*time.Second, true},
+		{"10.0.0.1", time.Minute + 3*time.Second, false},
+	} {
+		if got := rl.allow(tt.key, start.Add(tt.after)); got != tt.want {
+			t.Errorf("allow(%s) after %v = %v, want %v", tt.key, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(1, time.Minute)
+	rl.allow("10.0.0.1", start)
+	rl.allow("10.0.0.2", start.Add(30*time.Second))
+	if len(rl.windows) != 2 {
+		t.Fatalf("%d windows, want 2", len(rl.windows))
+	}
+
+	// Windows are only swept once per period.
+	rl.allow("10.0.0.3", start.Add(61*time.Second))
+	if len(rl.windows) != 2 {
+		t.Errorf("%d windows after the first sweep, want 2", len(rl.windows))
+	}
+	if _, ok := rl.windows["10.0.0.1"]; ok {
+		t.Error("expired window was not swept")
+	}
+	rl.allow("10.0.0.4", start.Add(100*time.Second))
+	if len(rl.windows) != 3 {
+		t.Errorf("%d windows before the next sweep, want 3", len(rl.windows))
+	}
+	rl.allow("10.0.0.4", start.Add(200*time.Second))
+	if len(rl.windows) != 1 {
+		t.Errorf("%d windows after the next sweep, want 1", len(rl.windows))
+	}
+}
+
+func TestSetSecurityPolicy(t *testing.T) {
+	s := NewServer(&ServerOptions{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	s.SetSecurityPolicy(&SecurityPolicy{RateLimit: 1})
+	limiter := s.policy.Load().limiter
+	limiter.allow("10.0.0.1", time.Now())
+
+	// Reloading the same limits keeps the windows of clients.
+	s.SetSecurityPolicy(&SecurityPolicy{RateLimit: 1, RateLimitPeriod: time.Minute, CORSOrigins: []string{"https://example.com"}})
+	if got := s.policy.Load().limiter; got != limiter {
+		t.Error("limiter was replaced without a change of the rate limit")
+	}
+	if limiter.allow("10.0.0.1", time.Now()) {
+		t.Error("window was reset by replacing the policy")
+	}
+	for _, sp := range []*SecurityPolicy{
+		{RateLimit: 2, RateLimitPeriod: time.Minute},
+		{RateLimit: 2, RateLimitPeriod: time.Hour},
+	} {
+		s.SetSecurityPolicy(sp)
+		if got := s.policy.Load().limiter; got == limiter {
+			t.Errorf("limiter was kept after changing the rate limit to %+v", sp)
+		}
+		limiter = s.policy.Load().limiter
+	}
+	s.SetSecurityPolicy(&SecurityPolicy{})
+	if got := s.policy.Load().limiter; got != nil {
+		t.Error("limiter was kept after disabling rate limiting")
+	}
+}
+
+func TestCORSHeaders(t *testing.T) {
+	s := NewServer(&ServerOptions{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	for _, tt := range []struct {
+		origins     []string
+		origin      string
+		allowed     bool
+		allowOrigin string
+		credentials string
+	}{
+		{nil, "https://example.com", false, "", ""},
+		{[]string{"https://example.com/"}, "https://example.com", true, "https://example.com", "true"},
+		{[]string{"https://example.com"}, "https://other.com", false, "", ""},
+		{[]string{"*"}, "https://other.com", true, "*", ""},
+		{[]string{"*", "https://example.com"}, "https://example.com", true, "https://example.com", "true"},
+		{[]string{"*"}, "", false, "", ""},
+	} {
+		s.SetSecurityPolicy(&SecurityPolicy{CORSOrigins: tt.origins})
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		if tt.origin != "" {
+			r.Header.Set(HeaderOrigin, tt.origin)
+		}
+		w := httptest.NewRecorder()
+		allowed := s.setCORSHeaders(w, r)
+		allowOrigin := w.Header().Get(HeaderAccessControlAllowOrigin)
+		credentials := w.Header().Get(HeaderAccessControlAllowCredentials)
+		if allowed != tt.allowed || allowOrigin != tt.allowOrigin || credentials != tt.credentials {
+			t.Errorf("origins %v, origin %q: got %v, %q, %q, want %v, %q, %q",
+				tt.origins, tt.origin, allowed, allowOrigin, credentials, tt.allowed, tt.allowOrigin, tt.credentials)
+		}
+	}
+}
@@ -15,7 +15,7 @@ type router struct {
 
 // ServeHTTP...
 func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	if r.Method != http.MethodPost && r.Method != http.MethodOptions {
 		notFound(w, r)
 		return
 	}
@@ -24,6 +24,10 @@ func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		notFound(w, r)
 		return
 	}
+	if r.Method == http.MethodOptions {
+		rt.server.preflight(w, r)
+		return
+	}
 
 	defer func() {
 		if err := recover(); err != nil {
@@ -5,11 +5,13 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"glut/common/i18n"
 	"log/slog"
 	"net"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/acme"
@@ -33,14 +35,17 @@ type Server struct {
 	server             *http.Server
 	pool               sync.Pool
 	port               int
-	debug              bool
+	debug              atomic.Bool
 	tls                bool
 	logger             *slog.Logger
 	ipExtractor        IPExtractor
 	authenticator      Authenticator
 	authTokenExtractor AuthTokenExtractor
+	catalog            *i18n.Catalog
+	policy             atomic.Pointer[policy]
 	shutdownTimeout    time.Duration
 	maxRequestSize     int64
+	onStop             []func(ctx context.Context)
 }
 
 // ServerOptions...
@@ -53,6 +58,15 @@ type ServerOptions struct {
 	AuthTokenExtractor AuthTokenExtractor
 	IPExtractor        IPExtractor
 
+	// Catalog holds the translations of error messages. The locale of each
+	// request is selected from its Accept-Language header. Messages are
+	// returned in English if no catalog is set.
+	Catalog *i18n.Catalog
+
+	// SecurityPolicy sets the initial CORS and rate limit settings of the
+	// server. It can be replaced later using Server.SetSecurityPolicy.
+	SecurityPolicy *SecurityPolicy
+
 	// MaxRequestSize is the maximum accepted request size in bytes.
 	// This is used to prevent a denial of service attack where no Content-Length
 	// is provided and the server is fed data until it exhausts memory.
@@ -76,6 +90,7 @@ func (s *Server) ConfigureServer(options *ServerOptions) {
 	s.ipExtractor = ExtractIPDirect()
 	s.maxRequestSize = defaultMaxRequestSize
 	s.shutdownTimeout = defaultShutdownTimeout
+	s.policy.Store(newPolicy(nil))
 
 	// Configure flow pool.
 	s.pool.New = func() interface{} {
@@ -120,7 +135,7 @@ func (s *Server) ConfigureServer(options *ServerOptions) {
 	}
 	// Configure server with provided options.
 	if options.Debug {
-		s.debug = true
+		s.debug.Store(true)
 	}
 	if options.Port != 0 {
 		s.port = options.Port
@@ -140,6 +155,12 @@ func (s *Server) ConfigureServer(options *ServerOptions) {
 	if options.IPExtractor != nil {
 		s.ipExtractor = options.IPExtractor
 	}
+	if options.Catalog != nil {
+		s.catalog = options.Catalog
+	}
+	if options.SecurityPolicy != nil {
+		s.SetSecurityPolicy(options.SecurityPolicy)
+	}
 	if options.MaxRequestSize != 0 {
 		s.maxRequestSize = options.MaxRequestSize
 	}
@@ -155,6 +176,13 @@ func NewServer(options *ServerOptions) *Server {
 	return s
 }
 
+// SetDebug enables or disables debug mode while the server is running. In
+// debug mode, handled requests are logged and internal errors are included
+// in error responses.
+func (s *Server) SetDebug(debug bool) {
+	s.debug.Store(debug)
+}
+
 // Start...
 func (s *Server) Start(ctx context.Context) error {
 	ln, err := s.newListener(":" + strconv.Itoa(s.port))
@@ -196,9 +224,26 @@ func (s *Server) Stop() {
 		s.server.Close()
 	}
 
+	for _, fn := range s.onStop {
+		fn(shutdownCtx)
+	}
+
 	s.logger.Debug("Server shutdown complete.")
 }
 
+// OnStop registers fn to be called by Stop once the server is shut down.
+// Functions are called in order with a context done at the end of the
+// shutdown timeout.
+func (s *Server) OnStop(fn func(ctx context.Context)) {
+	s.onStop = append(s.onStop, fn)
+}
+
+// ServeHTTP serves a request with the handlers of s, without the server
+// listening, e.g. in tests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
 // Handle...
 func (s *Server) Handle(name string, handler HandlerFunc, options *Options) {
 	s.router.table[name] = &Flux{
@@ -0,0 +1,197 @@
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultLocale is the locale of the messages defined in Go code. A Catalog
+// never needs to provide messages for it.
+const DefaultLocale = "en"
+
+// Catalog holds translated messages for a set of locales. Messages are keyed
+// by error code and may reference parameters using {name} placeholders.
+type Catalog struct {
+	locales map[string]*Messages
+}
+
+// Messages are the translated messages of a single locale.
+type Messages struct {
+	// Errors are keyed by flux error code, optionally suffixed with the
+	// resource the error refers to (e.g. "not_found.user").
+	Errors map[string]string `yaml:"errors"`
+	// Validation messages are keyed by validation error code.
+	Validation map[string]string `yaml:"validation"`
+}
+
+// NewCatalog creates an empty Catalog.
+func NewCatalog() *Catalog {
+	return &Catalog{locales: make(map[string]*Messages)}
+}
+
+// LoadCatalog loads a Catalog from a directory containing one YAML file per
+// locale, named after the locale (e.g. "fr.yaml", "pt-BR.yaml").
+func LoadCatalog(dir string) (*Catalog, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("i18n.LoadCatalog: %w", err)
+	}
+	c := NewCatalog()
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("i18n.LoadCatalog: %w", err)
+		}
+		var m Messages
+		if err := yaml.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("i18n.LoadCatalog: %s: %w", path, err)
+		}
+		c.Add(strings.TrimSuffix(filepath.Base(path), ".yaml"), &m)
+	}
+	return c, nil
+}
+
+// Add adds the messages of a locale to the catalog, replacing any messages
+// previously added for it.
+func (c *Catalog) Add(locale string, m *Messages) {
+	c.locales[normalize(locale)] = m
+}
+
+// Locales returns the locales available in the catalog.
+func (c *Catalog) Locales() []string {
+	locales := make([]string, 0, len(c.locales))
+	for locale := range c.locales {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Match returns the best locale in the catalog for an Accept-Language header
+// value. DefaultLocale is returned if none of the requested locales match.
+func (c *Catalog) Match(acceptLanguage string) string {
+	if c == nil || acceptLanguage == "" {
+		return DefaultLocale
+	}
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		if tag == "*" {
+			break
+		}
+		if tag == DefaultLocale {
+			return DefaultLocale
+		}
+		if _, ok := c.locales[tag]; ok {
+			return tag
+		}
+		if base, _, ok := strings.Cut(tag, "-"); ok {
+			if base == DefaultLocale {
+				return DefaultLocale
+			}
+			if _, ok := c.locales[base]; ok {
+				return base
+			}
+		}
+	}
+	return DefaultLocale
+}
+
+// Error returns the message of a flux error in the given locale. The
+// resource-specific key "code.resource" is tried before "code". The default
+// message is returned if the catalog has no translation.
+func (c *Catalog) Error(locale, code string, params map[string]any, def string) string {
+	m := c.messages(locale)
+	if m == nil {
+		return def
+	}
+	if resource, ok := params["resource"].(string); ok {
+		if msg, ok := m.Errors[code+"."+resource]; ok {
+			return Format(msg, params)
+		}
+	}
+	if msg, ok := m.Errors[code]; ok {
+		return Format(msg, params)
+	}
+	return def
+}
+
+// Validation returns the message of a validation error in the given locale.
+// The default message is returned if the catalog has no translation.
+func (c *Catalog) Validation(locale, code string, params map[string]any, def string) string {
+	m := c.messages(locale)
+	if m == nil {
+		return def
+	}
+	if msg, ok := m.Validation[code]; ok {
+		return Format(msg, params)
+	}
+	return def
+}
+
+// messages returns the messages of a locale or nil if there are none.
+func (c *Catalog) messages(locale string) *Messages {
+	if c == nil || locale == DefaultLocale {
+		return nil
+	}
+	return c.locales[normalize(locale)]
+}
+
+// Format replaces {name} placeholders in msg with the matching parameters.
+// Placeholders without a matching parameter are left as is.
+func Format(msg string, params map[string]any) string {
+	if len(params) == 0 || !strings.Contains(msg, "{") {
+		return msg
+	}
+	pairs := make([]string, 0, len(params)*2)
+	for k, v := range params {
+		pairs = append(pairs, "{"+k+"}", fmt.Sprint(v))
+	}
+	return strings.NewReplacer(pairs...).Replace(msg)
+}
+
+// parseAcceptLanguage returns the language tags of an Accept-Language header
+// value ordered by descending quality. Tags with a quality of zero are
+// omitted.
+func parseAcceptLanguage(value string) []string {
+	type entry struct {
+		tag string
+		q   float64
+	}
+	var entries []entry
+	for _, part := range strings.Split(value, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		entries = append(entries, entry{normalize(tag), q})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].q > entries[j].q
+	})
+	tags := make([]string, len(entries))
+	for i, e := range entries {
+		tags[i] = e.tag
+	}
+	return tags
+}
+
+// normalize returns the canonical form of a language tag used as catalog key.
+func normalize(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
@@ -0,0 +1,125 @@
+package i18n
+
+import (
+	"slices"
+	"testing"
+)
+
+func testCatalog() *Catalog {
+	c := NewCatalog()
+	c.Add("es", &Messages{
+		Errors: map[string]string{
+			"not_found":      "No encontrado.",
+			"not_found.user": "Usuario {id} no encontrado.",
+		},
+		Validation: map[string]string{
+			"out_of_range": "Debe estar entre {min} y {max}.",
+		},
+	})
+	c.Add("pt_BR", &Messages{Errors: map[string]string{"not_found": "Não encontrado."}})
+	c.Add("fr", &Messages{})
+	return c
+}
+
+func TestMatch(t *testing.T) {
+	c := testCatalog()
+	for _, tt := range []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", DefaultLocale},
+		{"es", "es"},
+		{"ES", "es"},
+		{"es-MX", "es"},
+		{"es-MX,es;q=0.9", "es"},
+		{"pt-BR", "pt-br"},
+		{"pt_br", "pt-br"},
+		{"pt", DefaultLocale},
+		{"pt-PT", DefaultLocale},
+		{"de", DefaultLocale},
+		{"de, es;q=0.5", "es"},
+		{"es;q=0.5, fr;q=0.8", "fr"},
+		{"fr;q=0.1, es;q=0.9, en;q=0.5", "es"},
+		{"es;q=0.5, en;q=0.8", DefaultLocale},
+		{"en-GB, es", DefaultLocale},
+		{"es;q=0, fr;q=0.1", "fr"},
+		{"es;q=x, fr", "fr"},
+		{"*, es;q=0.5", DefaultLocale},
+		{"de, *;q=0.9, es;q=0.5", DefaultLocale},
+	} {
+		if got := c.Match(tt.acceptLanguage); got != tt.want {
+			t.Errorf("Match(%q) = %q, want %q", tt.acceptLanguage, got, tt.want)
+		}
+	}
+	var nilCatalog *Catalog
+	if got := nilCatalog.Match("es"); got != DefaultLocale {
+		t.Errorf("Match of a nil catalog = %q, want %q", got, DefaultLocale)
+	}
+}
+
+func TestError(t *testing.T) {
+	c := testCatalog()
+	for _, tt := range []struct {
+		locale string
+		code   string
+		params map[string]any
+		want   string
+	}{
+		{"es", "not_found", map[string]any{"resource": "user", "id": 42}, "Usuario 42 no encontrado."},
+		{"es", "not_found", map[string]any{"resource": "role"}, "No encontrado."},
+		{"es", "not_found", map[string]any{"resource": 1}, "No encontrado."},
+		{"es", "not_found", nil, "No encontrado."},
+		{"es", "gone", map[string]any{"resource": "user"}, "Not found."},
+		{"pt-br", "not_found", map[string]any{"resource": "user"}, "Não encontrado."},
+		{"fr", "not_found", nil, "Not found."},
+		{"de", "not_found", nil, "Not found."},
+		{DefaultLocale, "not_found", nil, "Not found."},
+	} {
+		if got := c.Error(tt.locale, tt.code, tt.params, "Not found."); got != tt.want {
+			t.Errorf("Error(%s, %s, %v) = %q, want %q", tt.locale, tt.code, tt.params, got, tt.want)
+		}
+	}
+
+	params := map[string]any{"min": 1, "max": 10}
+	if got, want := c.Validation("es", "out_of_range", params, "Out of range."), "Debe estar entre 1 y 10."; got != want {
+		t.Errorf("Validation = %q, want %q", got, want)
+	}
+	if got, want := c.Validation("es", "required", nil, "Required."), "Required."; got != want {
+		t.Errorf("Validation of an untranslated code = %q, want %q", got, want)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, tt := range []struct {
+		msg    string
+		params map[string]any
+		want   string
+	}{
+		{"Between {min} and {max}.", map[string]any{"min": 1, "max": 10}, "Between 1 and 10."},
+		{"At most {max} and {max} again.", map[string]any{"max": 3}, "At most 3 and 3 again."},
+		{"Unknown {name}.", map[string]any{"max": 3}, "Unknown {name}."},
+		{"No params {max}.", nil, "No params {max}."},
+		{"No placeholders.", map[string]any{"max": 3}, "No placeholders."},
+		{"Nested {{max}}.", map[string]any{"max": 3}, "Nested {3}."},
+		{"Allowed: {allowed}.", map[string]any{"allowed": []string{"a", "b"}}, "Allowed: [a b]."},
+	} {
+		if got := Format(tt.msg, tt.params); got != tt.want {
+			t.Errorf("Format(%q, %v) = %q, want %q", tt.msg, tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestLoadCatalog(t *testing.T) {
+	c, err := LoadCatalog("../../locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Locales(); !slices.Contains(got, "es") {
+		t.Fatalf("Locales = %v, want es", got)
+	}
+	for _, code := range []string{"not_found", "too_many_requests", "forbidden"} {
+		if got := c.Error("es", code, nil, ""); got == "" {
+			t.Errorf("es has no message for %s", code)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"context"
 	"log/slog"
 	"os"
 )
@@ -20,3 +21,25 @@ func New() *slog.Logger {
 func SetDebug() {
 	logLevel.Set(slog.LevelDebug)
 }
+
+// SetLevel sets the global log level.
+func SetLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying logger. Code without access to the
+// logger of a request, such as database tracers, retrieves it with
+// FromContext.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx or def if there is none.
+func FromContext(ctx context.Context, def *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return def
+}
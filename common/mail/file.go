@@ -0,0 +1,49 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type fileMailer struct {
+	dir string
+}
+
+// NewFileMailer returns a Mailer writing each message to a .eml file in dir,
+// which serves as a local mailbox during development. Files are named after
+// the time they were written, so that they sort in order.
+func NewFileMailer(dir string) Mailer {
+	return &fileMailer{dir: dir}
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg *Message) error {
+	now := time.Now()
+	var b bytes.Buffer
+	if err := msg.Encode(&b, now); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that readers never see partial
+	// messages.
+	name := now.UTC().Format("20060102T150405.000000000Z") + "-" + randomHex(4) + ".eml"
+	tmp, err := os.CreateTemp(m.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(m.dir, name))
+}
@@ -0,0 +1,132 @@
+// Package mail sends emails.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Message is an email to a single recipient. From and To are addresses as
+// parsed by net/mail, e.g. "Glut <no-reply@example.com>".
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	// Text is the plain text body. HTML, if set, is sent as an alternative
+	// to it.
+	Text string
+	HTML string
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// addresses returns the parsed sender and recipient of m.
+func (m *Message) addresses() (from, to *mail.Address, err error) {
+	if from, err = mail.ParseAddress(m.From); err != nil {
+		return nil, nil, fmt.Errorf("%w: from: %v", ErrInvalidMessage, err)
+	}
+	if to, err = mail.ParseAddress(m.To); err != nil {
+		return nil, nil, fmt.Errorf("%w: to: %v", ErrInvalidMessage, err)
+	}
+	return from, to, nil
+}
+
+// Encode writes m in the Internet Message Format, dated date.
+func (m *Message) Encode(w io.Writer, date time.Time) error {
+	from, to, err := m.addresses()
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("%w: subject contains a line break", ErrInvalidMessage)
+	}
+
+	var b bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", messageID(from.Address))
+	header("MIME-Version", "1.0")
+
+	if m.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		if err := writeQuotedPrintable(&b, m.Text); err != nil {
+			return err
+		}
+	} else {
+		mw := multipart.NewWriter(&b)
+		header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": mw.Boundary()}))
+		b.WriteString("\r\n")
+		for _, part := range []struct{ typ, body string }{
+			{"text/plain; charset=utf-8", m.Text},
+			{"text/html; charset=utf-8", m.HTML},
+		} {
+			pw, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.typ},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return err
+			}
+			if err := writeQuotedPrintable(pw, part.body); err != nil {
+				return err
+			}
+		}
+		if err := mw.Close(); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// writeQuotedPrintable writes s to w in quoted-printable with CRLF line
+// breaks.
+func writeQuotedPrintable(w io.Writer, s string) error {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(strings.ReplaceAll(s, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+// messageID returns a new message id in the domain of the address from.
+func messageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndexByte(from, '@'); i >= 0 {
+		domain = from[i+1:]
+	}
+	return "<" + randomHex(16) + "@" + domain + ">"
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,187 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = &Message{
+	From:    "Glut <no-reply@example.com>",
+	To:      "alice@example.com",
+	Subject: "Réinitialiser le mot de passe",
+	Text:    "Hi alice,\n\nUse this token: abc\n",
+	HTML:    "<p>Hi alice,</p><p>Use this token: <b>abc</b></p>",
+}
+
+// readMessage parses an encoded message and returns it with its raw body.
+func readMessage(t *testing.T, r io.Reader) (*mail.Message, string) {
+	t.Helper()
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(m.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, string(body)
+}
+
+func TestEncode(t *testing.T) {
+	date := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var b bytes.Buffer
+	if err := testMessage.Encode(&b, date); err != nil {
+		t.Fatal(err)
+	}
+	m, body := readMessage(t, &b)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != testMessage.Subject {
+		t.Errorf("Subject = %q, %v, want %q", subject, err, testMessage.Subject)
+	}
+	if got, _ := m.Header.Date(); !got.Equal(date) {
+		t.Errorf("Date = %v, want %v", got, date)
+	}
+	if id := m.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Message-ID = %q, want one of example.com", id)
+	}
+	if ct := m.Header.Get("Content-Type"); !strings.HasPrefix(ct, "multipart/alternative;") {
+		t.Errorf("Content-Type = %q, want multipart/alternative", ct)
+	}
+	for _, want := range []string{"Use this token: abc", "<b>abc</b>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %q:\n%s", want, body)
+		}
+	}
+
+	plain := *testMessage
+	plain.HTML = ""
+	b.Reset()
+	if err := plain.Encode(&b, date); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := readMessage(t, &b); m.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type of a text message = %q", m.Header.Get("Content-Type"))
+	}
+
+	for _, invalid := range []Message{
+		{From: testMessage.From, To: "alice", Subject: "Hi"},
+		{From: testMessage.From, To: testMessage.To, Subject: "Hi\r\nBcc: mallory@example.com"},
+	} {
+		if err := invalid.Encode(io.Discard, date); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Encode(%+v): got %v, want ErrInvalidMessage", invalid, err)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := NewFileMailer(dir)
+	for i := 0; i < 2; i++ {
+		if err := m.Send(context.Background(), testMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("files = %v, %v, want 2", files, err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if msg, _ := readMessage(t, f); msg.Header.Get("To") != "<alice@example.com>" {
+		t.Errorf("To = %q", msg.Header.Get("To"))
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The server accepts one message and sends back what it received.
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c := textproto.NewConn(conn)
+		c.PrintfLine("220 localhost ESMTP")
+		var log strings.Builder
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd, _, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(cmd) {
+			case "EHLO", "HELO":
+				c.PrintfLine("250 localhost")
+			case "MAIL", "RCPT":
+				log.WriteString(line + "\n")
+				c.PrintfLine("250 OK")
+			case "DATA":
+				c.PrintfLine("354 Go ahead")
+				data, err := c.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				log.Write(data)
+				c.PrintfLine("250 OK")
+			case "QUIT":
+				c.PrintfLine("221 Bye")
+				received <- log.String()
+				return
+			default:
+				c.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	m := NewSMTPMailer(&SMTPConfig{Host: "127.0.0.1", Port: addr.Port, TLS: TLSNone})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Send(ctx, testMessage); err != nil {
+		t.Fatal(err)
+	}
+	got := <-received
+	for _, want := range []string{"MAIL FROM:<no-reply@example.com>", "RCPT TO:<alice@example.com>", "Use this token: abc"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("server received no %q:\n%s", want, got)
+		}
+	}
+
+	// Without STARTTLS support, the default TLS mode refuses to send.
+	m = NewSMTPMailer(&SMTPConfig{Host: "127.0.0.1", Port: addr.Port})
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			c := textproto.NewConn(conn)
+			c.PrintfLine("220 localhost ESMTP")
+			c.ReadLine()
+			c.PrintfLine("250 localhost")
+			c.ReadLine()
+			conn.Close()
+		}
+	}()
+	if err := m.Send(ctx, testMessage); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Send without STARTTLS: got %v, want an error", err)
+	}
+}
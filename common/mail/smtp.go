@@ -0,0 +1,125 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// TLS modes of SMTP connections.
+const (
+	// TLSStartTLS upgrades plain connections with STARTTLS, failing if the
+	// server does not support it.
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects with TLS, usually on port 465.
+	TLSImplicit = "tls"
+	// TLSNone sends in plain text, e.g. to a local relay.
+	TLSNone = "none"
+)
+
+// SMTPConfig...
+type SMTPConfig struct {
+	Host string
+	Port int
+	// Username and Password authenticate with PLAIN auth, which is refused
+	// without TLS unless the host is local. No authentication is done if
+	// Username is empty.
+	Username string
+	Password string
+	// TLS is TLSStartTLS, TLSImplicit or TLSNone. It defaults to
+	// TLSStartTLS.
+	TLS string
+}
+
+type smtpMailer struct {
+	cfg SMTPConfig
+}
+
+// NewSMTPMailer returns a Mailer sending each message on a new connection to
+// an SMTP server. Sending is bounded by the deadline of its context.
+func NewSMTPMailer(cfg *SMTPConfig) Mailer {
+	m := &smtpMailer{cfg: *cfg}
+	if m.cfg.TLS == "" {
+		m.cfg.TLS = TLSStartTLS
+	}
+	return m
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg *Message) error {
+	from, to, err := msg.addresses()
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := msg.Encode(&b, time.Now()); err != nil {
+		return err
+	}
+
+	if err := m.send(ctx, from.Address, to.Address, b.Bytes()); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+func (m *smtpMailer) send(ctx context.Context, from, to string, body []byte) error {
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: m.cfg.Host}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if m.cfg.TLS == TLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	// The client has no context; closing the connection interrupts it.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if m.cfg.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp: server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
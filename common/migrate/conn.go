@@ -0,0 +1,138 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"glut/common/sqlite"
+	"hash/fnv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// execFunc executes a statement.
+type execFunc func(ctx context.Context, sql string, args ...any) error
+
+// rows are the rows of a query.
+type rows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+	Close()
+}
+
+// conn is the database a Migrator runs on. Statements use $n placeholders,
+// which are understood by all supported databases.
+type conn interface {
+	// lock blocks until the migration lock is acquired and returns the
+	// function releasing it.
+	lock(ctx context.Context) (unlock func() error, err error)
+	exec(ctx context.Context, sql string, args ...any) error
+	query(ctx context.Context, sql string, args ...any) (rows, error)
+	// tx runs fn in a transaction, committing it if fn returns nil.
+	tx(ctx context.Context, fn func(exec execFunc) error) error
+	// timestampType is the column type of timestamps.
+	timestampType() string
+}
+
+// pgConn is a PostgreSQL connection.
+type pgConn struct {
+	conn *pgx.Conn
+}
+
+// lock acquires a session level advisory lock.
+func (c pgConn) lock(ctx context.Context) (func() error, error) {
+	h := fnv.New64a()
+	h.Write([]byte(lockName))
+	key := int64(h.Sum64())
+
+	if _, err := c.conn.Exec(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		return nil, err
+	}
+	return func() error {
+		// Use a fresh context so the lock is released even if ctx is done.
+		_, err := c.conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		return err
+	}, nil
+}
+
+func (c pgConn) exec(ctx context.Context, sql string, args ...any) error {
+	_, err := c.conn.Exec(ctx, sql, args...)
+	return err
+}
+
+func (c pgConn) query(ctx context.Context, sql string, args ...any) (rows, error) {
+	return c.conn.Query(ctx, sql, args...)
+}
+
+func (c pgConn) tx(ctx context.Context, fn func(exec execFunc) error) error {
+	tx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(func(ctx context.Context, sql string, args ...any) error {
+		_, err := tx.Exec(ctx, sql, args...)
+		return err
+	}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (c pgConn) timestampType() string {
+	return "timestamptz"
+}
+
+// sqliteConn is a SQLite database opened with sqlite.Open.
+type sqliteConn struct {
+	db *sql.DB
+}
+
+// lock does nothing: SQLite databases are not shared by instances.
+func (c sqliteConn) lock(ctx context.Context) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+func (c sqliteConn) exec(ctx context.Context, sql string, args ...any) error {
+	_, err := c.db.ExecContext(ctx, sql, sqlite.Args(args)...)
+	return err
+}
+
+func (c sqliteConn) query(ctx context.Context, sql string, args ...any) (rows, error) {
+	r, err := c.db.QueryContext(ctx, sql, sqlite.Args(args)...)
+	if err != nil {
+		return nil, err
+	}
+	return sqlRows{r}, nil
+}
+
+func (c sqliteConn) tx(ctx context.Context, fn func(exec execFunc) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(func(ctx context.Context, sql string, args ...any) error {
+		_, err := tx.ExecContext(ctx, sql, sqlite.Args(args)...)
+		return err
+	}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// timestampType is a type the driver reads as times.
+func (c sqliteConn) timestampType() string {
+	return "timestamp"
+}
+
+// sqlRows adapts sql.Rows to rows.
+type sqlRows struct {
+	*sql.Rows
+}
+
+func (r sqlRows) Close() {
+	r.Rows.Close()
+}
@@ -0,0 +1,401 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// lockName identifies the advisory lock held while migrating.
+	lockName = "glut.migrate"
+	// table is the name of the table recording applied migrations.
+	table = "schema_migrations"
+)
+
+// Migration states reported by Migrator.Status.
+const (
+	StateApplied  = "applied"
+	StatePending  = "pending"
+	StateModified = "modified"
+	StateUnknown  = "unknown"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("applied migration was modified")
+	ErrUnknownMigration = errors.New("applied migration is unknown")
+	ErrIrreversible     = errors.New("migration cannot be reverted")
+	ErrUnknownVersion   = errors.New("version is unknown")
+)
+
+// fileNameRegexp matches migration file names such as 0001_create_users.up.sql.
+var fileNameRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status is the state of a migration in the database.
+type Status struct {
+	Version   int64
+	Name      string
+	State     string
+	AppliedAt *time.Time
+}
+
+// Load reads migrations from the root of fsys. Migrations consist of an up
+// file named <version>_<name>.up.sql and an optional down file named
+// <version>_<name>.down.sql. Migrations are returned ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("migrate.Load: %w", err)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		m := fileNameRegexp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate.Load: %s: %w", entry.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, path.Join(".", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("migrate.Load: %w", err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migrate.Load: version %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(b)
+			sum := sha256.Sum256(b)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migrate.Load: version %d has no up migration", mig.Version)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations. On PostgreSQL, it uses a single
+// connection so that the advisory lock preventing concurrent migrations is
+// held for the whole run.
+type Migrator struct {
+	conn       conn
+	migrations []Migration
+	logger     *slog.Logger
+}
+
+// New creates a new Migrator for a PostgreSQL database.
+func New(conn *pgx.Conn, migrations []Migration, logger *slog.Logger) *Migrator {
+	return &Migrator{
+		conn:       pgConn{conn},
+		migrations: migrations,
+		logger:     logger,
+	}
+}
+
+// NewSQLite creates a new Migrator for a SQLite database. SQLite has no
+// advisory locks, so migrators must not run concurrently; a migration applied
+// twice fails on the version of its record and is rolled back.
+func NewSQLite(db *sql.DB, migrations []Migration, logger *slog.Logger) *Migrator {
+	return &Migrator{
+		conn:       sqliteConn{db},
+		migrations: migrations,
+		logger:     logger,
+	}
+}
+
+// applied is a row of the migrations table.
+type applied struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Up applies all pending migrations and returns the number applied. Applied
+// migrations are verified against their checksums first.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	var count int
+	err := m.locked(ctx, func() error {
+		done, err := m.verify(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, mig); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return count, fmt.Errorf("migrate.Up: %w", err)
+	}
+	return count, nil
+}
+
+// Down reverts the last steps applied migrations and returns the number
+// reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	var count int
+	err := m.locked(ctx, func() error {
+		done, err := m.verify(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if err := m.revert(ctx, mig); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return count, fmt.Errorf("migrate.Down: %w", err)
+	}
+	return count, nil
+}
+
+// Baseline records the migrations up to version as applied without running
+// them and returns the number recorded. It adopts databases whose schema was
+// created before migrations were used, after which Up applies the remaining
+// migrations. Applied migrations are verified against their checksums first.
+func (m *Migrator) Baseline(ctx context.Context, version int64) (int, error) {
+	var count int
+	err := m.locked(ctx, func() error {
+		done, err := m.verify(ctx)
+		if err != nil {
+			return err
+		}
+		known := false
+		for _, mig := range m.migrations {
+			known = known || mig.Version == version
+		}
+		if !known {
+			return fmt.Errorf("%w: version=%d", ErrUnknownVersion, version)
+		}
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok || mig.Version > version {
+				continue
+			}
+			if err := m.conn.tx(ctx, func(exec execFunc) error {
+				return m.record(ctx, exec, mig)
+			}); err != nil {
+				return err
+			}
+			m.logger.Info("Recorded migration without applying it.",
+				slog.Int64("version", mig.Version),
+				slog.String("name", mig.Name))
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return count, fmt.Errorf("migrate.Baseline: %w", err)
+	}
+	return count, nil
+}
+
+// Status returns the state of all known and applied migrations ordered by
+// version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.createTable(ctx); err != nil {
+		return nil, fmt.Errorf("migrate.Status: %w", err)
+	}
+	done, err := m.applied(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("migrate.Status: %w", err)
+	}
+
+	var statuses []Status
+	known := make(map[int64]struct{}, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = struct{}{}
+		st := Status{Version: mig.Version, Name: mig.Name, State: StatePending}
+		if a, ok := done[mig.Version]; ok {
+			st.AppliedAt = &a.appliedAt
+			st.State = StateApplied
+			if a.checksum != mig.Checksum {
+				st.State = StateModified
+			}
+		}
+		statuses = append(statuses, st)
+	}
+	for version, a := range done {
+		if _, ok := known[version]; ok {
+			continue
+		}
+		appliedAt := a.appliedAt
+		statuses = append(statuses, Status{Version: version, Name: a.name, State: StateUnknown, AppliedAt: &appliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// locked runs fn while holding the migration lock. Other instances block
+// until the lock is released.
+func (m *Migrator) locked(ctx context.Context, fn func() error) error {
+	unlock, err := m.conn.lock(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			m.logger.Error("Failed to release migration lock.", slog.String("error", err.Error()))
+		}
+	}()
+
+	if err := m.createTable(ctx); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// verify returns the applied migrations after checking that each of them is
+// known and unmodified.
+func (m *Migrator) verify(ctx context.Context) (map[int64]applied, error) {
+	done, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = mig
+	}
+	for version, a := range done {
+		mig, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: version=%d, name=%s", ErrUnknownMigration, version, a.name)
+		}
+		if a.checksum != mig.Checksum {
+			return nil, fmt.Errorf("%w: version=%d, name=%s", ErrChecksumMismatch, version, mig.Name)
+		}
+	}
+	return done, nil
+}
+
+// apply runs the up migration of mig and records it in one transaction.
+func (m *Migrator) apply(ctx context.Context, mig Migration) error {
+	start := time.Now()
+	if err := m.conn.tx(ctx, func(exec execFunc) error {
+		if err := exec(ctx, mig.Up); err != nil {
+			return fmt.Errorf("failed to apply migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		return m.record(ctx, exec, mig)
+	}); err != nil {
+		return err
+	}
+	m.logger.Info("Applied migration.",
+		slog.Int64("version", mig.Version),
+		slog.String("name", mig.Name),
+		slog.Int64("elapsed_ms", time.Since(start).Milliseconds()))
+	return nil
+}
+
+// record records mig as applied.
+func (m *Migrator) record(ctx context.Context, exec execFunc, mig Migration) error {
+	q := `INSERT INTO ` + table + ` (version, name, checksum, applied_at) VALUES ($1, $2, $3, $4);`
+	return exec(ctx, q, mig.Version, mig.Name, mig.Checksum, time.Now().UTC())
+}
+
+// revert runs the down migration of mig and removes its record in one
+// transaction.
+func (m *Migrator) revert(ctx context.Context, mig Migration) error {
+	if mig.Down == "" {
+		return fmt.Errorf("%w: version=%d, name=%s", ErrIrreversible, mig.Version, mig.Name)
+	}
+	start := time.Now()
+	if err := m.conn.tx(ctx, func(exec execFunc) error {
+		if err := exec(ctx, mig.Down); err != nil {
+			return fmt.Errorf("failed to revert migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		q := `DELETE FROM ` + table + ` WHERE version = $1;`
+		return exec(ctx, q, mig.Version)
+	}); err != nil {
+		return err
+	}
+	m.logger.Info("Reverted migration.",
+		slog.Int64("version", mig.Version),
+		slog.String("name", mig.Name),
+		slog.Int64("elapsed_ms", time.Since(start).Milliseconds()))
+	return nil
+}
+
+// createTable creates the migrations table if it does not exist.
+func (m *Migrator) createTable(ctx context.Context) error {
+	q := `
+	CREATE TABLE IF NOT EXISTS ` + table + ` (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		checksum text NOT NULL,
+		applied_at ` + m.conn.timestampType() + ` NOT NULL
+	);`
+	return m.conn.exec(ctx, q)
+}
+
+// applied returns the applied migrations keyed by version.
+func (m *Migrator) applied(ctx context.Context) (map[int64]applied, error) {
+	rows, err := m.conn.query(ctx, `SELECT version, name, checksum, applied_at FROM `+table+`;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int64]applied)
+	for rows.Next() {
+		var version int64
+		var a applied
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = a
+	}
+	return done, rows.Err()
+}
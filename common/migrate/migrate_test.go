@@ -0,0 +1,236 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"glut/common/sqlite"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+var testFS = fstest.MapFS{
+	"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);")},
+	"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	"0002_create_posts.up.sql":   {Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL REFERENCES users (id));")},
+	"0002_create_posts.down.sql": {Data: []byte("DROP TABLE posts;")},
+	"0010_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT;")},
+	"0010_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP COLUMN email;")},
+	"README.md":                  {Data: []byte("Migrations of the test database.")},
+}
+
+// newTestDB returns a new SQLite database.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sqlite.Open(context.Background(), &sqlite.Config{URL: filepath.Join(t.TempDir(), "glut.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// loadTest returns the migrations of fsys.
+func loadTest(t *testing.T, fsys fstest.MapFS) []Migration {
+	t.Helper()
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrations
+}
+
+// states returns the states of the migrations of m by version.
+func states(t *testing.T, m *Migrator) map[int64]string {
+	t.Helper()
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(map[int64]string)
+	for _, st := range statuses {
+		states[st.Version] = st.State
+		if (st.State == StatePending) != (st.AppliedAt == nil) {
+			t.Errorf("migration %d is %s, applied at %v", st.Version, st.State, st.AppliedAt)
+		}
+	}
+	return states
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func TestLoad(t *testing.T) {
+	migrations := loadTest(t, testFS)
+	if len(migrations) != 3 {
+		t.Fatalf("Load returned %d migrations, want 3", len(migrations))
+	}
+	for i, want := range []struct {
+		version int64
+		name    string
+	}{{1, "create_users"}, {2, "create_posts"}, {10, "add_email"}} {
+		mig := migrations[i]
+		if mig.Version != want.version || mig.Name != want.name || mig.Up == "" || mig.Down == "" || mig.Checksum == "" {
+			t.Errorf("migrations[%d] = %+v, want version %d named %s", i, mig, want.version, want.name)
+		}
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"missing up": {"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")}},
+		"conflicting names": {
+			"0001_create_users.up.sql":  {Data: []byte("CREATE TABLE users (id INTEGER);")},
+			"0001_create_people.up.sql": {Data: []byte("CREATE TABLE people (id INTEGER);")},
+		},
+	} {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("Load with %s: got no error", name)
+		}
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := newTestDB(t)
+	m := NewSQLite(db, loadTest(t, testFS), logger)
+
+	if got := states(t, m); got[1] != StatePending || got[2] != StatePending || got[10] != StatePending {
+		t.Errorf("states before Up = %v, want all pending", got)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 3 {
+		t.Fatalf("Up = %d, %v, want 3", n, err)
+	}
+	if got := states(t, m); got[1] != StateApplied || got[2] != StateApplied || got[10] != StateApplied {
+		t.Errorf("states after Up = %v, want all applied", got)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Errorf("Up again = %d, %v, want 0", n, err)
+	}
+
+	if n, err := m.Down(ctx, 2); err != nil || n != 2 {
+		t.Fatalf("Down(2) = %d, %v, want 2", n, err)
+	}
+	if got := states(t, m); got[1] != StateApplied || got[2] != StatePending || got[10] != StatePending {
+		t.Errorf("states after Down(2) = %v, want 1 applied", got)
+	}
+	if !tableExists(t, db, "users") || tableExists(t, db, "posts") {
+		t.Error("Down(2) did not revert exactly the last 2 migrations")
+	}
+	if n, err := m.Down(ctx, 5); err != nil || n != 1 {
+		t.Errorf("Down(5) = %d, %v, want 1", n, err)
+	}
+	if tableExists(t, db, "users") {
+		t.Error("users remains after reverting all migrations")
+	}
+}
+
+func TestMigratorVerifies(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := newTestDB(t)
+	if _, err := NewSQLite(db, loadTest(t, testFS), logger).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// An applied migration was modified.
+	modified := fstest.MapFS{}
+	for name, f := range testFS {
+		modified[name] = f
+	}
+	modified["0002_create_posts.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY);")}
+	m := NewSQLite(db, loadTest(t, modified), logger)
+	if _, err := m.Up(ctx); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Up with a modified migration = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := m.Down(ctx, 1); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Down with a modified migration = %v, want ErrChecksumMismatch", err)
+	}
+	if got := states(t, m); got[2] != StateModified {
+		t.Errorf("state of the modified migration = %s, want %s", got[2], StateModified)
+	}
+
+	// An applied migration is no longer known.
+	known := fstest.MapFS{}
+	for name, f := range testFS {
+		if name[:4] != "0010" {
+			known[name] = f
+		}
+	}
+	m = NewSQLite(db, loadTest(t, known), logger)
+	if _, err := m.Up(ctx); !errors.Is(err, ErrUnknownMigration) {
+		t.Errorf("Up with an unknown migration = %v, want ErrUnknownMigration", err)
+	}
+	if got := states(t, m); got[10] != StateUnknown {
+		t.Errorf("state of the unknown migration = %s, want %s", got[10], StateUnknown)
+	}
+}
+
+func TestMigratorFailures(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := newTestDB(t)
+
+	// A failing migration is rolled back and stops Up.
+	failing := fstest.MapFS{
+		"0001_create_users.up.sql": testFS["0001_create_users.up.sql"],
+		"0002_broken.up.sql":       {Data: []byte("CREATE TABLE tags (id INTEGER); INSERT INTO missing VALUES (1);")},
+		"0003_create_posts.up.sql": testFS["0002_create_posts.up.sql"],
+	}
+	m := NewSQLite(db, loadTest(t, failing), logger)
+	if n, err := m.Up(ctx); err == nil || n != 1 {
+		t.Errorf("Up with a failing migration = %d, %v, want 1 and an error", n, err)
+	}
+	if got := states(t, m); got[1] != StateApplied || got[2] != StatePending || got[3] != StatePending {
+		t.Errorf("states after a failed migration = %v, want 1 applied", got)
+	}
+	if tableExists(t, db, "tags") {
+		t.Error("the failed migration was not rolled back")
+	}
+
+	// Migrations without a down migration cannot be reverted.
+	if _, err := m.Down(ctx, 1); !errors.Is(err, ErrIrreversible) {
+		t.Errorf("Down of a migration without down = %v, want ErrIrreversible", err)
+	}
+	if got := states(t, m); got[1] != StateApplied {
+		t.Errorf("state of the irreversible migration = %s, want %s", got[1], StateApplied)
+	}
+}
+
+func TestMigratorBaseline(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := newTestDB(t)
+
+	// The schema of the first migration exists without its record.
+	if _, err := db.Exec(string(testFS["0001_create_users.up.sql"].Data)); err != nil {
+		t.Fatal(err)
+	}
+	m := NewSQLite(db, loadTest(t, testFS), logger)
+	if _, err := m.Baseline(ctx, 3); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Baseline(3) = %v, want ErrUnknownVersion", err)
+	}
+	if n, err := m.Baseline(ctx, 1); err != nil || n != 1 {
+		t.Fatalf("Baseline(1) = %d, %v, want 1", n, err)
+	}
+	if tableExists(t, db, "posts") {
+		t.Error("Baseline ran a migration")
+	}
+	if n, err := m.Baseline(ctx, 1); err != nil || n != 0 {
+		t.Errorf("Baseline(1) again = %d, %v, want 0", n, err)
+	}
+	if got := states(t, m); got[1] != StateApplied || got[2] != StatePending || got[10] != StatePending {
+		t.Errorf("states after Baseline(1) = %v, want 1 applied", got)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 2 {
+		t.Errorf("Up after Baseline(1) = %d, %v, want 2", n, err)
+	}
+}
@@ -0,0 +1,258 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultMaxReplicaLag      = 5 * time.Second
+	defaultReplicaCheckPeriod = 5 * time.Second
+)
+
+// replicaLagQuery returns the replication lag of a replica in seconds. A
+// replica that has replayed all WAL it received is not lagging, even if the
+// last replayed transaction is old because the primary is idle.
+const replicaLagQuery = `
+SELECT CASE
+	WHEN NOT pg_is_in_recovery() THEN 0
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END::float8`
+
+type readYourWritesKey struct{}
+
+// ReadYourWrites returns a context that routes all queries of a Cluster to the
+// primary, so that reads observe writes made just before them.
+func ReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readYourWritesKey{}, true)
+}
+
+// IsReadYourWrites reports whether ctx routes queries to the primary.
+func IsReadYourWrites(ctx context.Context) bool {
+	v, _ := ctx.Value(readYourWritesKey{}).(bool)
+	return v
+}
+
+// Cluster is a primary database with optional read replicas. Exec and Begin
+// always use the primary, as do all queries run in a transaction. Query and
+// QueryRow use a healthy replica unless the context is flagged with
+// ReadYourWrites. Replicas lagging behind more than the configured maximum or
+// failing their check are skipped until they recover; without a healthy
+// replica, reads fall back to the primary.
+type Cluster struct {
+	primary  *pgxpool.Pool
+	replicas []*replica
+	tracer   *Tracer
+	next     atomic.Uint64
+	maxLag   time.Duration
+	logger   *slog.Logger
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// replica is a read replica and its last known state.
+type replica struct {
+	pool    replicaPool
+	host    string
+	healthy atomic.Bool
+}
+
+// replicaPool is the part of a *pgxpool.Pool used for replicas.
+type replicaPool interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Close()
+}
+
+// NewCluster creates connection pools for the primary and the replicas of
+// cfg. Replica pools use the same pool settings and Tracer as the primary.
+// The lag of replicas is checked once before NewCluster returns and then
+// periodically until the cluster is closed.
+func NewCluster(ctx context.Context, cfg *Config) (*Cluster, error) {
+	if cfg.MaxReplicaLag == 0 {
+		cfg.MaxReplicaLag = defaultMaxReplicaLag
+	}
+	if cfg.ReplicaCheckPeriod == 0 {
+		cfg.ReplicaCheckPeriod = defaultReplicaCheckPeriod
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	if cfg.Tracer == nil {
+		cfg.Tracer = NewTracer(cfg.SlowQueryThreshold, logger)
+	}
+
+	primary, err := New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{
+		primary: primary,
+		tracer:  cfg.Tracer,
+		maxLag:  cfg.MaxReplicaLag,
+		logger:  logger,
+	}
+	for _, url := range cfg.ReplicaURLs {
+		rcfg := *cfg
+		rcfg.URL = url
+		pool, err := New(ctx, &rcfg)
+		if err != nil {
+			c.closePools()
+			return nil, err
+		}
+		c.replicas = append(c.replicas, &replica{pool: pool, host: pool.Config().ConnConfig.Host})
+	}
+	if len(c.replicas) == 0 {
+		return c, nil
+	}
+
+	c.checkReplicas(ctx)
+	checkCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.runChecks(checkCtx, cfg.ReplicaCheckPeriod)
+	}()
+	return c, nil
+}
+
+// Primary returns the pool of the primary.
+func (c *Cluster) Primary() *pgxpool.Pool {
+	return c.primary
+}
+
+// Tracer returns the tracer shared by the pools of the cluster.
+func (c *Cluster) Tracer() *Tracer {
+	return c.tracer
+}
+
+// Exec executes sql on the primary.
+func (c *Cluster) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return c.primary.Exec(ctx, sql, args...)
+}
+
+// Begin starts a transaction on the primary.
+func (c *Cluster) Begin(ctx context.Context) (pgx.Tx, error) {
+	return c.primary.Begin(ctx)
+}
+
+// BeginTx starts a transaction with opts on the primary.
+func (c *Cluster) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	return c.primary.BeginTx(ctx, opts)
+}
+
+// Query runs a query on a replica or, if ctx is flagged with ReadYourWrites,
+// on the primary.
+func (c *Cluster) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return c.reader(ctx).Query(ctx, sql, args...)
+}
+
+// QueryRow runs a query returning at most one row on a replica or, if ctx is
+// flagged with ReadYourWrites, on the primary.
+func (c *Cluster) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return c.reader(ctx).QueryRow(ctx, sql, args...)
+}
+
+// Ping checks the connection to the primary.
+func (c *Cluster) Ping(ctx context.Context) error {
+	return c.primary.Ping(ctx)
+}
+
+// Close stops the replica checks and closes all pools.
+func (c *Cluster) Close() {
+	if c.cancel != nil {
+		c.cancel()
+		c.wg.Wait()
+	}
+	c.closePools()
+}
+
+// closePools closes the pools of the primary and the replicas.
+func (c *Cluster) closePools() {
+	c.primary.Close()
+	for _, r := range c.replicas {
+		r.pool.Close()
+	}
+}
+
+// reader returns the pool used for reads with ctx.
+func (c *Cluster) reader(ctx context.Context) replicaPool {
+	if r := c.replica(ctx); r != nil {
+		return r.pool
+	}
+	return c.primary
+}
+
+// replica returns the replica used for reads with ctx, or nil if reads use
+// the primary. Healthy replicas are used in turn.
+func (c *Cluster) replica(ctx context.Context) *replica {
+	if len(c.replicas) == 0 || IsReadYourWrites(ctx) {
+		return nil
+	}
+	n := uint64(len(c.replicas))
+	start := c.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		r := c.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// runChecks checks the replicas every period until ctx is done.
+func (c *Cluster) runChecks(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkReplicas(ctx)
+		}
+	}
+}
+
+// checkReplicas updates the health of the replicas from their lag.
+func (c *Cluster) checkReplicas(ctx context.Context) {
+	for _, r := range c.replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, c.maxLag)
+		var lagSeconds float64
+		err := r.pool.QueryRow(checkCtx, replicaLagQuery).Scan(&lagSeconds)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		lag := time.Duration(lagSeconds * float64(time.Second))
+		healthy := err == nil && lag <= c.maxLag
+		if r.healthy.Swap(healthy) == healthy {
+			continue
+		}
+		switch {
+		case healthy:
+			c.logger.Info("Replica is healthy; routing reads to it.", slog.String("host", r.host))
+		case err != nil:
+			c.logger.Warn("Replica check failed; routing reads elsewhere.",
+				slog.String("host", r.host),
+				slog.String("error", err.Error()))
+		default:
+			c.logger.Warn("Replica is lagging; routing reads elsewhere.",
+				slog.String("host", r.host),
+				slog.Duration("lag", lag),
+				slog.Duration("max_lag", c.maxLag))
+		}
+	}
+}
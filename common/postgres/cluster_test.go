@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// stubPool is a replica pool whose lag check returns lag or fails with err.
+type stubPool struct {
+	lag float64
+	err error
+}
+
+func (p *stubPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (p *stubPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return stubRow{p}
+}
+
+func (p *stubPool) Close() {}
+
+type stubRow struct{ p *stubPool }
+
+func (r stubRow) Scan(dest ...any) error {
+	if r.p.err != nil {
+		return r.p.err
+	}
+	*dest[0].(*float64) = r.p.lag
+	return nil
+}
+
+// newTestCluster returns a cluster without a primary whose replicas are
+// named after their index and use pools.
+func newTestCluster(logs *bytes.Buffer, pools ...*stubPool) *Cluster {
+	c := &Cluster{maxLag: 5 * time.Second, logger: slog.New(slog.NewTextHandler(logs, nil))}
+	for i, p := range pools {
+		c.replicas = append(c.replicas, &replica{pool: p, host: string(rune('a' + i))})
+	}
+	return c
+}
+
+func TestClusterReplica(t *testing.T) {
+	ctx := context.Background()
+	var logs bytes.Buffer
+	if r := newTestCluster(&logs).replica(ctx); r != nil {
+		t.Errorf("replica without replicas = %s, want the primary", r.host)
+	}
+
+	c := newTestCluster(&logs, &stubPool{}, &stubPool{}, &stubPool{})
+	hosts := func(ctx context.Context) string {
+		var hosts []string
+		for i := 0; i < 4; i++ {
+			host := "primary"
+			if r := c.replica(ctx); r != nil {
+				host = r.host
+			}
+			hosts = append(hosts, host)
+		}
+		return strings.Join(hosts, ",")
+	}
+	if got, want := hosts(ctx), "primary,primary,primary,primary"; got != want {
+		t.Errorf("reads before the first check = %s, want %s", got, want)
+	}
+
+	c.replicas[0].healthy.Store(true)
+	c.replicas[2].healthy.Store(true)
+	if got := hosts(ctx); !strings.Contains(got, "a") || !strings.Contains(got, "c") || strings.Contains(got, "b") || strings.Contains(got, "primary") {
+		t.Errorf("reads with replicas a and c healthy = %s, want both of them", got)
+	}
+	if got, want := hosts(ReadYourWrites(ctx)), "primary,primary,primary,primary"; got != want {
+		t.Errorf("reads with ReadYourWrites = %s, want %s", got, want)
+	}
+	if IsReadYourWrites(ctx) || !IsReadYourWrites(ReadYourWrites(ctx)) {
+		t.Error("IsReadYourWrites does not report the flag of the context")
+	}
+}
+
+func TestClusterCheckReplicas(t *testing.T) {
+	ctx := context.Background()
+	var logs bytes.Buffer
+	a := &stubPool{lag: 1}
+	b := &stubPool{lag: 10}
+	c := &stubPool{err: errors.New("connection refused")}
+	cluster := newTestCluster(&logs, a, b, c)
+
+	healthy := func() []bool {
+		var res []bool
+		for _, r := range cluster.replicas {
+			res = append(res, r.healthy.Load())
+		}
+		return res
+	}
+	cluster.checkReplicas(ctx)
+	if got := healthy(); !got[0] || got[1] || got[2] {
+		t.Errorf("health = %v, want only the replica within the maximum lag", got)
+	}
+	if out := logs.String(); !strings.Contains(out, "Replica is healthy") || !strings.Contains(out, "host=a") {
+		t.Errorf("logs = %q, want replica a reported healthy", out)
+	}
+	if r := cluster.replica(ctx); r == nil || r.host != "a" {
+		t.Errorf("replica = %v, want a", r)
+	}
+
+	// Changes of health are logged once.
+	logs.Reset()
+	cluster.checkReplicas(ctx)
+	if logs.Len() != 0 {
+		t.Errorf("logs of an unchanged check = %q, want none", logs.String())
+	}
+
+	a.err = errors.New("timeout")
+	b.lag = 5
+	cluster.checkReplicas(ctx)
+	if got := healthy(); got[0] || !got[1] || got[2] {
+		t.Errorf("health = %v, want only the replica that caught up", got)
+	}
+	if out := logs.String(); !strings.Contains(out, "Replica check failed") || !strings.Contains(out, "error=timeout") {
+		t.Errorf("logs = %q, want the failed check of a", out)
+	}
+
+	b.lag = 6
+	logs.Reset()
+	cluster.checkReplicas(ctx)
+	if r := cluster.replica(ctx); r != nil {
+		t.Errorf("replica without healthy replicas = %s, want the primary", r.host)
+	}
+	if out := logs.String(); !strings.Contains(out, "Replica is lagging") || !strings.Contains(out, "host=b") {
+		t.Errorf("logs = %q, want replica b reported lagging", out)
+	}
+}
@@ -2,6 +2,8 @@ package postgres
 
 import (
 	"context"
+	"errors"
+	"log/slog"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -18,6 +20,7 @@ const (
 	defaultHealthCheckPeriod = 5 * time.Minute
 )
 
+// Config...
 type Config struct {
 	URL               string
 	MinOpenConns      int
@@ -25,8 +28,27 @@ type Config struct {
 	MaxConnLifetime   time.Duration
 	MaxConnIdleTime   time.Duration
 	HealthCheckPeriod time.Duration
+
+	// ReplicaURLs are the URLs of read replicas used by a Cluster.
+	ReplicaURLs []string
+	// MaxReplicaLag is the replication lag above which a replica is not used.
+	MaxReplicaLag time.Duration
+	// ReplicaCheckPeriod is the interval between replica lag checks.
+	ReplicaCheckPeriod time.Duration
+	// ApplicationName is reported to the server as the application_name of
+	// connections unless the URL sets one.
+	ApplicationName string
+	// SlowQueryThreshold is the duration above which statements are logged.
+	// Slow statements are not logged if it is zero.
+	SlowQueryThreshold time.Duration
+	// Tracer traces the statements of the pool. A Tracer is created if it is
+	// nil, so pools can share a Tracer to aggregate their counters.
+	Tracer *Tracer
+	// Logger logs slow statements and replica state changes of a Cluster.
+	Logger *slog.Logger
 }
 
+// New creates a connection pool.
 func New(ctx context.Context, cfg *Config) (*pgxpool.Pool, error) {
 	if cfg.URL == "" {
 		cfg.URL = defaultURL
@@ -52,14 +74,14 @@ func New(ctx context.Context, cfg *Config) (*pgxpool.Pool, error) {
 		return nil, err
 	}
 
-	poolConf.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
-		var hstoreOID uint32
-		if err := conn.QueryRow(context.Background(), `select oid from pg_type where typname = 'hstore'`).Scan(&hstoreOID); err != nil {
-			panic(err)
-		}
-		conn.TypeMap().RegisterType(&pgtype.Type{Name: "hstore", OID: hstoreOID, Codec: pgtype.HstoreCodec{}})
-		return nil
+	if cfg.Tracer == nil {
+		cfg.Tracer = NewTracer(cfg.SlowQueryThreshold, cfg.Logger)
 	}
+	poolConf.ConnConfig.Tracer = cfg.Tracer
+	if _, ok := poolConf.ConnConfig.RuntimeParams["application_name"]; !ok && cfg.ApplicationName != "" {
+		poolConf.ConnConfig.RuntimeParams["application_name"] = cfg.ApplicationName
+	}
+	poolConf.AfterConnect = registerTypes
 
 	poolConf.MinConns = int32(cfg.MinOpenConns)
 	poolConf.MaxConns = int32(cfg.MaxOpenConns)
@@ -73,3 +95,35 @@ func New(ctx context.Context, cfg *Config) (*pgxpool.Pool, error) {
 	}
 	return pool, nil
 }
+
+// Connect opens a single connection. It is used for work that must run on one
+// connection, such as migrations holding a session level advisory lock.
+func Connect(ctx context.Context, url string) (*pgx.Conn, error) {
+	if url == "" {
+		url = defaultURL
+	}
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if err := registerTypes(ctx, conn); err != nil {
+		conn.Close(ctx)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// registerTypes registers the codecs of extension types with conn. Types of
+// extensions that are not installed yet, such as on a database that has not
+// been migrated, are skipped.
+func registerTypes(ctx context.Context, conn *pgx.Conn) error {
+	var hstoreOID uint32
+	if err := conn.QueryRow(ctx, `select oid from pg_type where typname = 'hstore'`).Scan(&hstoreOID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	conn.TypeMap().RegisterType(&pgtype.Type{Name: "hstore", OID: hstoreOID, Codec: pgtype.HstoreCodec{}})
+	return nil
+}
@@ -0,0 +1,185 @@
+package postgres
+
+import (
+	"context"
+	"glut/common/log"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maxTracedStatements limits the number of distinct statements counted by a
+// Tracer. Statements beyond the limit are counted as otherStatement.
+const maxTracedStatements = 1000
+
+// otherStatement is the statement of counters aggregating statements beyond
+// maxTracedStatements.
+const otherStatement = "(other)"
+
+var (
+	whitespaceRegexp = regexp.MustCompile(`\s+`)
+	stringRegexp     = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numberRegexp     = regexp.MustCompile(`\$?\b\d+(?:\.\d+)?\b`)
+	argListRegexp    = regexp.MustCompile(`\(\?(?:, \?)*\)(?:, \(\?(?:, \?)*\))*`)
+)
+
+// StatementStats are the counters of a normalized statement.
+type StatementStats struct {
+	SQL    string
+	Calls  int64
+	Errors int64
+	Slow   int64
+	Rows   int64
+	Total  time.Duration
+	Max    time.Duration
+}
+
+// Tracer is a pgx query tracer. It counts calls, errors and durations per
+// normalized statement and logs statements slower than a threshold with the
+// logger of the context the query was run with, which carries the request id
+// of flows.
+type Tracer struct {
+	threshold atomic.Int64
+	logger    *slog.Logger
+
+	mu    sync.Mutex
+	stats map[string]*StatementStats
+}
+
+// traceKey is the context key of the state of a traced query.
+type traceKey struct{}
+
+// trace is the state of a traced query between its start and end.
+type trace struct {
+	sql   string
+	args  int
+	start time.Time
+}
+
+// NewTracer creates a Tracer logging statements slower than threshold. Slow
+// statements are not logged if threshold is zero.
+func NewTracer(threshold time.Duration, logger *slog.Logger) *Tracer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	t := &Tracer{
+		logger: logger,
+		stats:  make(map[string]*StatementStats),
+	}
+	t.threshold.Store(int64(threshold))
+	return t
+}
+
+// SetThreshold sets the duration above which statements are logged.
+func (t *Tracer) SetThreshold(threshold time.Duration) {
+	t.threshold.Store(int64(threshold))
+}
+
+// Stats returns the counters of all statements ordered by total duration,
+// longest first.
+func (t *Tracer) Stats() []StatementStats {
+	t.mu.Lock()
+	stats := make([]StatementStats, 0, len(t.stats))
+	for _, st := range t.stats {
+		stats = append(stats, *st)
+	}
+	t.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Total > stats[j].Total
+	})
+	return stats
+}
+
+// TraceQueryStart satisfies the pgx.QueryTracer interface.
+func (t *Tracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, traceKey{}, &trace{
+		sql:   data.SQL,
+		args:  len(data.Args),
+		start: time.Now(),
+	})
+}
+
+// TraceQueryEnd satisfies the pgx.QueryTracer interface.
+func (t *Tracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	tr, ok := ctx.Value(traceKey{}).(*trace)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(tr.start)
+	sql := NormalizeSQL(tr.sql)
+	rows := data.CommandTag.RowsAffected()
+	slow := isSlow(elapsed, time.Duration(t.threshold.Load()))
+
+	t.count(sql, elapsed, rows, data.Err != nil, slow)
+
+	if !slow {
+		return
+	}
+	attrs := []any{
+		slog.String("sql", sql),
+		slog.Int("args", tr.args),
+		slog.Int64("rows_affected", rows),
+		slog.Int64("elapsed_ms", elapsed.Milliseconds()),
+	}
+	if data.Err != nil {
+		attrs = append(attrs, slog.String("error", data.Err.Error()))
+	}
+	log.FromContext(ctx, t.logger).Warn("Slow query.", attrs...)
+}
+
+// isSlow reports whether a statement running for elapsed is slow. Nothing is
+// slow if threshold is zero.
+func isSlow(elapsed, threshold time.Duration) bool {
+	return threshold > 0 && elapsed >= threshold
+}
+
+// count adds a call of sql to the counters.
+func (t *Tracer) count(sql string, elapsed time.Duration, rows int64, failed, slow bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.stats[sql]
+	if !ok {
+		if len(t.stats) >= maxTracedStatements {
+			sql = otherStatement
+			st = t.stats[sql]
+		}
+		if st == nil {
+			st = &StatementStats{SQL: sql}
+			t.stats[sql] = st
+		}
+	}
+	st.Calls++
+	st.Rows += rows
+	st.Total += elapsed
+	if elapsed > st.Max {
+		st.Max = elapsed
+	}
+	if failed {
+		st.Errors++
+	}
+	if slow {
+		st.Slow++
+	}
+}
+
+// NormalizeSQL returns sql with literals and placeholders replaced by ? and
+// whitespace collapsed, so that statements differing only in their values or
+// in the length of their value lists are equal.
+func NormalizeSQL(sql string) string {
+	sql = stringRegexp.ReplaceAllString(sql, "?")
+	sql = numberRegexp.ReplaceAllString(sql, "?")
+	sql = whitespaceRegexp.ReplaceAllString(sql, " ")
+	sql = strings.ReplaceAll(sql, "( ", "(")
+	sql = strings.ReplaceAll(sql, " )", ")")
+	sql = strings.ReplaceAll(sql, " ,", ",")
+	sql = strings.ReplaceAll(sql, ",?", ", ?")
+	sql = strings.ReplaceAll(sql, "),(", "), (")
+	sql = argListRegexp.ReplaceAllString(sql, "(...)")
+	return strings.TrimSpace(sql)
+}
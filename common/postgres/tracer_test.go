@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	for _, tt := range []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM auth.users WHERE id = $1", "SELECT * FROM auth.users WHERE id = ?"},
+		{"SELECT * FROM t2 WHERE col1 = $10", "SELECT * FROM t2 WHERE col1 = ?"},
+		{"SELECT * FROM t WHERE name = 'O''Brien' AND age > 42 AND score < 3.5", "SELECT * FROM t WHERE name = ? AND age > ? AND score < ?"},
+		{"SELECT 'a', 'b'", "SELECT ?, ?"},
+		{"SELECT  *\n\tFROM t\n WHERE  x = 1 ", "SELECT * FROM t WHERE x = ?"},
+		{"SELECT * FROM t WHERE id IN ($1, $2, $3)", "SELECT * FROM t WHERE id IN (...)"},
+		{"SELECT * FROM t WHERE id IN ( 1 , 2 )", "SELECT * FROM t WHERE id IN (...)"},
+		{"INSERT INTO t (a, b) VALUES ($1, $2)", "INSERT INTO t (a, b) VALUES (...)"},
+		{"INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4)", "INSERT INTO t (a, b) VALUES (...)"},
+	} {
+		if got := NormalizeSQL(tt.sql); got != tt.want {
+			t.Errorf("NormalizeSQL(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestIsSlow(t *testing.T) {
+	for _, tt := range []struct {
+		elapsed, threshold time.Duration
+		want               bool
+	}{
+		{time.Second, 0, false},
+		{time.Millisecond, 100 * time.Millisecond, false},
+		{100 * time.Millisecond, 100 * time.Millisecond, true},
+		{time.Second, 100 * time.Millisecond, true},
+	} {
+		if got := isSlow(tt.elapsed, tt.threshold); got != tt.want {
+			t.Errorf("isSlow(%v, %v) = %v, want %v", tt.elapsed, tt.threshold, got, tt.want)
+		}
+	}
+}
+
+// traceQuery traces sql as if it ran for elapsed and failed with err.
+func traceQuery(tr *Tracer, sql string, elapsed time.Duration, tag string, err error) {
+	ctx := tr.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: sql, Args: []any{1}})
+	ctx.Value(traceKey{}).(*trace).start = time.Now().Add(-elapsed)
+	tr.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag(tag), Err: err})
+}
+
+func TestTracer(t *testing.T) {
+	var logs bytes.Buffer
+	tr := NewTracer(100*time.Millisecond, slog.New(slog.NewTextHandler(&logs, nil)))
+
+	traceQuery(tr, "UPDATE t SET a = $1", time.Millisecond, "UPDATE 2", nil)
+	if logs.Len() != 0 {
+		t.Errorf("logs of a fast query = %q, want none", logs.String())
+	}
+	traceQuery(tr, "UPDATE t SET a = 5", time.Second, "UPDATE 3", errors.New("deadlock detected"))
+	out := logs.String()
+	for _, want := range []string{"Slow query.", `sql="UPDATE t SET a = ?"`, "args=1", "rows_affected=3", "error=\"deadlock detected\""} {
+		if !strings.Contains(out, want) {
+			t.Errorf("logs of a slow query = %q, want %s", out, want)
+		}
+	}
+
+	stats := tr.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("Stats = %+v, want the normalized statement", stats)
+	}
+	st := stats[0]
+	if st.SQL != "UPDATE t SET a = ?" || st.Calls != 2 || st.Errors != 1 || st.Slow != 1 || st.Rows != 5 || st.Max < time.Second || st.Total < st.Max {
+		t.Errorf("stats = %+v", st)
+	}
+
+	// Statements are not logged without a threshold.
+	tr.SetThreshold(0)
+	logs.Reset()
+	traceQuery(tr, "DELETE FROM t", 2*time.Second, "DELETE 0", nil)
+	if logs.Len() != 0 {
+		t.Errorf("logs without a threshold = %q, want none", logs.String())
+	}
+	if stats := tr.Stats(); len(stats) != 2 || stats[0].SQL != "DELETE FROM t" || stats[0].Slow != 0 {
+		t.Errorf("Stats = %+v, want DELETE FROM t first and not slow", stats)
+	}
+}
+
+func TestTracerLimit(t *testing.T) {
+	tr := NewTracer(0, nil)
+	for i := 0; i < maxTracedStatements+2; i++ {
+		traceQuery(tr, fmt.Sprintf("SELECT * FROM t%d", i), time.Millisecond, "SELECT 1", nil)
+	}
+	stats := tr.Stats()
+	if len(stats) != maxTracedStatements+1 {
+		t.Fatalf("%d statements counted, want %d", len(stats), maxTracedStatements+1)
+	}
+	for _, st := range stats {
+		if st.SQL == otherStatement && st.Calls != 2 {
+			t.Errorf("%s has %d calls, want 2", otherStatement, st.Calls)
+		}
+	}
+}
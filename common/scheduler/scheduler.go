@@ -0,0 +1,351 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPollInterval = 10 * time.Second
+	defaultTimeout      = 10 * time.Minute
+	defaultRetention    = 30 * 24 * time.Hour
+
+	// purgeInterval is how often old runs are purged.
+	purgeInterval = time.Hour
+)
+
+// Statuses of job runs.
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusTimedOut  = "timed_out"
+)
+
+var (
+	ErrJobExists  = errors.New("job already exists")
+	ErrInvalidJob = errors.New("invalid job")
+)
+
+// Job is a job run by a Scheduler. Periodic jobs run every Interval, the
+// first time at At; other jobs run once at At. A zero At is the time the
+// scheduler starts.
+type Job struct {
+	// Name identifies the job across instances.
+	Name     string
+	Interval time.Duration
+	At       time.Time
+	// Timeout bounds each run; it defaults to Options.Timeout.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// Run is a run of a job, recorded in the history of the Store.
+type Run struct {
+	ID         string
+	Job        string
+	Instance   string
+	Status     string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+	Error      *string
+}
+
+// Store records the runs of jobs and locks them across instances.
+type Store interface {
+	// Lock tries to acquire the lock of a job, returning false if another
+	// instance holds it. The returned function releases the lock.
+	Lock(ctx context.Context, job string) (unlock func(), ok bool, err error)
+	// LastRun returns the last started run of a job and whether it exists.
+	LastRun(ctx context.Context, job string) (Run, bool, error)
+	Started(ctx context.Context, run Run) error
+	Finished(ctx context.Context, run Run) error
+	// Runs returns up to limit runs, the last started first, of a job or,
+	// if job is empty, of all jobs.
+	Runs(ctx context.Context, job string, limit int) ([]Run, error)
+	// Purge deletes the runs started before a time.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// Options...
+type Options struct {
+	Logger *slog.Logger
+	// Instance identifies the instance in the history of runs.
+	Instance string
+	// PollInterval is how often due jobs are looked for.
+	PollInterval time.Duration
+	// Timeout is the default timeout of runs.
+	Timeout time.Duration
+	// Retention is how long runs are kept.
+	Retention time.Duration
+}
+
+// Scheduler runs jobs. A job runs on one instance at a time: instances take
+// the lock of a due job before running it, and check it is still due once
+// they hold the lock.
+type Scheduler struct {
+	store  Store
+	opts   Options
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	jobs    []Job
+	running map[string]bool
+	started time.Time
+	// stop stops the loop, which closes stopped; cancelJobs cancels the
+	// runs, which wg waits for.
+	stop       context.CancelFunc
+	stopped    chan struct{}
+	cancelJobs context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// New returns a Scheduler recording runs in store. Zero options are replaced
+// by defaults.
+func New(store Store, opts *Options) *Scheduler {
+	s := &Scheduler{
+		store:   store,
+		opts:    *opts,
+		logger:  opts.Logger,
+		now:     time.Now,
+		running: make(map[string]bool),
+	}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	if s.opts.PollInterval <= 0 {
+		s.opts.PollInterval = defaultPollInterval
+	}
+	if s.opts.Timeout <= 0 {
+		s.opts.Timeout = defaultTimeout
+	}
+	if s.opts.Retention <= 0 {
+		s.opts.Retention = defaultRetention
+	}
+	return s
+}
+
+// Add adds a job. It fails with ErrJobExists if a job has the same name.
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Run == nil || job.Interval < 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidJob, job.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.Name == job.Name {
+			return fmt.Errorf("%w: %q", ErrJobExists, job.Name)
+		}
+	}
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+// Start runs due jobs in the background until Stop is called or ctx is
+// done. Runs are not canceled when ctx is done, only by Stop.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+
+	loopCtx, stop := context.WithCancel(ctx)
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	s.stop, s.cancelJobs = stop, cancelJobs
+	s.stopped = make(chan struct{})
+	s.started = s.now()
+	go s.loop(loopCtx, jobCtx)
+}
+
+// Stop stops running due jobs and waits for the running ones until ctx is
+// done, after which they are canceled.
+func (s *Scheduler) Stop(ctx context.Context) {
+	s.mu.Lock()
+	stop := s.stop
+	s.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	stop()
+	<-s.stopped
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn("Canceling running jobs.")
+		s.cancelJobs()
+		<-done
+	}
+	s.cancelJobs()
+}
+
+func (s *Scheduler) loop(ctx, jobCtx context.Context) {
+	defer close(s.stopped)
+	var purged time.Time
+	for {
+		s.tick(ctx, jobCtx)
+
+		if now := s.now(); now.Sub(purged) >= purgeInterval {
+			purged = now
+			if n, err := s.store.Purge(ctx, now.Add(-s.opts.Retention)); err != nil {
+				if ctx.Err() == nil {
+					s.logger.Error("Failed to purge job runs.", slog.String("error", err.Error()))
+				}
+			} else if n != 0 {
+				s.logger.Debug("Purged job runs.", slog.Int("runs", n))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.opts.PollInterval):
+		}
+	}
+}
+
+// tick starts the due jobs that are not running on this instance.
+func (s *Scheduler) tick(ctx, jobCtx context.Context) {
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if !s.running[job.Name] {
+			jobs = append(jobs, job)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, job := range jobs {
+		due, err := s.due(ctx, job)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Error("Failed to check job.", slog.String("job", job.Name), slog.String("error", err.Error()))
+			}
+			continue
+		}
+		if !due {
+			continue
+		}
+
+		s.mu.Lock()
+		s.running[job.Name] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func(job Job) {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.running, job.Name)
+				s.mu.Unlock()
+			}()
+			s.run(jobCtx, job)
+		}(job)
+	}
+}
+
+// due reports whether job is due.
+func (s *Scheduler) due(ctx context.Context, job Job) (bool, error) {
+	now := s.now()
+	last, ok, err := s.store.LastRun(ctx, job.Name)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		at := job.At
+		if at.IsZero() {
+			at = s.started
+		}
+		return !now.Before(at), nil
+	}
+	return job.Interval > 0 && !now.Before(last.StartedAt.Add(job.Interval)), nil
+}
+
+// run runs job if it is still due once its lock is held.
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	logger := s.logger.With(slog.String("job", job.Name))
+	unlock, ok, err := s.store.Lock(ctx, job.Name)
+	if err != nil {
+		logger.Error("Failed to lock job.", slog.String("error", err.Error()))
+		return
+	}
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// Another instance may have run the job since it was found due.
+	if due, err := s.due(ctx, job); err != nil || !due {
+		if err != nil {
+			logger.Error("Failed to check job.", slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	run := Run{
+		ID:        uuid.New().String(),
+		Job:       job.Name,
+		Instance:  s.opts.Instance,
+		Status:    StatusRunning,
+		StartedAt: s.now(),
+	}
+	if err := s.store.Started(ctx, run); err != nil {
+		logger.Error("Failed to record job run.", slog.String("error", err.Error()))
+		return
+	}
+	logger.Debug("Running job.", slog.String("run_id", run.ID))
+
+	timeout := job.Timeout
+	if timeout <= 0 {
+		timeout = s.opts.Timeout
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	err = call(runCtx, job.Run)
+	cancel()
+
+	finishedAt := s.now()
+	run.FinishedAt = &finishedAt
+	run.Status = StatusSucceeded
+	if err != nil {
+		msg := err.Error()
+		run.Error = &msg
+		run.Status = StatusFailed
+		if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			run.Status = StatusTimedOut
+		}
+	}
+
+	// The run is recorded even if the jobs were canceled.
+	if err := s.store.Finished(context.WithoutCancel(ctx), run); err != nil {
+		logger.Error("Failed to record job run.", slog.String("error", err.Error()))
+	}
+	duration := slog.Int64("duration_ms", finishedAt.Sub(run.StartedAt).Milliseconds())
+	if run.Error != nil {
+		logger.Error("Job failed.", slog.String("status", run.Status), slog.String("error", *run.Error), duration)
+	} else {
+		logger.Debug("Job succeeded.", duration)
+	}
+}
+
+// call calls fn, returning panics as errors.
+func call(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"glut/common/migrate"
+	"glut/common/sqlite"
+	"glut/db/migrations"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestScheduler returns a Scheduler on a migrated SQLite database, started
+// at testNow. Its jobs are run by calling tick.
+func newTestScheduler(t *testing.T) (*Scheduler, Store) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	all, err := migrations.SQLite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlite.Open(ctx, &sqlite.Config{URL: filepath.Join(t.TempDir(), "glut.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrate.NewSQLite(db, all, logger).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewSQLiteStore(db)
+	s := New(store, &Options{Logger: logger, Instance: "test"})
+	s.now = func() time.Time { return testNow }
+	s.started = testNow
+	return s, store
+}
+
+// tick runs the due jobs of s at now and waits for them.
+func tick(s *Scheduler, now time.Time) {
+	ctx := context.Background()
+	s.now = func() time.Time { return now }
+	s.tick(ctx, ctx)
+	s.wg.Wait()
+}
+
+func TestSchedulerRuns(t *testing.T) {
+	ctx := context.Background()
+	s, store := newTestScheduler(t)
+
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	count := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			counts[name]++
+			return nil
+		}
+	}
+	jobs := []Job{
+		{Name: "periodic", Interval: time.Hour, Run: count("periodic")},
+		{Name: "once", At: testNow.Add(30 * time.Minute), Run: count("once")},
+		{Name: "failing", Interval: time.Hour, Run: func(ctx context.Context) error { return errors.New("boom") }},
+		{Name: "panicking", Interval: time.Hour, Run: func(ctx context.Context) error { panic("boom") }},
+		{Name: "slow", Interval: time.Hour, Timeout: time.Millisecond, Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	}
+	for _, job := range jobs {
+		if err := s.Add(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Add(jobs[0]); !errors.Is(err, ErrJobExists) {
+		t.Errorf("Add of an existing job: got %v, want ErrJobExists", err)
+	}
+	if err := s.Add(Job{Name: "nil"}); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("Add of a job without Run: got %v, want ErrInvalidJob", err)
+	}
+
+	tick(s, testNow)
+	tick(s, testNow.Add(20*time.Minute))
+	if counts["periodic"] != 1 || counts["once"] != 0 {
+		t.Errorf("runs before the interval = %v, want periodic once", counts)
+	}
+	tick(s, testNow.Add(40*time.Minute))
+	tick(s, testNow.Add(time.Hour))
+	tick(s, testNow.Add(3*time.Hour))
+	if counts["periodic"] != 3 || counts["once"] != 1 {
+		t.Errorf("runs = %v, want periodic 3 times and once once", counts)
+	}
+
+	for name, want := range map[string]string{
+		"periodic":  StatusSucceeded,
+		"once":      StatusSucceeded,
+		"failing":   StatusFailed,
+		"panicking": StatusFailed,
+		"slow":      StatusTimedOut,
+	} {
+		runs, err := store.Runs(ctx, name, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != 1 || runs[0].Status != want || runs[0].FinishedAt == nil || runs[0].Instance != "test" {
+			t.Errorf("last run of %s = %+v, want %s", name, runs, want)
+		}
+		if want != StatusSucceeded && runs[0].Error == nil {
+			t.Errorf("last run of %s has no error", name)
+		}
+	}
+
+	runs, err := store.Runs(ctx, "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 13 {
+		t.Errorf("got %d runs, want 13", len(runs))
+	}
+	if n, err := store.Purge(ctx, testNow.Add(2*time.Hour)); err != nil || n != 9 {
+		t.Errorf("Purge = %d, %v, want 9", n, err)
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	s, store := newTestScheduler(t)
+	s.now = time.Now
+
+	started := make(chan struct{})
+	if err := s.Add(Job{Name: "blocking", Run: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Start(context.Background())
+	<-started
+
+	// Stop waits for the run until its context is done, then cancels it.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	s.Stop(ctx)
+
+	runs, err := store.Runs(context.Background(), "blocking", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Status != StatusFailed || runs[0].FinishedAt == nil {
+		t.Errorf("runs = %+v, want one failed run", runs)
+	}
+}
@@ -0,0 +1,196 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"glut/common/sqlite"
+	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockPrefix prefixes the names of job locks.
+const lockPrefix = "glut.scheduler."
+
+// pgStore is a Store on the scheduler schema of db/migrations. Jobs are
+// locked with session level advisory locks, which are released if their
+// instance dies.
+type pgStore struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresStore returns a Store on db, which must be a primary database.
+func NewPostgresStore(db *pgxpool.Pool) Store {
+	return pgStore{db: db}
+}
+
+// Lock holds a connection of the pool until the lock is released.
+func (s pgStore) Lock(ctx context.Context, job string) (func(), bool, error) {
+	h := fnv.New64a()
+	h.Write([]byte(lockPrefix + job))
+	key := int64(h.Sum64())
+
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	var ok bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok); err != nil || !ok {
+		conn.Release()
+		return nil, false, err
+	}
+	return func() {
+		// Use a fresh context so the lock is released even if ctx is done.
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			// Closing the connection releases its locks.
+			conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+	}, true, nil
+}
+
+func (s pgStore) LastRun(ctx context.Context, job string) (Run, bool, error) {
+	runs, err := s.runs(ctx, `
+	SELECT id, job, instance, status, started_at, finished_at, error
+	FROM scheduler.job_runs
+	WHERE job = $1
+	ORDER BY started_at DESC
+	LIMIT 1`, job)
+	if err != nil || len(runs) == 0 {
+		return Run{}, false, err
+	}
+	return runs[0], true, nil
+}
+
+func (s pgStore) Started(ctx context.Context, run Run) error {
+	_, err := s.db.Exec(ctx, `
+	INSERT INTO scheduler.job_runs (id, job, instance, status, started_at)
+	VALUES ($1, $2, $3, $4, $5)`,
+		run.ID, run.Job, run.Instance, run.Status, run.StartedAt)
+	return err
+}
+
+func (s pgStore) Finished(ctx context.Context, run Run) error {
+	_, err := s.db.Exec(ctx, `
+	UPDATE scheduler.job_runs SET status = $2, finished_at = $3, error = $4
+	WHERE id = $1`,
+		run.ID, run.Status, run.FinishedAt, run.Error)
+	return err
+}
+
+func (s pgStore) Runs(ctx context.Context, job string, limit int) ([]Run, error) {
+	return s.runs(ctx, `
+	SELECT id, job, instance, status, started_at, finished_at, error
+	FROM scheduler.job_runs
+	WHERE $1 = '' OR job = $1
+	ORDER BY started_at DESC
+	LIMIT $2`, job, limit)
+}
+
+func (s pgStore) runs(ctx context.Context, sql string, args ...any) ([]Run, error) {
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Run, error) {
+		var r Run
+		err := row.Scan(&r.ID, &r.Job, &r.Instance, &r.Status, &r.StartedAt, &r.FinishedAt, &r.Error)
+		return r, err
+	})
+}
+
+func (s pgStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	tag, err := s.db.Exec(ctx, `
+	DELETE FROM scheduler.job_runs
+	WHERE started_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// sqliteStore is a Store on the scheduler tables of db/migrations/sqlite.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore returns a Store on db, which must be opened with
+// sqlite.Open.
+func NewSQLiteStore(db *sql.DB) Store {
+	return sqliteStore{db: db}
+}
+
+// Lock always succeeds: SQLite databases are not shared by instances.
+func (s sqliteStore) Lock(ctx context.Context, job string) (func(), bool, error) {
+	return func() {}, true, nil
+}
+
+func (s sqliteStore) LastRun(ctx context.Context, job string) (Run, bool, error) {
+	runs, err := s.runs(ctx, `
+	SELECT id, job, instance, status, started_at, finished_at, error
+	FROM scheduler_job_runs
+	WHERE job = ?
+	ORDER BY started_at DESC
+	LIMIT 1`, job)
+	if err != nil || len(runs) == 0 {
+		return Run{}, false, err
+	}
+	return runs[0], true, nil
+}
+
+func (s sqliteStore) Started(ctx context.Context, run Run) error {
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO scheduler_job_runs (id, job, instance, status, started_at)
+	VALUES (?, ?, ?, ?, ?)`,
+		sqlite.Args([]any{run.ID, run.Job, run.Instance, run.Status, run.StartedAt})...)
+	return err
+}
+
+func (s sqliteStore) Finished(ctx context.Context, run Run) error {
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE scheduler_job_runs SET status = ?, finished_at = ?, error = ?
+	WHERE id = ?`,
+		sqlite.Args([]any{run.Status, run.FinishedAt, run.Error, run.ID})...)
+	return err
+}
+
+func (s sqliteStore) Runs(ctx context.Context, job string, limit int) ([]Run, error) {
+	return s.runs(ctx, `
+	SELECT id, job, instance, status, started_at, finished_at, error
+	FROM scheduler_job_runs
+	WHERE ?1 = '' OR job = ?1
+	ORDER BY started_at DESC
+	LIMIT ?2`, job, limit)
+}
+
+func (s sqliteStore) runs(ctx context.Context, query string, args ...any) ([]Run, error) {
+	rows, err := s.db.QueryContext(ctx, query, sqlite.Args(args)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var r Run
+		if err := rows.Scan(&r.ID, &r.Job, &r.Instance, &r.Status, &r.StartedAt, &r.FinishedAt, &r.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+func (s sqliteStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, `
+	DELETE FROM scheduler_job_runs
+	WHERE started_at < ?`,
+		sqlite.Args([]any{before})...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
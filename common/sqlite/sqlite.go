@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	defaultURL             = "glut.db"
+	defaultMaxOpenConns    = 10
+	defaultMaxConnLifetime = 10 * time.Minute
+	defaultMaxConnIdleTime = 5 * time.Minute
+	defaultBusyTimeout     = 5 * time.Second
+)
+
+// TimeFormat is the format of time arguments. Times are written in UTC with
+// all fractional digits, so that their text compares in time order. Columns
+// declared as TIMESTAMP are read back as times by the driver.
+const TimeFormat = "2006-01-02 15:04:05.000000000Z"
+
+// ForeignKey is returned by Constraint for foreign key violations, which
+// SQLite does not attribute to a constraint.
+const ForeignKey = "FOREIGN KEY"
+
+// Config...
+type Config struct {
+	// URL is the path of the database file, or a file: URI whose query
+	// parameters are passed to the driver.
+	URL             string
+	MaxOpenConns    int
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	// BusyTimeout is how long a connection waits for the locks of other
+	// connections before failing.
+	BusyTimeout time.Duration
+}
+
+// Open opens the database of cfg. Connections enforce foreign keys, use
+// write-ahead logging and begin transactions with BEGIN IMMEDIATE, so that
+// concurrent transactions wait for each other's write lock instead of failing
+// when they start writing.
+func Open(ctx context.Context, cfg *Config) (*sql.DB, error) {
+	if cfg.URL == "" {
+		cfg.URL = defaultURL
+	}
+	if cfg.MaxOpenConns == 0 {
+		cfg.MaxOpenConns = defaultMaxOpenConns
+	}
+	if cfg.MaxConnLifetime == 0 {
+		cfg.MaxConnLifetime = defaultMaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime == 0 {
+		cfg.MaxConnIdleTime = defaultMaxConnIdleTime
+	}
+	if cfg.BusyTimeout == 0 {
+		cfg.BusyTimeout = defaultBusyTimeout
+	}
+
+	dsn, query, _ := strings.Cut(cfg.URL, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "busy_timeout("+strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10)+")")
+	// LIKE is case sensitive as in PostgreSQL.
+	params.Add("_pragma", "case_sensitive_like(1)")
+	if !params.Has("_txlock") {
+		params.Set("_txlock", "immediate")
+	}
+
+	db, err := sql.Open("sqlite", dsn+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetConnMaxLifetime(cfg.MaxConnLifetime)
+	db.SetConnMaxIdleTime(cfg.MaxConnIdleTime)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Args returns args with times formatted with TimeFormat. Nil time pointers
+// are returned as nil.
+func Args(args []any) []any {
+	ret := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			ret[i] = v.UTC().Format(TimeFormat)
+		case *time.Time:
+			if v == nil {
+				ret[i] = nil
+			} else {
+				ret[i] = v.UTC().Format(TimeFormat)
+			}
+		default:
+			ret[i] = arg
+		}
+	}
+	return ret
+}
+
+// Constraint returns the constraint violated by err, or an empty string if
+// err is not a constraint violation. Unique constraints are named by their
+// columns, e.g. "auth_users.username", and foreign keys by ForeignKey.
+func Constraint(err error) string {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code()&0xff != sqlite3.SQLITE_CONSTRAINT {
+		return ""
+	}
+	if sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY {
+		return ForeignKey
+	}
+	// Messages end with the constraint, e.g. "constraint failed: UNIQUE
+	// constraint failed: auth_users.username (2067)".
+	msg := sqliteErr.Error()
+	if i := strings.LastIndex(msg, " ("); i >= 0 {
+		msg = msg[:i]
+	}
+	if i := strings.LastIndex(msg, ": "); i >= 0 {
+		msg = msg[i+2:]
+	}
+	return msg
+}
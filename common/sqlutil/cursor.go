@@ -0,0 +1,322 @@
+package sqlutil
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stephenafamo/bob"
+	"github.com/stephenafamo/bob/clause"
+	"github.com/stephenafamo/bob/dialect/psql"
+	"github.com/stephenafamo/bob/dialect/psql/dialect"
+	"github.com/stephenafamo/bob/dialect/psql/sm"
+	"github.com/stephenafamo/bob/mods"
+)
+
+// ErrInvalidCursor is returned when a cursor is malformed, was not signed by
+// the codec, or does not match the query it is used with.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Keyset describes the order of a keyset paginated query: rows are ordered by
+// sort keys and then by a unique id column breaking ties in the direction of
+// the last key.
+type Keyset struct {
+	// Scope names the paginated entity. Cursors are only valid for queries of
+	// the same scope.
+	Scope string
+	Keys  []SortKey
+	// IDColumn is the id column expression, e.g. "u.id".
+	IDColumn string
+}
+
+// Cursor is a position in a keyset paginated query.
+type Cursor struct {
+	Scope  string    `json:"s"`
+	Sort   string    `json:"k"`
+	Values []*string `json:"v"`
+	ID     string    `json:"i"`
+	// Before selects the rows before the position instead of after it.
+	Before bool `json:"b,omitempty"`
+}
+
+// CursorCodec encodes and decodes cursors signed with HMAC-SHA256, so that
+// clients cannot craft cursors.
+type CursorCodec struct {
+	key []byte
+}
+
+// NewCursorCodec creates a codec signing cursors with key. A random key is
+// used if key is empty, so cursors are only valid for the lifetime of the
+// codec.
+func NewCursorCodec(key []byte) *CursorCodec {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(fmt.Sprintf("sqlutil.NewCursorCodec: %v", err))
+		}
+	}
+	return &CursorCodec{key: slices.Clone(key)}
+}
+
+// Encode returns the opaque representation of c.
+func (cc *CursorCodec) Encode(c Cursor) string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(cc.sign(payload))
+}
+
+// Decode parses and verifies an encoded cursor.
+func (cc *CursorCodec) Decode(s string) (Cursor, error) {
+	p, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, cc.sign(payload)) {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// sign returns the signature of payload.
+func (cc *CursorCodec) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, cc.key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// Sort returns the sort option of k.
+func (k Keyset) Sort() string {
+	return FormatSort(k.Keys)
+}
+
+// Check returns ErrInvalidCursor if c was not created for a query ordered by
+// k.
+func (k Keyset) Check(c Cursor) error {
+	if c.Scope != k.Scope || c.Sort != k.Sort() || len(c.Values) != len(k.Keys) || c.ID == "" {
+		return ErrInvalidCursor
+	}
+	for i, key := range k.Keys {
+		v := c.Values[i]
+		if v == nil {
+			if !key.Nullable {
+				return ErrInvalidCursor
+			}
+			continue
+		}
+		if key.Time {
+			if _, err := time.Parse(time.RFC3339Nano, *v); err != nil {
+				return ErrInvalidCursor
+			}
+		}
+	}
+	return nil
+}
+
+// OrderBy returns the query mods ordering rows by k, or in the reverse order
+// if reverse is set.
+func (k Keyset) OrderBy(reverse bool) []bob.Mod[*dialect.SelectQuery] {
+	defs := k.Order(reverse)
+	orderBy := make([]bob.Mod[*dialect.SelectQuery], len(defs))
+	for i, def := range defs {
+		orderBy[i] = mods.OrderBy[*dialect.SelectQuery](def)
+	}
+	return orderBy
+}
+
+// Order returns the order of k as clauses for queries of any dialect. The
+// order of nulls is explicit for nullable keys, since dialects differ in
+// their default.
+func (k Keyset) Order(reverse bool) []clause.OrderDef {
+	var defs []clause.OrderDef
+	desc := reverse
+	for _, key := range k.Keys {
+		desc = key.Desc != reverse
+		def := orderDef(key.Column, desc)
+		if key.Nullable && desc {
+			def.Nulls = "FIRST"
+		} else if key.Nullable {
+			def.Nulls = "LAST"
+		}
+		defs = append(defs, def)
+	}
+	return append(defs, orderDef(k.IDColumn, desc))
+}
+
+// orderDef returns the clause ordering by col.
+func orderDef(col string, desc bool) clause.OrderDef {
+	if desc {
+		return clause.OrderDef{Expression: psql.Raw(col), Direction: SortDirDesc}
+	}
+	return clause.OrderDef{Expression: psql.Raw(col), Direction: SortDirAsc}
+}
+
+// Mods returns the query mods selecting the page after or before c, or the
+// first page if c is nil. One row more than limit is selected to tell whether
+// more rows follow.
+func (k Keyset) Mods(c *Cursor, limit int) []bob.Mod[*dialect.SelectQuery] {
+	reverse := c != nil && c.Before
+	var mods []bob.Mod[*dialect.SelectQuery]
+	if c != nil {
+		mods = append(mods, sm.Where(k.Condition(c)))
+	}
+	mods = append(mods, k.OrderBy(reverse)...)
+	return append(mods, sm.Limit(limit+1))
+}
+
+// Condition returns the condition of Mods selecting the rows after or before
+// c, for queries of any dialect. The rows before c are selected in the
+// reverse order of k.
+func (k Keyset) Condition(c *Cursor) bob.Expression {
+	return k.after(c, c.Before)
+}
+
+// after returns the condition selecting the rows following c in the order of
+// k, or in the reverse order if reverse is set. A row follows c if it is equal
+// to c on the first keys and follows it on the next one, where the id is the
+// last key.
+func (k Keyset) after(c *Cursor, reverse bool) bob.Expression {
+	if len(k.Keys) == 1 && !k.Keys[0].Nullable {
+		key := k.Keys[0]
+		op := ">"
+		if key.Desc != reverse {
+			op = "<"
+		}
+		return psql.Raw(fmt.Sprintf("(%s, %s) %s (?, ?)", key.Column, k.IDColumn, op), key.value(*c.Values[0]), c.ID)
+	}
+
+	var levels []string
+	var args []any
+	var eq []string
+	var eqArgs []any
+	desc := reverse
+	for i, key := range k.Keys {
+		desc = key.Desc != reverse
+		v := c.Values[i]
+		if cond, arg, ok := key.follows(v, desc); ok {
+			levels = append(levels, "("+strings.Join(append(slices.Clone(eq), cond), " AND ")+")")
+			args = append(append(args, eqArgs...), arg...)
+		}
+		if v == nil {
+			eq = append(eq, key.Column+" IS NULL")
+		} else {
+			eq = append(eq, key.Column+" = ?")
+			eqArgs = append(eqArgs, key.value(*v))
+		}
+	}
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	levels = append(levels, "("+strings.Join(append(eq, k.IDColumn+" "+op+" ?"), " AND ")+")")
+	args = append(append(args, eqArgs...), c.ID)
+	return psql.Raw("("+strings.Join(levels, " OR ")+")", args...)
+}
+
+// follows returns the condition selecting the values of the key following v
+// in the given direction. It returns false if no value follows v.
+func (key SortKey) follows(v *string, desc bool) (string, []any, bool) {
+	switch {
+	case v == nil && desc:
+		// Nulls are first in descending order, so all values follow them.
+		return key.Column + " IS NOT NULL", nil, true
+	case v == nil:
+		return "", nil, false
+	case desc:
+		return key.Column + " < ?", []any{key.value(*v)}, true
+	case key.Nullable:
+		return "(" + key.Column + " > ? OR " + key.Column + " IS NULL)", []any{key.value(*v)}, true
+	}
+	return key.Column + " > ?", []any{key.value(*v)}, true
+}
+
+// value returns the query argument of an encoded sort value.
+func (key SortKey) value(v string) any {
+	if key.Time {
+		t, _ := time.Parse(time.RFC3339Nano, v)
+		return t
+	}
+	return v
+}
+
+// Paginate returns the page of rows selected with the mods of k. The key
+// function returns the sort values and id of an item; sort values must be
+// strings, time.Times or pointers to one of them.
+func Paginate[T any](cc *CursorCodec, k Keyset, c *Cursor, rows []T, limit int, key func(T) ([]any, string)) Page[T] {
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	before := c != nil && c.Before
+	if before {
+		slices.Reverse(rows)
+	}
+	page := Page[T]{Items: rows, Limit: limit}
+	if len(rows) == 0 {
+		return page
+	}
+	if more || before {
+		next := cc.Encode(cursorAt(k, rows[len(rows)-1], key, false))
+		page.NextCursor = &next
+	}
+	if (more && before) || (c != nil && !before) {
+		prev := cc.Encode(cursorAt(k, rows[0], key, true))
+		page.PrevCursor = &prev
+	}
+	page.HasMore = page.NextCursor != nil
+	return page
+}
+
+// cursorAt returns the cursor positioned at item.
+func cursorAt[T any](k Keyset, item T, key func(T) ([]any, string), before bool) Cursor {
+	vs, id := key(item)
+	values := make([]*string, len(vs))
+	for i, v := range vs {
+		values[i] = formatValue(v)
+	}
+	return Cursor{
+		Scope:  k.Scope,
+		Sort:   k.Sort(),
+		Values: values,
+		ID:     id,
+		Before: before,
+	}
+}
+
+// formatValue returns the encoded sort value of v.
+func formatValue(v any) *string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case *string:
+		if v == nil {
+			return nil
+		}
+		s = *v
+	case time.Time:
+		s = v.UTC().Format(time.RFC3339Nano)
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		s = v.UTC().Format(time.RFC3339Nano)
+	default:
+		panic(fmt.Sprintf("sqlutil: unsupported sort value type %T", v))
+	}
+	return &s
+}
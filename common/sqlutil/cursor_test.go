@@ -0,0 +1,343 @@
+package sqlutil
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"glut/common/sqlite"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stephenafamo/bob/dialect/psql"
+	"github.com/stephenafamo/bob/dialect/psql/sm"
+	sqlitedialect "github.com/stephenafamo/bob/dialect/sqlite"
+	"github.com/stephenafamo/bob/dialect/sqlite/dialect"
+	sqlitesm "github.com/stephenafamo/bob/dialect/sqlite/sm"
+	"github.com/stephenafamo/bob/mods"
+)
+
+var (
+	testUsername    = SortKey{Name: "username", Column: "username"}
+	testLastLoginAt = SortKey{Name: "last_login_at", Column: "last_login_at", Nullable: true, Time: true}
+)
+
+func desc(key SortKey) SortKey {
+	key.Desc = true
+	return key
+}
+
+func TestCursorCodec(t *testing.T) {
+	cc := NewCursorCodec([]byte("secret"))
+	at := "2024-03-01T00:00:00Z"
+	c := Cursor{Scope: "users", Sort: "last_login_at,desc", Values: []*string{&at, nil}, ID: "42", Before: true}
+
+	s := cc.Encode(c)
+	got, err := cc.Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Scope != c.Scope || got.Sort != c.Sort || got.ID != c.ID || !got.Before ||
+		len(got.Values) != 2 || got.Values[0] == nil || *got.Values[0] != at || got.Values[1] != nil {
+		t.Errorf("Decode(Encode(%+v)) = %+v", c, got)
+	}
+
+	// A cursor whose payload was changed keeps the signature of the original.
+	payload, sig, _ := strings.Cut(s, ".")
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(raw), `"42"`, `"43"`, 1))) + "." + sig
+
+	for name, s := range map[string]string{
+		"empty":             "",
+		"unsigned":          payload,
+		"tampered":          tampered,
+		"invalid signature": payload + ".c2lnbmF0dXJl",
+		"invalid encoding":  "!" + s,
+		"foreign key":       NewCursorCodec([]byte("other")).Encode(c),
+		"random key":        NewCursorCodec(nil).Encode(c),
+	} {
+		if _, err := cc.Decode(s); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Decode(%s cursor) = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
+
+func TestKeysetCheck(t *testing.T) {
+	k := Keyset{Scope: "users", Keys: []SortKey{desc(testLastLoginAt), testUsername}, IDColumn: "id"}
+	at, name, invalid := "2024-03-01T00:00:00Z", "alice", "yesterday"
+	valid := Cursor{Scope: "users", Sort: "last_login_at,desc,username,asc", Values: []*string{&at, &name}, ID: "42"}
+	if err := k.Check(valid); err != nil {
+		t.Errorf("Check(%+v) = %v, want nil", valid, err)
+	}
+	valid.Values = []*string{nil, &name}
+	if err := k.Check(valid); err != nil {
+		t.Errorf("Check with a null time = %v, want nil", err)
+	}
+
+	for name, c := range map[string]Cursor{
+		"other scope":      {Scope: "sessions", Sort: valid.Sort, Values: valid.Values, ID: "42"},
+		"other direction":  {Scope: "users", Sort: "last_login_at,asc,username,asc", Values: valid.Values, ID: "42"},
+		"other keys":       {Scope: "users", Sort: "username,asc", Values: []*string{&name}, ID: "42"},
+		"missing value":    {Scope: "users", Sort: valid.Sort, Values: []*string{&at}, ID: "42"},
+		"missing id":       {Scope: "users", Sort: valid.Sort, Values: valid.Values},
+		"null username":    {Scope: "users", Sort: valid.Sort, Values: []*string{&at, nil}, ID: "42"},
+		"invalid time":     {Scope: "users", Sort: valid.Sort, Values: []*string{&invalid, &name}, ID: "42"},
+		"reordered values": {Scope: "users", Sort: valid.Sort, Values: []*string{&name, &at}, ID: "42"},
+	} {
+		if err := k.Check(c); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Check(%s cursor) = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
+
+func TestKeysetMods(t *testing.T) {
+	k := Keyset{Scope: "users", Keys: []SortKey{desc(testLastLoginAt)}, IDColumn: "id"}
+	at := "2024-03-01T00:00:00Z"
+	for _, tt := range []struct {
+		c    *Cursor
+		want string
+	}{
+		{nil, "ORDER BY (last_login_at) DESC NULLS FIRST, (id) DESC LIMIT 11"},
+		{&Cursor{Values: []*string{&at}, ID: "42"}, "WHERE (((last_login_at < $1) OR (last_login_at = $2 AND id < $3))) ORDER BY (last_login_at) DESC NULLS FIRST, (id) DESC LIMIT 11"},
+		{&Cursor{Values: []*string{&at}, ID: "42", Before: true}, "WHERE ((((last_login_at > $1 OR last_login_at IS NULL)) OR (last_login_at = $2 AND id > $3))) ORDER BY (last_login_at) ASC NULLS LAST, (id) ASC LIMIT 11"},
+		{&Cursor{Values: []*string{nil}, ID: "42"}, "WHERE (((last_login_at IS NOT NULL) OR (last_login_at IS NULL AND id < $1))) ORDER BY (last_login_at) DESC NULLS FIRST, (id) DESC LIMIT 11"},
+		{&Cursor{Values: []*string{nil}, ID: "42", Before: true}, "WHERE (((last_login_at IS NULL AND id > $1))) ORDER BY (last_login_at) ASC NULLS LAST, (id) ASC LIMIT 11"},
+	} {
+		q := psql.Select(sm.Columns("id"), sm.From("users"))
+		q.Apply(k.Mods(tt.c, 10)...)
+		sql, _ := q.MustBuild()
+		if got := strings.Join(strings.Fields(sql), " "); !strings.HasSuffix(got, tt.want) {
+			t.Errorf("query of %+v = %q, want suffix %q", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	cc := NewCursorCodec([]byte("secret"))
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	rows := []testUser{{ID: "3", LastLoginAt: &at}, {ID: "2", LastLoginAt: &at}, {ID: "1"}}
+	k := Keyset{Scope: "users", Keys: []SortKey{desc(testLastLoginAt)}, IDColumn: "id"}
+	key := func(u testUser) ([]any, string) { return []any{u.LastLoginAt}, u.ID }
+
+	first := Paginate(cc, k, nil, rows, 2, key)
+	if len(first.Items) != 2 || first.PrevCursor != nil || first.NextCursor == nil {
+		t.Fatalf("first page = %+v, want 2 items and a next cursor", first)
+	}
+	next, err := cc.Decode(*first.NextCursor)
+	if err != nil || next.ID != "2" || len(next.Values) != 1 || next.Values[0] == nil || *next.Values[0] != "2024-03-01T00:00:00Z" || next.Before {
+		t.Errorf("next cursor = %+v, %v, want after user 2", next, err)
+	}
+
+	last := Paginate(cc, k, &next, rows[2:], 2, key)
+	if len(last.Items) != 1 || last.NextCursor != nil || last.PrevCursor == nil {
+		t.Fatalf("last page = %+v, want 1 item and a previous cursor", last)
+	}
+	prev, err := cc.Decode(*last.PrevCursor)
+	if err != nil || prev.ID != "1" || len(prev.Values) != 1 || prev.Values[0] != nil || !prev.Before {
+		t.Errorf("previous cursor = %+v, %v, want before user 1", prev, err)
+	}
+
+	// Rows selected before a cursor are selected in reverse order.
+	back := Paginate(cc, k, &prev, []testUser{rows[1], rows[0]}, 2, key)
+	if len(back.Items) != 2 || back.Items[0].ID != "3" || back.PrevCursor != nil || back.NextCursor == nil {
+		t.Errorf("page before user 1 = %+v, want users 3 and 2 and a next cursor", back)
+	}
+}
+
+type testUser struct {
+	ID          string
+	Username    string
+	LastLoginAt *time.Time
+}
+
+// TestKeysetPages pages through users forwards and backwards in orders with
+// ties and nulls, and checks that every user is listed once in the order of
+// the keyset. Nulls are last in ascending order and first in descending order.
+func TestKeysetPages(t *testing.T) {
+	ctx := context.Background()
+	db, err := sqlite.Open(ctx, &sqlite.Config{URL: filepath.Join(t.TempDir(), "glut.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.ExecContext(ctx, "CREATE TABLE users (id TEXT PRIMARY KEY, username TEXT NOT NULL, last_login_at TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+
+	t1 := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	t2, t3 := t1.Add(time.Hour), t1.Add(time.Hour+time.Nanosecond)
+	users := []testUser{
+		{"1", "alice", &t1},
+		{"2", "bob", nil},
+		{"3", "carol", &t2},
+		{"4", "alice", nil},
+		{"5", "dave", &t1},
+		{"6", "bob", &t3},
+		{"7", "erin", nil},
+	}
+	for _, u := range users {
+		if _, err := db.ExecContext(ctx, "INSERT INTO users VALUES (?, ?, ?)", sqlite.Args([]any{u.ID, u.Username, u.LastLoginAt})...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cc := NewCursorCodec(nil)
+	for _, keys := range [][]SortKey{
+		{testUsername},
+		{desc(testUsername)},
+		{testLastLoginAt},
+		{desc(testLastLoginAt)},
+		{desc(testLastLoginAt), testUsername},
+		{testLastLoginAt, desc(testUsername)},
+		{testUsername, desc(testLastLoginAt)},
+	} {
+		k := Keyset{Scope: "users", Keys: keys, IDColumn: "id"}
+		want := sortUsers(users, keys)
+		for _, limit := range []int{1, 2, 3} {
+			page := func(c *Cursor) Page[testUser] {
+				t.Helper()
+				rows, err := queryUsers(ctx, db, k, c, limit)
+				if err != nil {
+					t.Fatalf("%s: %v", k.Sort(), err)
+				}
+				return Paginate(cc, k, c, rows, limit, func(u testUser) ([]any, string) {
+					var vs []any
+					for _, key := range keys {
+						if key.Name == "username" {
+							vs = append(vs, u.Username)
+						} else {
+							vs = append(vs, u.LastLoginAt)
+						}
+					}
+					return vs, u.ID
+				})
+			}
+			decode := func(s string) *Cursor {
+				t.Helper()
+				c, err := cc.Decode(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := k.Check(c); err != nil {
+					t.Fatalf("%s: Check(%+v) = %v", k.Sort(), c, err)
+				}
+				return &c
+			}
+
+			var forward []string
+			p := page(nil)
+			for {
+				forward = append(forward, userIDs(p.Items)...)
+				if p.NextCursor == nil {
+					break
+				}
+				p = page(decode(*p.NextCursor))
+			}
+			if !slices.Equal(forward, want) {
+				t.Errorf("%s by %d: forward pages = %v, want %v", k.Sort(), limit, forward, want)
+			}
+
+			// Page back from the last page.
+			backward := userIDs(p.Items)
+			for p.PrevCursor != nil {
+				p = page(decode(*p.PrevCursor))
+				backward = append(userIDs(p.Items), backward...)
+			}
+			if !slices.Equal(backward, want) {
+				t.Errorf("%s by %d: backward pages = %v, want %v", k.Sort(), limit, backward, want)
+			}
+		}
+	}
+}
+
+// queryUsers selects the users of the page of k after or before c.
+func queryUsers(ctx context.Context, db *sql.DB, k Keyset, c *Cursor, limit int) ([]testUser, error) {
+	q := sqlitedialect.Select(sqlitesm.Columns("id", "username", "last_login_at"), sqlitesm.From("users"))
+	reverse := false
+	if c != nil {
+		q.Apply(sqlitesm.Where(k.Condition(c)))
+		reverse = c.Before
+	}
+	for _, def := range k.Order(reverse) {
+		q.Apply(mods.OrderBy[*dialect.SelectQuery](def))
+	}
+	q.Apply(sqlitesm.Limit(limit + 1))
+	query, args := q.MustBuild()
+
+	rows, err := db.QueryContext(ctx, query, sqlite.Args(args)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []testUser
+	for rows.Next() {
+		var u testUser
+		var at sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Username, &at); err != nil {
+			return nil, err
+		}
+		if at.Valid {
+			u.LastLoginAt = &at.Time
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// sortUsers returns the ids of users in the order of keys, ties broken by id
+// in the direction of the last key.
+func sortUsers(users []testUser, keys []SortKey) []string {
+	sorted := slices.Clone(users)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		last := false
+		for _, key := range keys {
+			last = key.Desc
+			var c int
+			if key.Name == "username" {
+				c = strings.Compare(a.Username, b.Username)
+			} else {
+				c = compareTimes(a.LastLoginAt, b.LastLoginAt)
+			}
+			if key.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		if last {
+			return a.ID > b.ID
+		}
+		return a.ID < b.ID
+	})
+	return userIDs(sorted)
+}
+
+// compareTimes compares nullable times, nulls after other times.
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return a.Compare(*b)
+}
+
+func userIDs(users []testUser) []string {
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
+}
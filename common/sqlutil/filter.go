@@ -0,0 +1,235 @@
+package sqlutil
+
+import (
+	"fmt"
+	"glut/common/valid"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stephenafamo/bob"
+	"github.com/stephenafamo/bob/dialect/psql"
+	"github.com/stephenafamo/bob/dialect/psql/dialect"
+	"github.com/stephenafamo/bob/dialect/psql/sm"
+)
+
+// Filter operators.
+const (
+	OpEq     = "eq"
+	OpIn     = "in"
+	OpRange  = "range"
+	OpIsNull = "is_null"
+	OpPrefix = "prefix"
+)
+
+const (
+	maxFilters     = 20
+	maxFilterIn    = 100
+	maxSortKeys    = 4
+	likeEscapeChar = `\`
+)
+
+// FieldType is the type of the values of a field.
+type FieldType int
+
+const (
+	TypeString FieldType = iota
+	TypeUUID
+	TypeTime
+)
+
+// Field is a field of an entity that queries may filter or sort by.
+type Field struct {
+	// Column is the SQL expression of the field, e.g. "u.created_at".
+	Column   string
+	Type     FieldType
+	Nullable bool
+	// Ops are the filter operators allowed on the field.
+	Ops      []string
+	Sortable bool
+}
+
+// Fields declares the fields of an entity by the names exposed to clients.
+// Only declared fields and operators are translated to SQL; field names and
+// columns never come from input.
+type Fields map[string]Field
+
+// Filter is a condition on a field. Value is the operand of eq and prefix
+// and, for is_null, whether the field must be null. Values are the operands
+// of in. From and To bound a range; From is inclusive and To is exclusive.
+type Filter struct {
+	Field  string     `json:"field"`
+	Op     string     `json:"op"`
+	Value  any        `json:"value"`
+	Values []string   `json:"values"`
+	From   *time.Time `json:"from"`
+	To     *time.Time `json:"to"`
+}
+
+// Where returns the query mods applying filters. The filters are combined
+// with AND. Invalid filters are reported with their index, e.g. as
+// filters[1].op.
+func (fs Fields) Where(filters []Filter) ([]bob.Mod[*dialect.SelectQuery], valid.Errors) {
+	conds, errs := fs.Conditions(filters)
+	mods := make([]bob.Mod[*dialect.SelectQuery], len(conds))
+	for i, cond := range conds {
+		mods[i] = sm.Where(cond)
+	}
+	return mods, errs
+}
+
+// Conditions returns the SQL conditions of filters as validated by Where.
+// The conditions are standard SQL, for queries of any dialect.
+func (fs Fields) Conditions(filters []Filter) ([]bob.Expression, valid.Errors) {
+	if len(filters) > maxFilters {
+		return nil, valid.Errors{valid.MaxItems("filters", maxFilters)}
+	}
+	var conds []bob.Expression
+	var errs valid.Errors
+	for i, filter := range filters {
+		name := fmt.Sprintf("filters[%d]", i)
+		field, ok := fs[filter.Field]
+		if !ok {
+			errs = append(errs, valid.Invalid(name+".field"))
+			continue
+		}
+		if !slices.Contains(field.Ops, filter.Op) {
+			errs = append(errs, valid.Invalid(name+".op"))
+			continue
+		}
+		expr, err := field.condition(filter, name)
+		if err != nil {
+			errs = append(errs, *err)
+			continue
+		}
+		conds = append(conds, expr)
+	}
+	return conds, errs
+}
+
+// condition returns the SQL condition of filter on the field.
+func (f Field) condition(filter Filter, name string) (bob.Expression, *valid.Error) {
+	col := psql.Raw(f.Column)
+	switch filter.Op {
+	case OpEq:
+		v, ok := filter.Value.(string)
+		if !ok || !f.validValue(v) {
+			return nil, invalid(name + ".value")
+		}
+		return col.EQ(psql.Arg(v)), nil
+	case OpIn:
+		if len(filter.Values) == 0 {
+			return nil, required(name + ".values")
+		}
+		if len(filter.Values) > maxFilterIn {
+			err := valid.MaxItems(name+".values", maxFilterIn)
+			return nil, &err
+		}
+		for _, v := range filter.Values {
+			if !f.validValue(v) {
+				return nil, invalid(name + ".values")
+			}
+		}
+		return col.In(psql.Arg(AnySlice(filter.Values)...)), nil
+	case OpRange:
+		if filter.From == nil && filter.To == nil {
+			return nil, required(name + ".from")
+		}
+		if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+			return nil, invalid(name + ".to")
+		}
+		var conds []bob.Expression
+		if filter.From != nil {
+			conds = append(conds, col.GTE(psql.Arg(*filter.From)))
+		}
+		if filter.To != nil {
+			conds = append(conds, col.LT(psql.Arg(*filter.To)))
+		}
+		return psql.Group(psql.And(conds...)), nil
+	case OpIsNull:
+		isNull, ok := filter.Value.(bool)
+		if !ok {
+			return nil, invalid(name + ".value")
+		}
+		if isNull {
+			return col.IsNull(), nil
+		}
+		return col.IsNotNull(), nil
+	case OpPrefix:
+		v, ok := filter.Value.(string)
+		if !ok || v == "" {
+			return nil, invalid(name + ".value")
+		}
+		return psql.Raw(f.Column+" LIKE ? ESCAPE '"+likeEscapeChar+"'", escapeLike(v)+"%"), nil
+	}
+	return nil, invalid(name + ".op")
+}
+
+// validValue reports whether v is a valid value of the field.
+func (f Field) validValue(v string) bool {
+	switch f.Type {
+	case TypeUUID:
+		return valid.IsUUID(v)
+	case TypeTime:
+		_, err := time.Parse(time.RFC3339Nano, v)
+		return err == nil
+	}
+	return true
+}
+
+// Sort parses a sort option into sort keys. The option is a comma separated
+// list of field names, each optionally followed by a direction, e.g.
+// "last_login_at,desc,username". Fields are sorted in ascending order unless
+// desc is given. An empty option returns def.
+func (fs Fields) Sort(option string, def []SortKey) ([]SortKey, bool) {
+	if strings.TrimSpace(option) == "" {
+		return def, true
+	}
+	var keys []SortKey
+	for _, token := range strings.Split(option, ",") {
+		token = strings.TrimSpace(token)
+		switch strings.ToUpper(token) {
+		case SortDirAsc, SortDirDesc:
+			if len(keys) == 0 {
+				return nil, false
+			}
+			keys[len(keys)-1].Desc = strings.ToUpper(token) == SortDirDesc
+			continue
+		}
+		field, ok := fs[token]
+		if !ok || !field.Sortable {
+			return nil, false
+		}
+		for _, key := range keys {
+			if key.Name == token {
+				return nil, false
+			}
+		}
+		keys = append(keys, SortKey{
+			Name:     token,
+			Column:   field.Column,
+			Nullable: field.Nullable,
+			Time:     field.Type == TypeTime,
+		})
+	}
+	if len(keys) > maxSortKeys {
+		return nil, false
+	}
+	return keys, true
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	r := strings.NewReplacer(likeEscapeChar, likeEscapeChar+likeEscapeChar, "%", likeEscapeChar+"%", "_", likeEscapeChar+"_")
+	return r.Replace(s)
+}
+
+func invalid(field string) *valid.Error {
+	err := valid.Invalid(field)
+	return &err
+}
+
+func required(field string) *valid.Error {
+	err := valid.Required(field)
+	return &err
+}
@@ -0,0 +1,165 @@
+package sqlutil
+
+import (
+	"glut/common/valid"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stephenafamo/bob/dialect/psql"
+	"github.com/stephenafamo/bob/dialect/psql/sm"
+)
+
+var testFields = Fields{
+	"id":            {Column: "u.id", Type: TypeUUID, Ops: []string{OpEq, OpIn}, Sortable: true},
+	"username":      {Column: "u.username", Ops: []string{OpEq, OpPrefix}, Sortable: true},
+	"email":         {Column: "u.email", Ops: []string{OpEq}},
+	"last_login_at": {Column: "u.last_login_at", Type: TypeTime, Nullable: true, Ops: []string{OpRange, OpIsNull}, Sortable: true},
+}
+
+// whereSQL returns the SQL and arguments of a query on users filtered by
+// filters.
+func whereSQL(t *testing.T, filters []Filter) (string, []any) {
+	t.Helper()
+	mods, errs := testFields.Where(filters)
+	if len(errs) != 0 {
+		t.Fatalf("Where(%+v): %v", filters, errs)
+	}
+	q := psql.Select(sm.Columns("u.id"), sm.From("auth.users").As("u"))
+	q.Apply(mods...)
+	sql, args := q.MustBuild()
+	return strings.Join(strings.Fields(sql), " "), args
+}
+
+func TestWhere(t *testing.T) {
+	id := "4c1f1bc8-5bd4-4b4a-9b3e-1b0c2b7c9a11"
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	for _, tt := range []struct {
+		name    string
+		filters []Filter
+		where   string
+		args    []any
+	}{
+		{"eq", []Filter{{Field: "username", Op: OpEq, Value: "alice"}}, "WHERE ((u.username) = $1)", []any{"alice"}},
+		{"in", []Filter{{Field: "id", Op: OpIn, Values: []string{id}}}, "WHERE ((u.id) IN ($1))", []any{id}},
+		{"range", []Filter{{Field: "last_login_at", Op: OpRange, From: &from, To: &to}}, "WHERE ((((u.last_login_at) >= $1) AND ((u.last_login_at) < $2)))", []any{from, to}},
+		{"open range", []Filter{{Field: "last_login_at", Op: OpRange, To: &to}}, "WHERE ((((u.last_login_at) < $1)))", []any{to}},
+		{"is null", []Filter{{Field: "last_login_at", Op: OpIsNull, Value: true}}, "WHERE ((u.last_login_at) IS NULL)", nil},
+		{"is not null", []Filter{{Field: "last_login_at", Op: OpIsNull, Value: false}}, "WHERE ((u.last_login_at) IS NOT NULL)", nil},
+		{"prefix", []Filter{{Field: "username", Op: OpPrefix, Value: `a%_\`}}, `WHERE (u.username LIKE $1 ESCAPE '\')`, []any{`a\%\_\\%`}},
+		{"and", []Filter{
+			{Field: "username", Op: OpEq, Value: "alice"},
+			{Field: "email", Op: OpEq, Value: "alice@example.com"},
+		}, "WHERE ((u.username) = $1) AND ((u.email) = $2)", []any{"alice", "alice@example.com"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args := whereSQL(t, tt.filters)
+			if !strings.HasSuffix(sql, tt.where) {
+				t.Errorf("SQL = %q, want it to end with %q", sql, tt.where)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestWhereInvalid(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tooMany := make([]string, maxFilterIn+1)
+	for i := range tooMany {
+		tooMany[i] = "4c1f1bc8-5bd4-4b4a-9b3e-1b0c2b7c9a11"
+	}
+	for _, tt := range []struct {
+		name   string
+		filter Filter
+		field  string
+		code   string
+	}{
+		{"unknown field", Filter{Field: "password", Op: OpEq, Value: "x"}, "filters[0].field", valid.CodeInvalid},
+		{"unknown op", Filter{Field: "username", Op: "like", Value: "x"}, "filters[0].op", valid.CodeInvalid},
+		{"op not allowed", Filter{Field: "email", Op: OpPrefix, Value: "x"}, "filters[0].op", valid.CodeInvalid},
+		{"eq not a string", Filter{Field: "username", Op: OpEq, Value: 1.0}, "filters[0].value", valid.CodeInvalid},
+		{"eq invalid uuid", Filter{Field: "id", Op: OpEq, Value: "1"}, "filters[0].value", valid.CodeInvalid},
+		{"in empty", Filter{Field: "id", Op: OpIn}, "filters[0].values", valid.CodeRequired},
+		{"in invalid uuid", Filter{Field: "id", Op: OpIn, Values: []string{"1"}}, "filters[0].values", valid.CodeInvalid},
+		{"in too many", Filter{Field: "id", Op: OpIn, Values: tooMany}, "filters[0].values", valid.CodeMaxItems},
+		{"range unbounded", Filter{Field: "last_login_at", Op: OpRange}, "filters[0].from", valid.CodeRequired},
+		{"range empty", Filter{Field: "last_login_at", Op: OpRange, From: &from, To: &from}, "filters[0].to", valid.CodeInvalid},
+		{"is null not a bool", Filter{Field: "last_login_at", Op: OpIsNull, Value: "true"}, "filters[0].value", valid.CodeInvalid},
+		{"prefix empty", Filter{Field: "username", Op: OpPrefix, Value: ""}, "filters[0].value", valid.CodeInvalid},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mods, errs := testFields.Where([]Filter{tt.filter})
+			if len(mods) != 0 || len(errs) != 1 || errs[0].Field != tt.field || errs[0].Code != tt.code {
+				t.Errorf("Where = %d mods, %+v, want a %s error on %s", len(mods), errs, tt.code, tt.field)
+			}
+		})
+	}
+
+	filters := make([]Filter, maxFilters+1)
+	for i := range filters {
+		filters[i] = Filter{Field: "username", Op: OpEq, Value: "alice"}
+	}
+	if _, errs := testFields.Where(filters); len(errs) != 1 || errs[0].Field != "filters" || errs[0].Code != valid.CodeMaxItems {
+		t.Errorf("Where with %d filters = %+v, want a max_items error", len(filters), errs)
+	}
+
+	// Every invalid filter is reported.
+	_, errs := testFields.Where([]Filter{
+		{Field: "username", Op: OpEq, Value: "alice"},
+		{Field: "password", Op: OpEq, Value: "x"},
+		{Field: "email", Op: OpIn, Values: []string{"x"}},
+	})
+	if len(errs) != 2 || errs[0].Field != "filters[1].field" || errs[1].Field != "filters[2].op" {
+		t.Errorf("errors = %+v, want errors on filters[1] and filters[2]", errs)
+	}
+}
+
+func TestSort(t *testing.T) {
+	def := []SortKey{{Name: "id", Column: "u.id"}}
+	for _, tt := range []struct {
+		option string
+		want   []SortKey
+	}{
+		{"", def},
+		{"  ", def},
+		{"username", []SortKey{{Name: "username", Column: "u.username"}}},
+		{"last_login_at,desc,username", []SortKey{
+			{Name: "last_login_at", Column: "u.last_login_at", Desc: true, Nullable: true, Time: true},
+			{Name: "username", Column: "u.username"},
+		}},
+		{" username , DESC ", []SortKey{{Name: "username", Column: "u.username", Desc: true}}},
+		{"username,asc", []SortKey{{Name: "username", Column: "u.username"}}},
+	} {
+		keys, ok := testFields.Sort(tt.option, def)
+		if !ok || !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("Sort(%q) = %+v, %v, want %+v", tt.option, keys, ok, tt.want)
+		}
+	}
+
+	for _, option := range []string{
+		"password",                     // unknown field
+		"email",                        // not sortable
+		"desc",                         // direction without a field
+		"username,username",            // repeated field
+		"username,",                    // empty field
+		"id,username,last_login_at,id", // repeated field beyond the limit
+	} {
+		if keys, ok := testFields.Sort(option, def); ok {
+			t.Errorf("Sort(%q) = %+v, want it refused", option, keys)
+		}
+	}
+
+	many := Fields{}
+	var names []string
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		many[name] = Field{Column: name, Sortable: true}
+		names = append(names, name)
+	}
+	if keys, ok := many.Sort(strings.Join(names, ","), nil); ok {
+		t.Errorf("Sort with %d keys = %+v, want it refused", len(names), keys)
+	}
+}
@@ -0,0 +1,79 @@
+package sqlutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// exactCountLimit is the number of rows up to which tables are counted
+// exactly by Count.
+const exactCountLimit = 10_000
+
+// Page is a page of a paginated query. Pages of queries paginated by offset
+// have an Offset; pages of queries paginated by cursor have a Cursor and the
+// cursors of the adjacent pages. Total is only set if it was requested.
+type Page[T any] struct {
+	Items          []T     `json:"items"`
+	Total          *int64  `json:"total,omitempty"`
+	TotalEstimated bool    `json:"total_estimated,omitempty"`
+	Limit          int     `json:"limit"`
+	Offset         *int    `json:"offset,omitempty"`
+	Cursor         *string `json:"cursor,omitempty"`
+	NextCursor     *string `json:"next_cursor"`
+	PrevCursor     *string `json:"prev_cursor"`
+	HasMore        bool    `json:"has_more"`
+}
+
+// OffsetPage returns the page of rows selected with offset and a limit of
+// limit+1, the extra row telling whether more rows follow.
+func OffsetPage[T any](rows []T, limit, offset int) Page[T] {
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	return Page[T]{
+		Items:   rows,
+		Limit:   limit,
+		Offset:  &offset,
+		HasMore: more,
+	}
+}
+
+// Count returns the number of rows selected by sql, a query on table, and
+// whether the number is an estimate. Tables with fewer than exactCountLimit
+// rows are counted exactly. Rows of larger tables are estimated from the table
+// statistics if the query is not filtered and from the query plan otherwise.
+func Count(ctx context.Context, db DB, table string, filtered bool, sql string, args ...any) (int64, bool, error) {
+	var tableRows int64
+	q := `SELECT COALESCE((SELECT reltuples FROM pg_class WHERE oid = to_regclass($1)), -1)::bigint`
+	if err := db.QueryRow(ctx, q, table).Scan(&tableRows); err != nil {
+		return 0, false, fmt.Errorf("sqlutil.Count: %w", err)
+	}
+
+	// Tables that were never analyzed have no row estimate (-1).
+	if tableRows < exactCountLimit {
+		var count int64
+		if err := db.QueryRow(ctx, "SELECT count(*) FROM ("+sql+") AS t", args...).Scan(&count); err != nil {
+			return 0, false, fmt.Errorf("sqlutil.Count: %w", err)
+		}
+		return count, false, nil
+	}
+	if !filtered {
+		return tableRows, true, nil
+	}
+
+	var plan []byte
+	if err := db.QueryRow(ctx, "EXPLAIN (FORMAT JSON) "+sql, args...).Scan(&plan); err != nil {
+		return 0, false, fmt.Errorf("sqlutil.Count: %w", err)
+	}
+	var explain []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(plan, &explain); err != nil || len(explain) == 0 {
+		return 0, false, fmt.Errorf("sqlutil.Count: unexpected query plan: %s", plan)
+	}
+	return int64(explain[0].Plan.Rows), true, nil
+}
@@ -1,6 +1,38 @@
 package sqlutil
 
+import "strings"
+
 const (
 	SortDirAsc  = "ASC"
 	SortDirDesc = "DESC"
 )
+
+// SortKey is a key of the order of a query.
+type SortKey struct {
+	// Name is the name of the key as exposed to clients.
+	Name string
+	// Column is the SQL expression of the key, e.g. "u.created_at".
+	Column string
+	// Desc sorts in descending order.
+	Desc bool
+	// Nullable must be set if the column may be null. Nulls are ordered after
+	// other values in ascending order and before them in descending order, as
+	// by PostgreSQL.
+	Nullable bool
+	// Time must be set if the column is a timestamp.
+	Time bool
+}
+
+// FormatSort returns the sort option selecting keys, e.g.
+// "last_login_at,desc,username,asc".
+func FormatSort(keys []SortKey) string {
+	parts := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		dir := SortDirAsc
+		if key.Desc {
+			dir = SortDirDesc
+		}
+		parts = append(parts, key.Name, strings.ToLower(dir))
+	}
+	return strings.Join(parts, ",")
+}
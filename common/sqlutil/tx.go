@@ -0,0 +1,155 @@
+package sqlutil
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	defaultTxMaxAttempts = 3
+	txRetryBaseDelay     = 10 * time.Millisecond
+	txRetryMaxDelay      = 500 * time.Millisecond
+)
+
+// SQLSTATE codes and classes of the errors handled by WithTx and Constraint.
+const (
+	codeIntegrityViolationClass = "23"
+	codeUniqueViolation         = "23505"
+	codeSerializationFailure    = "40001"
+	codeDeadlockDetected        = "40P01"
+)
+
+// Beginner starts transactions. It is implemented by pools, connections and
+// transactions, the latter starting savepoints.
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// TxOptions are the options of a transaction run by WithTx.
+type TxOptions struct {
+	IsoLevel pgx.TxIsoLevel
+	ReadOnly bool
+	// MaxAttempts is the number of times the transaction is run before its
+	// error is returned. It defaults to 3; 1 disables retries.
+	MaxAttempts int
+	// RetryConstraints are the unique constraints whose violation is retried,
+	// e.g. when rows are numbered by counting existing rows. A retry
+	// observes the rows inserted by the concurrent transaction.
+	RetryConstraints []string
+}
+
+// WithTx runs fn in a transaction on db, committing it if fn returns nil
+// and rolling it back otherwise. Serialization failures, deadlocks and
+// violations of opts.RetryConstraints roll back the transaction and run fn
+// again after a backoff, so fn must not have effects outside of tx.
+//
+// If db is a transaction, fn runs in a savepoint that is released on success
+// and rolled back on error, without retries: those are left to the outermost
+// transaction. Isolation options are ignored for savepoints.
+func WithTx(ctx context.Context, db Beginner, opts TxOptions, fn func(tx pgx.Tx) error) error {
+	if _, ok := db.(pgx.Tx); ok {
+		return runTx(ctx, db, fn)
+	}
+	txdb, ok := db.(interface {
+		BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+	})
+	if !ok {
+		return runTx(ctx, db, fn)
+	}
+
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultTxMaxAttempts
+	}
+	txOpts := pgx.TxOptions{IsoLevel: opts.IsoLevel}
+	if opts.ReadOnly {
+		txOpts.AccessMode = pgx.ReadOnly
+	}
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, beginTxFunc(func(ctx context.Context) (pgx.Tx, error) {
+			return txdb.BeginTx(ctx, txOpts)
+		}), fn)
+		if err == nil || attempt >= maxAttempts || !retryable(err, opts.RetryConstraints) {
+			return err
+		}
+		if err := sleep(ctx, backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// beginTxFunc adapts a function to the Beginner interface.
+type beginTxFunc func(ctx context.Context) (pgx.Tx, error)
+
+func (f beginTxFunc) Begin(ctx context.Context) (pgx.Tx, error) {
+	return f(ctx)
+}
+
+// runTx runs fn in a transaction begun on db.
+func runTx(ctx context.Context, db Beginner, fn func(tx pgx.Tx) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// retryable reports whether a transaction failing with err may succeed if run
+// again.
+func retryable(err error, constraints []string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Code {
+	case codeSerializationFailure, codeDeadlockDetected:
+		return true
+	case codeUniqueViolation:
+		return slices.Contains(constraints, pgErr.ConstraintName)
+	}
+	return false
+}
+
+// Constraint returns the name of the constraint violated by err, or an empty
+// string if err is not an integrity constraint violation.
+func Constraint(err error) string {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || !strings.HasPrefix(pgErr.Code, codeIntegrityViolationClass) {
+		return ""
+	}
+	return pgErr.ConstraintName
+}
+
+// backoff returns the delay before the attempt following attempt, doubling
+// with each attempt up to a maximum, with full jitter.
+func backoff(attempt int) time.Duration {
+	d := txRetryBaseDelay << (attempt - 1)
+	if d <= 0 || d > txRetryMaxDelay {
+		d = txRetryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
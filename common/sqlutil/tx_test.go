@@ -0,0 +1,178 @@
+package sqlutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// testDB is a pool whose transactions record how they end.
+type testDB struct {
+	begun, committed, rolledBack, savepoints int
+}
+
+func (db *testDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return db.BeginTx(ctx, pgx.TxOptions{})
+}
+
+func (db *testDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	db.begun++
+	return &testTx{db: db}, nil
+}
+
+type testTx struct {
+	pgx.Tx
+	db        *testDB
+	savepoint bool
+	closed    bool
+}
+
+func (tx *testTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	tx.db.savepoints++
+	return &testTx{db: tx.db, savepoint: true}, nil
+}
+
+func (tx *testTx) Commit(ctx context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	tx.db.committed++
+	return nil
+}
+
+func (tx *testTx) Rollback(ctx context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	tx.db.rolledBack++
+	return nil
+}
+
+func TestRetryable(t *testing.T) {
+	constraints := []string{"sessions_user_id_number_key"}
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{&pgconn.PgError{Code: codeSerializationFailure}, true},
+		{&pgconn.PgError{Code: codeDeadlockDetected}, true},
+		{fmt.Errorf("insert session: %w", &pgconn.PgError{Code: codeSerializationFailure}), true},
+		{&pgconn.PgError{Code: codeUniqueViolation, ConstraintName: "sessions_user_id_number_key"}, true},
+		{&pgconn.PgError{Code: codeUniqueViolation, ConstraintName: "users_username_key"}, false},
+		{&pgconn.PgError{Code: "23503", ConstraintName: "sessions_user_id_number_key"}, false},
+		{&pgconn.PgError{Code: "42P01"}, false},
+		{errors.New("connection reset"), false},
+		{context.Canceled, false},
+	} {
+		if got := retryable(tt.err, constraints); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt <= 64; attempt++ {
+		max := txRetryMaxDelay
+		if attempt <= 6 {
+			max = txRetryBaseDelay << (attempt - 1)
+		}
+		for i := 0; i < 100; i++ {
+			if d := backoff(attempt); d <= 0 || d > max {
+				t.Fatalf("backoff(%d) = %v, want a delay in (0, %v]", attempt, d, max)
+			}
+		}
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+	serialization := &pgconn.PgError{Code: codeSerializationFailure}
+	unique := &pgconn.PgError{Code: codeUniqueViolation, ConstraintName: "sessions_user_id_number_key"}
+	for _, tt := range []struct {
+		name      string
+		opts      TxOptions
+		errs      []error // errors of the attempts, nil once one succeeds
+		attempts  int
+		committed int
+		err       error
+	}{
+		{"success", TxOptions{}, []error{nil}, 1, 1, nil},
+		{"retried", TxOptions{}, []error{serialization, &pgconn.PgError{Code: codeDeadlockDetected}, nil}, 3, 1, nil},
+		{"attempts exhausted", TxOptions{}, []error{serialization, serialization, serialization}, 3, 0, serialization},
+		{"more attempts", TxOptions{MaxAttempts: 4}, []error{serialization, serialization, serialization, nil}, 4, 1, nil},
+		{"retries disabled", TxOptions{MaxAttempts: 1}, []error{serialization}, 1, 0, serialization},
+		{"listed constraint", TxOptions{RetryConstraints: []string{unique.ConstraintName}}, []error{unique, nil}, 2, 1, nil},
+		{"other constraint", TxOptions{}, []error{unique}, 1, 0, unique},
+		{"other error", TxOptions{}, []error{pgx.ErrNoRows}, 1, 0, pgx.ErrNoRows},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &testDB{}
+			attempts := 0
+			err := WithTx(ctx, db, tt.opts, func(tx pgx.Tx) error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("WithTx = %v, want %v", err, tt.err)
+			}
+			if attempts != tt.attempts || db.begun != tt.attempts {
+				t.Errorf("%d attempts in %d transactions, want %d", attempts, db.begun, tt.attempts)
+			}
+			if db.committed != tt.committed || db.rolledBack != tt.attempts-tt.committed {
+				t.Errorf("%d transactions committed and %d rolled back, want %d and %d", db.committed, db.rolledBack, tt.committed, tt.attempts-tt.committed)
+			}
+		})
+	}
+}
+
+func TestWithTxSavepoint(t *testing.T) {
+	ctx := context.Background()
+	db := &testDB{}
+	outer, _ := db.BeginTx(ctx, pgx.TxOptions{})
+
+	// Savepoints are not retried, leaving retries to the outer transaction.
+	attempts := 0
+	serialization := &pgconn.PgError{Code: codeSerializationFailure}
+	err := WithTx(ctx, outer, TxOptions{MaxAttempts: 3}, func(tx pgx.Tx) error {
+		attempts++
+		if tx == outer || !tx.(*testTx).savepoint {
+			t.Errorf("fn ran in %+v, want a savepoint", tx)
+		}
+		return serialization
+	})
+	if !errors.Is(err, serialization) || attempts != 1 {
+		t.Errorf("WithTx = %v after %d attempts, want a serialization failure after 1", err, attempts)
+	}
+	if err := WithTx(ctx, outer, TxOptions{}, func(tx pgx.Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if db.savepoints != 2 || db.rolledBack != 1 || db.committed != 1 || outer.(*testTx).closed {
+		t.Errorf("%d savepoints, %d rolled back and %d released, outer closed %v; want 2, 1, 1 and open",
+			db.savepoints, db.rolledBack, db.committed, outer.(*testTx).closed)
+	}
+}
+
+func TestWithTxCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	db := &testDB{}
+	attempts := 0
+	start := time.Now()
+	err := WithTx(ctx, db, TxOptions{MaxAttempts: 10}, func(tx pgx.Tx) error {
+		attempts++
+		return &pgconn.PgError{Code: codeSerializationFailure}
+	})
+	if !errors.Is(err, context.Canceled) || attempts != 1 {
+		t.Errorf("WithTx = %v after %d attempts, want context.Canceled after 1", err, attempts)
+	}
+	if d := time.Since(start); d > txRetryMaxDelay {
+		t.Errorf("WithTx took %v after its context was canceled", d)
+	}
+}
@@ -5,13 +5,29 @@ import (
 	"strings"
 )
 
+// Validation error codes. Codes are stable and intended to be matched by
+// clients, unlike the English message in Error.Error.
+const (
+	CodeRequired      = "required"
+	CodeInputRequired = "input_required"
+	CodeInvalid       = "invalid"
+	CodeInvalidID     = "invalid_id"
+	CodeInvalidIDs    = "invalid_ids"
+	CodeMinLength     = "min_length"
+	CodeMaxLength     = "max_length"
+	CodeMaxItems      = "max_items"
+	CodeOutOfRange    = "out_of_range"
+)
+
 // Errors represents a collection of validation errors.
 type Errors []Error
 
 // Error represents a validation error.
 type Error struct {
-	Field string `json:"field,omitempty"`
-	Error string `json:"error"`
+	Field  string         `json:"field,omitempty"`
+	Code   string         `json:"code"`
+	Params map[string]any `json:"params,omitempty"`
+	Error  string         `json:"error"`
 }
 
 // Error satisfies the error interface.
@@ -26,3 +42,70 @@ func (e Errors) Error() string {
 	}
 	return strings.Join(errs, ",")
 }
+
+// Required returns an error for a missing field value.
+func Required(field string) Error {
+	return Error{Field: field, Code: CodeRequired, Error: "Required."}
+}
+
+// InputRequired returns an error for an input that has none of its
+// optional fields set.
+func InputRequired() Error {
+	return Error{Code: CodeInputRequired, Error: "Input required."}
+}
+
+// Invalid returns an error for a field with an invalid value.
+func Invalid(field string) Error {
+	return Error{Field: field, Code: CodeInvalid, Error: fmt.Sprintf("Invalid %s.", field)}
+}
+
+// InvalidID returns an error for a field that is not a valid id.
+func InvalidID(field string) Error {
+	return Error{Field: field, Code: CodeInvalidID, Error: "Invalid id."}
+}
+
+// InvalidIDs returns an error for a list field containing an invalid id.
+func InvalidIDs(field string) Error {
+	return Error{Field: field, Code: CodeInvalidIDs, Error: "Contains invalid id."}
+}
+
+// MinLength returns an error for a field shorter than min characters.
+func MinLength(field string, min int) Error {
+	return Error{
+		Field:  field,
+		Code:   CodeMinLength,
+		Params: map[string]any{"min_length": min},
+		Error:  fmt.Sprintf("Must be at least %d characters.", min),
+	}
+}
+
+// MaxLength returns an error for a field longer than max characters.
+func MaxLength(field string, max int) Error {
+	return Error{
+		Field:  field,
+		Code:   CodeMaxLength,
+		Params: map[string]any{"max_length": max},
+		Error:  fmt.Sprintf("Must be at most %d characters.", max),
+	}
+}
+
+// MaxItems returns an error for a list field with more than max items.
+func MaxItems(field string, max int) Error {
+	return Error{
+		Field:  field,
+		Code:   CodeMaxItems,
+		Params: map[string]any{"max_items": max},
+		Error:  fmt.Sprintf("Must have at most %d items.", max),
+	}
+}
+
+// OutOfRange returns an error for a field with a value outside of the
+// inclusive range [min, max].
+func OutOfRange(field string, min, max any) Error {
+	return Error{
+		Field:  field,
+		Code:   CodeOutOfRange,
+		Params: map[string]any{"min": min, "max": max},
+		Error:  fmt.Sprintf("Must be between %v and %v.", min, max),
+	}
+}
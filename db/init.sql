@@ -1,7 +1,3 @@
 DROP DATABASE IF EXISTS glut;
 
 CREATE DATABASE glut;
-
-\c glut;
-
-CREATE EXTENSION IF NOT EXISTS hstore;
@@ -0,0 +1 @@
+DROP SCHEMA auth CASCADE;
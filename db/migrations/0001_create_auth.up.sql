@@ -1,3 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS hstore;
+
 CREATE SCHEMA auth;
 
 CREATE TABLE auth.users (
@@ -0,0 +1,9 @@
+ALTER TABLE auth.tokens ADD COLUMN meta_hstore hstore;
+
+UPDATE auth.tokens t
+SET meta_hstore = (SELECT hstore(array_agg(key), array_agg(value)) FROM jsonb_each_text(t.meta))
+WHERE meta IS NOT NULL;
+
+ALTER TABLE auth.tokens DROP COLUMN meta;
+
+ALTER TABLE auth.tokens RENAME COLUMN meta_hstore TO meta;
@@ -0,0 +1 @@
+ALTER TABLE auth.tokens ALTER COLUMN meta TYPE jsonb USING hstore_to_jsonb(meta);
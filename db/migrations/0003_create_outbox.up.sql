@@ -0,0 +1,18 @@
+CREATE TABLE auth.outbox (
+  seq bigserial PRIMARY KEY,
+  id uuid UNIQUE NOT NULL,
+  type text NOT NULL,
+  aggregate_id uuid NOT NULL,
+  actor_id uuid,
+  payload jsonb NOT NULL,
+  created_at timestamptz NOT NULL,
+  state text NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'delivered', 'dead')),
+  attempts int NOT NULL DEFAULT 0,
+  next_attempt_at timestamptz NOT NULL,
+  locked_until timestamptz,
+  last_error text,
+  delivered_at timestamptz
+);
+
+CREATE INDEX outbox_pending_idx ON auth.outbox (aggregate_id, seq) WHERE state = 'pending';
+CREATE INDEX outbox_delivered_at_idx ON auth.outbox (delivered_at) WHERE state = 'delivered';
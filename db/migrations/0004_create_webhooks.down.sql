@@ -0,0 +1,2 @@
+DROP TABLE auth.webhook_deliveries;
+DROP TABLE auth.webhooks;
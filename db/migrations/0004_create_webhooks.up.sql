@@ -0,0 +1,33 @@
+CREATE TABLE auth.webhooks (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  url text NOT NULL,
+  secret text NOT NULL,
+  events text[] NOT NULL,
+  description text,
+  enabled boolean NOT NULL DEFAULT true,
+  failures int NOT NULL DEFAULT 0,
+  created_at timestamptz NOT NULL,
+  updated_at timestamptz,
+  disabled_at timestamptz
+);
+
+CREATE TABLE auth.webhook_deliveries (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  webhook_id uuid NOT NULL REFERENCES auth.webhooks (id) ON DELETE CASCADE,
+  event_id uuid NOT NULL,
+  event_type text NOT NULL,
+  payload jsonb NOT NULL,
+  state text NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'delivered', 'failed')),
+  attempts int NOT NULL DEFAULT 0,
+  created_at timestamptz NOT NULL,
+  next_attempt_at timestamptz,
+  locked_until timestamptz,
+  last_attempt_at timestamptz,
+  status_code int,
+  error text,
+  duration_ms bigint,
+  delivered_at timestamptz,
+  UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX webhook_deliveries_pending_idx ON auth.webhook_deliveries (next_attempt_at) WHERE state = 'pending';
@@ -0,0 +1 @@
+DROP SCHEMA scheduler CASCADE;
@@ -0,0 +1,14 @@
+CREATE SCHEMA scheduler;
+
+CREATE TABLE scheduler.job_runs (
+  id uuid PRIMARY KEY,
+  job text NOT NULL,
+  instance text NOT NULL,
+  status text NOT NULL CHECK (status IN ('running', 'succeeded', 'failed', 'timed_out')),
+  started_at timestamptz NOT NULL,
+  finished_at timestamptz,
+  error text
+);
+
+CREATE INDEX job_runs_job_started_at_idx ON scheduler.job_runs (job, started_at);
+CREATE INDEX job_runs_started_at_idx ON scheduler.job_runs (started_at);
@@ -0,0 +1,20 @@
+CREATE TABLE auth.emails (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id uuid REFERENCES auth.users (id) ON DELETE CASCADE,
+  kind text NOT NULL,
+  recipient text NOT NULL,
+  subject text NOT NULL,
+  text_body text NOT NULL,
+  html_body text NOT NULL DEFAULT '',
+  state text NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'sent', 'failed')),
+  attempts int NOT NULL DEFAULT 0,
+  created_at timestamptz NOT NULL,
+  next_attempt_at timestamptz,
+  locked_until timestamptz,
+  last_attempt_at timestamptz,
+  error text,
+  sent_at timestamptz
+);
+
+CREATE INDEX emails_pending_idx ON auth.emails (next_attempt_at) WHERE state = 'pending';
+CREATE INDEX emails_user_id_idx ON auth.emails (user_id);
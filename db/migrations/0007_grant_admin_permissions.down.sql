@@ -0,0 +1,5 @@
+-- The admin role is kept as users may hold it.
+DELETE FROM auth.permissions WHERE name IN (
+  'users:read', 'users:delete', 'sessions:read', 'sessions:delete', 'bans:read', 'bans:write',
+  'rbac:read', 'rbac:write', 'rbac:check', 'webhooks:read', 'webhooks:write', 'emails:read'
+);
@@ -0,0 +1,30 @@
+-- The permissions required by the admin handlers, granted to the protected
+-- admin role so that a fresh database can be administered.
+INSERT INTO auth.roles (name, description, created_at)
+VALUES ('admin', 'Administers users, roles and webhooks.', now())
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO auth.permissions (name, description, created_at)
+VALUES
+  ('users:read', 'Read user data.'),
+  ('users:delete', 'Delete user data.'),
+  ('sessions:read', 'Read the sessions of users.'),
+  ('sessions:delete', 'Clear the sessions of users.'),
+  ('bans:read', 'Read bans.'),
+  ('bans:write', 'Ban and unban users.'),
+  ('rbac:read', 'Read roles, permissions and role assignments.'),
+  ('rbac:write', 'Manage roles, permissions and role assignments.'),
+  ('rbac:check', 'Check the permissions of users.'),
+  ('webhooks:read', 'Read webhooks and their deliveries.'),
+  ('webhooks:write', 'Manage webhooks and redeliver events.'),
+  ('emails:read', 'Read the email queue.')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO auth.role_permissions (role_id, permission_id, created_at)
+SELECT r.id, p.id, now()
+FROM auth.roles r, auth.permissions p
+WHERE r.name = 'admin' AND p.name IN (
+  'users:read', 'users:delete', 'sessions:read', 'sessions:delete', 'bans:read', 'bans:write',
+  'rbac:read', 'rbac:write', 'rbac:check', 'webhooks:read', 'webhooks:write', 'emails:read'
+)
+ON CONFLICT (role_id, permission_id) DO NOTHING;
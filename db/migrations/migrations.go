@@ -0,0 +1,38 @@
+// Package migrations embeds the database migrations of glut.
+//
+// Databases created from db/auth.sql before migrations were used already
+// have the schema of version 1 of the PostgreSQL migrations, so applying
+// them fails on the existing auth schema. Such databases are adopted by
+// recording that version without running it and then applying the
+// remaining migrations:
+//
+//	glut migrate baseline 1
+//	glut migrate up
+package migrations
+
+import (
+	"embed"
+	"glut/common/migrate"
+	"io/fs"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFiles embed.FS
+
+// All returns the embedded database migrations of PostgreSQL.
+func All() ([]migrate.Migration, error) {
+	return migrate.Load(files)
+}
+
+// SQLite returns the embedded database migrations of SQLite. The tables of
+// a schema are prefixed with its name, e.g. auth_users for auth.users.
+func SQLite() ([]migrate.Migration, error) {
+	fsys, err := fs.Sub(sqliteFiles, "sqlite")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.Load(fsys)
+}
@@ -0,0 +1,9 @@
+DROP TABLE auth_bans;
+DROP TABLE auth_tokens;
+DROP TABLE auth_session_roles;
+DROP TABLE auth_sessions;
+DROP TABLE auth_user_roles;
+DROP TABLE auth_role_permissions;
+DROP TABLE auth_permissions;
+DROP TABLE auth_roles;
+DROP TABLE auth_users;
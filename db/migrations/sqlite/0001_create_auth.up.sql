@@ -0,0 +1,88 @@
+CREATE TABLE auth_users (
+  id text PRIMARY KEY,
+  username text UNIQUE NOT NULL,
+  email text NOT NULL,
+  password_hash text NOT NULL,
+  created_at timestamp NOT NULL,
+  verified_at timestamp,
+  last_login_at timestamp,
+  last_login_ip text
+);
+
+CREATE TABLE auth_roles (
+  id text PRIMARY KEY,
+  name text UNIQUE NOT NULL,
+  description text,
+  created_at timestamp NOT NULL,
+  updated_at timestamp,
+  created_by text REFERENCES auth_users (id) ON DELETE SET NULL,
+  updated_by text REFERENCES auth_users (id) ON DELETE SET NULL
+);
+
+CREATE TABLE auth_permissions (
+  id text PRIMARY KEY,
+  name text UNIQUE NOT NULL,
+  description text,
+  created_at timestamp NOT NULL,
+  updated_at timestamp,
+  created_by text REFERENCES auth_users (id) ON DELETE SET NULL,
+  updated_by text REFERENCES auth_users (id) ON DELETE SET NULL
+);
+
+CREATE TABLE auth_role_permissions (
+  id text PRIMARY KEY,
+  role_id text NOT NULL REFERENCES auth_roles (id) ON DELETE CASCADE,
+  permission_id text NOT NULL REFERENCES auth_permissions (id) ON DELETE CASCADE,
+  created_at timestamp NOT NULL,
+  created_by text REFERENCES auth_users (id) ON DELETE SET NULL,
+  UNIQUE (role_id, permission_id)
+);
+
+CREATE TABLE auth_user_roles (
+  id text PRIMARY KEY,
+  user_id text NOT NULL REFERENCES auth_users (id) ON DELETE CASCADE,
+  role_id text NOT NULL REFERENCES auth_roles (id) ON DELETE CASCADE,
+  created_at timestamp NOT NULL,
+  created_by text REFERENCES auth_users (id) ON DELETE SET NULL,
+  UNIQUE (user_id, role_id)
+);
+
+CREATE TABLE auth_sessions (
+  id text PRIMARY KEY,
+  token text UNIQUE NOT NULL,
+  user_id text NOT NULL REFERENCES auth_users (id) ON DELETE CASCADE,
+  user_ip text NOT NULL,
+  session_number integer NOT NULL,
+  created_at timestamp NOT NULL,
+  expires_at timestamp NOT NULL,
+  CHECK (expires_at >= created_at),
+  UNIQUE (user_id, session_number),
+  CHECK (session_number BETWEEN 1 AND 10)
+);
+
+CREATE TABLE auth_session_roles (
+  session_id text NOT NULL REFERENCES auth_sessions (token) ON DELETE CASCADE,
+  role_id text NOT NULL REFERENCES auth_roles (id) ON DELETE CASCADE,
+  PRIMARY KEY (session_id, role_id)
+);
+
+CREATE TABLE auth_tokens (
+  id text PRIMARY KEY,
+  user_id text NOT NULL REFERENCES auth_users (id) ON DELETE CASCADE,
+  kind text NOT NULL,
+  created_at timestamp NOT NULL,
+  expires_at timestamp NOT NULL,
+  meta text CHECK (json_valid(meta)),
+  UNIQUE (user_id, kind),
+  CHECK (expires_at >= created_at)
+);
+
+CREATE TABLE auth_bans (
+  user_id text PRIMARY KEY REFERENCES auth_users (id) ON DELETE CASCADE,
+  reason text NOT NULL,
+  description text,
+  banned_at timestamp NOT NULL,
+  unbanned_at timestamp NOT NULL,
+  banned_by text REFERENCES auth_users (id) ON DELETE SET NULL,
+  CHECK (unbanned_at >= banned_at)
+);
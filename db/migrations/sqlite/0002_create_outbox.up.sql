@@ -0,0 +1,18 @@
+CREATE TABLE auth_outbox (
+  seq integer PRIMARY KEY,
+  id text UNIQUE NOT NULL,
+  type text NOT NULL,
+  aggregate_id text NOT NULL,
+  actor_id text,
+  payload text NOT NULL CHECK (json_valid(payload)),
+  created_at timestamp NOT NULL,
+  state text NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'delivered', 'dead')),
+  attempts int NOT NULL DEFAULT 0,
+  next_attempt_at timestamp NOT NULL,
+  locked_until timestamp,
+  last_error text,
+  delivered_at timestamp
+);
+
+CREATE INDEX auth_outbox_pending_idx ON auth_outbox (aggregate_id, seq) WHERE state = 'pending';
+CREATE INDEX auth_outbox_delivered_at_idx ON auth_outbox (delivered_at) WHERE state = 'delivered';
@@ -0,0 +1,2 @@
+DROP TABLE auth_webhook_deliveries;
+DROP TABLE auth_webhooks;
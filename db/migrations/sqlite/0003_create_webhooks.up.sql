@@ -0,0 +1,33 @@
+CREATE TABLE auth_webhooks (
+  id text PRIMARY KEY,
+  url text NOT NULL,
+  secret text NOT NULL,
+  events text NOT NULL CHECK (json_valid(events)),
+  description text,
+  enabled boolean NOT NULL DEFAULT true,
+  failures int NOT NULL DEFAULT 0,
+  created_at timestamp NOT NULL,
+  updated_at timestamp,
+  disabled_at timestamp
+);
+
+CREATE TABLE auth_webhook_deliveries (
+  id text PRIMARY KEY,
+  webhook_id text NOT NULL REFERENCES auth_webhooks (id) ON DELETE CASCADE,
+  event_id text NOT NULL,
+  event_type text NOT NULL,
+  payload text NOT NULL CHECK (json_valid(payload)),
+  state text NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'delivered', 'failed')),
+  attempts int NOT NULL DEFAULT 0,
+  created_at timestamp NOT NULL,
+  next_attempt_at timestamp,
+  locked_until timestamp,
+  last_attempt_at timestamp,
+  status_code int,
+  error text,
+  duration_ms bigint,
+  delivered_at timestamp,
+  UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX auth_webhook_deliveries_pending_idx ON auth_webhook_deliveries (next_attempt_at) WHERE state = 'pending';
@@ -0,0 +1,12 @@
+CREATE TABLE scheduler_job_runs (
+  id text PRIMARY KEY,
+  job text NOT NULL,
+  instance text NOT NULL,
+  status text NOT NULL CHECK (status IN ('running', 'succeeded', 'failed', 'timed_out')),
+  started_at timestamp NOT NULL,
+  finished_at timestamp,
+  error text
+);
+
+CREATE INDEX scheduler_job_runs_job_started_at_idx ON scheduler_job_runs (job, started_at);
+CREATE INDEX scheduler_job_runs_started_at_idx ON scheduler_job_runs (started_at);
@@ -0,0 +1,20 @@
+CREATE TABLE auth_emails (
+  id text PRIMARY KEY,
+  user_id text REFERENCES auth_users (id) ON DELETE CASCADE,
+  kind text NOT NULL,
+  recipient text NOT NULL,
+  subject text NOT NULL,
+  text_body text NOT NULL,
+  html_body text NOT NULL DEFAULT '',
+  state text NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'sent', 'failed')),
+  attempts int NOT NULL DEFAULT 0,
+  created_at timestamp NOT NULL,
+  next_attempt_at timestamp,
+  locked_until timestamp,
+  last_attempt_at timestamp,
+  error text,
+  sent_at timestamp
+);
+
+CREATE INDEX auth_emails_pending_idx ON auth_emails (next_attempt_at) WHERE state = 'pending';
+CREATE INDEX auth_emails_user_id_idx ON auth_emails (user_id);
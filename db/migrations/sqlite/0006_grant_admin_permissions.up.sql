@@ -0,0 +1,42 @@
+-- The permissions required by the admin handlers, granted to the protected
+-- admin role so that a fresh database can be administered. Ids are random
+-- version 4 UUIDs and times are written in the format of sqlite.TimeFormat.
+INSERT INTO auth_roles (id, name, description, created_at)
+VALUES (
+  lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))),
+  'admin', 'Administers users, roles and webhooks.', strftime('%Y-%m-%d %H:%M:%f', 'now') || '000000Z'
+)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO auth_permissions (id, name, description, created_at)
+SELECT
+  lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))),
+  column1, column2, strftime('%Y-%m-%d %H:%M:%f', 'now') || '000000Z'
+FROM (VALUES
+  ('users:read', 'Read user data.'),
+  ('users:delete', 'Delete user data.'),
+  ('sessions:read', 'Read the sessions of users.'),
+  ('sessions:delete', 'Clear the sessions of users.'),
+  ('bans:read', 'Read bans.'),
+  ('bans:write', 'Ban and unban users.'),
+  ('rbac:read', 'Read roles, permissions and role assignments.'),
+  ('rbac:write', 'Manage roles, permissions and role assignments.'),
+  ('rbac:check', 'Check the permissions of users.'),
+  ('webhooks:read', 'Read webhooks and their deliveries.'),
+  ('webhooks:write', 'Manage webhooks and redeliver events.'),
+  ('emails:read', 'Read the email queue.')
+)
+-- The WHERE clause resolves the ambiguity of an upsert from a SELECT.
+WHERE true
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO auth_role_permissions (id, role_id, permission_id, created_at)
+SELECT
+  lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))),
+  r.id, p.id, strftime('%Y-%m-%d %H:%M:%f', 'now') || '000000Z'
+FROM auth_roles r, auth_permissions p
+WHERE r.name = 'admin' AND p.name IN (
+  'users:read', 'users:delete', 'sessions:read', 'sessions:delete', 'bans:read', 'bans:write',
+  'rbac:read', 'rbac:write', 'rbac:check', 'webhooks:read', 'webhooks:write', 'emails:read'
+)
+ON CONFLICT (role_id, permission_id) DO NOTHING;